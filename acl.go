@@ -0,0 +1,80 @@
+package fidias
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// grpc metadata key carrying the token of a request
+const tokenMetadataKey = "fidias-token"
+
+// ACL scopes access to a namespace by the token sent with each request.  Nodes
+// are always allowed access using the cluster token
+type ACL struct {
+	// Tokens allowed to read and write the namespace
+	ReadWrite []string
+	// Tokens only allowed to read the namespace
+	ReadOnly []string
+}
+
+// allows returns true if the token is allowed the access
+func (acl *ACL) allows(token string, write bool) bool {
+	if acl == nil {
+		return true
+	}
+	if token == "" {
+		return false
+	}
+
+	for _, t := range acl.ReadWrite {
+		if t == token {
+			return true
+		}
+	}
+	if write {
+		return false
+	}
+	for _, t := range acl.ReadOnly {
+		if t == token {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadNamespaces loads the named namespaces of the cluster from a json file
+// mapping names to their acl e.g. {"docs": {"ReadWrite": ["<token>"]}, "tmp":
+// null}.  A null acl allows all access
+func LoadNamespaces(path string) (map[string]*ACL, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var m map[string]*ACL
+	if err = json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+
+	for name := range m {
+		if err = validateNamespace(name); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// incomingToken returns the token from the incoming grpc metadata
+func incomingToken(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if v := md[tokenMetadataKey]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
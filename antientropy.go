@@ -0,0 +1,314 @@
+package fidias
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hexablock/log"
+)
+
+var errNotStale = errors.New("local view is not older")
+
+// AntiEntropyTransport exchanges merkle trees and keys with remote nodes.  The
+// namespace is carried by the context
+type AntiEntropyTransport interface {
+	GetKey(ctx context.Context, host string, key []byte) (*KVPair, error)
+	MerkleHashes(ctx context.Context, host, set string, level int32, nodes []int32) ([][]byte, error)
+	MerkleLeaves(ctx context.Context, host, set string, leaves []int32) ([]*KVPair, error)
+}
+
+// AntiEntropyStatus is the status of anti-entropy on a node.  Counters are
+// totals since it was created
+type AntiEntropyStatus struct {
+	Runs int64
+	// Merkle tree exchanges with peers
+	Exchanges int64
+	// Leaves that differed from a peer
+	DivergedLeaves int64
+	// Local keys older than on a peer
+	Stale      int64
+	Repaired   int64
+	Unrepaired int64
+	// Keys with the same height and a different modification on a peer
+	Conflicts int64
+	// Keys only found on a peer.  These are repaired unless removed locally
+	// after the height on the peer
+	Missing   int64
+	Running   bool
+	LastRun   time.Time
+	LastError string `json:",omitempty"`
+	// Duration of the last completed run
+	Runtime time.Duration
+}
+
+// AntiEntropy periodically compares the local store of each namespace with the
+// other nodes replicating its keys and repairs keys that are behind.  Keys are
+// compared per replica set i.e. the nodes the logs of the keys are placed on,
+// so nodes only compare the keys they both replicate.  The cached merkle tree
+// of each set is exchanged top down with each node of the set, only descending
+// into nodes that differ, so replicas in sync are compared with a single hash.
+// The keys of the differing leaves are then compared by height and the local
+// view of a key is replaced with a newer view from the peer.  Keys only found
+// on a peer are copied unless the fsm removed the key at or after the height
+// on the peer, in which case the peer missed the remove.  Peers repair
+// themselves when they run.  Replica sets without a local key are not compared
+// until a key of the set is applied locally
+type AntiEntropy struct {
+	// Counters first for 64-bit alignment of atomic operations
+	runs, exchanges, diverged, stale, repaired, unrepaired, conflicts, missing int64
+
+	// Local rpc host
+	host  string
+	trans AntiEntropyTransport
+
+	namespaces *namespaces
+
+	mu      sync.Mutex
+	running bool
+	lastRun time.Time
+	lastErr error
+	runtime time.Duration
+
+	stop chan struct{}
+}
+
+func newAntiEntropy(host string, trans AntiEntropyTransport, ns *namespaces) *AntiEntropy {
+	return &AntiEntropy{
+		host:       host,
+		trans:      trans,
+		namespaces: ns,
+	}
+}
+
+// Start runs anti-entropy in the background every interval until stopped
+func (ae *AntiEntropy) Start(interval time.Duration) {
+	ae.stop = make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ae.stop:
+				return
+			case <-time.After(interval):
+			}
+
+			if err := ae.Run(); err != nil {
+				log.Printf("[ERROR] Anti-entropy failed error='%v'", err)
+			}
+		}
+	}()
+}
+
+// Stop stops background anti-entropy
+func (ae *AntiEntropy) Stop() {
+	if ae.stop != nil {
+		close(ae.stop)
+	}
+}
+
+// Run compares all namespaces with their peers once
+func (ae *AntiEntropy) Run() error {
+	ae.mu.Lock()
+	if ae.running {
+		ae.mu.Unlock()
+		return nil
+	}
+	ae.running = true
+	ae.mu.Unlock()
+
+	start := time.Now()
+	err := ae.run()
+
+	ae.mu.Lock()
+	ae.running = false
+	ae.lastRun = start
+	ae.lastErr = err
+	ae.runtime = time.Since(start)
+	ae.mu.Unlock()
+
+	atomic.AddInt64(&ae.runs, 1)
+	return err
+}
+
+func (ae *AntiEntropy) run() error {
+	var err error
+	for _, name := range ae.namespaces.names() {
+		n, er := ae.namespaces.get(name)
+		if er != nil {
+			err = er
+			continue
+		}
+
+		forest := n.fsm.merkle
+		if er = forest.place(); er != nil {
+			err = er
+			continue
+		}
+
+		for _, set := range forest.sets() {
+			for _, peer := range replicaPeers(set, ae.host) {
+				if er = ae.exchange(name, n, set, peer); er != nil {
+					log.Printf("[ERROR] Anti-entropy exchange failed namespace=%q peer=%s error='%v'", name, peer, er)
+					err = er
+				}
+			}
+		}
+	}
+	return err
+}
+
+// exchange compares the keys of the replica set in the local store of the
+// namespace with the peer and repairs the local keys that are behind or
+// missing
+func (ae *AntiEntropy) exchange(name string, n *namespace, set, peer string) error {
+	atomic.AddInt64(&ae.exchanges, 1)
+
+	ctx := withNamespace(context.Background(), name)
+	forest := n.fsm.merkle
+
+	leaves, err := ae.divergedLeaves(ctx, forest, set, peer)
+	if err != nil || len(leaves) == 0 {
+		return err
+	}
+	atomic.AddInt64(&ae.diverged, int64(len(leaves)))
+
+	remote, err := ae.trans.MerkleLeaves(ctx, peer, set, leaves)
+	if err != nil {
+		return err
+	}
+
+	local := make(map[string]*KVPair)
+	forest.leafDigests(set, leaves, func(kvp *KVPair) bool {
+		local[string(kvp.Key)] = kvp
+		return true
+	})
+
+	// Keys in a different replica set on either node may have moved as nodes
+	// joined or left so they are looked up again
+	relocate := make([]string, 0)
+
+	for _, r := range remote {
+		l, ok := local[string(r.Key)]
+		if ok {
+			delete(local, string(r.Key))
+		}
+
+		switch {
+		case !ok && forest.contains(r.Key):
+			relocate = append(relocate, string(r.Key))
+
+		case !ok:
+			atomic.AddInt64(&ae.missing, 1)
+			// Keys of sets the node is no longer part of are not copied
+			if !inReplicaSet(set, ae.host) {
+				continue
+			}
+			if h, removed := forest.removedAt(r.Key); removed && h >= r.Height {
+				continue
+			}
+			ae.repairKey(ctx, name, n.fsm, peer, r)
+
+		case r.Height > l.Height:
+			atomic.AddInt64(&ae.stale, 1)
+			ae.repairKey(ctx, name, n.fsm, peer, r)
+
+		case r.Height == l.Height && !bytes.Equal(r.Modification, l.Modification):
+			atomic.AddInt64(&ae.conflicts, 1)
+			log.Printf("[WARNING] Anti-entropy conflict namespace=%q key=%s peer=%s height=%d",
+				name, r.Key, peer, r.Height)
+		}
+	}
+
+	// Keys the peer does not have in the set.  The peer repairs them when it
+	// runs if the set is unchanged
+	for key := range local {
+		relocate = append(relocate, key)
+	}
+	return forest.relocate(relocate)
+}
+
+// repairKey repairs the local view of the key from the peer updating the
+// counters
+func (ae *AntiEntropy) repairKey(ctx context.Context, name string, fsm *FSM, peer string, digest *KVPair) {
+	err := ae.repair(ctx, fsm, peer, digest)
+	switch {
+	case err == nil:
+		atomic.AddInt64(&ae.repaired, 1)
+	case err != errNotStale:
+		atomic.AddInt64(&ae.unrepaired, 1)
+		log.Printf("[ERROR] Anti-entropy failed to repair namespace=%q key=%s peer=%s error='%v'",
+			name, digest.Key, peer, err)
+	}
+}
+
+// divergedLeaves returns the leaves of the local tree of the replica set that
+// differ from the tree of the peer descending only into differing nodes
+func (ae *AntiEntropy) divergedLeaves(ctx context.Context, forest *merkleForest, set, peer string) ([]int32, error) {
+	nodes := []int32{0}
+	for level := int32(0); level <= merkleDepth; level++ {
+		remote, err := ae.trans.MerkleHashes(ctx, peer, set, level, nodes)
+		if err != nil {
+			return nil, err
+		}
+		if len(remote) != len(nodes) {
+			return nil, fmt.Errorf("merkle hash count mismatch: %d != %d", len(remote), len(nodes))
+		}
+		local, err := forest.hashes(set, level, nodes)
+		if err != nil {
+			return nil, err
+		}
+
+		differ := make([]int32, 0)
+		for i, n := range nodes {
+			if !bytes.Equal(local[i], remote[i]) {
+				differ = append(differ, n)
+			}
+		}
+
+		if level == merkleDepth || len(differ) == 0 {
+			return differ, nil
+		}
+		nodes = merkleChildren(differ)
+	}
+	return nil, nil
+}
+
+// repair fetches the newer view of the key from the peer and applies it
+// locally
+func (ae *AntiEntropy) repair(ctx context.Context, fsm *FSM, peer string, digest *KVPair) error {
+	kvp, err := ae.trans.GetKey(ctx, peer, digest.Key)
+	if err != nil {
+		return err
+	}
+	return fsm.repair(kvp)
+}
+
+// Status returns the current anti-entropy status
+func (ae *AntiEntropy) Status() *AntiEntropyStatus {
+	st := &AntiEntropyStatus{
+		Runs:           atomic.LoadInt64(&ae.runs),
+		Exchanges:      atomic.LoadInt64(&ae.exchanges),
+		DivergedLeaves: atomic.LoadInt64(&ae.diverged),
+		Stale:          atomic.LoadInt64(&ae.stale),
+		Repaired:       atomic.LoadInt64(&ae.repaired),
+		Unrepaired:     atomic.LoadInt64(&ae.unrepaired),
+		Conflicts:      atomic.LoadInt64(&ae.conflicts),
+		Missing:        atomic.LoadInt64(&ae.missing),
+	}
+
+	ae.mu.Lock()
+	defer ae.mu.Unlock()
+
+	st.Running = ae.running
+	st.LastRun = ae.lastRun
+	st.Runtime = ae.runtime
+	if ae.lastErr != nil {
+		st.LastError = ae.lastErr.Error()
+	}
+
+	return st
+}
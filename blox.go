@@ -0,0 +1,512 @@
+package fidias
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/hexablock/blox"
+	"github.com/hexablock/blox/block"
+	"github.com/hexablock/log"
+)
+
+// Number of blocks read or written concurrently per file
+const bloxWorkers = 3
+
+// ProgressFunc is called with the bytes transferred so far and the total.  The
+// total is zero when unknown e.g. for uploads
+type ProgressFunc func(done, total uint64)
+
+// BloxOptions are the options for file transfers.  All fields are optional
+type BloxOptions struct {
+	// Block size in bytes used when sharding an upload
+	BlockSize uint64
+	// Durability policy of uploaded blocks.  The cluster replication factor is
+	// used if nil
+	Durability *Durability
+	// Codec compressing the upload.  It is recorded with the root so downloads
+	// are decompressed regardless
+	Compression Compression
+	// Called as data is transferred
+	Progress ProgressFunc
+}
+
+// BloxStats are the stats of a file upload
+type BloxStats struct {
+	// Time taken to shard and write the data blocks
+	Runtime time.Duration
+	// Stored file size in bytes i.e. after compression and encryption
+	Size uint64
+	// Number of data blocks
+	Blocks int
+	// True if the root index block already existed i.e. the same content was
+	// uploaded before
+	Exists bool
+	// Logical and physical bytes of the blocks written
+	Dedupe *DedupeStats
+}
+
+// Blox is a client interface to store and retrieve files as content addressed
+// blocks.  Files are identified by the id of their root index block
+type Blox struct {
+	client *Client
+	// Namespace uploads are registered to
+	namespace string
+	// Encrypts uploads if the namespace has a key
+	env *envelope
+}
+
+// Blox returns the file client interface for the default namespace.  Uploads
+// are encrypted if the keyring has a key for it
+func (client *Client) Blox() *Blox {
+	return &Blox{client: client, namespace: DefaultNamespace, env: client.envelope(DefaultNamespace)}
+}
+
+// device returns a block device writing with the policy
+func (bx *Blox) device(policy *Durability) (*DurableDevice, error) {
+	c := bx.client
+	return NewDurableDevice(policy, c.dev, c.dht, c.blockTrans, c.shards, c.conf.Phi.HashFunc)
+}
+
+// Put shards the data from the reader into blocks and writes them along with
+// the root index block to the cluster.  The upload is marked in progress
+// before any block is written and the root is registered for garbage
+// collection once done.  The de-duplication stats of the upload are added to
+// the cluster-wide counters.  It returns the root id.
+//
+// Encrypted uploads use convergent encryption so identical content uploaded to
+// a namespace with the same key is still de-duplicated.  Each block holds one
+// encrypted chunk so the file size of the index block includes the encryption
+// overhead
+func (bx *Blox) Put(r io.Reader, opts *BloxOptions) ([]byte, *BloxStats, error) {
+	if opts == nil {
+		opts = &BloxOptions{}
+	}
+
+	durable, err := bx.device(opts.Durability)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	uploadID, err := bx.beginUpload()
+	if err != nil {
+		return nil, nil, err
+	}
+	rootID, stats, err := bx.put(r, durable, opts, uploadID)
+	if err != nil {
+		bx.endUpload(uploadID)
+		return nil, nil, err
+	}
+	return rootID, stats, nil
+}
+
+// put writes the upload marked in progress with the upload id
+func (bx *Blox) put(r io.Reader, durable *DurableDevice, opts *BloxOptions, uploadID string) ([]byte, *BloxStats, error) {
+	var err error
+	dev := NewDedupeDevice(durable)
+
+	sharder := blox.NewStreamSharder(dev, bloxWorkers)
+	if opts.BlockSize > 0 {
+		sharder.SetBlockSize(opts.BlockSize)
+	}
+	if opts.Progress != nil {
+		r = &progressReader{r: r, f: opts.Progress}
+	}
+	// Compress before encrypting as ciphertext does not compress
+	if r, err = NewCompressReader(r, opts.Compression); err != nil {
+		return nil, nil, err
+	}
+
+	if bx.env != nil {
+		bs := opts.BlockSize
+		if bs == 0 {
+			bs = defaultEncryptedBlockSize
+		}
+		if bs <= chunkOverhead {
+			return nil, nil, fmt.Errorf("block size too small for encryption: %d", bs)
+		}
+		sharder.SetBlockSize(bs)
+		r = newEncryptReader(r, bx.env, bs)
+	}
+
+	if err = sharder.Shard(r); err != nil {
+		return nil, nil, err
+	}
+
+	idx := sharder.IndexBlock()
+	stats := &BloxStats{
+		Runtime: sharder.Runtime(),
+		Size:    idx.FileSize(),
+		Blocks:  idx.BlockCount(),
+	}
+
+	if _, err = dev.SetBlock(idx); err != nil {
+		if err != block.ErrBlockExists {
+			return nil, nil, err
+		}
+		stats.Exists = true
+	}
+
+	stats.Dedupe = dev.Stats()
+	reg := NewRootRequest(idx, opts.Compression, stats.Dedupe)
+	reg.Upload = uploadID
+	if err = bx.client.RegisterRoot(bx.namespace, reg); err != nil {
+		return nil, nil, err
+	}
+
+	return idx.ID(), stats, nil
+}
+
+// beginUpload marks a new upload to the namespace in progress and returns its
+// id
+func (bx *Blox) beginUpload() (string, error) {
+	uploadID, err := NewUploadID()
+	if err != nil {
+		return "", err
+	}
+	return uploadID, bx.client.BeginUpload(bx.namespace, uploadID, 0)
+}
+
+// endUpload clears the marker of a failed upload.  Markers that cannot be
+// cleared expire
+func (bx *Blox) endUpload(uploadID string) {
+	if err := bx.client.EndUpload(bx.namespace, uploadID); err != nil {
+		log.Printf("[ERROR] Failed to clear upload marker upload=%s error='%v'", uploadID, err)
+	}
+}
+
+// Get returns a reader assembling the file with the root id.  Blocks are
+// fetched as the reader is consumed, decrypted if the file is encrypted and
+// decompressed if it is compressed.  Any error assembling the file is returned
+// by Read.  Only the progress option is used and reports the stored bytes
+func (bx *Blox) Get(rootID []byte, opts *BloxOptions) (io.ReadCloser, error) {
+	dev, err := bx.device(nil)
+	if err != nil {
+		return nil, err
+	}
+	codec, err := RootCompression(bx.kv(), rootID)
+	if err != nil {
+		return nil, err
+	}
+
+	asm := blox.NewAssembler(dev, bloxWorkers)
+	idx, err := asm.SetRoot(rootID)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(asm.Assemble(pw))
+	}()
+
+	var rd io.ReadCloser = pr
+	if opts != nil && opts.Progress != nil {
+		rd = &progressReader{r: pr, c: pr, f: opts.Progress, total: idx.FileSize()}
+	}
+	if bx.env != nil {
+		rd = newDecryptReader(rd, bx.env, idx.BlockSize())
+	}
+
+	dr, err := NewDecompressReader(rd, codec)
+	if err != nil {
+		rd.Close()
+		return nil, err
+	}
+	return &readCloser{Reader: dr, Closer: rd}, nil
+}
+
+// Stat returns the root index block of the file
+func (bx *Blox) Stat(rootID []byte) (*block.IndexBlock, error) {
+	dev, err := bx.device(nil)
+	if err != nil {
+		return nil, err
+	}
+	return blox.NewAssembler(dev, bloxWorkers).SetRoot(rootID)
+}
+
+// Delete marks the root of the file deleted.  No blocks are removed as data
+// blocks may be shared with other files through de-duplication.  The next GC
+// collects the root and the blocks not shared with a kept root regardless of
+// its grace period unless a kv value still references it
+func (bx *Blox) Delete(rootID []byte) error {
+	return DeleteRoot(bx.kv(), rootID)
+}
+
+// GC runs a garbage collection of the roots not referenced by the kv prefixes
+// in the options
+func (bx *Blox) GC(opts *GCOptions) (*GCReport, error) {
+	dev, err := bx.device(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	gc := NewBlockGC(func(name string) (BloxKV, error) {
+		return bx.client.Namespace(name).KV(), nil
+	}, dev)
+	return gc.Run(opts)
+}
+
+// Uploads returns the multipart uploads of the cluster
+func (bx *Blox) Uploads() *MultipartUploads {
+	return NewMultipartUploads(bx.kv(), bx.client, bx.device, bx.client.conf.Phi.HashFunc)
+}
+
+// PutMultipart uploads size bytes from the reader as a multipart upload of
+// parts of partSize bytes, uploading up to bloxWorkers parts concurrently.
+// The part size is rounded up to a multiple of the block size.  Compression,
+// encryption and progress options are not supported.  The upload is aborted
+// if a part fails.  It returns the root index block
+func (bx *Blox) PutMultipart(r io.ReaderAt, size int64, partSize uint64, opts *BloxOptions) (*block.IndexBlock, error) {
+	if opts == nil {
+		opts = &BloxOptions{}
+	}
+	if bx.env != nil || opts.Compression != CompressionNone {
+		return nil, fmt.Errorf("multipart uploads cannot be compressed or encrypted")
+	}
+
+	uploads := bx.Uploads()
+	upload, err := uploads.Initiate(bx.namespace, opts.BlockSize, opts.Durability)
+	if err != nil {
+		return nil, err
+	}
+
+	if partSize < upload.BlockSize {
+		partSize = upload.BlockSize
+	}
+	if rem := partSize % upload.BlockSize; rem != 0 {
+		partSize += upload.BlockSize - rem
+	}
+
+	var (
+		parts = int((uint64(size) + partSize - 1) / partSize)
+		next  = make(chan int)
+		errs  = make(chan error, bloxWorkers)
+		wg    sync.WaitGroup
+	)
+	if parts == 0 {
+		parts = 1
+	}
+
+	for i := 0; i < bloxWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range next {
+				off := int64(n-1) * int64(partSize)
+				sr := io.NewSectionReader(r, off, int64(partSize))
+				if _, _, er := uploads.UploadPart(upload.ID, n, sr); er != nil {
+					errs <- fmt.Errorf("part %d: %v", n, er)
+					return
+				}
+			}
+		}()
+	}
+
+	// Stops handing out parts on the first error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(next)
+		for n := 1; n <= parts; n++ {
+			select {
+			case next <- n:
+			case err := <-errs:
+				errs <- err
+				return
+			}
+		}
+	}()
+	wg.Wait()
+
+	select {
+	case err = <-errs:
+		if er := uploads.Abort(upload.ID); er != nil {
+			log.Printf("[ERROR] Failed to abort upload id=%s error='%v'", upload.ID, er)
+		}
+		return nil, err
+	default:
+	}
+
+	return uploads.Complete(upload.ID)
+}
+
+// SnapshotTree writes a snapshot of the directory in the namespace of the
+// client and returns its root id.  The root is registered for garbage
+// collection so its blocks are kept while a kv value references it
+func (bx *Blox) SnapshotTree(dir []byte) ([]byte, error) {
+	dev, err := bx.device(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tree blocks are de-duplicated against earlier snapshots of the same keys
+	uploadID, err := bx.beginUpload()
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := bx.client.Namespace(bx.namespace).kvs.SnapshotTree(dir, dev)
+	if err == nil {
+		reg := &RootRequest{ID: root, Kind: rootKindSnapshot, Upload: uploadID}
+		err = bx.client.RegisterRoot(bx.namespace, reg)
+	}
+	if err != nil {
+		bx.endUpload(uploadID)
+		return nil, err
+	}
+	return root, nil
+}
+
+// RestoreTree recreates the snapshot with the root id under dir in the
+// namespace of the client.  Keys are written through the KV of the namespace so
+// encrypted values are re-encrypted for the key they are restored to.  It
+// returns the number of keys restored
+func (bx *Blox) RestoreTree(root, dir []byte) (int, error) {
+	dev, err := bx.device(nil)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		ctx = context.Background()
+		wo  = DefaultWriteOptions()
+		kv  = bx.client.Namespace(bx.namespace).KV()
+	)
+	return restoreTree(ctx, root, dir, dev,
+		func(key []byte) error {
+			_, _, err := kv.GetContext(ctx, key, &ReadOptions{})
+			return err
+		},
+		func(kvp *KVPair, src []byte) error {
+			if kvp.IsDir() {
+				_, _, err := kv.MkdirContext(ctx, kvp, wo)
+				return err
+			}
+
+			if isSealed(kvp.Value) {
+				switch {
+				case bx.env != nil && src != nil:
+					// Opened with the key it was sealed for as the set seals
+					// it for the key it is restored to
+					val, err := bx.env.open(src, kvp.Value)
+					if err != nil {
+						return err
+					}
+					kvp.Value = val
+				case bx.env != nil || !bytes.Equal(kvp.Key, src):
+					return ErrEncrypted
+				}
+			}
+			_, _, err := kv.SetContext(ctx, kvp, wo)
+			return err
+		},
+	)
+}
+
+// DiffSnapshots returns the keys that changed from snapshot a to snapshot b
+func (bx *Blox) DiffSnapshots(a, b []byte) ([]*SnapshotChange, error) {
+	dev, err := bx.device(nil)
+	if err != nil {
+		return nil, err
+	}
+	return DiffSnapshots(dev, a, b)
+}
+
+// DedupeReport returns the cluster-wide de-duplication report
+func (bx *Blox) DedupeReport() (*DedupeReport, error) {
+	return ReadDedupeReport(bx.kv())
+}
+
+// kv returns the KV of the BloxNamespace
+func (bx *Blox) kv() *KV {
+	return bx.client.Namespace(BloxNamespace).KV()
+}
+
+// BloxKV is the kv blox state is kept in i.e. root registrations, multipart
+// uploads, shard maps and counters.  Nodes use the kvs of the BloxNamespace
+// and clients its KV so client writes are made through the rpcs of a node
+type BloxKV interface {
+	Get(key []byte, opt *ReadOptions) (*KVPair, *ReadStats, error)
+	Scan(opts *ScanOptions) ([]*KVPair, *ReadStats, error)
+	RemoveTree(dir []byte, wo *WriteOptions) (int, error)
+
+	set(kvp *KVPair) (*KVPair, error)
+	caset(kvp *KVPair, mod []byte) (*KVPair, error)
+	incr(key []byte, delta int64) (*KVPair, error)
+	remove(key []byte) error
+}
+
+func (kvs *KVS) set(kvp *KVPair) (*KVPair, error) {
+	kvp, _, err := kvs.Set(kvp, DefaultWriteOptions())
+	return kvp, err
+}
+
+func (kvs *KVS) caset(kvp *KVPair, mod []byte) (*KVPair, error) {
+	kvp, _, err := kvs.CASet(kvp, mod, DefaultWriteOptions())
+	return kvp, err
+}
+
+func (kvs *KVS) incr(key []byte, delta int64) (*KVPair, error) {
+	kvp, _, err := kvs.Incr(key, delta, DefaultWriteOptions())
+	return kvp, err
+}
+
+func (kvs *KVS) remove(key []byte) error {
+	_, err := kvs.Remove(key, DefaultWriteOptions())
+	return err
+}
+
+func (kv *KV) set(kvp *KVPair) (*KVPair, error) {
+	kvp, _, err := kv.Set(kvp, DefaultWriteOptions())
+	return kvp, err
+}
+
+func (kv *KV) caset(kvp *KVPair, mod []byte) (*KVPair, error) {
+	kvp, _, err := kv.CASet(kvp, mod, DefaultWriteOptions())
+	return kvp, err
+}
+
+func (kv *KV) incr(key []byte, delta int64) (*KVPair, error) {
+	kvp, _, err := kv.Incr(key, delta, DefaultWriteOptions())
+	return kvp, err
+}
+
+func (kv *KV) remove(key []byte) error {
+	_, err := kv.Remove(key, DefaultWriteOptions())
+	return err
+}
+
+// readCloser closes the underlying stream of a wrapping reader
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// progressReader reports the bytes read to the progress func
+type progressReader struct {
+	r     io.Reader
+	c     io.Closer
+	f     ProgressFunc
+	done  uint64
+	total uint64
+}
+
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	if n > 0 {
+		pr.done += uint64(n)
+		pr.f(pr.done, pr.total)
+	}
+	return n, err
+}
+
+func (pr *progressReader) Close() error {
+	if pr.c != nil {
+		return pr.c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package fidias
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func Test_progressReader(t *testing.T) {
+	var calls, last uint64
+	pr := &progressReader{
+		r:     bytes.NewReader(make([]byte, 10000)),
+		total: 10000,
+		f: func(done, total uint64) {
+			if done < last || total != 10000 {
+				t.Fatal("wrong progress", done, total)
+			}
+			calls++
+			last = done
+		},
+	}
+
+	if _, err := ioutil.ReadAll(pr); err != nil {
+		t.Fatal(err)
+	}
+	if calls == 0 || last != 10000 {
+		t.Fatal("wrong progress", calls, last)
+	}
+	if err := pr.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -2,6 +2,7 @@ package fidias
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -9,76 +10,107 @@ import (
 	kelips "github.com/hexablock/go-kelips"
 	"github.com/hexablock/hexalog"
 	"github.com/hexablock/hexatype"
+	"github.com/hexablock/log"
 	"github.com/hexablock/phi"
 )
 
+var errNoEndpoints = errors.New("no endpoints available")
+
 // KV is a client KV interface to perform key-value operations.
 type KV struct {
-	walHost string
+	// Namespace the requests are performed in
+	namespace string
+
+	// Endpoints write requests are submitted to
+	endpoints *endpointSet
 
 	kvs  *KVS
 	pool *outPool
+
+	// Encrypts values if the namespace has a key
+	env *envelope
+
+	// Token sent with each request
+	token string
 }
 
 // Set makes a set client request
 func (kv *KV) Set(kvp *KVPair, wo *WriteOptions) (*KVPair, *WriteStats, error) {
-	conn, err := kv.pool.getConn(kv.walHost)
+	return kv.SetContext(context.Background(), kvp, wo)
+}
+
+// SetContext makes a set client request with the given context
+func (kv *KV) SetContext(ctx context.Context, kvp *KVPair, wo *WriteOptions) (*KVPair, *WriteStats, error) {
+	skvp, err := kv.env.sealPair(kvp)
 	if err != nil {
 		return nil, nil, err
 	}
-	defer kv.pool.returnConn(conn)
 
-	resp, err := conn.client.SetRPC(context.Background(), &WriteRequest{KV: kvp, Options: wo})
+	req := &WriteRequest{KV: skvp, Options: wo}
+	resp, err := kv.write(ctx, kvp.Key, func(ctx context.Context, client FidiasRPCClient) (*WriteResponse, error) {
+		return client.SetRPC(ctx, req)
+	})
 	if err != nil {
 		return nil, nil, err
 	}
 
-	return resp.KV, resp.Stats, nil
+	return resp.KV, resp.Stats, kv.env.openPairs(resp.KV)
 }
 
 // CASet compares the mod and sets the KVPair.  If the mods do not match an
 // error is returned
 func (kv *KV) CASet(kvp *KVPair, mod []byte, wo *WriteOptions) (*KVPair, *WriteStats, error) {
-	conn, err := kv.pool.getConn(kv.walHost)
+	return kv.CASetContext(context.Background(), kvp, mod, wo)
+}
+
+// CASetContext compares the mod and sets the KVPair with the given context
+func (kv *KV) CASetContext(ctx context.Context, kvp *KVPair, mod []byte, wo *WriteOptions) (*KVPair, *WriteStats, error) {
+	skvp, err := kv.env.sealPair(kvp)
 	if err != nil {
 		return nil, nil, err
 	}
-	defer kv.pool.returnConn(conn)
 
-	req := &WriteRequest{KV: kvp, Options: wo}
+	req := &WriteRequest{KV: skvp, Options: wo}
 	req.KV.Modification = mod
-	resp, err := conn.client.CASetRPC(context.Background(), req)
+	resp, err := kv.write(ctx, kvp.Key, func(ctx context.Context, client FidiasRPCClient) (*WriteResponse, error) {
+		return client.CASetRPC(ctx, req)
+	})
 	if err != nil {
 		return nil, nil, err
 	}
 
-	return resp.KV, resp.Stats, nil
+	return resp.KV, resp.Stats, kv.env.openPairs(resp.KV)
 }
 
+// Remove makes a remove client request
 func (kv *KV) Remove(key []byte, wo *WriteOptions) (*WriteStats, error) {
-	conn, err := kv.pool.getConn(kv.walHost)
-	if err != nil {
-		return nil, err
-	}
-	defer kv.pool.returnConn(conn)
+	return kv.RemoveContext(context.Background(), key, wo)
+}
 
+// RemoveContext makes a remove client request with the given context
+func (kv *KV) RemoveContext(ctx context.Context, key []byte, wo *WriteOptions) (*WriteStats, error) {
 	req := &WriteRequest{KV: &KVPair{Key: key}, Options: wo}
-	resp, err := conn.client.RemoveRPC(context.Background(), req)
+	resp, err := kv.write(ctx, key, func(ctx context.Context, client FidiasRPCClient) (*WriteResponse, error) {
+		return client.RemoveRPC(ctx, req)
+	})
 	if err != nil {
 		return nil, err
 	}
 	return resp.Stats, nil
 }
 
+// CARemove compares the mod and removes the key.  If the mods do not match an
+// error is returned
 func (kv *KV) CARemove(key []byte, mod []byte, wo *WriteOptions) (*WriteStats, error) {
-	conn, err := kv.pool.getConn(kv.walHost)
-	if err != nil {
-		return nil, err
-	}
-	defer kv.pool.returnConn(conn)
+	return kv.CARemoveContext(context.Background(), key, mod, wo)
+}
 
+// CARemoveContext compares the mod and removes the key with the given context
+func (kv *KV) CARemoveContext(ctx context.Context, key []byte, mod []byte, wo *WriteOptions) (*WriteStats, error) {
 	req := &WriteRequest{KV: &KVPair{Key: key, Modification: mod}, Options: wo}
-	resp, err := conn.client.CARemoveRPC(context.Background(), req)
+	resp, err := kv.write(ctx, key, func(ctx context.Context, client FidiasRPCClient) (*WriteResponse, error) {
+		return client.CARemoveRPC(ctx, req)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -86,14 +118,337 @@ func (kv *KV) CARemove(key []byte, mod []byte, wo *WriteOptions) (*WriteStats, e
 	return resp.Stats, err
 }
 
-// Get retreives a key on the cluster from the first available node
+// Mkdir creates an empty directory on the cluster with the mode, owner and
+// metadata of the supplied pair
+func (kv *KV) Mkdir(dir *KVPair, wo *WriteOptions) (*KVPair, *WriteStats, error) {
+	return kv.MkdirContext(context.Background(), dir, wo)
+}
+
+// MkdirContext creates an empty directory on the cluster with the given
+// context
+func (kv *KV) MkdirContext(ctx context.Context, dir *KVPair, wo *WriteOptions) (*KVPair, *WriteStats, error) {
+	req := &WriteRequest{KV: dir, Options: wo}
+	resp, err := kv.write(ctx, dir.Key, func(ctx context.Context, client FidiasRPCClient) (*WriteResponse, error) {
+		return client.MkdirRPC(ctx, req)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return resp.KV, resp.Stats, nil
+}
+
+// RemoveTree removes the directory and all of its descendants from the
+// cluster.  It returns the number of keys removed
+func (kv *KV) RemoveTree(dir []byte, wo *WriteOptions) (int, error) {
+	return kv.RemoveTreeContext(context.Background(), dir, wo)
+}
+
+// RemoveTreeContext removes the directory and all of its descendants from the
+// cluster with the given context
+func (kv *KV) RemoveTreeContext(ctx context.Context, dir []byte, wo *WriteOptions) (int, error) {
+	return removeTree(ctx, dir,
+		func(key []byte) (*KVPair, error) {
+			kvp, _, err := kv.GetContext(ctx, key, &ReadOptions{})
+			return kvp, err
+		},
+		func(d []byte) ([]*KVPair, error) {
+			ls, _, err := kv.ListContext(ctx, d, &ReadOptions{})
+			return ls, err
+		},
+		func(key []byte) error {
+			_, err := kv.RemoveContext(ctx, key, wo)
+			return err
+		},
+	)
+}
+
+// Incr atomically adds delta to the integer value of the key on the cluster.
+// It returns the resulting pair
+func (kv *KV) Incr(key []byte, delta int64, wo *WriteOptions) (*KVPair, *WriteStats, error) {
+	return kv.IncrContext(context.Background(), key, delta, wo)
+}
+
+// IncrContext atomically adds delta to the integer value of the key on the
+// cluster with the given context
+func (kv *KV) IncrContext(ctx context.Context, key []byte, delta int64, wo *WriteOptions) (*KVPair, *WriteStats, error) {
+	if kv.env != nil {
+		return nil, nil, ErrEncrypted
+	}
+
+	req := &IncrRequest{Key: key, Delta: delta, Options: wo}
+	resp, err := kv.write(ctx, key, func(ctx context.Context, client FidiasRPCClient) (*WriteResponse, error) {
+		return client.IncrRPC(ctx, req)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return resp.KV, resp.Stats, nil
+}
+
+// Append atomically appends data to the value of the key on the cluster.  It
+// returns the resulting pair
+func (kv *KV) Append(key, data []byte, wo *WriteOptions) (*KVPair, *WriteStats, error) {
+	return kv.AppendContext(context.Background(), key, data, wo)
+}
+
+// AppendContext atomically appends data to the value of the key on the
+// cluster with the given context
+func (kv *KV) AppendContext(ctx context.Context, key, data []byte, wo *WriteOptions) (*KVPair, *WriteStats, error) {
+	if kv.env != nil {
+		return nil, nil, ErrEncrypted
+	}
+
+	req := &WriteRequest{KV: NewKVPair(key, data), Options: wo}
+	resp, err := kv.write(ctx, key, func(ctx context.Context, client FidiasRPCClient) (*WriteResponse, error) {
+		return client.AppendRPC(ctx, req)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return resp.KV, resp.Stats, nil
+}
+
+// write submits the write request using f.  See request for how endpoints are
+// selected
+func (kv *KV) write(ctx context.Context, key []byte, f func(context.Context, FidiasRPCClient) (*WriteResponse, error)) (*WriteResponse, error) {
+	var resp *WriteResponse
+	err := kv.request(ctx, key, true, func(ctx context.Context, client FidiasRPCClient) (err error) {
+		resp, err = f(ctx, client)
+		return
+	})
+	return resp, err
+}
+
+// request submits the request using f to the active endpoint in the namespace
+// of the KV.  If the endpoint is unavailable the request is retried on the
+// remaining endpoints.  Once all known endpoints have failed, nodes owning the
+// key are discovered via the dht and tried.  Writes are not idempotent so they
+// are only failed over if the endpoint could not be connected to, never once
+// sent
+func (kv *KV) request(ctx context.Context, key []byte, write bool, f func(context.Context, FidiasRPCClient) error) error {
+	// Send the namespace with the request
+	rctx := outgoingContext(withNamespace(ctx, kv.namespace), kv.token)
+
+	var (
+		tried      = make(map[string]bool)
+		discovered bool
+		err        = errNoEndpoints
+	)
+
+	for {
+
+		for _, host := range kv.endpoints.candidates() {
+			if tried[host] {
+				continue
+			}
+			tried[host] = true
+
+			// Don't failover if the caller is no longer waiting
+			if er := ctx.Err(); er != nil {
+				return contextError(er)
+			}
+
+			var conn *rpcOutConn
+			if conn, err = kv.pool.getConn(host); err != nil {
+				kv.endpoints.markFailed(host, err)
+				continue
+			}
+			if write && !conn.ready(ctx) {
+				kv.pool.returnConn(conn)
+				if er := ctx.Err(); er != nil {
+					return contextError(er)
+				}
+				err = fmt.Errorf("failed to connect: %s", host)
+				kv.endpoints.markFailed(host, err)
+				continue
+			}
+
+			err = f(rctx, conn.client)
+			kv.pool.returnConn(conn)
+
+			if err == nil {
+				kv.endpoints.setActive(host)
+				return nil
+			}
+
+			// Return request errors as is
+			if write || !isUnavailable(err) || ctx.Err() != nil {
+				return fromRPCError(err)
+			}
+
+			log.Printf("[WARNING] Endpoint unavailable host=%s error='%v'", host, err)
+			kv.endpoints.markFailed(host, err)
+		}
+
+		if discovered || kv.discover(key) == 0 {
+			return fromRPCError(err)
+		}
+		discovered = true
+	}
+
+}
+
+// discover looks up the nodes owning the key and adds their rpc address to the
+// endpoints.  It returns the number of new endpoints added
+func (kv *KV) discover(key []byte) int {
+	nodes, err := kv.kvs.dht.Lookup(append(kv.kvs.prefix, key...))
+	if err != nil {
+		return 0
+	}
+	return kv.addNodes(nodes)
+}
+
+// addNodes adds the rpc address of each node to the endpoints
+func (kv *KV) addNodes(nodes []*hexatype.Node) int {
+	var c int
+	for _, n := range nodes {
+		if kv.endpoints.add(n.Metadata()["hexalog"]) {
+			c++
+		}
+	}
+	return c
+}
+
+// Get retreives a key on the cluster from the first available node.  Nodes
+// serving the read are added to the known endpoints
 func (kv *KV) Get(key []byte, opt *ReadOptions) (*KVPair, *ReadStats, error) {
-	return kv.kvs.Get(key, opt)
+	return kv.GetContext(context.Background(), key, opt)
+}
+
+// GetContext retreives a key on the cluster from the first available node with
+// the given context
+func (kv *KV) GetContext(ctx context.Context, key []byte, opt *ReadOptions) (*KVPair, *ReadStats, error) {
+	kvp, stats, err := kv.kvs.GetContext(ctx, key, opt)
+	if stats != nil {
+		kv.addNodes(stats.Nodes)
+	}
+	if err == nil {
+		err = kv.env.openPairs(kvp)
+	}
+	return kvp, stats, err
 }
 
 // List retrieves dir files from all the hosts owning it
 func (kv *KV) List(dir []byte, opt *ReadOptions) ([]*KVPair, *ReadStats, error) {
-	return kv.kvs.List(dir, opt)
+	return kv.ListContext(context.Background(), dir, opt)
+}
+
+// ListContext retrieves dir files from all the hosts owning it with the given
+// context
+func (kv *KV) ListContext(ctx context.Context, dir []byte, opt *ReadOptions) ([]*KVPair, *ReadStats, error) {
+	kvps, stats, err := kv.kvs.ListContext(ctx, dir, opt)
+	if er := kv.env.openPairs(kvps...); er != nil {
+		return nil, stats, er
+	}
+	return kvps, stats, err
+}
+
+// Scan returns the keys in the range given by the options from the nodes owning
+// the prefix
+func (kv *KV) Scan(opts *ScanOptions) ([]*KVPair, *ReadStats, error) {
+	return kv.ScanContext(context.Background(), opts)
+}
+
+// ScanContext returns the keys in the range given by the options with the
+// given context
+func (kv *KV) ScanContext(ctx context.Context, opts *ScanOptions) ([]*KVPair, *ReadStats, error) {
+	kvps, stats, err := kv.kvs.ScanContext(ctx, opts)
+	if er := kv.env.openPairs(kvps...); er != nil {
+		return nil, stats, er
+	}
+	return kvps, stats, err
+}
+
+// QueryIndex returns the keys matching the query on a secondary index.  The
+// query is run by the active endpoint.  Encrypted values are not indexed
+func (kv *KV) QueryIndex(q *IndexQuery) ([]*KVPair, *ReadStats, error) {
+	return kv.QueryIndexContext(context.Background(), q)
+}
+
+// QueryIndexContext returns the keys matching the query on a secondary index
+// with the given context
+func (kv *KV) QueryIndexContext(ctx context.Context, q *IndexQuery) ([]*KVPair, *ReadStats, error) {
+	var resp *IndexResult
+	err := kv.request(ctx, nil, false, func(ctx context.Context, client FidiasRPCClient) (err error) {
+		resp, err = client.QueryIndexRPC(ctx, q)
+		return
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return resp.KVs, resp.Stats, kv.env.openPairs(resp.KVs...)
+}
+
+// Namespace is a client interface to a single namespace
+type Namespace struct {
+	name   string
+	client *Client
+	kvs    *KVS
+	env    *envelope
+}
+
+// Name returns the name of the namespace
+func (ns *Namespace) Name() string {
+	return ns.name
+}
+
+// KV returns a key-value client interface for the namespace.  Values are
+// encrypted and decrypted transparently if the keyring has a key for the
+// namespace.  Increment and append are not supported on encrypted namespaces
+func (ns *Namespace) KV() *KV {
+	return &KV{
+		namespace: ns.name,
+		endpoints: ns.client.endpoints,
+		kvs:       ns.kvs,
+		pool:      ns.client.pool,
+		env:       ns.env,
+		token:     ns.client.conf.Token,
+	}
+}
+
+// Blox returns the file client interface for the namespace.  Uploads are
+// registered to and, if the keyring has a key for the namespace, encrypted
+// with the namespace
+func (ns *Namespace) Blox() *Blox {
+	return &Blox{client: ns.client, namespace: ns.name, env: ns.env}
+}
+
+// Usage returns the usage of the namespace on each known endpoint keyed by
+// host
+func (ns *Namespace) Usage() (map[string]*NamespaceUsage, error) {
+	return ns.UsageContext(context.Background())
+}
+
+// UsageContext returns the usage of the namespace on each known endpoint with
+// the given context.  Each node accounts for the keys it stores.  Unavailable
+// endpoints are skipped
+func (ns *Namespace) UsageContext(ctx context.Context) (map[string]*NamespaceUsage, error) {
+	rctx := withNamespace(ctx, ns.name)
+
+	var (
+		out = make(map[string]*NamespaceUsage)
+		err = errNoEndpoints
+	)
+
+	for _, host := range ns.client.endpoints.hosts() {
+		var usage *NamespaceUsage
+		if usage, err = ns.client.trans.Usage(rctx, host); err != nil {
+			if er := ctx.Err(); er != nil {
+				return nil, contextError(er)
+			}
+			continue
+		}
+		out[host] = usage
+	}
+
+	if len(out) == 0 {
+		return nil, err
+	}
+	return out, nil
 }
 
 // Client is a fidias client.  It is used by non-partiicating client users
@@ -109,28 +464,67 @@ type Client struct {
 	// DHT aware block device - Get, Set, Remove
 	dev *phi.BlockDevice
 
+	// Transport and shard maps used by durable block devices
+	blockTrans BlockTransport
+	shards     ShardStore
+
 	// Client kvs interface
 	kvs *KVS
 
+	// Network transport used for reads and health checks
+	trans *NetTransport
+
 	// outbound grpc pool
 	pool *outPool
 
+	// Known fidias rpc endpoints shared by all KV interfaces
+	endpoints *endpointSet
+
 	// Initial node used
 	local hexatype.Node
+
+	shutdown chan struct{}
 }
 
-// NewClient inits a new fidias client with the config.
-func NewClient(conf *Config) (client *Client, err error) {
-	client = &Client{conf: conf, pool: newOutPool(300*time.Second, 45*time.Second)}
+// NewClient inits a new fidias client with the config.  The WAL advertise
+// host and peers are used as seed endpoints.  The first seed that responds is
+// used as the initial node
+func NewClient(conf *Config) (*Client, error) {
+	return NewClientContext(context.Background(), conf)
+}
+
+// NewClientContext inits a new fidias client with the config.  The context
+// bounds the time spent contacting the seed endpoints
+func NewClientContext(ctx context.Context, conf *Config) (client *Client, err error) {
+	client = &Client{
+		conf:     conf,
+		pool:     newOutPool(300*time.Second, 45*time.Second),
+		shutdown: make(chan struct{}),
+	}
 
 	c := conf.Phi.Hexalog
-	if c.AdvertiseHost == "" {
-		err = fmt.Errorf("WAL host not provided")
+
+	seeds := make([]string, 0, len(conf.Peers)+1)
+	if c.AdvertiseHost != "" {
+		seeds = append(seeds, c.AdvertiseHost)
+	}
+	seeds = append(seeds, conf.Peers...)
+	if len(seeds) == 0 {
+		err = fmt.Errorf("WAL host or peers not provided")
 		return
 	}
-
-	fidTrans := NewNetTransport(30*time.Second, 300*time.Second)
-	if client.local, err = fidTrans.LocalNode(c.AdvertiseHost); err != nil {
+	client.endpoints = newEndpointSet(seeds)
+
+	client.trans = NewNetTransport(30*time.Second, 300*time.Second)
+	client.trans.token = conf.Token
+	for _, host := range client.endpoints.hosts() {
+		if client.local, err = client.trans.LocalNodeContext(ctx, host); err == nil {
+			client.endpoints.setActive(host)
+			break
+		}
+		client.endpoints.markFailed(host, err)
+	}
+	if err != nil {
 		return
 	}
 
@@ -143,21 +537,119 @@ func NewClient(conf *Config) (client *Client, err error) {
 	// Init wal
 	ltrans := hexalog.NewNetTransport(30*time.Second, 300*time.Second)
 	client.wal = phi.NewHexalog(ltrans, c.Votes, c.Hasher)
-	client.kvs = NewKVS(conf.KVPrefix, client.wal, fidTrans, client.dht)
+	client.kvs = NewKVS(conf.KVPrefix, client.wal, client.trans, client.dht)
+	client.shards = NewKVShardStore(client.Namespace(BloxNamespace).KV())
+
+	if conf.HealthCheckInterval > 0 {
+		go client.healthCheck()
+	}
 
 	return client, nil
 }
 
-// KV returns a key-value client interface
+// KV returns a key-value client interface for the default namespace
 func (client *Client) KV() *KV {
 	kv := &KV{
-		walHost: client.conf.Phi.Hexalog.AdvertiseHost,
-		kvs:     client.kvs,
-		pool:    client.pool,
+		endpoints: client.endpoints,
+		kvs:       client.kvs,
+		pool:      client.pool,
+		env:       client.envelope(DefaultNamespace),
+		token:     client.conf.Token,
 	}
 	return kv
 }
 
+// Namespace returns a client interface for the named namespace.  Requests fail
+// with ErrNamespaceNotFound if the namespace is not configured on the cluster
+// and ErrPermissionDenied if the token is not allowed by its acl
+func (client *Client) Namespace(name string) *Namespace {
+	kvs := NewKVS(namespacePrefix(client.conf.KVPrefix, name), client.wal, client.trans, client.dht)
+	kvs.namespace = name
+
+	return &Namespace{name: name, client: client, kvs: kvs, env: client.envelope(name)}
+}
+
+// RegisterRoot registers an uploaded root to the namespace through a node so it
+// is tracked by the garbage collector.  The token of the client must be
+// allowed to write to the namespace
+func (client *Client) RegisterRoot(namespace string, root *RootRequest) error {
+	kv := client.Namespace(namespace).KV()
+	_, err := kv.write(context.Background(), root.ID, func(ctx context.Context, c FidiasRPCClient) (*WriteResponse, error) {
+		return c.RegisterRootRPC(ctx, root)
+	})
+	return err
+}
+
+// BeginUpload marks an upload of size bytes to the namespace in progress
+// through a node so the blocks it de-duplicates against are not swept by the
+// garbage collector.  The size is checked against the quota of the namespace
+func (client *Client) BeginUpload(namespace, uploadID string, size int64) error {
+	kv := client.Namespace(namespace).KV()
+	_, err := kv.write(context.Background(), pendingKey(uploadID), func(ctx context.Context, c FidiasRPCClient) (*WriteResponse, error) {
+		return c.BeginUploadRPC(ctx, &UploadRequest{ID: uploadID, Size: size})
+	})
+	return err
+}
+
+// EndUpload clears the marker of a failed or aborted upload to the namespace
+// through a node
+func (client *Client) EndUpload(namespace, uploadID string) error {
+	kv := client.Namespace(namespace).KV()
+	_, err := kv.write(context.Background(), pendingKey(uploadID), func(ctx context.Context, c FidiasRPCClient) (*WriteResponse, error) {
+		return c.EndUploadRPC(ctx, &UploadRequest{ID: uploadID})
+	})
+	return err
+}
+
+// envelope returns the envelope encrypting the namespace or nil if the keyring
+// has no key for it
+func (client *Client) envelope(namespace string) *envelope {
+	return newEnvelope(client.conf.Keyring, namespace)
+}
+
+// Endpoints returns all known rpc endpoints
+func (client *Client) Endpoints() []string {
+	return client.endpoints.hosts()
+}
+
+// Shutdown stops the health checks and closes outbound connections
+func (client *Client) Shutdown() {
+	close(client.shutdown)
+	client.pool.shutdown()
+	client.trans.Shutdown()
+}
+
+// healthCheck checks the health of all known endpoints every health check
+// interval until the client is shutdown
+func (client *Client) healthCheck() {
+	for {
+		select {
+		case <-client.shutdown:
+			return
+		case <-time.After(client.conf.HealthCheckInterval):
+		}
+
+		for _, host := range client.endpoints.hosts() {
+			if _, err := client.trans.LocalNode(host); err != nil {
+				log.Printf("[WARNING] Endpoint health check failed host=%s error='%v'", host, err)
+				client.endpoints.markFailed(host, err)
+			} else {
+				client.endpoints.markHealthy(host)
+			}
+		}
+	}
+}
+
+// DHT returns the distributed hash table client interface
+func (client *Client) DHT() phi.DHT {
+	return client.dht
+}
+
+// BlockDevice returns the cluster aware block device used by the client
+func (client *Client) BlockDevice() *phi.BlockDevice {
+	return client.dev
+}
+
 func (client *Client) initDHT() error {
 	dht, err := kelips.NewClient(client.local.Host())
 	if err == nil {
@@ -172,6 +664,7 @@ func (client *Client) initBlockDevice() {
 
 	opt := blox.DefaultNetClientOptions(c.Phi.HashFunc)
 	trans := blox.NewNetTransport(opt)
+	client.blockTrans = trans
 
 	client.dev = phi.NewBlockDevice(c.Phi.Replicas, c.Phi.HashFunc, hexatype.Node{}, nil, trans)
 	client.dev.RegisterDHT(client.dht)
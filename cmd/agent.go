@@ -2,9 +2,12 @@ package main
 
 import (
 	"crypto/sha256"
+	"expvar"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"path/filepath"
+	"time"
 
 	"github.com/hashicorp/memberlist"
 
@@ -44,15 +47,93 @@ func (cli *CLI) runAgent() error {
 		log.Println("[INFO] Bootstrap node")
 	}
 
+	if *gcInterval > 0 {
+		if err = startBlockGC(fid); err != nil {
+			return err
+		}
+	}
+
+	var scrubber *fidias.Scrubber
+	if *scrubInterval > 0 {
+		if scrubber, err = fid.NewScrubber(); err != nil {
+			return err
+		}
+		expvar.Publish("scrub", expvar.Func(func() interface{} { return scrubber.Status() }))
+		scrubber.Start(*scrubInterval)
+	}
+
+	var antiEntropy *fidias.AntiEntropy
+	if *antiEntropyInterval > 0 {
+		antiEntropy = fid.NewAntiEntropy()
+		expvar.Publish("anti-entropy", expvar.Func(func() interface{} { return antiEntropy.Status() }))
+		antiEntropy.Start(*antiEntropyInterval)
+	}
+
+	uploads, err := fid.MultipartUploads()
+	if err != nil {
+		return err
+	}
+
 	restHandler := &gateway.HTTPServer{
-		DHT:    fid.DHT(),
-		KVS:    fid.KVS(),
-		Device: fid.BlockDevice(),
+		DHT:         fid.DHT(),
+		KVS:         fid.KVS(),
+		Namespace:   fid.Namespace,
+		Authorize:   fid.Authorize,
+		Quotas:      fid,
+		Roots:       fid,
+		Device:      fid.BlockDevice(),
+		Durable:     fid.DurableDevice,
+		Scrubber:    scrubber,
+		AntiEntropy: antiEntropy,
+		Uploads:     uploads,
 	}
 
 	return http.ListenAndServe(*httpAddr, restHandler)
 }
 
+// startBlockGC runs block garbage collection in the background every gc
+// interval.  Only the agent holding the gc lease runs it.  The lease outlives a
+// missed interval so it moves to another agent only once the holder is gone
+func startBlockGC(fid *fidias.Fidias) error {
+	refs, err := fidias.ParseGCReferences(*gcRefs)
+	if err != nil {
+		return err
+	}
+	if len(refs) == 0 {
+		return fmt.Errorf("gc references required")
+	}
+
+	gc, err := fid.BlockGC()
+	if err != nil {
+		return err
+	}
+
+	opts := &fidias.GCOptions{References: refs, GracePeriod: *gcGrace, DryRun: *gcDryRun}
+	go func() {
+		for range time.Tick(*gcInterval) {
+			ok, err := gc.Lease(*grpcAdvAddr, 2**gcInterval)
+			if err != nil {
+				log.Printf("[ERROR] Block GC lease failed error='%v'", err)
+				continue
+			}
+			if !ok {
+				continue
+			}
+
+			report, err := gc.Run(opts)
+			if err != nil {
+				log.Printf("[ERROR] Block GC failed error='%v'", err)
+				continue
+			}
+			log.Printf("[INFO] Block GC roots=%d collected=%d deferred=%d pending=%d marked=%d adopted=%d blocks=%d reclaimed=%d dry-run=%v runtime=%v",
+				report.Roots, report.Collected, report.Deferred, report.Pending, report.Marked, report.Adopted, report.Blocks,
+				report.ReclaimedBytes, report.DryRun, report.Runtime)
+		}
+	}()
+
+	return nil
+}
+
 func initAgentConf() *fidias.Config {
 	if *dataDir == "" {
 		log.Fatal("[ERROR] Data directory required!")
@@ -74,6 +155,25 @@ func initAgentConf() *fidias.Config {
 	c.Phi.Hexalog = hexalog.DefaultConfig(*grpcAdvAddr)
 	c.Phi.Hexalog.Votes = 2
 
+	if *quotaKeys > 0 || *quotaBytes > 0 {
+		c.DefaultQuota = &fidias.Quota{MaxKeys: *quotaKeys, MaxBytes: *quotaBytes}
+	}
+
+	if c.Indexes, err = parseIndexes(*indexes); err != nil {
+		log.Fatal("[ERROR]", err)
+	}
+
+	if *namespacesFile != "" {
+		if c.Namespaces, err = fidias.LoadNamespaces(*namespacesFile); err != nil {
+			log.Fatal("[ERROR]", err)
+		}
+	}
+	c.Token = *token
+
+	if c.Compression, err = fidias.ParseCompression(*compression); err != nil {
+		log.Fatal("[ERROR]", err)
+	}
+
 	c.Phi.SetHashFunc(sha256.New)
 	return c
 }
@@ -1,13 +1,21 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"encoding/hex"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/hexablock/blox/block"
 	"github.com/hexablock/fidias"
+	kelips "github.com/hexablock/go-kelips"
 	"github.com/hexablock/log"
+	"github.com/hexablock/phi"
 )
 
 var (
@@ -32,14 +40,69 @@ var (
 	isAgent   = flag.Bool("agent", false, "Run the agent")
 	debug     = flag.Bool("debug", false, "Turn debug mode on")
 	isVersion = flag.Bool("version", false, "Show version")
+
+	// Default namespace quota.  Zero is unlimited
+	quotaKeys  = flag.Int64("quota-keys", 0, "Maximum keys per namespace")
+	quotaBytes = flag.Int64("quota-bytes", 0, "Maximum key and block bytes per namespace")
+
+	// Secondary indexes maintained by the agent
+	indexes = flag.String("indexes", "", "Secondary indexes as name:prefix:path,...")
+
+	// Namespaces and access
+	namespacesFile = flag.String("namespaces", os.Getenv("FID_NAMESPACES"), "Json file of named namespaces and their acl")
+	token          = flag.String("token", os.Getenv("FID_TOKEN"), "Token sent with requests.  Agents use it as the cluster token")
+
+	// Client output
+	outFormat = flag.String("format", "json", "Client output format: json, table or raw")
+	showStats = flag.Bool("show-stats", false, "Show read and write stats for client commands")
+	timeout   = flag.Duration("timeout", 0, "Client command timeout e.g. 500ms.  Zero waits indefinitely")
+	namespace = flag.String("namespace", os.Getenv("FID_NAMESPACE"), "Namespace client commands run in")
+	keyring   = flag.String("keyring", os.Getenv("FID_KEYRING"), "Json file of namespace encryption keys")
+
+	// Range scans
+	scanReverse  = flag.Bool("reverse", false, "Scan keys in descending order")
+	scanLimit    = flag.Int("limit", 0, "Maximum keys returned by a scan.  Zero is unlimited")
+	scanKeysOnly = flag.Bool("keys-only", false, "Scan keys without their values")
+
+	// File uploads
+	durability   = flag.String("durability", "", "File durability as <replicas> or <data>:<parity> shards")
+	showProgress = flag.Bool("progress", false, "Show file transfer progress on stderr")
+	partSize     = flag.Uint64("part-size", 0, "Upload files in parts of this many MB.  Zero uploads in a single stream")
+
+	// Codec compressing kv values on the agent and file uploads on the client
+	compression = flag.String("compression", "", "Compression codec: none, snappy or zstd")
+
+	// Block garbage collection.  The agent holding the gc lease collects
+	// periodically if the interval is set
+	gcInterval = flag.Duration("gc-interval", 0, "Block garbage collection interval.  Zero disables it")
+	gcGrace    = flag.Duration("gc-grace", 24*time.Hour, "Age of unreferenced roots before they are collected")
+	gcRefs     = flag.String("gc-refs", "", "Prefixes referencing roots as namespace:prefix,...")
+	gcDryRun   = flag.Bool("dry-run", false, "Report what gc would collect without removing anything")
+
+	// Interval between verifying the blocks stored on the agent
+	scrubInterval = flag.Duration("scrub-interval", 0, "Block scrub interval.  Zero disables it")
+
+	// Interval between comparing the kv stores of the agent with its peers
+	antiEntropyInterval = flag.Duration("anti-entropy-interval", 0, "KV anti-entropy interval.  Zero disables it")
+
+	// Directory creation
+	dirMode  = flag.String("mode", "0755", "Directory permission bits used by mkdir")
+	dirOwner = flag.String("owner", os.Getenv("USER"), "Directory owner used by mkdir")
 )
 
 // CLI is the command line interface
 type CLI struct {
 }
 
+// cmdResult is the result of a single client command
+type cmdResult struct {
+	data   interface{}
+	rstats *fidias.ReadStats
+	wstats *fidias.WriteStats
+}
+
 func (cli *CLI) runClient(args []string) error {
-	if len(args) < 2 {
+	if len(args) == 0 || (len(args) < 2 && args[0] != "shell" && args[0] != "dedupe") {
 		flag.Usage()
 		os.Exit(1)
 	}
@@ -49,16 +112,55 @@ func (cli *CLI) runClient(args []string) error {
 		return err
 	}
 
+	if args[0] == "shell" {
+		return newShell(client).run()
+	}
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	res, err := runCommand(ctx, client, args)
+	if err != nil {
+		return err
+	}
+
+	if res.data != nil {
+		if err = writeOutput(os.Stdout, *outFormat, res.data); err != nil {
+			return err
+		}
+	}
+
+	if *showStats {
+		writeStats(os.Stderr, res.rstats, res.wstats)
+	}
+
+	return nil
+}
+
+// runCommand runs a single client command.  args[0] is the command followed by
+// its arguments
+func runCommand(ctx context.Context, client *fidias.Client, args []string) (*cmdResult, error) {
+	if len(args) == 0 || (len(args) < 2 && args[0] != "dedupe") {
+		return nil, fmt.Errorf("not enough args")
+	}
+
 	var (
-		kvclient = client.KV()
+		kvclient = client.Namespace(*namespace).KV()
 		cmd      = args[0]
-		key      = []byte(args[1])
+		key      []byte
 		data     interface{}
+		rstats   *fidias.ReadStats
+		wstats   *fidias.WriteStats
+		err      error
 	)
+	// All commands but dedupe take a key or root id
+	if len(args) > 1 {
+		key = []byte(args[1])
+	}
 
 	switch cmd {
 	case "get":
-		data, _, err = kvclient.Get(key, &fidias.ReadOptions{})
+		data, rstats, err = kvclient.GetContext(ctx, key, &fidias.ReadOptions{})
 
 	case "set":
 		if len(args) != 3 {
@@ -66,35 +168,359 @@ func (cli *CLI) runClient(args []string) error {
 			break
 		}
 
-		kvp := fidias.NewKVPair(key, []byte(args[2]))
+		var value []byte
+		if value, err = readValue(args[2]); err != nil {
+			break
+		}
+
+		kvp := fidias.NewKVPair(key, value)
+		wo := fidias.DefaultWriteOptions()
+		data, wstats, err = kvclient.SetContext(ctx, kvp, wo)
+
+	case "cas":
+		if len(args) != 4 {
+			err = fmt.Errorf("not enough args")
+			break
+		}
+
+		var mod, value []byte
+		if mod, err = hex.DecodeString(args[2]); err != nil {
+			break
+		}
+		if value, err = readValue(args[3]); err != nil {
+			break
+		}
+
+		kvp := fidias.NewKVPair(key, value)
 		wo := fidias.DefaultWriteOptions()
-		data, _, err = kvclient.Set(kvp, wo)
+		data, wstats, err = kvclient.CASetContext(ctx, kvp, mod, wo)
 
 	case "rm":
 		wo := fidias.DefaultWriteOptions()
-		_, err = kvclient.Remove(key, wo)
+		wstats, err = kvclient.RemoveContext(ctx, key, wo)
+
+	case "mkdir":
+		var mode uint64
+		if mode, err = strconv.ParseUint(*dirMode, 8, 32); err != nil {
+			break
+		}
+
+		dir := fidias.NewDirKVPair(key, os.FileMode(mode))
+		dir.Owner = *dirOwner
+		if dir.Metadata, err = parseMetadata(args[2:]); err != nil {
+			break
+		}
+
+		wo := fidias.DefaultWriteOptions()
+		data, wstats, err = kvclient.MkdirContext(ctx, dir, wo)
+
+	case "rm-tree":
+		wo := fidias.DefaultWriteOptions()
+		_, err = kvclient.RemoveTreeContext(ctx, key, wo)
+
+	case "cas-rm":
+		if len(args) != 3 {
+			err = fmt.Errorf("modification not specified")
+			break
+		}
+
+		var mod []byte
+		if mod, err = hex.DecodeString(args[2]); err != nil {
+			break
+		}
+
+		wo := fidias.DefaultWriteOptions()
+		wstats, err = kvclient.CARemoveContext(ctx, key, mod, wo)
 
 	case "ls":
 		if len(args) != 2 {
 			err = fmt.Errorf("prefix not specified")
 			break
 		}
-		data, _, err = kvclient.List([]byte(args[1]), &fidias.ReadOptions{})
+		data, rstats, err = kvclient.ListContext(ctx, []byte(args[1]), &fidias.ReadOptions{})
+
+	case "scan":
+		opts := &fidias.ScanOptions{Prefix: key, Reverse: *scanReverse, Limit: int32(*scanLimit), KeysOnly: *scanKeysOnly}
+		if len(args) > 2 {
+			opts.Start = []byte(args[2])
+		}
+		if len(args) > 3 {
+			opts.End = []byte(args[3])
+		}
+		data, rstats, err = kvclient.ScanContext(ctx, opts)
+
+	case "query":
+		q := &fidias.IndexQuery{Index: args[1]}
+		switch len(args) {
+		case 2:
+		case 3:
+			q.Value = fidias.IndexValue(args[2])
+		case 4:
+			if args[2] != "" {
+				q.Start = fidias.IndexValue(args[2])
+			}
+			if args[3] != "" {
+				q.End = fidias.IndexValue(args[3])
+			}
+		default:
+			err = fmt.Errorf("too many args")
+		}
+		if err != nil {
+			break
+		}
+		data, rstats, err = kvclient.QueryIndexContext(ctx, q)
+
+	case "incr":
+		delta := int64(1)
+		if len(args) > 2 {
+			if delta, err = strconv.ParseInt(args[2], 10, 64); err != nil {
+				break
+			}
+		}
+
+		wo := fidias.DefaultWriteOptions()
+		data, wstats, err = kvclient.IncrContext(ctx, key, delta, wo)
+
+	case "append":
+		if len(args) != 3 {
+			err = fmt.Errorf("not enough args")
+			break
+		}
+
+		var value []byte
+		if value, err = readValue(args[2]); err != nil {
+			break
+		}
+
+		wo := fidias.DefaultWriteOptions()
+		data, wstats, err = kvclient.AppendContext(ctx, key, value, wo)
+
+	case "put-file":
+		data, err = putFile(client.Namespace(*namespace).Blox(), args[1])
+
+	case "get-file":
+		out := "-"
+		if len(args) > 2 {
+			out = args[2]
+		}
+		err = getFile(client.Namespace(*namespace).Blox(), args[1], out)
+
+	case "stat-file":
+		var id []byte
+		if id, err = hex.DecodeString(args[1]); err == nil {
+			data, err = client.Namespace(*namespace).Blox().Stat(id)
+		}
+
+	case "rm-file":
+		var id []byte
+		if id, err = hex.DecodeString(args[1]); err == nil {
+			err = client.Namespace(*namespace).Blox().Delete(id)
+		}
+
+	case "gc":
+		var refs []*fidias.GCReference
+		if refs, err = fidias.ParseGCReferences(args[1]); err == nil {
+			opts := &fidias.GCOptions{References: refs, GracePeriod: *gcGrace, DryRun: *gcDryRun}
+			data, err = client.Namespace(*namespace).Blox().GC(opts)
+		}
+
+	case "dedupe":
+		data, err = client.Namespace(*namespace).Blox().DedupeReport()
+
+	case "snapshot":
+		var root []byte
+		if root, err = client.Namespace(*namespace).Blox().SnapshotTree(key); err == nil {
+			data = map[string]string{"Root": hex.EncodeToString(root)}
+		}
+
+	case "restore":
+		if len(args) != 3 {
+			err = fmt.Errorf("not enough args")
+			break
+		}
+		var (
+			root []byte
+			n    int
+		)
+		if root, err = hex.DecodeString(args[1]); err != nil {
+			break
+		}
+		if n, err = client.Namespace(*namespace).Blox().RestoreTree(root, []byte(args[2])); err == nil {
+			data = map[string]int{"Restored": n}
+		}
+
+	case "diff":
+		if len(args) != 3 {
+			err = fmt.Errorf("not enough args")
+			break
+		}
+		var a, b []byte
+		if a, err = hex.DecodeString(args[1]); err != nil {
+			break
+		}
+		if b, err = hex.DecodeString(args[2]); err != nil {
+			break
+		}
+		data, err = client.Namespace(*namespace).Blox().DiffSnapshots(a, b)
+
+	case "dht":
+		data, err = runDHT(client.DHT(), args[1:])
 
 	default:
 		err = fmt.Errorf("command not found: %s", args[0])
 	}
 
+	if err != nil {
+		return nil, err
+	}
+
+	return &cmdResult{data: data, rstats: rstats, wstats: wstats}, nil
+}
+
+// commandContext returns the context for a single client command bounded by
+// the timeout flag if set
+func commandContext() (context.Context, context.CancelFunc) {
+	if *timeout > 0 {
+		return context.WithTimeout(context.Background(), *timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// runDHT runs the dht sub-commands.  args[0] is the sub-command
+func runDHT(dht phi.DHT, args []string) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, fmt.Errorf("key not specified")
+	}
+
+	key := []byte(args[1])
+
+	switch args[0] {
+	case "lookup":
+		return dht.Lookup(key)
+
+	case "insert":
+		if len(args) != 3 {
+			return nil, fmt.Errorf("host not specified")
+		}
+		return nil, dht.Insert(key, kelips.NewTupleHost(args[2]))
+
+	}
+
+	return nil, fmt.Errorf("dht command not found: %s", args[0])
+}
+
+// bloxOptions returns the file transfer options from the flags
+func bloxOptions() (*fidias.BloxOptions, error) {
+	opts := &fidias.BloxOptions{}
+	if *durability != "" {
+		policy, err := fidias.ParseDurability(*durability)
+		if err != nil {
+			return nil, err
+		}
+		opts.Durability = policy
+	}
+	c, err := fidias.ParseCompression(*compression)
+	if err != nil {
+		return nil, err
+	}
+	opts.Compression = c
+	if *showProgress {
+		opts.Progress = func(done, total uint64) {
+			if total > 0 {
+				fmt.Fprintf(os.Stderr, "\r%d/%d bytes", done, total)
+			} else {
+				fmt.Fprintf(os.Stderr, "\r%d bytes", done)
+			}
+		}
+	}
+	return opts, nil
+}
+
+// putFile shards the file at the given path onto the cluster and returns the
+// index block.  A path of '-' reads from stdin
+func putFile(bx *fidias.Blox, path string) (*block.IndexBlock, error) {
+	opts, err := bloxOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	if *partSize > 0 && path != "-" {
+		return putFileMultipart(bx, path, opts)
+	}
+
+	rd, err := openInput(path)
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+
+	id, stats, err := bx.Put(rd, opts)
+	if *showProgress {
+		fmt.Fprintln(os.Stderr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if *showStats {
+		d := stats.Dedupe
+		fmt.Fprintf(os.Stderr, "logical=%d physical=%d saved=%d duplicate-blocks=%d/%d\n",
+			d.LogicalBytes, d.PhysicalBytes, d.SavedBytes(), d.DuplicateBlocks, d.Blocks)
+	}
+
+	return bx.Stat(id)
+}
+
+// putFileMultipart uploads the file at the given path in parts of -part-size
+func putFileMultipart(bx *fidias.Blox, path string, opts *fidias.BloxOptions) (*block.IndexBlock, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	stat, err := fh.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	return bx.PutMultipart(fh, stat.Size(), *partSize*1024*1024, opts)
+}
+
+// getFile assembles the file with the given hex root id from the cluster and
+// writes it to the path.  A path of '-' writes to stdout
+func getFile(bx *fidias.Blox, rootID, path string) error {
+	id, err := hex.DecodeString(rootID)
 	if err != nil {
 		return err
 	}
 
-	if data != nil {
-		b, _ := json.MarshalIndent(data, "", "  ")
-		os.Stdout.Write(b)
-		os.Stdout.Write([]byte("\n"))
+	opts, err := bloxOptions()
+	if err != nil {
+		return err
 	}
-	return nil
+
+	rd, err := bx.Get(id, opts)
+	if err != nil {
+		return err
+	}
+	defer rd.Close()
+
+	var out io.Writer = os.Stdout
+	if path != "-" {
+		fh, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer fh.Close()
+		out = fh
+	}
+
+	_, err = io.Copy(out, rd)
+	if *showProgress {
+		fmt.Fprintln(os.Stderr)
+	}
+	return err
 }
 
 func (cli *CLI) isVersion() {
@@ -135,13 +561,25 @@ func (cli *CLI) Run() {
 
 func setupClient() (*fidias.Client, error) {
 	conf := fidias.DefaultConfig()
-	// Grpc address
+	// Grpc addresses.  The first is used as the initial node and the remaining
+	// as failover endpoints
 	if *joinAddr != "" {
-		conf.Phi.Hexalog.AdvertiseHost = *joinAddr
+		peers := strings.Split(*joinAddr, ",")
+		conf.Phi.Hexalog.AdvertiseHost = peers[0]
+		conf.Peers = peers[1:]
 	} else {
 		// default
 		conf.Phi.Hexalog.AdvertiseHost = *grpcAdvAddr
 	}
 
+	if *keyring != "" {
+		kr, err := fidias.LoadKeyring(*keyring)
+		if err != nil {
+			return nil, err
+		}
+		conf.Keyring = kr
+	}
+	conf.Token = *token
+
 	return fidias.NewClient(conf)
 }
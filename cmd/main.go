@@ -18,13 +18,80 @@ Agent:
     -rpc-addr <address:port>        GRPC advertise address
     -join <peer1,peer2>             List of peers to join
     -retry-join <peer1,peers>       List of peers to retry joins
+    -quota-keys <n>                 Maximum keys per namespace
+    -quota-bytes <n>                Maximum key and block bytes per namespace
+    -indexes <name:prefix:path,...> Secondary indexes on json values
+    -namespaces <path>              Json file of named namespaces and their acl
+    -token <token>                  Cluster token shared by all agents
+    -compression <codec>            Compress large kv values with snappy or zstd
+    -gc-interval <duration>         Block garbage collection interval
+    -gc-refs <ns:prefix,...>        Prefixes whose values reference file roots
+    -gc-grace <duration>            Age of unreferenced roots before collection
+    -scrub-interval <duration>      Interval between verifying local blocks
+    -anti-entropy-interval <duration>
+                                    Interval between repairing kv keys that are
+                                    behind their replicas
 
 Client (experimental):
 
-  set <key> <value>    Set a key-value pair
-  get <key>            Get a key
-  rm  <key>            Remove a key
-  ls  <prefix>         List a prefix
+  fid [ -format json|table|raw ] [ -show-stats ] [ -timeout <duration> ] [ -namespace <name> ]
+      [ -keyring <path> ] [ -token <token> ] <command> [ args ]
+
+  set     <key> <value>           Set a key-value pair
+  cas     <key> <mod> <value>     Set a key-value pair if mod is the current modification
+  get     <key>                   Get a key
+  rm      <key>                   Remove a key.  Directories must be empty
+  rm-tree <dir>                   Remove a directory and all of its contents
+  mkdir   <dir> [ key=value ... ] Create a directory with the metadata.  The
+                                  -mode and -owner flags set its mode and owner
+  cas-rm  <key> <mod>             Remove a key if mod is the current modification
+  ls      <prefix>                List a prefix
+  scan    <prefix> [ <start> [ <end> ] ]
+                                  Scan keys with the prefix from start up to end.
+                                  The -reverse, -limit and -keys-only flags
+                                  control the order, count and values returned
+  query   <index> [ <value> | <start> <end> ]
+                                  Query a secondary index by value or range.
+                                  An empty start or end is unbounded
+  incr    <key> [ delta ]         Atomically add delta (default 1) to an integer key
+  append  <key> <value>           Atomically append the value to a key
+
+  put-file <path>                 Upload a file returning the index block.  The
+                                  -durability flag sets replicas or data:parity
+                                  erasure coding shards and -compression the
+                                  snappy or zstd codec.  The -part-size flag
+                                  uploads the file in parts concurrently
+  get-file <id> [ path ]          Download a file by its root id
+  stat-file <id>                  Show the index block of a file
+  rm-file <id>                    Delete a file leaving its blocks to gc
+  gc      <ns:prefix,...>         Remove files and snapshots not referenced by
+                                  values under the prefixes.  Unreferenced roots
+                                  older than -gc-grace are marked and removed a
+                                  -gc-grace later.  The -dry-run flag only
+                                  reports what is collected
+  dedupe                          Show the cluster-wide de-duplication savings
+                                  of file uploads
+  snapshot <dir>                  Snapshot a directory tree returning its root id
+  restore <id> <dir>              Restore a snapshot to a new directory
+  diff    <id> <id>               Show the keys changed between two snapshots
+
+  The -progress flag shows file transfer progress on stderr
+
+  The -token flag is sent with each request and checked against the acl of the
+  namespace
+
+  The -keyring flag loads a json file mapping namespace names to hex encoded
+  32 byte keys.  Values and files of namespaces with a key are encrypted on the
+  client.  Incr and append are not supported on encrypted namespaces
+
+  dht lookup <key>                Lookup the nodes for a key
+  dht insert <key> <host>         Insert a key-host tuple
+
+  shell                           Start an interactive shell.  In addition to the
+                                  above it supports cd, pwd, history and exit
+
+  A value of '-' is read from stdin and a value of '@<path>' is read from the
+  file at path.  A path of '-' uses stdin or stdout
 
 `)
 
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hexablock/blox/block"
+	"github.com/hexablock/fidias"
+	"github.com/hexablock/hexatype"
+)
+
+// writeOutput writes the data to w in the requested format.  Supported formats
+// are json, table and raw.  Raw writes the value bytes for key-value pairs and
+// one entry per line for lists
+func writeOutput(w io.Writer, format string, data interface{}) error {
+	switch format {
+	case "json":
+		b, err := json.MarshalIndent(data, "", "  ")
+		if err != nil {
+			return err
+		}
+		w.Write(b)
+		w.Write([]byte("\n"))
+		return nil
+
+	case "table":
+		return writeTable(w, data)
+
+	case "raw":
+		return writeRaw(w, data)
+
+	}
+
+	return fmt.Errorf("invalid output format: %s", format)
+}
+
+func writeTable(w io.Writer, data interface{}) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	switch d := data.(type) {
+	case *fidias.KVPair:
+		fmt.Fprintln(tw, "KEY\tSIZE\tFLAGS\tHEIGHT\tMODTIME\tMODIFICATION")
+		writeKVPairRow(tw, d)
+
+	case []*fidias.KVPair:
+		fmt.Fprintln(tw, "KEY\tSIZE\tFLAGS\tHEIGHT\tMODTIME\tMODIFICATION")
+		for _, kvp := range d {
+			writeKVPairRow(tw, kvp)
+		}
+
+	case []*hexatype.Node:
+		fmt.Fprintln(tw, "HOST\tID\tHEARTBEATS")
+		for _, n := range d {
+			fmt.Fprintf(tw, "%s\t%x\t%d\n", n.Host(), n.ID, n.Heartbeats)
+		}
+
+	case *block.IndexBlock:
+		fmt.Fprintln(tw, "ID\tSIZE\tBLOCK SIZE\tBLOCKS")
+		fmt.Fprintf(tw, "%x\t%d\t%d\t%d\n", d.ID(), d.FileSize(), d.BlockSize(), d.BlockCount())
+
+	default:
+		return fmt.Errorf("table format not supported for type: %T", data)
+	}
+
+	return tw.Flush()
+}
+
+func writeKVPairRow(w io.Writer, kvp *fidias.KVPair) {
+	fmt.Fprintf(w, "%s\t%d\t%s\t%d\t%s\t%x\n", kvp.Key, len(kvp.Value),
+		kvpFlagString(kvp), kvp.Height, time.Unix(0, int64(kvp.ModTime)).Format(time.RFC3339), kvp.Modification)
+}
+
+func kvpFlagString(kvp *fidias.KVPair) string {
+	if kvp.IsDir() {
+		return "dir"
+	}
+	return fmt.Sprintf("%d", kvp.Flags)
+}
+
+func writeRaw(w io.Writer, data interface{}) error {
+	switch d := data.(type) {
+	case *fidias.KVPair:
+		w.Write(d.Value)
+
+	case []*fidias.KVPair:
+		for _, kvp := range d {
+			fmt.Fprintf(w, "%s\n", kvp.Key)
+		}
+
+	case []*hexatype.Node:
+		for _, n := range d {
+			fmt.Fprintln(w, n.Host())
+		}
+
+	case *block.IndexBlock:
+		fmt.Fprintln(w, hex.EncodeToString(d.ID()))
+
+	default:
+		return fmt.Errorf("raw format not supported for type: %T", data)
+	}
+
+	return nil
+}
+
+// writeStats writes the non-nil read and/or write stats to w
+func writeStats(w io.Writer, rstats *fidias.ReadStats, wstats *fidias.WriteStats) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	if rstats != nil {
+		nodes := make([]string, 0, len(rstats.Nodes))
+		for _, n := range rstats.Nodes {
+			nodes = append(nodes, fmt.Sprintf("%s/%x", n.Host(), n.ID))
+		}
+
+		fmt.Fprintf(tw, "Response time\t%v\n", time.Duration(rstats.RespTime))
+		fmt.Fprintf(tw, "Group\t%d\n", rstats.Group)
+		fmt.Fprintf(tw, "Priority\t%d\n", rstats.Priority)
+		fmt.Fprintf(tw, "Nodes\t%s\n", strings.Join(nodes, ","))
+	}
+
+	if wstats != nil {
+		hosts := make([]string, 0, len(wstats.Participants))
+		for _, p := range wstats.Participants {
+			hosts = append(hosts, fmt.Sprintf("%s/%x", p.Host, p.ID))
+		}
+
+		fmt.Fprintf(tw, "Ballot time\t%v\n", time.Duration(wstats.BallotTime))
+		fmt.Fprintf(tw, "Apply time\t%v\n", time.Duration(wstats.ApplyTime))
+		fmt.Fprintf(tw, "Participants\t%s\n", strings.Join(hosts, ","))
+	}
+
+	tw.Flush()
+}
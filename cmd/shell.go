@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh/terminal"
+
+	"github.com/hexablock/fidias"
+)
+
+// Commands whose first argument is a key and are resolved relative to the
+// current directory
+var shellKeyCommands = map[string]bool{
+	"get":     true,
+	"set":     true,
+	"cas":     true,
+	"rm":      true,
+	"rm-tree": true,
+	"cas-rm":  true,
+	"ls":      true,
+	"scan":    true,
+	"incr":    true,
+	"append":  true,
+	"mkdir":   true,
+}
+
+// lineReader reads a single line of input
+type lineReader interface {
+	ReadLine() (string, error)
+}
+
+// scanReader is a lineReader used when stdin is not a terminal
+type scanReader struct {
+	*bufio.Scanner
+}
+
+func (sr *scanReader) ReadLine() (string, error) {
+	if sr.Scan() {
+		return sr.Text(), nil
+	}
+	if err := sr.Err(); err != nil {
+		return "", err
+	}
+	return "", io.EOF
+}
+
+// shell is an interactive client session.  It keeps a single client and its
+// connections open for all commands run in the session
+type shell struct {
+	client *fidias.Client
+	kv     *fidias.KV
+
+	// Current kv directory.  Empty is the root
+	cwd string
+
+	// Commands run in this session
+	history []string
+
+	in  lineReader
+	out io.Writer
+}
+
+func newShell(client *fidias.Client) *shell {
+	return &shell{
+		client:  client,
+		kv:      client.Namespace(*namespace).KV(),
+		history: make([]string, 0),
+	}
+}
+
+// run starts the read-eval-print loop until exit or EOF is received
+func (sh *shell) run() error {
+	fd := int(os.Stdin.Fd())
+
+	if terminal.IsTerminal(fd) {
+		state, err := terminal.MakeRaw(fd)
+		if err != nil {
+			return err
+		}
+		defer terminal.Restore(fd, state)
+
+		term := terminal.NewTerminal(struct {
+			io.Reader
+			io.Writer
+		}{os.Stdin, os.Stdout}, sh.prompt())
+		term.AutoCompleteCallback = sh.complete
+
+		sh.in = term
+		sh.out = term
+
+	} else {
+		sh.in = &scanReader{bufio.NewScanner(os.Stdin)}
+		sh.out = os.Stdout
+	}
+
+	for {
+		line, err := sh.in.ReadLine()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			continue
+		}
+		sh.history = append(sh.history, line)
+
+		if args[0] == "exit" || args[0] == "quit" {
+			return nil
+		}
+
+		start := time.Now()
+		if err = sh.exec(args); err != nil {
+			fmt.Fprintf(sh.out, "Error: %v\n", err)
+		}
+		fmt.Fprintf(sh.out, "(%v)\n", time.Since(start))
+
+		if term, ok := sh.in.(*terminal.Terminal); ok {
+			term.SetPrompt(sh.prompt())
+		}
+	}
+}
+
+// exec runs a single shell command
+func (sh *shell) exec(args []string) error {
+	switch args[0] {
+	case "pwd":
+		fmt.Fprintln(sh.out, "/"+sh.cwd)
+		return nil
+
+	case "cd":
+		dir := ""
+		if len(args) > 1 {
+			dir = sh.resolve(args[1])
+		}
+		return sh.chdir(dir)
+
+	case "history":
+		for i, h := range sh.history {
+			fmt.Fprintf(sh.out, "%4d  %s\n", i+1, h)
+		}
+		return nil
+
+	case "help":
+		usage()
+		return nil
+
+	case "ls":
+		// Default to the current directory
+		if len(args) == 1 {
+			args = append(args, ".")
+		}
+		// Top level keys are spread across nodes so the root cannot be listed
+		if sh.resolve(args[1]) == "" {
+			return fmt.Errorf("ls requires a directory at the root")
+		}
+
+	}
+
+	if shellKeyCommands[args[0]] && len(args) > 1 {
+		args[1] = sh.resolve(args[1])
+		if args[1] == "" {
+			return fmt.Errorf("key required")
+		}
+	}
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	res, err := runCommand(ctx, sh.client, args)
+	if err != nil {
+		return err
+	}
+
+	// Buffer the output to make sure it is newline terminated
+	buf := bytes.NewBuffer(nil)
+	if res.data != nil {
+		if err = writeOutput(buf, *outFormat, res.data); err != nil {
+			return err
+		}
+	}
+	if *showStats {
+		writeStats(buf, res.rstats, res.wstats)
+	}
+
+	if buf.Len() > 0 && buf.Bytes()[buf.Len()-1] != '\n' {
+		buf.WriteByte('\n')
+	}
+	_, err = sh.out.Write(buf.Bytes())
+
+	return err
+}
+
+// chdir changes the current directory to dir.  dir must be an existing
+// directory or empty for the root
+func (sh *shell) chdir(dir string) error {
+	if dir != "" {
+		kvp, _, err := sh.kv.Get([]byte(dir), &fidias.ReadOptions{})
+		if err != nil {
+			return err
+		}
+		if !kvp.IsDir() {
+			return fmt.Errorf("not a directory: %s", dir)
+		}
+	}
+
+	sh.cwd = dir
+	return nil
+}
+
+// resolve returns the absolute key for the given path relative to the current
+// directory.  Keys do not have a leading slash
+func (sh *shell) resolve(p string) string {
+	if !strings.HasPrefix(p, "/") {
+		p = path.Join("/", sh.cwd, p)
+	}
+	return strings.TrimPrefix(path.Clean(p), "/")
+}
+
+func (sh *shell) prompt() string {
+	return fmt.Sprintf("fid:/%s> ", sh.cwd)
+}
+
+// complete completes the last word on the line with the keys in its directory
+// using a list request
+func (sh *shell) complete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+
+	i := strings.LastIndex(line[:pos], " ")
+	if i < 0 {
+		// Command completion is not supported
+		return "", 0, false
+	}
+	word := line[i+1 : pos]
+
+	// Split the word into the dir to list and the partial name
+	var dir, partial string
+	if j := strings.LastIndex(word, "/"); j >= 0 {
+		dir, partial = word[:j+1], word[j+1:]
+	} else {
+		partial = word
+	}
+
+	absDir := sh.resolve(dir)
+	if absDir == "" {
+		// Root listing is not supported
+		return "", 0, false
+	}
+
+	ls, _, err := sh.kv.List([]byte(absDir), &fidias.ReadOptions{})
+	if err != nil {
+		return "", 0, false
+	}
+
+	matches := make([]string, 0, len(ls))
+	for _, kvp := range ls {
+		name := path.Base(string(kvp.Key))
+		if !strings.HasPrefix(name, partial) {
+			continue
+		}
+		if kvp.IsDir() {
+			name += "/"
+		}
+		matches = append(matches, name)
+	}
+
+	if len(matches) == 0 {
+		return "", 0, false
+	}
+
+	completed := dir + commonPrefix(matches)
+	newLine := line[:i+1] + completed + line[pos:]
+
+	return newLine, i + 1 + len(completed), true
+}
+
+// commonPrefix returns the longest common prefix of all the strings
+func commonPrefix(strs []string) string {
+	prefix := strs[0]
+	for _, s := range strs[1:] {
+		for !strings.HasPrefix(s, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
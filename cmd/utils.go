@@ -2,8 +2,14 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
+	"os"
 	"strconv"
+	"strings"
+
+	"github.com/hexablock/fidias"
 )
 
 // given a advertise and bind address return the advertise addr or an error
@@ -47,3 +53,66 @@ func parseAddr(host string) (string, int) {
 	i, _ := strconv.ParseInt(port, 10, 32)
 	return host, int(i)
 }
+
+// readValue returns the value for a command line argument.  A value of '-'
+// reads from stdin and a value prefixed with '@' reads the named file,
+// otherwise the argument itself is the value
+func readValue(arg string) ([]byte, error) {
+	if arg == "-" || strings.HasPrefix(arg, "@") {
+		rd, err := openInput(strings.TrimPrefix(arg, "@"))
+		if err != nil {
+			return nil, err
+		}
+		defer rd.Close()
+
+		return ioutil.ReadAll(rd)
+	}
+
+	return []byte(arg), nil
+}
+
+// parseMetadata parses key=value pairs into a map.  It returns nil if there
+// are no pairs
+func parseMetadata(pairs []string) (map[string]string, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+
+	meta := make(map[string]string, len(pairs))
+	for _, p := range pairs {
+		i := strings.Index(p, "=")
+		if i < 1 {
+			return nil, fmt.Errorf("invalid metadata: %s", p)
+		}
+		meta[p[:i]] = p[i+1:]
+	}
+	return meta, nil
+}
+
+// openInput opens the file at path for reading.  A path of '-' returns stdin
+func openInput(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return ioutil.NopCloser(os.Stdin), nil
+	}
+	return os.Open(path)
+}
+
+// parseIndexes parses comma separated index definitions of the form
+// name:prefix:path e.g. by-email:users/:email
+func parseIndexes(s string) ([]*fidias.Index, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	defs := strings.Split(s, ",")
+	out := make([]*fidias.Index, 0, len(defs))
+	for _, d := range defs {
+		parts := strings.SplitN(d, ":", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid index: %s", d)
+		}
+		out = append(out, &fidias.Index{Name: parts[0], Prefix: []byte(parts[1]), Path: parts[2]})
+	}
+
+	return out, nil
+}
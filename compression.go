@@ -0,0 +1,220 @@
+package fidias
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression is the codec used to compress kv values and uploaded files
+type Compression int32
+
+const (
+	// CompressionNone disables compression
+	CompressionNone Compression = iota
+	// CompressionSnappy favours speed over ratio
+	CompressionSnappy
+	// CompressionZstd favours ratio over speed
+	CompressionZstd
+)
+
+// Values smaller than this are never compressed as the savings do not cover
+// the cost
+const minCompressSize = 512
+
+var (
+	zstdOnce sync.Once
+	zstdEnc  *zstd.Encoder
+	zstdDec  *zstd.Decoder
+)
+
+// ParseCompression parses a codec name i.e. none, snappy or zstd.  An empty
+// string is none
+func ParseCompression(s string) (Compression, error) {
+	switch strings.ToLower(s) {
+	case "", "none":
+		return CompressionNone, nil
+	case "snappy":
+		return CompressionSnappy, nil
+	case "zstd":
+		return CompressionZstd, nil
+	}
+	return CompressionNone, fmt.Errorf("invalid compression: %q", s)
+}
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionSnappy:
+		return "snappy"
+	case CompressionZstd:
+		return "zstd"
+	}
+	return fmt.Sprintf("unknown(%d)", int32(c))
+}
+
+// initZstd creates the shared zstd encoder and decoder used for kv values
+func initZstd() {
+	zstdEnc, _ = zstd.NewWriter(nil)
+	zstdDec, _ = zstd.NewReader(nil)
+}
+
+// compress returns the data compressed with the codec
+func compress(c Compression, data []byte) ([]byte, error) {
+	switch c {
+	case CompressionNone:
+		return data, nil
+	case CompressionSnappy:
+		return snappy.Encode(nil, data), nil
+	case CompressionZstd:
+		zstdOnce.Do(initZstd)
+		return zstdEnc.EncodeAll(data, nil), nil
+	}
+	return nil, fmt.Errorf("invalid compression: %d", c)
+}
+
+// decompress returns the data decompressed with the codec
+func decompress(c Compression, data []byte) ([]byte, error) {
+	switch c {
+	case CompressionNone:
+		return data, nil
+	case CompressionSnappy:
+		return snappy.Decode(nil, data)
+	case CompressionZstd:
+		zstdOnce.Do(initZstd)
+		return zstdDec.DecodeAll(data, nil)
+	}
+	return nil, fmt.Errorf("invalid compression: %d", c)
+}
+
+// compressPair returns a copy of the pair with its value compressed.  The pair
+// is returned as is if it is a directory, already compressed, sealed, too small
+// or does not compress.  Sealed values are ciphertext which does not compress
+func compressPair(c Compression, kvp *KVPair) (*KVPair, error) {
+	if c == CompressionNone || kvp.IsDir() || kvp.Compression != 0 || len(kvp.Value) < minCompressSize {
+		return kvp, nil
+	}
+	if isSealed(kvp.Value) {
+		return kvp, nil
+	}
+
+	val, err := compress(c, kvp.Value)
+	if err != nil {
+		return nil, err
+	}
+	if len(val) >= len(kvp.Value) {
+		return kvp, nil
+	}
+
+	cp := *kvp
+	cp.Value = val
+	cp.Compression = int32(c)
+	return &cp, nil
+}
+
+// decompressPair returns a copy of the pair with its value decompressed.
+// Uncompressed pairs are returned as is.  A copy is made as pairs may be
+// shared with the local store
+func decompressPair(kvp *KVPair) (*KVPair, error) {
+	if kvp == nil || kvp.Compression == 0 {
+		return kvp, nil
+	}
+
+	val, err := decompress(Compression(kvp.Compression), kvp.Value)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, kvp.Key)
+	}
+
+	cp := *kvp
+	cp.Value = val
+	cp.Compression = 0
+	return &cp, nil
+}
+
+// decompressPairs decompresses the values of the pairs replacing them in the
+// slice
+func decompressPairs(kvps []*KVPair) error {
+	for i, kvp := range kvps {
+		dkvp, err := decompressPair(kvp)
+		if err != nil {
+			return err
+		}
+		kvps[i] = dkvp
+	}
+	return nil
+}
+
+// NewCompressReader returns a reader of the data from r compressed with the
+// codec.  The stream carries no header so the codec must be recorded with the
+// root of the file i.e. RegisterRoot, and given to NewDecompressReader.  The
+// reader is returned as is if the codec is none
+func NewCompressReader(r io.Reader, c Compression) (io.Reader, error) {
+	if c == CompressionNone {
+		return r, nil
+	}
+	if c != CompressionSnappy && c != CompressionZstd {
+		return nil, fmt.Errorf("invalid compression: %d", c)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(compressStream(pw, r, c))
+	}()
+	return pr, nil
+}
+
+func compressStream(w io.Writer, r io.Reader, c Compression) error {
+	var cw io.WriteCloser
+	switch c {
+	case CompressionSnappy:
+		cw = snappy.NewBufferedWriter(w)
+	case CompressionZstd:
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return err
+		}
+		cw = zw
+	}
+
+	if _, err := io.Copy(cw, r); err != nil {
+		cw.Close()
+		return err
+	}
+	return cw.Close()
+}
+
+// NewDecompressReader returns a reader decompressing the stream from r with
+// the codec.  The reader is returned as is if the codec is none
+func NewDecompressReader(r io.Reader, c Compression) (io.Reader, error) {
+	switch c {
+	case CompressionNone:
+		return r, nil
+	case CompressionSnappy:
+		return snappy.NewReader(r), nil
+	case CompressionZstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return &zstdReader{zr}, nil
+	}
+	return nil, fmt.Errorf("invalid compression: %d", c)
+}
+
+// zstdReader releases the decoder once the stream has been read
+type zstdReader struct {
+	*zstd.Decoder
+}
+
+func (zr *zstdReader) Read(p []byte) (int, error) {
+	n, err := zr.Decoder.Read(p)
+	if err == io.EOF {
+		zr.Decoder.Close()
+	}
+	return n, err
+}
@@ -0,0 +1,137 @@
+package fidias
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func Test_ParseCompression(t *testing.T) {
+	for _, s := range []string{"", "none", "snappy", "ZSTD"} {
+		c, err := ParseCompression(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s != "" && c.String() != string(bytes.ToLower([]byte(s))) {
+			t.Fatal("wrong codec", s, c)
+		}
+	}
+	if _, err := ParseCompression("lz4"); err == nil {
+		t.Fatal("should fail")
+	}
+}
+
+func Test_compressPair(t *testing.T) {
+	value := bytes.Repeat([]byte(`{"key":"value"},`), 100)
+
+	for _, c := range []Compression{CompressionSnappy, CompressionZstd} {
+		kvp := NewKVPair([]byte("key"), value)
+		ckvp, err := compressPair(c, kvp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ckvp.Compression != int32(c) || len(ckvp.Value) >= len(value) {
+			t.Fatal("value not compressed", c)
+		}
+		if kvp.Compression != 0 || !bytes.Equal(kvp.Value, value) {
+			t.Fatal("original pair modified")
+		}
+
+		dkvp, err := decompressPair(ckvp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dkvp.Compression != 0 || !bytes.Equal(dkvp.Value, value) {
+			t.Fatal("value mismatch", c)
+		}
+		if ckvp.Compression != int32(c) {
+			t.Fatal("compressed pair modified")
+		}
+	}
+
+	// Small values and directories are not compressed
+	small := NewKVPair([]byte("key"), []byte("value"))
+	if ckvp, _ := compressPair(CompressionZstd, small); ckvp != small {
+		t.Fatal("small value should not be compressed")
+	}
+	dir := NewDirKVPair([]byte("dir"), 0755)
+	if ckvp, _ := compressPair(CompressionZstd, dir); ckvp != dir {
+		t.Fatal("directory should not be compressed")
+	}
+	sealed := NewKVPair([]byte("key"), append(append([]byte{}, envelopeMagic...), value...))
+	if ckvp, _ := compressPair(CompressionZstd, sealed); ckvp != sealed {
+		t.Fatal("sealed value should not be compressed")
+	}
+}
+
+func Test_encodeSetData_compressed(t *testing.T) {
+	kvp := &KVPair{Value: []byte("data"), Compression: int32(CompressionSnappy)}
+	data, err := encodeSetData(kvp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data[0] != opKVSetPair {
+		t.Fatal("compressed values should be set with metadata")
+	}
+}
+
+func Test_encodeSetData_metadata(t *testing.T) {
+	kvp := NewKVPair([]byte("key"), []byte("data"))
+	kvp.Metadata = map[string]string{rootDeletedMetadata: "true"}
+	data, err := encodeSetData(kvp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data[0] != opKVSetPair {
+		t.Fatal("pairs with metadata should be set with metadata")
+	}
+
+	var out KVPair
+	if err = proto.Unmarshal(data[1:], &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Metadata[rootDeletedMetadata] != "true" {
+		t.Fatal("metadata not encoded")
+	}
+}
+
+func Test_CompressReader(t *testing.T) {
+	data := bytes.Repeat([]byte("compressible data "), 10000)
+
+	for _, c := range []Compression{CompressionNone, CompressionSnappy, CompressionZstd} {
+		r, err := NewCompressReader(bytes.NewReader(data), c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		enc, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c != CompressionNone && len(enc) >= len(data) {
+			t.Fatal("stream not compressed", c)
+		}
+
+		dr, err := NewDecompressReader(bytes.NewReader(enc), c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := ioutil.ReadAll(dr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out, data) {
+			t.Fatal("data mismatch", c)
+		}
+	}
+
+	// Uncompressed streams are passed through as is regardless of content
+	dr, _ := NewDecompressReader(bytes.NewReader([]byte("FZC1\x01data")), CompressionNone)
+	if out, _ := ioutil.ReadAll(dr); string(out) != "FZC1\x01data" {
+		t.Fatal("uncompressed stream should pass through")
+	}
+	if _, err := NewDecompressReader(bytes.NewReader(nil), Compression(9)); err == nil {
+		t.Fatal("should fail with an invalid codec")
+	}
+}
@@ -1,6 +1,10 @@
 package fidias
 
-import "github.com/hexablock/phi"
+import (
+	"time"
+
+	"github.com/hexablock/phi"
+)
 
 // Config is the fidias config
 type Config struct {
@@ -9,12 +13,49 @@ type Config struct {
 
 	Phi *phi.Config
 
+	// Peers are additional rpc endpoints used by clients.  They are tried in
+	// order if the WAL advertise host is unavailable
 	Peers []string
+
+	// Interval at which clients check the health of known endpoints.  Zero
+	// disables health checks
+	HealthCheckInterval time.Duration
+
+	// Named namespaces of the cluster along with the acl scoping access to each.
+	// Requests to other namespaces fail with ErrNamespaceNotFound.  The default
+	// namespace, which may also be given an acl, and the blox namespace always
+	// exist.  All nodes must be configured with the same namespaces
+	Namespaces map[string]*ACL
+
+	// Token sent with every request.  Nodes also use it for requests to other
+	// nodes and are allowed access to all namespaces with it, so all nodes must
+	// have the same token and it must not be given to clients
+	Token string
+
+	// Quota applied to namespaces without one in Quotas.  Nil is unlimited
+	DefaultQuota *Quota
+
+	// Quotas of specific namespaces keyed by name.  The default namespace has
+	// an empty name
+	Quotas map[string]*Quota
+
+	// Secondary indexes maintained in every namespace.  All nodes must be
+	// configured with the same indexes
+	Indexes []*Index
+
+	// Codec used to compress kv values of at least 512 bytes written through
+	// the node.  Values compressed with any codec are decompressed on read
+	Compression Compression
+
+	// Keys used by clients to encrypt the values and uploads of namespaces.
+	// Namespaces without a key are not encrypted
+	Keyring *Keyring
 }
 
 func DefaultConfig() *Config {
 	return &Config{
-		KVPrefix: "kv/",
-		Phi:      phi.DefaultConfig(),
+		KVPrefix:            "kv/",
+		Phi:                 phi.DefaultConfig(),
+		HealthCheckInterval: 10 * time.Second,
 	}
 }
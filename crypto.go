@@ -0,0 +1,378 @@
+package fidias
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+const (
+	// Size of namespace and data keys.  Keys are used for AES-256
+	encryptionKeySize = 32
+	// Size of the wrapped data key i.e. nonce, key and tag
+	wrappedKeySize = 12 + encryptionKeySize + 16
+	// Bytes added to each encrypted block chunk i.e. magic, wrapped key and tag
+	chunkOverhead = 4 + wrappedKeySize + 16
+	// Plaintext block size used for encrypted uploads when not specified
+	defaultEncryptedBlockSize = 1024 * 1024
+)
+
+var (
+	// Prefix identifying an encrypted kv value
+	envelopeMagic = []byte("FEV1")
+	// Prefix identifying an encrypted block chunk
+	chunkMagic = []byte("FEC1")
+
+	errDecrypt = errors.New("failed to decrypt")
+	// Returned when reading a value or block that is not encrypted from a
+	// namespace with a key
+	errNotEncrypted = errors.New("not encrypted")
+)
+
+// Keyring holds the per-namespace keys used for client-side encryption
+type Keyring struct {
+	mu   sync.RWMutex
+	keys map[string][]byte
+}
+
+// NewKeyring returns an empty keyring
+func NewKeyring() *Keyring {
+	return &Keyring{keys: make(map[string][]byte)}
+}
+
+// LoadKeyring loads a keyring from a json file mapping namespace names to hex
+// encoded 32 byte keys e.g. {"": "<default namespace key>", "docs": "..."}
+func LoadKeyring(path string) (*Keyring, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var m map[string]string
+	if err = json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+
+	kr := NewKeyring()
+	for ns, hkey := range m {
+		key, err := hex.DecodeString(hkey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key for namespace %q: %v", ns, err)
+		}
+		if err = kr.SetKey(ns, key); err != nil {
+			return nil, err
+		}
+	}
+	return kr, nil
+}
+
+// SetKey sets the key of the namespace
+func (kr *Keyring) SetKey(namespace string, key []byte) error {
+	if len(key) != encryptionKeySize {
+		return fmt.Errorf("invalid key size for namespace %q: %d", namespace, len(key))
+	}
+
+	kr.mu.Lock()
+	kr.keys[namespace] = append([]byte(nil), key...)
+	kr.mu.Unlock()
+	return nil
+}
+
+// Key returns the key of the namespace or nil if it has none
+func (kr *Keyring) Key(namespace string) []byte {
+	if kr == nil {
+		return nil
+	}
+
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	return kr.keys[namespace]
+}
+
+// envelope encrypts kv values and block chunks with a namespace key.  Values
+// are encrypted with a random data key wrapped by the namespace key.  Block
+// chunks use convergent encryption where the data key is derived from the
+// namespace key and the chunk so equal chunks encrypt to equal blocks and are
+// de-duplicated
+type envelope struct {
+	key  []byte
+	kek  cipher.AEAD
+	name string
+}
+
+// newEnvelope returns an envelope for the namespace or nil if the keyring has
+// no key for it
+func newEnvelope(kr *Keyring, namespace string) *envelope {
+	key := kr.Key(namespace)
+	if key == nil {
+		return nil
+	}
+
+	// Cannot fail as key sizes are validated by the keyring
+	kek, _ := newGCM(key)
+	return &envelope{key: key, kek: kek, name: namespace}
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// wrap encrypts the data key with the namespace key using the nonce
+func (env *envelope) wrap(dek, nonce []byte) []byte {
+	return env.kek.Seal(nonce, nonce, dek, []byte(env.name))
+}
+
+// unwrap decrypts a wrapped data key
+func (env *envelope) unwrap(wrapped []byte) ([]byte, error) {
+	dek, err := env.kek.Open(nil, wrapped[:12], wrapped[12:], []byte(env.name))
+	if err != nil {
+		return nil, errDecrypt
+	}
+	return dek, nil
+}
+
+// valueAAD returns the additional data authenticating a kv value.  It binds
+// the value to its namespace and key so it cannot be moved to another key
+func (env *envelope) valueAAD(key []byte) []byte {
+	aad := make([]byte, 0, len(env.name)+1+len(key))
+	aad = append(aad, env.name...)
+	return append(append(aad, 0), key...)
+}
+
+// seal encrypts the value of the key
+func (env *envelope) seal(key, value []byte) ([]byte, error) {
+	dek := make([]byte, encryptionKeySize)
+	nonces := make([]byte, 24)
+	if _, err := io.ReadFull(rand.Reader, dek); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(rand.Reader, nonces); err != nil {
+		return nil, err
+	}
+
+	aead, err := newGCM(dek)
+	if err != nil {
+		return nil, err
+	}
+
+	out := append([]byte{}, envelopeMagic...)
+	out = append(out, env.wrap(dek, nonces[:12])...)
+	out = append(out, nonces[12:]...)
+	return aead.Seal(out, nonces[12:], value, env.valueAAD(key)), nil
+}
+
+// open decrypts the value of the key.  Values that are not encrypted fail
+func (env *envelope) open(key, value []byte) ([]byte, error) {
+	if !isSealed(value) {
+		return nil, errNotEncrypted
+	}
+	value = value[len(envelopeMagic):]
+	if len(value) < wrappedKeySize+12 {
+		return nil, errDecrypt
+	}
+
+	dek, err := env.unwrap(value[:wrappedKeySize])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(dek)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := value[wrappedKeySize : wrappedKeySize+12]
+	out, err := aead.Open(nil, nonce, value[wrappedKeySize+12:], env.valueAAD(key))
+	if err != nil {
+		return nil, errDecrypt
+	}
+	return out, nil
+}
+
+// sealPair returns a copy of the pair with its value encrypted.  Directories
+// are not encrypted
+func (env *envelope) sealPair(kvp *KVPair) (*KVPair, error) {
+	if env == nil || kvp.IsDir() {
+		return kvp, nil
+	}
+
+	val, err := env.seal(kvp.Key, kvp.Value)
+	if err != nil {
+		return nil, err
+	}
+	c := *kvp
+	c.Value = val
+	return &c, nil
+}
+
+// openPairs decrypts the values of the pairs in place
+func (env *envelope) openPairs(kvps ...*KVPair) error {
+	if env == nil {
+		return nil
+	}
+
+	for _, kvp := range kvps {
+		if kvp == nil || kvp.IsDir() {
+			continue
+		}
+		val, err := env.open(kvp.Key, kvp.Value)
+		if err != nil {
+			return fmt.Errorf("%v: %s", err, kvp.Key)
+		}
+		kvp.Value = val
+	}
+	return nil
+}
+
+// isSealed returns true if the kv value is encrypted.  Servers use it to refuse
+// operations that cannot preserve an encrypted value
+func isSealed(value []byte) bool {
+	return bytes.HasPrefix(value, envelopeMagic)
+}
+
+// sealChunk encrypts a block chunk deterministically.  The data key is the
+// hmac of the chunk with the namespace key and the wrapping nonce is derived
+// from the data key.  A data key only ever encrypts the one chunk it was
+// derived from so a fixed data nonce is safe
+func (env *envelope) sealChunk(chunk []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, env.key)
+	mac.Write(chunk)
+	dek := mac.Sum(nil)
+
+	mac = hmac.New(sha256.New, env.key)
+	mac.Write(dek)
+	nonce := mac.Sum(nil)[:12]
+
+	aead, err := newGCM(dek)
+	if err != nil {
+		return nil, err
+	}
+
+	out := append([]byte{}, chunkMagic...)
+	out = append(out, env.wrap(dek, nonce)...)
+	return aead.Seal(out, make([]byte, 12), chunk, nil), nil
+}
+
+// openChunk decrypts a block chunk
+func (env *envelope) openChunk(chunk []byte) ([]byte, error) {
+	if !bytes.HasPrefix(chunk, chunkMagic) || len(chunk) < chunkOverhead {
+		return nil, errDecrypt
+	}
+	chunk = chunk[len(chunkMagic):]
+
+	dek, err := env.unwrap(chunk[:wrappedKeySize])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(dek)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := aead.Open(nil, make([]byte, 12), chunk[wrappedKeySize:], nil)
+	if err != nil {
+		return nil, errDecrypt
+	}
+	return out, nil
+}
+
+// encryptReader encrypts the underlying reader in chunks sized so each
+// encrypted chunk fills exactly one block
+type encryptReader struct {
+	r     io.Reader
+	env   *envelope
+	chunk []byte
+	buf   []byte
+	err   error
+}
+
+func newEncryptReader(r io.Reader, env *envelope, blockSize uint64) *encryptReader {
+	return &encryptReader{r: r, env: env, chunk: make([]byte, blockSize-chunkOverhead)}
+}
+
+func (er *encryptReader) Read(p []byte) (int, error) {
+	for len(er.buf) == 0 {
+		if er.err != nil {
+			return 0, er.err
+		}
+
+		n, err := io.ReadFull(er.r, er.chunk)
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		er.err = err
+		if n == 0 {
+			continue
+		}
+
+		if er.buf, err = er.env.sealChunk(er.chunk[:n]); err != nil {
+			er.err = err
+			return 0, err
+		}
+	}
+
+	n := copy(p, er.buf)
+	er.buf = er.buf[n:]
+	return n, nil
+}
+
+// decryptReader decrypts a stream of block sized encrypted chunks.  A chunk
+// that is not encrypted fails the read
+type decryptReader struct {
+	r     io.ReadCloser
+	env   *envelope
+	chunk []byte
+	buf   []byte
+	err   error
+}
+
+func newDecryptReader(r io.ReadCloser, env *envelope, blockSize uint64) *decryptReader {
+	return &decryptReader{r: r, env: env, chunk: make([]byte, blockSize)}
+}
+
+func (dr *decryptReader) Read(p []byte) (int, error) {
+	for len(dr.buf) == 0 {
+		if dr.err != nil {
+			return 0, dr.err
+		}
+
+		n, err := io.ReadFull(dr.r, dr.chunk)
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		dr.err = err
+		if n == 0 {
+			continue
+		}
+
+		if !bytes.HasPrefix(dr.chunk[:n], chunkMagic) {
+			dr.err = errNotEncrypted
+			return 0, dr.err
+		}
+		if dr.buf, err = dr.env.openChunk(dr.chunk[:n]); err != nil {
+			dr.err = err
+			return 0, err
+		}
+	}
+
+	n := copy(p, dr.buf)
+	dr.buf = dr.buf[n:]
+	return n, nil
+}
+
+func (dr *decryptReader) Close() error {
+	return dr.r.Close()
+}
@@ -0,0 +1,169 @@
+package fidias
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+func testEnvelope(t *testing.T, ns string) *envelope {
+	kr := NewKeyring()
+	key := make([]byte, encryptionKeySize)
+	rand.Read(key)
+	if err := kr.SetKey(ns, key); err != nil {
+		t.Fatal(err)
+	}
+	return newEnvelope(kr, ns)
+}
+
+func Test_Keyring(t *testing.T) {
+	kr := NewKeyring()
+	if err := kr.SetKey("ns", make([]byte, 16)); err == nil {
+		t.Fatal("should fail with short key")
+	}
+	if kr.Key("ns") != nil {
+		t.Fatal("key should not be set")
+	}
+
+	var nilkr *Keyring
+	if nilkr.Key("ns") != nil || newEnvelope(nilkr, "ns") != nil {
+		t.Fatal("nil keyring should have no keys")
+	}
+}
+
+func Test_envelope_value(t *testing.T) {
+	env := testEnvelope(t, "ns")
+
+	sealed, err := env.seal([]byte("key"), []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, []byte("secret")) {
+		t.Fatal("value not encrypted")
+	}
+	sealed2, _ := env.seal([]byte("key"), []byte("secret"))
+	if bytes.Equal(sealed, sealed2) {
+		t.Fatal("values should use random keys")
+	}
+
+	val, err := env.open([]byte("key"), sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(val) != "secret" {
+		t.Fatalf("wrong value: %s", val)
+	}
+
+	// Plaintext values are refused
+	if _, err = env.open([]byte("key"), []byte("plain")); err != errNotEncrypted {
+		t.Fatal("should fail with plaintext", err)
+	}
+
+	// Wrong namespace key, namespace or kv key
+	if _, err = testEnvelope(t, "ns").open([]byte("key"), sealed); err != errDecrypt {
+		t.Fatal("should fail with wrong namespace key", err)
+	}
+	other := &envelope{key: env.key, kek: env.kek, name: "other"}
+	if _, err = other.open([]byte("key"), sealed); err != errDecrypt {
+		t.Fatal("should fail with wrong namespace", err)
+	}
+	if _, err = env.open([]byte("moved"), sealed); err != errDecrypt {
+		t.Fatal("should fail with wrong key", err)
+	}
+}
+
+func Test_envelope_pairs(t *testing.T) {
+	env := testEnvelope(t, "ns")
+
+	kvp := NewKVPair([]byte("key"), []byte("value"))
+	sealed, err := env.sealPair(kvp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(kvp.Value) != "value" {
+		t.Fatal("original pair modified")
+	}
+	if err = env.openPairs(sealed, nil); err != nil {
+		t.Fatal(err)
+	}
+	if string(sealed.Value) != "value" {
+		t.Fatalf("wrong value: %s", sealed.Value)
+	}
+
+	var nilenv *envelope
+	if c, _ := nilenv.sealPair(kvp); c != kvp {
+		t.Fatal("nil envelope should not encrypt")
+	}
+}
+
+func Test_envelope_chunk(t *testing.T) {
+	env := testEnvelope(t, "ns")
+
+	chunk := make([]byte, 1000)
+	rand.Read(chunk)
+
+	c1, err := env.sealChunk(chunk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, _ := env.sealChunk(chunk)
+	if !bytes.Equal(c1, c2) {
+		t.Fatal("chunks should encrypt deterministically")
+	}
+	if len(c1) != len(chunk)+chunkOverhead {
+		t.Fatal("wrong overhead", len(c1)-len(chunk))
+	}
+
+	out, err := env.openChunk(c1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, chunk) {
+		t.Fatal("chunk mismatch")
+	}
+
+	c1[len(c1)-1] ^= 0xff
+	if _, err = env.openChunk(c1); err != errDecrypt {
+		t.Fatal("should fail on tampered chunk", err)
+	}
+}
+
+func Test_encryptReader(t *testing.T) {
+	env := testEnvelope(t, "ns")
+	blockSize := uint64(chunkOverhead + 100)
+
+	for _, size := range []int{0, 1, 100, 250, 1000} {
+		data := make([]byte, size)
+		rand.Read(data)
+
+		enc, err := ioutil.ReadAll(newEncryptReader(bytes.NewReader(data), env, blockSize))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Every chunk but the last fills a block
+		if chunks := (size + 99) / 100; len(enc) != size+chunks*chunkOverhead {
+			t.Fatalf("wrong encrypted size size=%d got=%d", size, len(enc))
+		}
+
+		rc := ioutil.NopCloser(bytes.NewReader(enc))
+		dec, err := ioutil.ReadAll(newDecryptReader(rc, env, blockSize))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(dec, data) {
+			t.Fatalf("data mismatch size=%d", size)
+		}
+	}
+}
+
+func Test_decryptReader_plaintext(t *testing.T) {
+	env := testEnvelope(t, "ns")
+	data := bytes.Repeat([]byte("plaintext "), 50)
+
+	rc := ioutil.NopCloser(bytes.NewReader(data))
+	out, err := ioutil.ReadAll(newDecryptReader(rc, env, 64))
+	if err != errNotEncrypted || len(out) != 0 {
+		t.Fatal("plaintext should be refused", err)
+	}
+}
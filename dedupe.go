@@ -0,0 +1,161 @@
+package fidias
+
+import (
+	"sync"
+
+	"github.com/hexablock/blox"
+	"github.com/hexablock/blox/block"
+)
+
+// Key prefix in the BloxNamespace of the cluster-wide de-duplication counters
+const bloxDedupePrefix = "dedupe/"
+
+// Counter keys of the cluster-wide de-duplication stats
+const (
+	dedupeBlocksKey          = bloxDedupePrefix + "blocks"
+	dedupeDuplicateBlocksKey = bloxDedupePrefix + "duplicate-blocks"
+	dedupeLogicalBytesKey    = bloxDedupePrefix + "logical-bytes"
+	dedupePhysicalBytesKey   = bloxDedupePrefix + "physical-bytes"
+)
+
+// DedupeStats are the de-duplication stats of block writes.  Bytes are counted
+// once per block before replication or erasure coding
+type DedupeStats struct {
+	// Blocks written including index blocks
+	Blocks int64
+	// Blocks that already existed and were not stored again
+	DuplicateBlocks int64
+	// Bytes of all blocks written
+	LogicalBytes int64
+	// Bytes of the blocks actually stored
+	PhysicalBytes int64
+}
+
+// SavedBytes returns the bytes not stored due to de-duplication
+func (s *DedupeStats) SavedBytes() int64 {
+	return s.LogicalBytes - s.PhysicalBytes
+}
+
+// SavedRatio returns the fraction of the logical bytes not stored.  It is zero
+// if nothing was written
+func (s *DedupeStats) SavedRatio() float64 {
+	if s.LogicalBytes == 0 {
+		return 0
+	}
+	return float64(s.SavedBytes()) / float64(s.LogicalBytes)
+}
+
+// DedupeReport is the cluster-wide de-duplication report
+type DedupeReport struct {
+	DedupeStats
+	SavedBytes int64
+	SavedRatio float64
+}
+
+func newDedupeReport(stats *DedupeStats) *DedupeReport {
+	return &DedupeReport{
+		DedupeStats: *stats,
+		SavedBytes:  stats.SavedBytes(),
+		SavedRatio:  stats.SavedRatio(),
+	}
+}
+
+// DedupeDevice is a block device counting the logical and physical bytes of the
+// blocks written through it.  A block is a duplicate if the underlying device
+// returns block.ErrBlockExists
+type DedupeDevice struct {
+	blox.BlockDevice
+
+	mu    sync.Mutex
+	stats DedupeStats
+}
+
+// NewDedupeDevice returns a device counting the blocks written to dev
+func NewDedupeDevice(dev blox.BlockDevice) *DedupeDevice {
+	return &DedupeDevice{BlockDevice: dev}
+}
+
+// SetBlock writes the block to the underlying device updating the stats
+func (dev *DedupeDevice) SetBlock(blk block.Block) ([]byte, error) {
+	id, err := dev.BlockDevice.SetBlock(blk)
+	if err != nil && err != block.ErrBlockExists {
+		return id, err
+	}
+
+	size := int64(blk.Size())
+
+	dev.mu.Lock()
+	dev.stats.Blocks++
+	dev.stats.LogicalBytes += size
+	if err == nil {
+		dev.stats.PhysicalBytes += size
+	} else {
+		dev.stats.DuplicateBlocks++
+	}
+	dev.mu.Unlock()
+
+	return id, err
+}
+
+// Stats returns a copy of the stats of the blocks written so far
+func (dev *DedupeDevice) Stats() *DedupeStats {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+
+	stats := dev.stats
+	return &stats
+}
+
+// RecordDedupe adds the stats of an upload to the cluster-wide counters in the
+// blox kv
+func RecordDedupe(kv BloxKV, stats *DedupeStats) error {
+	counters := []struct {
+		key   string
+		delta int64
+	}{
+		{dedupeBlocksKey, stats.Blocks},
+		{dedupeDuplicateBlocksKey, stats.DuplicateBlocks},
+		{dedupeLogicalBytesKey, stats.LogicalBytes},
+		{dedupePhysicalBytesKey, stats.PhysicalBytes},
+	}
+
+	for _, c := range counters {
+		if c.delta == 0 {
+			continue
+		}
+		if _, err := kv.incr([]byte(c.key), c.delta); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadDedupeReport returns the cluster-wide de-duplication report from the
+// counters in the blox kv
+func ReadDedupeReport(kv BloxKV) (*DedupeReport, error) {
+	kvps, _, err := kv.Scan(&ScanOptions{Prefix: []byte(bloxDedupePrefix)})
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &DedupeStats{}
+	for _, kvp := range kvps {
+		i, er := kvp.Int64()
+		if er != nil {
+			continue
+		}
+
+		switch string(kvp.Key) {
+		case dedupeBlocksKey:
+			stats.Blocks = i
+		case dedupeDuplicateBlocksKey:
+			stats.DuplicateBlocks = i
+		case dedupeLogicalBytesKey:
+			stats.LogicalBytes = i
+		case dedupePhysicalBytesKey:
+			stats.PhysicalBytes = i
+		}
+	}
+
+	return newDedupeReport(stats), nil
+}
@@ -0,0 +1,53 @@
+package fidias
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/hexablock/blox/block"
+)
+
+// testSetDevice is a device only tracking the ids of the blocks set
+type testSetDevice struct {
+	testBaseDevice
+	ids map[string]bool
+}
+
+func (dev *testSetDevice) SetBlock(blk block.Block) ([]byte, error) {
+	if dev.ids[string(blk.ID())] {
+		return blk.ID(), block.ErrBlockExists
+	}
+	dev.ids[string(blk.ID())] = true
+	return blk.ID(), nil
+}
+
+func Test_DedupeDevice(t *testing.T) {
+	dev := NewDedupeDevice(&testSetDevice{ids: make(map[string]bool)})
+
+	a := newMemBlock(block.BlockTypeData, make([]byte, 100), sha256.New)
+	b := newMemBlock(block.BlockTypeData, make([]byte, 50), sha256.New)
+	for _, blk := range []block.Block{a, b, a} {
+		if _, err := dev.SetBlock(blk); err != nil && err != block.ErrBlockExists {
+			t.Fatal(err)
+		}
+	}
+
+	stats := dev.Stats()
+	if stats.Blocks != 3 || stats.DuplicateBlocks != 1 {
+		t.Fatal("wrong block counts", stats.Blocks, stats.DuplicateBlocks)
+	}
+	if stats.LogicalBytes != 250 || stats.PhysicalBytes != 150 {
+		t.Fatal("wrong bytes", stats.LogicalBytes, stats.PhysicalBytes)
+	}
+	if stats.SavedBytes() != 100 || stats.SavedRatio() != 0.4 {
+		t.Fatal("wrong savings", stats.SavedBytes(), stats.SavedRatio())
+	}
+
+	report := newDedupeReport(stats)
+	if report.SavedBytes != 100 || report.Blocks != 3 {
+		t.Fatal("wrong report", report)
+	}
+	if (&DedupeStats{}).SavedRatio() != 0 {
+		t.Fatal("empty stats should have no savings")
+	}
+}
@@ -0,0 +1,600 @@
+package fidias
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hexablock/blox"
+	"github.com/hexablock/blox/block"
+	"github.com/hexablock/phi"
+)
+
+// BloxNamespace is the reserved namespace holding the shard maps of erasure
+// coded blocks.  It is exempt from quotas as block usage is accounted to the
+// namespace of the upload
+const BloxNamespace = "_blox"
+
+const (
+	// Key prefix in the BloxNamespace of the shard maps of erasure coded blocks
+	bloxShardsPrefix = "shards/"
+	// Key prefix in the BloxNamespace indexing each shard to its block
+	bloxShardIndexPrefix = "shard-index/"
+)
+
+// Maximum number of dht lookups used to find distinct nodes to place a block on
+const maxPlacementRounds = 16
+
+// Durability is the durability policy of a block upload.  Blocks are either
+// replicated to Replicas distinct nodes or erasure coded into DataShards data
+// and ParityShards parity shards each placed on a distinct node
+type Durability struct {
+	Replicas     int
+	DataShards   int
+	ParityShards int
+}
+
+// ParseDurability parses a durability policy.  A single number e.g. "3" is a
+// replication factor and "data:parity" e.g. "4:2" is a Reed-Solomon erasure
+// coding with 4 data and 2 parity shards
+func ParseDurability(s string) (*Durability, error) {
+	var (
+		d   = &Durability{}
+		err error
+	)
+
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		if d.DataShards, err = strconv.Atoi(s[:i]); err == nil {
+			d.ParityShards, err = strconv.Atoi(s[i+1:])
+		}
+	} else {
+		d.Replicas, err = strconv.Atoi(s)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid durability: %q", s)
+	}
+	if err = d.validate(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *Durability) validate() error {
+	if d.ErasureCoded() {
+		if _, err := newReedSolomon(d.DataShards, d.ParityShards); err != nil {
+			return err
+		}
+		return nil
+	}
+	if d.Replicas < 1 {
+		return fmt.Errorf("invalid replication factor: %d", d.Replicas)
+	}
+	return nil
+}
+
+// ErasureCoded returns true if the policy erasure codes blocks
+func (d *Durability) ErasureCoded() bool {
+	return d.DataShards > 0
+}
+
+// String returns the policy in the format accepted by ParseDurability
+func (d *Durability) String() string {
+	if d.ErasureCoded() {
+		return fmt.Sprintf("%d:%d", d.DataShards, d.ParityShards)
+	}
+	return strconv.Itoa(d.Replicas)
+}
+
+// BlockTransport reads and writes blocks on a specific host
+type BlockTransport interface {
+	GetBlock(host string, id []byte) (block.Block, error)
+	SetBlock(host string, blk block.Block) ([]byte, error)
+	RemoveBlock(host string, id []byte) error
+}
+
+// ShardStore persists the shard maps of erasure coded blocks
+type ShardStore interface {
+	GetShardMap(id []byte) (*ShardMap, error)
+	SetShardMap(sm *ShardMap) error
+	RemoveShardMap(id []byte) error
+	// FindShardMap returns the shard map of the block with the shard
+	FindShardMap(shardID []byte) (*ShardMap, error)
+}
+
+// kvShardStore stores shard maps in a kv under the shards directory keyed by
+// the hex block id.  Each shard is indexed to its block under the shard index
+// directory so the map of a shard is found without a scan
+type kvShardStore struct {
+	kv BloxKV
+}
+
+// NewKVShardStore returns a ShardStore persisting shard maps to the kv of the
+// BloxNamespace
+func NewKVShardStore(kv BloxKV) ShardStore {
+	return &kvShardStore{kv: kv}
+}
+
+func (store *kvShardStore) GetShardMap(id []byte) (*ShardMap, error) {
+	kvp, _, err := store.kv.Get(shardMapKey(id), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var sm ShardMap
+	if err = proto.Unmarshal(kvp.Value, &sm); err != nil {
+		return nil, err
+	}
+	return &sm, nil
+}
+
+// SetShardMap indexes the shards before storing the map so a stored map is
+// always found by any of its shards
+func (store *kvShardStore) SetShardMap(sm *ShardMap) error {
+	val, err := proto.Marshal(sm)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range sm.Shards {
+		kvp := NewKVPair(shardIndexKey(s.ID), sm.ID)
+		if _, err = store.kv.set(kvp); err != nil {
+			return err
+		}
+	}
+
+	_, err = store.kv.set(NewKVPair(shardMapKey(sm.ID), val))
+	return err
+}
+
+// FindShardMap returns the shard map of the block the shard is indexed to
+func (store *kvShardStore) FindShardMap(shardID []byte) (*ShardMap, error) {
+	kvp, _, err := store.kv.Get(shardIndexKey(shardID), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	sm, err := store.GetShardMap(kvp.Value)
+	if err != nil {
+		return nil, err
+	}
+	// The index is left behind if writing the map failed
+	for _, s := range sm.Shards {
+		if bytes.Equal(s.ID, shardID) {
+			return sm, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// RemoveShardMap removes the shard map followed by the index of its shards.
+// Shards since indexed to another block are left as is
+func (store *kvShardStore) RemoveShardMap(id []byte) error {
+	sm, err := store.GetShardMap(id)
+	if err != nil {
+		return err
+	}
+	if err = store.kv.remove(shardMapKey(id)); err != nil {
+		return err
+	}
+
+	for _, s := range sm.Shards {
+		kvp, _, er := store.kv.Get(shardIndexKey(s.ID), nil)
+		if er != nil || !bytes.Equal(kvp.Value, id) {
+			continue
+		}
+		if er = store.kv.remove(kvp.Key); er != nil {
+			err = er
+		}
+	}
+	return err
+}
+
+func shardMapKey(id []byte) []byte {
+	return []byte(bloxShardsPrefix + hex.EncodeToString(id))
+}
+
+func shardIndexKey(shardID []byte) []byte {
+	return []byte(bloxShardIndexPrefix + hex.EncodeToString(shardID))
+}
+
+// DurableDevice is a block device applying a durability policy to the blocks
+// written to it.  Replicated blocks are written to distinct nodes found via the
+// dht.  Erasure coded data blocks are split into shards on distinct nodes with
+// the shard map kept in the ShardStore.  All other blocks of an erasure coded
+// upload e.g. index blocks are replicated to parity+1 nodes so they survive as
+// many failures.  Without a policy writes go to the base device.
+//
+// Reads do not require the policy of the write.  They try the base device, then
+// the shard map reconstructing the block from any data shards worth of shards,
+// and finally every node a replica may have been placed on
+type DurableDevice struct {
+	policy *Durability
+
+	base     blox.BlockDevice
+	dht      phi.DHT
+	trans    BlockTransport
+	shards   ShardStore
+	hashFunc func() hash.Hash
+}
+
+// NewDurableDevice returns a device writing blocks with the policy.  A nil
+// policy uses the base device as is
+func NewDurableDevice(policy *Durability, base blox.BlockDevice, dht phi.DHT, trans BlockTransport, shards ShardStore, hashFunc func() hash.Hash) (*DurableDevice, error) {
+	if policy != nil {
+		if err := policy.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &DurableDevice{
+		policy:   policy,
+		base:     base,
+		dht:      dht,
+		trans:    trans,
+		shards:   shards,
+		hashFunc: hashFunc,
+	}, nil
+}
+
+// SetBlock writes the block according to the policy
+func (dev *DurableDevice) SetBlock(blk block.Block) ([]byte, error) {
+	switch {
+	case dev.policy == nil:
+		return dev.base.SetBlock(blk)
+
+	case dev.policy.ErasureCoded() && blk.Type() == block.BlockTypeData:
+		return dev.setErasureCoded(blk)
+
+	case dev.policy.ErasureCoded():
+		return dev.setReplicated(blk, dev.policy.ParityShards+1)
+	}
+
+	return dev.setReplicated(blk, dev.policy.Replicas)
+}
+
+// setReplicated writes the block to n distinct nodes.  A node already having
+// the block counts as a copy.  ErrInsufficientDurability is returned with the
+// number of copies written if fewer than n nodes have the block and
+// ErrBlockExists if all of them already had it
+func (dev *DurableDevice) setReplicated(blk block.Block, n int) ([]byte, error) {
+	hosts, err := dev.placement(blk.ID(), n)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		id       []byte
+		firstErr error
+		copies   int
+	)
+	for _, host := range hosts {
+		bid, er := dev.trans.SetBlock(host, blk)
+		switch er {
+		case nil:
+			id = bid
+			copies++
+		case block.ErrBlockExists:
+			copies++
+		default:
+			if firstErr == nil {
+				firstErr = er
+			}
+		}
+	}
+
+	if copies < n {
+		if firstErr == nil {
+			firstErr = fmt.Errorf("%d nodes found", len(hosts))
+		}
+		return nil, ErrInsufficientDurability.wrap(fmt.Errorf("%d of %d copies written: %v", copies, n, firstErr))
+	}
+	if id == nil {
+		return blk.ID(), block.ErrBlockExists
+	}
+	return id, nil
+}
+
+// setErasureCoded splits the block into shards, writes each to a distinct node
+// and stores the resulting shard map.  The shard map is only written once every
+// shard has been.  On failure the shards written are removed and
+// ErrInsufficientDurability is returned
+func (dev *DurableDevice) setErasureCoded(blk block.Block) ([]byte, error) {
+	sm, err := dev.shards.GetShardMap(blk.ID())
+	if err == nil && sm != nil {
+		return sm.ID, block.ErrBlockExists
+	}
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	data, err := readBlock(blk)
+	if err != nil {
+		return nil, err
+	}
+
+	rs, _ := newReedSolomon(dev.policy.DataShards, dev.policy.ParityShards)
+	shards := rs.encode(data)
+
+	hosts, err := dev.placement(blk.ID(), len(shards))
+	if err != nil {
+		return nil, err
+	}
+	if len(hosts) < len(shards) {
+		return nil, ErrInsufficientDurability.wrap(fmt.Errorf("%d nodes found for %d shards", len(hosts), len(shards)))
+	}
+
+	sm = &ShardMap{
+		ID:           blk.ID(),
+		Type:         int32(blk.Type()),
+		Size:         uint64(len(data)),
+		DataShards:   int32(rs.data),
+		ParityShards: int32(rs.parity),
+		Shards:       make([]*Shard, len(shards)),
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		errs    []error
+		written []*Shard
+	)
+	for i, s := range shards {
+		shard := newMemBlock(block.BlockTypeData, s, dev.hashFunc)
+		sm.Shards[i] = &Shard{ID: shard.ID(), Host: hosts[i]}
+
+		wg.Add(1)
+		go func(shard *memBlock, s *Shard) {
+			defer wg.Done()
+			_, er := dev.trans.SetBlock(s.Host, shard)
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch er {
+			case nil:
+				written = append(written, s)
+			case block.ErrBlockExists:
+			default:
+				errs = append(errs, er)
+			}
+		}(shard, sm.Shards[i])
+	}
+	wg.Wait()
+
+	// Every shard must be written for the block to have its full durability.
+	// Shards that already existed may belong to another block and are kept
+	if len(errs) > 0 {
+		for _, s := range written {
+			dev.trans.RemoveBlock(s.Host, s.ID)
+		}
+		return nil, ErrInsufficientDurability.wrap(fmt.Errorf("%d of %d shards written: %v",
+			len(shards)-len(errs), len(shards), errs[0]))
+	}
+
+	if err = dev.shards.SetShardMap(sm); err != nil {
+		return nil, err
+	}
+	return sm.ID, nil
+}
+
+// GetBlock returns the block from the base device, its shards or any of its
+// replicas in that order
+func (dev *DurableDevice) GetBlock(id []byte) (block.Block, error) {
+	blk, err := dev.base.GetBlock(id)
+	if err == nil {
+		return blk, nil
+	}
+
+	sm, er := dev.shards.GetShardMap(id)
+	if er == nil && sm != nil {
+		return dev.getErasureCoded(sm)
+	}
+	// Report a failed shard map lookup over the base device error should no
+	// replica be found either
+	if er != nil && !errors.Is(er, ErrNotFound) {
+		err = er
+	}
+
+	hosts, er := dev.placement(id, 0)
+	if er != nil {
+		return nil, err
+	}
+	for _, host := range hosts {
+		if blk, er = dev.trans.GetBlock(host, id); er == nil {
+			return blk, nil
+		}
+	}
+
+	return nil, err
+}
+
+// getErasureCoded reads the shards of a block reconstructing any that are
+// missing
+func (dev *DurableDevice) getErasureCoded(sm *ShardMap) (block.Block, error) {
+	rs, err := newReedSolomon(int(sm.DataShards), int(sm.ParityShards))
+	if err != nil {
+		return nil, err
+	}
+
+	shards := make([][]byte, len(sm.Shards))
+
+	var wg sync.WaitGroup
+	for i, s := range sm.Shards {
+		wg.Add(1)
+		go func(i int, s *Shard) {
+			defer wg.Done()
+			if blk, er := dev.trans.GetBlock(s.Host, s.ID); er == nil {
+				// A shard that cannot be read is treated as missing
+				shards[i], _ = readBlock(blk)
+			}
+		}(i, s)
+	}
+	wg.Wait()
+
+	if err = rs.reconstruct(shards); err != nil {
+		return nil, err
+	}
+
+	blk := newMemBlock(block.BlockType(sm.Type), rs.join(shards, int(sm.Size)), dev.hashFunc)
+	blk.id = sm.ID
+	return blk, nil
+}
+
+// RemoveBlock removes the block, its shards and shard map or its replicas
+func (dev *DurableDevice) RemoveBlock(id []byte) error {
+	sm, err := dev.shards.GetShardMap(id)
+	if err == nil && sm != nil {
+		for _, s := range sm.Shards {
+			dev.trans.RemoveBlock(s.Host, s.ID)
+		}
+		return dev.shards.RemoveShardMap(id)
+	}
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	err = dev.base.RemoveBlock(id)
+	if hosts, er := dev.placement(id, 0); er == nil {
+		for _, host := range hosts {
+			if er = dev.trans.RemoveBlock(host, id); er == nil {
+				err = nil
+			}
+		}
+	}
+	return err
+}
+
+// BlockExists returns true if the block exists on the base device or has a
+// shard map
+func (dev *DurableDevice) BlockExists(id []byte) (bool, error) {
+	if ok, err := dev.base.BlockExists(id); err == nil && ok {
+		return true, nil
+	}
+	sm, err := dev.shards.GetShardMap(id)
+	if err == nil && sm != nil {
+		return true, nil
+	}
+	if errors.Is(err, ErrNotFound) {
+		err = nil
+	}
+	return false, err
+}
+
+// placement returns up to n distinct hosts for the id or all candidates if n is
+// zero.  The nodes for the id are used first followed by those of derived keys
+// so the same hosts are returned in the same order for a stable membership
+func (dev *DurableDevice) placement(id []byte, n int) ([]string, error) {
+	var (
+		hosts = make([]string, 0, n)
+		seen  = make(map[string]bool)
+		key   = append([]byte{}, id...)
+	)
+
+	for i := 0; i < maxPlacementRounds && (n == 0 || len(hosts) < n); i++ {
+		if i > 0 {
+			key = append(append(key[:len(id)], '/'), strconv.Itoa(i)...)
+		}
+
+		nodes, err := dev.dht.Lookup(key)
+		if err != nil {
+			if i == 0 {
+				return nil, err
+			}
+			break
+		}
+
+		for _, node := range nodes {
+			host := node.Host()
+			if seen[host] {
+				continue
+			}
+			seen[host] = true
+			hosts = append(hosts, host)
+			if n > 0 && len(hosts) == n {
+				break
+			}
+		}
+	}
+
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no nodes found for block: %x", id)
+	}
+	return hosts, nil
+}
+
+func readBlock(blk block.Block) ([]byte, error) {
+	rd, err := blk.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+
+	return ioutil.ReadAll(rd)
+}
+
+// memBlock is an in-memory block used for shards and reconstructed blocks
+type memBlock struct {
+	id   []byte
+	typ  block.BlockType
+	data []byte
+}
+
+func newMemBlock(typ block.BlockType, data []byte, hashFunc func() hash.Hash) *memBlock {
+	return &memBlock{id: computeBlockID(hashFunc, typ, data), typ: typ, data: data}
+}
+
+// computeBlockID returns the content address of a block i.e. the hash of its
+// type followed by its data
+func computeBlockID(hashFunc func() hash.Hash, typ block.BlockType, data []byte) []byte {
+	h := hashFunc()
+	h.Write([]byte{byte(typ)})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func (blk *memBlock) ID() []byte {
+	return blk.id
+}
+
+func (blk *memBlock) Type() block.BlockType {
+	return blk.typ
+}
+
+func (blk *memBlock) Size() uint64 {
+	return uint64(len(blk.data))
+}
+
+func (blk *memBlock) Hash() []byte {
+	return blk.id
+}
+
+func (blk *memBlock) Reader() (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(blk.data)), nil
+}
+
+// Writer returns a writer replacing the data of the block on close
+func (blk *memBlock) Writer() (io.WriteCloser, error) {
+	return &memBlockWriter{blk: blk}, nil
+}
+
+type memBlockWriter struct {
+	blk *memBlock
+	buf bytes.Buffer
+}
+
+func (w *memBlockWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *memBlockWriter) Close() error {
+	w.blk.data = w.buf.Bytes()
+	return nil
+}
@@ -0,0 +1,398 @@
+package fidias
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hexablock/blox/block"
+	kelips "github.com/hexablock/go-kelips"
+	"github.com/hexablock/hexalog"
+	"github.com/hexablock/hexatype"
+	"github.com/hexablock/phi"
+)
+
+// testBlockNet is an in-memory set of hosts implementing the dht and block
+// transport used by a DurableDevice.  Shard maps are kept in a kvShardStore
+// over a single node kvs
+type testBlockNet struct {
+	ShardStore
+
+	mu     sync.Mutex
+	hosts  []string
+	blocks map[string]map[string][]byte
+}
+
+func newTestBlockNet(n int) *testBlockNet {
+	bn := &testBlockNet{
+		ShardStore: NewKVShardStore(newTestKVS()),
+		blocks:     make(map[string]map[string][]byte),
+	}
+	for i := 0; i < n; i++ {
+		host := fmt.Sprintf("host-%d", i)
+		bn.hosts = append(bn.hosts, host)
+		bn.blocks[host] = make(map[string][]byte)
+	}
+	return bn
+}
+
+// Lookup returns 2 hosts determined by the first byte of the key
+func (bn *testBlockNet) Lookup(key []byte) ([]*hexatype.Node, error) {
+	var sum byte
+	for _, b := range key {
+		sum += b
+	}
+	nodes := make([]*hexatype.Node, 2)
+	for i := range nodes {
+		nodes[i] = &hexatype.Node{Address: bn.hosts[(int(sum)+i)%len(bn.hosts)]}
+	}
+	return nodes, nil
+}
+
+func (bn *testBlockNet) Insert(key []byte, tuple kelips.TupleHost) error { return nil }
+func (bn *testBlockNet) Delete(key []byte, tuple kelips.TupleHost) error { return nil }
+
+func (bn *testBlockNet) SetBlock(host string, blk block.Block) ([]byte, error) {
+	data, err := readBlock(blk)
+	if err != nil {
+		return nil, err
+	}
+	bn.mu.Lock()
+	defer bn.mu.Unlock()
+	bn.blocks[host][string(blk.ID())] = data
+	return blk.ID(), nil
+}
+
+func (bn *testBlockNet) GetBlock(host string, id []byte) (block.Block, error) {
+	bn.mu.Lock()
+	defer bn.mu.Unlock()
+	data, ok := bn.blocks[host][string(id)]
+	if !ok {
+		return nil, block.ErrBlockNotFound
+	}
+	return &memBlock{id: id, typ: block.BlockTypeData, data: data}, nil
+}
+
+func (bn *testBlockNet) RemoveBlock(host string, id []byte) error {
+	bn.mu.Lock()
+	defer bn.mu.Unlock()
+	delete(bn.blocks[host], string(id))
+	return nil
+}
+
+func (bn *testBlockNet) BlockExists(id []byte) (bool, error) { return false, nil }
+
+// count returns the number of hosts storing the id
+func (bn *testBlockNet) count(id []byte) int {
+	var c int
+	for _, blocks := range bn.blocks {
+		if _, ok := blocks[string(id)]; ok {
+			c++
+		}
+	}
+	return c
+}
+
+// testWAL is the log of a single node applying entries to the fsm as they are
+// proposed
+type testWAL struct {
+	mu  sync.Mutex
+	fsm interface {
+		Apply(entryID []byte, entry *hexalog.Entry) interface{}
+	}
+	// Applied entries by id and the id of the last entry of each key
+	entries map[string]*hexalog.Entry
+	last    map[string][]byte
+}
+
+func newTestWAL(fsm interface {
+	Apply(entryID []byte, entry *hexalog.Entry) interface{}
+}) *testWAL {
+	return &testWAL{fsm: fsm, entries: make(map[string]*hexalog.Entry), last: make(map[string][]byte)}
+}
+
+func (wal *testWAL) GetEntry(key, id []byte) (*hexalog.Entry, error) {
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+	if ent, ok := wal.entries[string(id)]; ok && bytes.Equal(ent.Key, key) {
+		return ent, nil
+	}
+	return nil, hexatype.ErrEntryNotFound
+}
+
+func (wal *testWAL) NewEntry(key []byte) (*hexalog.Entry, []*hexalog.Participant, error) {
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+	if id, ok := wal.last[string(key)]; ok {
+		return wal.newEntryFrom(wal.entries[string(id)])
+	}
+	return &hexalog.Entry{Key: key, Height: 1, Timestamp: uint64(time.Now().UnixNano())}, []*hexalog.Participant{{}}, nil
+}
+
+func (wal *testWAL) NewEntryFrom(entry *hexalog.Entry) (*hexalog.Entry, []*hexalog.Participant, error) {
+	return wal.newEntryFrom(entry)
+}
+
+func (wal *testWAL) newEntryFrom(entry *hexalog.Entry) (*hexalog.Entry, []*hexalog.Participant, error) {
+	ent := &hexalog.Entry{
+		Key:       entry.Key,
+		Previous:  testEntryID(entry),
+		Height:    entry.Height + 1,
+		Timestamp: uint64(time.Now().UnixNano()),
+	}
+	return ent, []*hexalog.Participant{{}}, nil
+}
+
+// ProposeEntry applies the entry if it follows the last entry of its key
+func (wal *testWAL) ProposeEntry(entry *hexalog.Entry, opts *hexalog.RequestOptions, retry *phi.RetryOptions) ([]byte, *phi.WriteStats, error) {
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+
+	if !bytes.Equal(entry.Previous, wal.last[string(entry.Key)]) {
+		return nil, nil, fmt.Errorf("previous entry mismatch")
+	}
+
+	id := testEntryID(entry)
+	if resp := wal.fsm.Apply(id, entry); resp != nil {
+		if err, ok := resp.(error); ok {
+			return nil, nil, err
+		}
+	}
+	wal.entries[string(id)] = entry
+	wal.last[string(entry.Key)] = id
+	return id, &phi.WriteStats{}, nil
+}
+
+func testEntryID(entry *hexalog.Entry) []byte {
+	id := sha256.Sum256([]byte(fmt.Sprintf("%s/%d", entry.Key, entry.Height)))
+	return id[:]
+}
+
+// testDHT places every key on the single local node
+type testDHT struct{}
+
+func (testDHT) Lookup(key []byte) ([]*hexatype.Node, error)     { return []*hexatype.Node{{}}, nil }
+func (testDHT) Insert(key []byte, tuple kelips.TupleHost) error { return nil }
+func (testDHT) Delete(key []byte, tuple kelips.TupleHost) error { return nil }
+
+// newTestKVS returns the kvs of the default namespace on a single node
+func newTestKVS() *KVS {
+	store := NewInmemKVStore()
+	fsm := NewFSM("kv/", nil, store)
+	fsm.RegisterDHT(testDHT{})
+
+	trans := newLocalKVTransport("", nil)
+	trans.kv = store
+
+	return NewKVS("kv/", newTestWAL(fsm), trans, testDHT{})
+}
+
+// newTestNamespaces returns the namespaces of a single node
+func newTestNamespaces() *namespaces {
+	ns := newNamespaces("kv/", nil)
+
+	trans := newLocalKVTransport("", nil)
+	trans.namespaces = ns
+	ns.registerWAL(newTestWAL(ns), trans, testDHT{})
+
+	def, _ := ns.get(DefaultNamespace)
+	trans.kv = def.store
+	return ns
+}
+
+// testBaseDevice is a base device that never has any blocks
+type testBaseDevice struct{}
+
+func (testBaseDevice) SetBlock(blk block.Block) ([]byte, error) { return nil, errors.New("unused") }
+func (testBaseDevice) GetBlock(id []byte) (block.Block, error)  { return nil, block.ErrBlockNotFound }
+func (testBaseDevice) RemoveBlock(id []byte) error              { return block.ErrBlockNotFound }
+func (testBaseDevice) BlockExists(id []byte) (bool, error)      { return false, nil }
+
+func Test_ParseDurability(t *testing.T) {
+	d, err := ParseDurability("3")
+	if err != nil || d.Replicas != 3 || d.ErasureCoded() || d.String() != "3" {
+		t.Fatal("wrong replicas", d, err)
+	}
+	if d, err = ParseDurability("4:2"); err != nil || !d.ErasureCoded() || d.String() != "4:2" {
+		t.Fatal("wrong erasure coding", d, err)
+	}
+	for _, s := range []string{"", "0", "x", "4:", "0:2", "200:100"} {
+		if _, err = ParseDurability(s); err == nil {
+			t.Fatalf("%q should fail", s)
+		}
+	}
+}
+
+func Test_DurableDevice_replicated(t *testing.T) {
+	bn := newTestBlockNet(5)
+	dev, err := NewDurableDevice(&Durability{Replicas: 4}, testBaseDevice{}, bn, bn, bn, sha256.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blk := newMemBlock(block.BlockTypeData, []byte("replicated"), sha256.New)
+	if _, err = dev.SetBlock(blk); err != nil {
+		t.Fatal(err)
+	}
+	if c := bn.count(blk.ID()); c != 4 {
+		t.Fatal("wrong replica count", c)
+	}
+
+	// Readable with any single replica and without the policy
+	reader, _ := NewDurableDevice(nil, testBaseDevice{}, bn, bn, bn, sha256.New)
+	hosts, _ := reader.placement(blk.ID(), 4)
+	for _, h := range hosts[:3] {
+		bn.RemoveBlock(h, blk.ID())
+	}
+	got, err := reader.GetBlock(blk.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := readBlock(got); !bytes.Equal(data, []byte("replicated")) {
+		t.Fatal("wrong data", string(data))
+	}
+}
+
+func Test_DurableDevice_erasureCoded(t *testing.T) {
+	bn := newTestBlockNet(8)
+	dev, err := NewDurableDevice(&Durability{DataShards: 4, ParityShards: 2}, testBaseDevice{}, bn, bn, bn, sha256.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := bytes.Repeat([]byte("erasure coded block "), 10)
+	blk := newMemBlock(block.BlockTypeData, data, sha256.New)
+	if _, err = dev.SetBlock(blk); err != nil {
+		t.Fatal(err)
+	}
+
+	sm, err := bn.GetShardMap(blk.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, s := range sm.Shards {
+		seen[s.Host] = true
+	}
+	if len(sm.Shards) != 6 || len(seen) != 6 {
+		t.Fatal("shards should be on distinct hosts", sm)
+	}
+
+	// Lose parity worth of shards
+	bn.RemoveBlock(sm.Shards[0].Host, sm.Shards[0].ID)
+	bn.RemoveBlock(sm.Shards[4].Host, sm.Shards[4].ID)
+
+	got, err := dev.GetBlock(blk.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := readBlock(got); !bytes.Equal(out, data) || !bytes.Equal(got.ID(), blk.ID()) {
+		t.Fatal("wrong reconstructed block")
+	}
+
+	bn.RemoveBlock(sm.Shards[1].Host, sm.Shards[1].ID)
+	if _, err = dev.GetBlock(blk.ID()); err != errTooFewShards {
+		t.Fatal("should fail", err)
+	}
+
+	// Non-data blocks are replicated to parity+1 nodes
+	idx := newMemBlock(block.BlockTypeIndex, []byte("index"), sha256.New)
+	if _, err = dev.SetBlock(idx); err != nil {
+		t.Fatal(err)
+	}
+	if c := bn.count(idx.ID()); c != 3 {
+		t.Fatal("wrong replica count", c)
+	}
+
+	if err = dev.RemoveBlock(blk.ID()); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := dev.BlockExists(blk.ID()); ok {
+		t.Fatal("should be removed")
+	}
+}
+
+// testFailingBlockNet fails block writes to the listed hosts
+type testFailingBlockNet struct {
+	*testBlockNet
+	failing map[string]bool
+}
+
+func (bn *testFailingBlockNet) SetBlock(host string, blk block.Block) ([]byte, error) {
+	if bn.failing[host] {
+		return nil, errors.New("write failed")
+	}
+	return bn.testBlockNet.SetBlock(host, blk)
+}
+
+func Test_DurableDevice_insufficientDurability(t *testing.T) {
+	bn := &testFailingBlockNet{testBlockNet: newTestBlockNet(8), failing: make(map[string]bool)}
+
+	rdev, _ := NewDurableDevice(&Durability{Replicas: 3}, testBaseDevice{}, bn, bn, bn, sha256.New)
+	blk := newMemBlock(block.BlockTypeData, []byte("replicated"), sha256.New)
+	hosts, _ := rdev.placement(blk.ID(), 3)
+	bn.failing[hosts[1]] = true
+
+	if _, err := rdev.SetBlock(blk); !errors.Is(err, ErrInsufficientDurability) {
+		t.Fatal("should fail with one copy missing", err)
+	}
+
+	// More replicas than nodes cannot be placed
+	small := newTestBlockNet(2)
+	sdev, _ := NewDurableDevice(&Durability{Replicas: 3}, testBaseDevice{}, small, small, small, sha256.New)
+	if _, err := sdev.SetBlock(blk); !errors.Is(err, ErrInsufficientDurability) {
+		t.Fatal("should fail with too few nodes", err)
+	}
+	edev, _ := NewDurableDevice(&Durability{DataShards: 2, ParityShards: 1}, testBaseDevice{}, small, small, small, sha256.New)
+	if _, err := edev.SetBlock(blk); !errors.Is(err, ErrInsufficientDurability) {
+		t.Fatal("should fail with fewer nodes than shards", err)
+	}
+
+	// A failed shard write leaves no shard map and removes the written shards
+	bn = &testFailingBlockNet{testBlockNet: newTestBlockNet(8), failing: make(map[string]bool)}
+	edev, _ = NewDurableDevice(&Durability{DataShards: 4, ParityShards: 2}, testBaseDevice{}, bn, bn, bn, sha256.New)
+	data := bytes.Repeat([]byte("erasure coded block "), 10)
+	eblk := newMemBlock(block.BlockTypeData, data, sha256.New)
+	hosts, _ = edev.placement(eblk.ID(), 6)
+	bn.failing[hosts[5]] = true
+
+	if _, err := edev.SetBlock(eblk); !errors.Is(err, ErrInsufficientDurability) {
+		t.Fatal("should fail with a shard missing", err)
+	}
+	if _, err := bn.GetShardMap(eblk.ID()); !errors.Is(err, ErrNotFound) {
+		t.Fatal("shard map should not be written", err)
+	}
+	for _, h := range hosts[:5] {
+		if n := len(bn.blocks[h]); n != 0 {
+			t.Fatal("written shards should be removed", h, n)
+		}
+	}
+}
+
+func Test_kvShardStore(t *testing.T) {
+	store := NewKVShardStore(newTestKVS())
+
+	sm := &ShardMap{ID: []byte("block"), Shards: []*Shard{{ID: []byte("s1")}, {ID: []byte("s2")}}}
+	if err := store.SetShardMap(sm); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := store.FindShardMap([]byte("s2"))
+	if err != nil || !bytes.Equal(got.ID, sm.ID) {
+		t.Fatal("shard map not found", got, err)
+	}
+	if _, err = store.FindShardMap([]byte("s3")); !errors.Is(err, ErrNotFound) {
+		t.Fatal("should not be found", err)
+	}
+
+	if err = store.RemoveShardMap(sm.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.FindShardMap([]byte("s1")); !errors.Is(err, ErrNotFound) {
+		t.Fatal("shard index should be removed", err)
+	}
+}
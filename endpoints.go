@@ -0,0 +1,159 @@
+package fidias
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// endpoint is a remote fidias rpc host the client can submit requests to
+type endpoint struct {
+	host string
+	// Whether the last request or health check succeeded
+	healthy bool
+	// Last time the endpoint was checked or used
+	lastCheck time.Time
+	// Last error seen for the endpoint
+	lastErr error
+}
+
+// endpointSet contains all known endpoints and their health.  The active
+// endpoint is the one requests are sent to first
+type endpointSet struct {
+	mu sync.RWMutex
+
+	active string
+
+	// Endpoints in the order they were added
+	order     []string
+	endpoints map[string]*endpoint
+}
+
+func newEndpointSet(hosts []string) *endpointSet {
+	set := &endpointSet{
+		order:     make([]string, 0, len(hosts)),
+		endpoints: make(map[string]*endpoint, len(hosts)),
+	}
+
+	for _, h := range hosts {
+		set.add(h)
+	}
+
+	if len(set.order) > 0 {
+		set.active = set.order[0]
+	}
+
+	return set
+}
+
+// add adds the host to the set if it does not exist.  New endpoints are
+// assumed healthy until a check or request fails.  It returns true if the
+// endpoint was added
+func (set *endpointSet) add(host string) bool {
+	if host == "" {
+		return false
+	}
+
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	if _, ok := set.endpoints[host]; ok {
+		return false
+	}
+
+	set.endpoints[host] = &endpoint{host: host, healthy: true}
+	set.order = append(set.order, host)
+
+	return true
+}
+
+// setActive sets the host as the active endpoint and marks it healthy
+func (set *endpointSet) setActive(host string) {
+	set.mu.Lock()
+	if ep, ok := set.endpoints[host]; ok {
+		set.active = host
+		ep.healthy = true
+		ep.lastErr = nil
+		ep.lastCheck = time.Now()
+	}
+	set.mu.Unlock()
+}
+
+// markHealthy marks the host as healthy
+func (set *endpointSet) markHealthy(host string) {
+	set.mark(host, nil)
+}
+
+// markFailed marks the host as unhealthy with the error
+func (set *endpointSet) markFailed(host string, err error) {
+	set.mark(host, err)
+}
+
+func (set *endpointSet) mark(host string, err error) {
+	set.mu.Lock()
+	if ep, ok := set.endpoints[host]; ok {
+		ep.healthy = err == nil
+		ep.lastErr = err
+		ep.lastCheck = time.Now()
+	}
+	set.mu.Unlock()
+}
+
+// candidates returns the hosts in the order they should be tried.  The active
+// host is first if healthy, followed by all other healthy hosts and finally the
+// unhealthy ones
+func (set *endpointSet) candidates() []string {
+	set.mu.RLock()
+	defer set.mu.RUnlock()
+
+	healthy := make([]string, 0, len(set.order))
+	unhealthy := make([]string, 0)
+
+	if ep, ok := set.endpoints[set.active]; ok && ep.healthy {
+		healthy = append(healthy, ep.host)
+	}
+
+	for _, h := range set.order {
+		if h == set.active && set.endpoints[h].healthy {
+			continue
+		}
+
+		if set.endpoints[h].healthy {
+			healthy = append(healthy, h)
+		} else {
+			unhealthy = append(unhealthy, h)
+		}
+	}
+
+	return append(healthy, unhealthy...)
+}
+
+// hosts returns all known hosts
+func (set *endpointSet) hosts() []string {
+	set.mu.RLock()
+	out := make([]string, len(set.order))
+	copy(out, set.order)
+	set.mu.RUnlock()
+
+	return out
+}
+
+// isUnavailable returns true if the error is due to the remote host being
+// unreachable rather than the request itself failing.  Deadlines are not as
+// the request may have been applied, nor are typed errors returned by the
+// remote such as ErrNoQuorum
+func isUnavailable(err error) bool {
+	s, ok := status.FromError(err)
+	if !ok || s.Code() != codes.Unavailable {
+		return false
+	}
+	for _, kind := range errorKinds {
+		if kind.Code == s.Code() && strings.HasPrefix(s.Message(), kind.Message) {
+			return false
+		}
+	}
+	return true
+}
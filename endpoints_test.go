@@ -0,0 +1,58 @@
+package fidias
+
+import (
+	"fmt"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func Test_endpointSet(t *testing.T) {
+	set := newEndpointSet([]string{"host1", "host2", "host3"})
+
+	if set.add("host1") {
+		t.Fatal("should not add existing host")
+	}
+	if !set.add("host4") {
+		t.Fatal("should add new host")
+	}
+
+	c := set.candidates()
+	if c[0] != "host1" || len(c) != 4 {
+		t.Fatalf("wrong candidates: %v", c)
+	}
+
+	set.markFailed("host1", fmt.Errorf("unavailable"))
+	c = set.candidates()
+	if c[0] != "host2" || c[3] != "host1" {
+		t.Fatalf("failed host should be last: %v", c)
+	}
+
+	set.setActive("host3")
+	c = set.candidates()
+	if c[0] != "host3" || c[1] != "host2" {
+		t.Fatalf("active host should be first: %v", c)
+	}
+
+	set.markHealthy("host1")
+	c = set.candidates()
+	if c[1] != "host1" {
+		t.Fatalf("healthy host should be tried in order: %v", c)
+	}
+}
+
+func Test_isUnavailable(t *testing.T) {
+	if !isUnavailable(status.Error(codes.Unavailable, "transport is closing")) {
+		t.Fatal("unreachable host should be unavailable")
+	}
+	if isUnavailable(toRPCError(ErrTimeout)) {
+		t.Fatal("timed out requests may have been applied")
+	}
+	if isUnavailable(toRPCError(ErrNoQuorum)) {
+		t.Fatal("errors returned by the remote are not unavailable")
+	}
+	if isUnavailable(fmt.Errorf("unavailable")) {
+		t.Fatal("non status errors are not unavailable")
+	}
+}
@@ -0,0 +1,241 @@
+package fidias
+
+import (
+	"errors"
+	"fmt"
+)
+
+var errTooFewShards = errors.New("too few shards to reconstruct")
+
+// Exponent and log tables for GF(2^8) using the polynomial
+// x^8 + x^4 + x^3 + x^2 + 1.  The exponent table is doubled to avoid a modulo
+// in multiplication
+var (
+	gfExp [510]byte
+	gfLog [256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfLog[x] = byte(i)
+		if x <<= 1; x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	for i := 255; i < len(gfExp); i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+// gfInv returns the multiplicative inverse of a non-zero element
+func gfInv(a byte) byte {
+	return gfExp[255-int(gfLog[a])]
+}
+
+// reedSolomon is a systematic Reed-Solomon erasure code.  Data is split into
+// data shards followed by parity shards computed using a Cauchy matrix so any
+// data shards worth of shards can reconstruct the rest
+type reedSolomon struct {
+	data   int
+	parity int
+	// parity x data coding matrix
+	matrix [][]byte
+}
+
+func newReedSolomon(data, parity int) (*reedSolomon, error) {
+	if data < 1 || parity < 1 || data+parity > 256 {
+		return nil, fmt.Errorf("invalid shard counts: %d+%d", data, parity)
+	}
+
+	rs := &reedSolomon{data: data, parity: parity, matrix: make([][]byte, parity)}
+	for i := range rs.matrix {
+		rs.matrix[i] = make([]byte, data)
+		for j := range rs.matrix[i] {
+			rs.matrix[i][j] = gfInv(byte(data+i) ^ byte(j))
+		}
+	}
+	return rs, nil
+}
+
+// encode splits the data into equally sized data shards, padding the last with
+// zeros, and returns them followed by the parity shards
+func (rs *reedSolomon) encode(data []byte) [][]byte {
+	size := (len(data) + rs.data - 1) / rs.data
+	if size == 0 {
+		size = 1
+	}
+
+	shards := make([][]byte, rs.data+rs.parity)
+	for i := range shards {
+		shards[i] = make([]byte, size)
+		if i < rs.data && i*size < len(data) {
+			copy(shards[i], data[i*size:])
+		}
+	}
+
+	rs.encodeParity(shards)
+	return shards
+}
+
+// encodeParity computes the parity shards from the data shards
+func (rs *reedSolomon) encodeParity(shards [][]byte) {
+	for i, row := range rs.matrix {
+		out := shards[rs.data+i]
+		for b := range out {
+			out[b] = 0
+		}
+		for j, c := range row {
+			for b, v := range shards[j] {
+				out[b] ^= gfMul(c, v)
+			}
+		}
+	}
+}
+
+// reconstruct fills in missing i.e. nil shards in place.  At least as many
+// shards as data shards must be present and all present shards must be of the
+// same size
+func (rs *reedSolomon) reconstruct(shards [][]byte) error {
+	if len(shards) != rs.data+rs.parity {
+		return fmt.Errorf("wrong number of shards: %d", len(shards))
+	}
+
+	// Rows of the full coding matrix for the first data shards worth of present
+	// shards
+	present := make([]int, 0, rs.data)
+	size := -1
+	for i, s := range shards {
+		if s == nil || len(present) == rs.data {
+			continue
+		}
+		if size >= 0 && len(s) != size {
+			return fmt.Errorf("shard size mismatch")
+		}
+		size = len(s)
+		present = append(present, i)
+	}
+	if len(present) < rs.data {
+		return errTooFewShards
+	}
+
+	// Recover missing data shards by inverting the sub-matrix of the present
+	// shards
+	var missingData bool
+	for i := 0; i < rs.data; i++ {
+		if shards[i] == nil {
+			missingData = true
+			break
+		}
+	}
+
+	if missingData {
+		sub := make([][]byte, rs.data)
+		for r, i := range present {
+			if i < rs.data {
+				sub[r] = make([]byte, rs.data)
+				sub[r][i] = 1
+			} else {
+				sub[r] = append([]byte(nil), rs.matrix[i-rs.data]...)
+			}
+		}
+
+		inv, err := gfInvertMatrix(sub)
+		if err != nil {
+			return err
+		}
+
+		for i := 0; i < rs.data; i++ {
+			if shards[i] != nil {
+				continue
+			}
+			out := make([]byte, size)
+			for r, j := range present {
+				c := inv[i][r]
+				for b, v := range shards[j] {
+					out[b] ^= gfMul(c, v)
+				}
+			}
+			shards[i] = out
+		}
+	}
+
+	// Recompute any missing parity from the complete data
+	for i := rs.data; i < len(shards); i++ {
+		if shards[i] == nil {
+			for j := rs.data; j < len(shards); j++ {
+				if shards[j] == nil {
+					shards[j] = make([]byte, size)
+				}
+			}
+			rs.encodeParity(shards)
+			break
+		}
+	}
+
+	return nil
+}
+
+// join concatenates the data shards truncating the result to size bytes
+func (rs *reedSolomon) join(shards [][]byte, size int) []byte {
+	out := make([]byte, 0, size)
+	for _, s := range shards[:rs.data] {
+		out = append(out, s...)
+	}
+	if len(out) > size {
+		out = out[:size]
+	}
+	return out
+}
+
+// gfInvertMatrix inverts a square matrix using Gauss-Jordan elimination
+func gfInvertMatrix(m [][]byte) ([][]byte, error) {
+	n := len(m)
+	// Augment with the identity
+	work := make([][]byte, n)
+	for i := range m {
+		work[i] = make([]byte, 2*n)
+		copy(work[i], m[i])
+		work[i][n+i] = 1
+	}
+
+	for c := 0; c < n; c++ {
+		p := c
+		for p < n && work[p][c] == 0 {
+			p++
+		}
+		if p == n {
+			return nil, fmt.Errorf("singular matrix")
+		}
+		work[c], work[p] = work[p], work[c]
+
+		inv := gfInv(work[c][c])
+		for j := range work[c] {
+			work[c][j] = gfMul(work[c][j], inv)
+		}
+
+		for r := 0; r < n; r++ {
+			if r == c || work[r][c] == 0 {
+				continue
+			}
+			f := work[r][c]
+			for j := range work[r] {
+				work[r][j] ^= gfMul(f, work[c][j])
+			}
+		}
+	}
+
+	out := make([][]byte, n)
+	for i := range work {
+		out[i] = work[i][n:]
+	}
+	return out, nil
+}
@@ -0,0 +1,49 @@
+package fidias
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_reedSolomon(t *testing.T) {
+	rs, err := newReedSolomon(4, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("the quick brown fox jumps over the lazy dog")
+	shards := rs.encode(data)
+	if len(shards) != 6 {
+		t.Fatal("wrong shard count", len(shards))
+	}
+
+	// Every combination of 2 missing shards is recoverable
+	for i := 0; i < 6; i++ {
+		for j := i + 1; j < 6; j++ {
+			c := make([][]byte, len(shards))
+			copy(c, shards)
+			c[i], c[j] = nil, nil
+
+			if err = rs.reconstruct(c); err != nil {
+				t.Fatal(i, j, err)
+			}
+			for k := range c {
+				if !bytes.Equal(c[k], shards[k]) {
+					t.Fatalf("shard %d wrong after losing %d,%d", k, i, j)
+				}
+			}
+			if !bytes.Equal(rs.join(c, len(data)), data) {
+				t.Fatal("wrong data")
+			}
+		}
+	}
+
+	shards[0], shards[1], shards[5] = nil, nil, nil
+	if err = rs.reconstruct(shards); err != errTooFewShards {
+		t.Fatal("should fail", err)
+	}
+
+	if _, err = newReedSolomon(0, 2); err == nil {
+		t.Fatal("should fail without data shards")
+	}
+}
@@ -0,0 +1,198 @@
+package fidias
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/hexablock/hexatype"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	// ErrNotFound is returned when a key does not exist
+	ErrNotFound = &Error{Code: codes.NotFound, Message: "key not found", Err: hexatype.ErrKeyNotFound}
+
+	// ErrIndexNotFound is returned when querying an index that is not defined
+	ErrIndexNotFound = &Error{Code: codes.NotFound, Message: "index not found"}
+
+	// ErrUploadNotFound is returned when a multipart upload does not exist or
+	// has already been completed or aborted
+	ErrUploadNotFound = &Error{Code: codes.NotFound, Message: "upload not found"}
+
+	// ErrNamespaceNotFound is returned for requests to a namespace that is not
+	// configured on the cluster
+	ErrNamespaceNotFound = &Error{Code: codes.NotFound, Message: "namespace not found"}
+
+	// ErrPermissionDenied is returned when the token of a request is not
+	// allowed the access by the acl of the namespace
+	ErrPermissionDenied = &Error{Code: codes.PermissionDenied, Message: "permission denied"}
+
+	// ErrCASMismatch is returned when the modification supplied to a
+	// check-and-set operation is not the current one
+	ErrCASMismatch = &Error{Code: codes.Aborted, Message: "modification mismatch"}
+
+	// ErrTypeChange is returned when a write would change the type of an
+	// existing key e.g. a directory to a file
+	ErrTypeChange = &Error{Code: codes.FailedPrecondition, Message: "cannot change key-value type"}
+
+	// ErrDirNotEmpty is returned when removing a directory that has children
+	// without removing them first
+	ErrDirNotEmpty = &Error{Code: codes.FailedPrecondition, Message: "directory not empty"}
+
+	// ErrKeyExists is returned when a key to be created already exists
+	ErrKeyExists = &Error{Code: codes.AlreadyExists, Message: "key exists"}
+
+	// ErrQuotaExceeded is returned when a write would exceed the quota of the
+	// namespace
+	ErrQuotaExceeded = &Error{Code: codes.ResourceExhausted, Message: "quota exceeded"}
+
+	// ErrEncrypted is returned for server-side value operations e.g. increment,
+	// append or moving a value to another key in a namespace encrypted by the
+	// client
+	ErrEncrypted = &Error{Code: codes.FailedPrecondition, Message: "not supported on encrypted values"}
+
+	// ErrNoQuorum is returned when a log entry could not be committed by a
+	// quorum of the participants
+	ErrNoQuorum = &Error{Code: codes.Unavailable, Message: "quorum not reached"}
+
+	// ErrInsufficientDurability is returned when a block could not be written
+	// to as many nodes as its durability policy requires
+	ErrInsufficientDurability = &Error{Code: codes.Unavailable, Message: "insufficient durability"}
+
+	// ErrTimeout is returned when an operation does not complete before the
+	// deadline.  It matches context.DeadlineExceeded
+	ErrTimeout = &Error{Code: codes.DeadlineExceeded, Message: "timed out", Err: context.DeadlineExceeded}
+)
+
+// errorKinds are the typed errors returned over grpc.  Kinds are matched in
+// order by code and message prefix
+var errorKinds = []*Error{
+	ErrNotFound,
+	ErrIndexNotFound,
+	ErrUploadNotFound,
+	ErrNamespaceNotFound,
+	ErrPermissionDenied,
+	ErrCASMismatch,
+	ErrTypeChange,
+	ErrDirNotEmpty,
+	ErrKeyExists,
+	ErrQuotaExceeded,
+	ErrEncrypted,
+	ErrNoQuorum,
+	ErrInsufficientDurability,
+	ErrTimeout,
+}
+
+// Error is a typed fidias error.  Each error has a grpc code and message
+// identifying its kind.  Errors of the same kind match using errors.Is
+// regardless of the underlying cause
+type Error struct {
+	// Code the error maps to when returned over grpc
+	Code codes.Code
+	// Description of the error kind
+	Message string
+	// Underlying cause if any
+	Err error
+}
+
+// Error returns the message followed by the underlying cause if any
+func (e *Error) Error() string {
+	if e.Err == nil || e.Err.Error() == e.Message {
+		return e.Message
+	}
+	return e.Message + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying cause
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is returns true if the target is an Error of the same kind
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code && t.Message == e.Message
+}
+
+// wrap returns a new error of the same kind with err as the cause
+func (e *Error) wrap(err error) error {
+	return &Error{Code: e.Code, Message: e.Message, Err: err}
+}
+
+// contextError converts context errors to their fidias equivalent
+func contextError(err error) error {
+	if err == context.DeadlineExceeded {
+		return ErrTimeout
+	}
+	return err
+}
+
+// toRPCError converts errors to their grpc status equivalent so they are
+// returned to the caller with the appropriate code
+func toRPCError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var ferr *Error
+	switch {
+	case errors.As(err, &ferr):
+		return status.Error(ferr.Code, err.Error())
+
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+
+	case errors.Is(err, hexatype.ErrKeyNotFound):
+		return status.Error(codes.NotFound, ErrNotFound.wrap(err).Error())
+
+	}
+
+	return err
+}
+
+// fromRPCError converts grpc status errors back to the fidias and context
+// errors allowing callers to test them using errors.Is.  Status errors not
+// matching a kind are returned as is
+func fromRPCError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	s, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+
+	// Messages of typed errors are unique so the kind is matched exactly
+	for _, kind := range errorKinds {
+		if s.Code() == kind.Code && strings.HasPrefix(s.Message(), kind.Message) {
+			return remoteError(kind, s.Message())
+		}
+	}
+
+	switch s.Code() {
+	case codes.Canceled:
+		return context.Canceled
+
+	case codes.DeadlineExceeded:
+		return ErrTimeout
+
+	}
+
+	return err
+}
+
+// remoteError returns an error of the given kind from the message returned by
+// a remote.  The message contains the kind message followed by the cause
+func remoteError(kind *Error, msg string) error {
+	cause := strings.TrimPrefix(msg, kind.Message)
+	if cause == "" {
+		return kind
+	}
+	return kind.wrap(errors.New(strings.TrimPrefix(cause, ": ")))
+}
@@ -0,0 +1,82 @@
+package fidias
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/hexablock/hexatype"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func Test_Error_Is(t *testing.T) {
+	err := ErrCASMismatch.wrap(fmt.Errorf("entry not found"))
+	if !errors.Is(err, ErrCASMismatch) {
+		t.Fatal("should be a cas mismatch")
+	}
+	if errors.Is(err, ErrNotFound) {
+		t.Fatal("should not be not found")
+	}
+	if errors.Is(ErrDirNotEmpty, ErrTypeChange) {
+		t.Fatal("kinds with the same code should not match")
+	}
+	if err.Error() != "modification mismatch: entry not found" {
+		t.Fatalf("wrong message: %s", err)
+	}
+
+	if !errors.Is(ErrTimeout, context.DeadlineExceeded) {
+		t.Fatal("timeout should match deadline exceeded")
+	}
+	if !errors.Is(contextError(context.DeadlineExceeded), ErrTimeout) {
+		t.Fatal("deadline exceeded should convert to timeout")
+	}
+}
+
+func Test_remoteError(t *testing.T) {
+	err := remoteError(ErrNoQuorum, "quorum not reached: insufficient peers")
+	if !errors.Is(err, ErrNoQuorum) {
+		t.Fatal("should be no quorum")
+	}
+	if err.Error() != "quorum not reached: insufficient peers" {
+		t.Fatalf("wrong message: %s", err)
+	}
+
+	if err = remoteError(ErrTypeChange, ErrTypeChange.Message); err != ErrTypeChange {
+		t.Fatalf("should return the kind: %v", err)
+	}
+
+	err = fromRPCError(toRPCError(ErrDirNotEmpty.wrap(fmt.Errorf("dir"))))
+	if !errors.Is(err, ErrDirNotEmpty) {
+		t.Fatalf("should be dir not empty: %v", err)
+	}
+
+	if err = fromRPCError(toRPCError(ErrIndexNotFound)); err != ErrIndexNotFound {
+		t.Fatalf("should be index not found: %v", err)
+	}
+	if err = fromRPCError(toRPCError(ErrQuotaExceeded)); err != ErrQuotaExceeded {
+		t.Fatalf("should be quota exceeded: %v", err)
+	}
+	if err = fromRPCError(toRPCError(ErrUploadNotFound)); !errors.Is(err, ErrUploadNotFound) || errors.Is(err, ErrNotFound) {
+		t.Fatalf("should be upload not found: %v", err)
+	}
+	if err = fromRPCError(toRPCError(ErrEncrypted)); !errors.Is(err, ErrEncrypted) || errors.Is(err, ErrTypeChange) {
+		t.Fatalf("should be encrypted: %v", err)
+	}
+	if err = fromRPCError(toRPCError(hexatype.ErrKeyNotFound)); err != ErrNotFound {
+		t.Fatalf("should be not found: %v", err)
+	}
+	if err = fromRPCError(status.Error(codes.FailedPrecondition, "other")); errors.Is(err, ErrTypeChange) {
+		t.Fatalf("unknown errors should not be typed: %v", err)
+	}
+}
+
+func Test_retryable(t *testing.T) {
+	if retryable(ErrCASMismatch) || retryable(hexatype.ErrPreviousHash) {
+		t.Fatal("typed errors and cas mismatches should not be retried")
+	}
+	if !retryable(hexatype.ErrInsufficientPeers) {
+		t.Fatal("insufficient peers should be retried")
+	}
+}
@@ -1,10 +1,12 @@
 package fidias
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"time"
 
+	"github.com/hexablock/blox"
 	kelips "github.com/hexablock/go-kelips"
 	"github.com/hexablock/phi"
 )
@@ -14,31 +16,58 @@ import (
 type Fidias struct {
 	conf *Config
 
+	// Default namespace store
 	kvstore KVStore
 
+	// All namespaces.  It is the fsm routing entries to each namespace
+	namespaces *namespaces
+
 	fsm phi.FSM
 
 	phi *phi.Phi
 
+	// Default namespace kvs
 	kvs *KVS
+
+	// Network transport serving kv reads and merkle trees
+	kvnet *NetTransport
+
+	// Transport and shard maps used by durable block devices
+	blockTrans BlockTransport
+	shards     ShardStore
 }
 
 // Create creates a new fidias instance.  It inits the local node, gossip layer
 // and associated delegates
 func Create(conf *Config) (*Fidias, error) {
 
-	fid := &Fidias{
-		conf:    conf,
-		kvstore: NewInmemKVStore(),
-	}
+	fid := &Fidias{conf: conf}
 
 	localTuple := kelips.NewTupleHost(conf.Phi.DHT.AdvertiseHost)
-	fid.fsm = NewFSM(conf.KVPrefix, localTuple, fid.kvstore)
+	fid.namespaces = newNamespaces(conf.KVPrefix, localTuple)
+	if err := fid.namespaces.setNamespaces(conf.Token, conf.Namespaces); err != nil {
+		return nil, err
+	}
+	fid.namespaces.setQuotas(conf.DefaultQuota, conf.Quotas)
+	fid.namespaces.compression = conf.Compression
+	if err := fid.namespaces.setIndexes(conf.Indexes); err != nil {
+		return nil, err
+	}
+	fid.fsm = fid.namespaces
+
+	def, err := fid.namespaces.get(DefaultNamespace)
+	if err != nil {
+		return nil, err
+	}
+	fid.kvstore = def.store
 
 	kvnet := NewNetTransport(30*time.Second, 300*time.Second)
+	kvnet.namespaces = fid.namespaces
+	kvnet.token = conf.Token
 	RegisterFidiasRPCServer(fid.conf.Phi.GRPCServer, kvnet)
 
 	kvtrans := newLocalKVTransport(fid.conf.Phi.Hexalog.AdvertiseHost, kvnet)
+	kvtrans.namespaces = fid.namespaces
 	kvtrans.Register(fid.kvstore)
 
 	ph, err := phi.Create(conf.Phi, fid.fsm)
@@ -47,10 +76,24 @@ func Create(conf *Config) (*Fidias, error) {
 	}
 
 	fid.phi = ph
-	fid.kvs = NewKVS(fid.conf.KVPrefix, fid.phi.WAL(), kvtrans, fid.phi.DHT())
+	fid.namespaces.registerWAL(fid.phi.WAL(), kvtrans, fid.phi.DHT())
+	fid.kvs = def.kvs
 
 	kvnet.kvs = fid.kvs
 	kvnet.localProv = ph
+	fid.kvnet = kvnet
+
+	bkvs, err := fid.namespaces.kvs(BloxNamespace)
+	if err != nil {
+		return nil, err
+	}
+	fid.shards = NewKVShardStore(bkvs)
+	fid.blockTrans = blox.NewNetTransport(blox.DefaultNetClientOptions(conf.Phi.HashFunc))
+
+	// Quotas are enforced against the usage reported by every node
+	if conf.DefaultQuota != nil || len(conf.Quotas) > 0 {
+		fid.namespaces.startUsageReports(conf.Phi.Hexalog.AdvertiseHost, usageReportInterval)
+	}
 
 	return fid, nil
 }
@@ -96,16 +139,99 @@ func (fidias *Fidias) BlockDevice() *phi.BlockDevice {
 	return fidias.phi.BlockDevice()
 }
 
+// DurableDevice returns a block device writing blocks with the durability
+// policy.  A nil policy writes using the cluster block device as is.  Reads
+// from any durable device return blocks written with any policy
+func (fidias *Fidias) DurableDevice(policy *Durability) (*DurableDevice, error) {
+	return NewDurableDevice(policy, fidias.phi.BlockDevice(), fidias.phi.DHT(), fidias.blockTrans, fidias.shards, fidias.conf.Phi.HashFunc)
+}
+
+// BlockGC returns a garbage collector for the blocks uploaded to the cluster
+func (fidias *Fidias) BlockGC() (*BlockGC, error) {
+	dev, err := fidias.DurableDevice(nil)
+	if err != nil {
+		return nil, err
+	}
+	return NewBlockGC(func(name string) (BloxKV, error) {
+		return fidias.Namespace(name)
+	}, dev), nil
+}
+
+// NewScrubber returns a scrubber verifying the blocks stored on this node in
+// the local device of its block device
+func (fidias *Fidias) NewScrubber() (*Scrubber, error) {
+	dev, err := fidias.DurableDevice(nil)
+	if err != nil {
+		return nil, err
+	}
+	local := fidias.phi.BlockDevice().LocalDevice()
+	return NewScrubber(fidias.conf.Phi.DHT.AdvertiseHost, local, dev), nil
+}
+
+// NewAntiEntropy returns anti-entropy comparing the namespaces on this node
+// with the other nodes holding their keys
+func (fidias *Fidias) NewAntiEntropy() *AntiEntropy {
+	return newAntiEntropy(fidias.conf.Phi.Hexalog.AdvertiseHost, fidias.kvnet, fidias.namespaces)
+}
+
+// MultipartUploads returns the multipart uploads of the cluster.  Uploads
+// started on any node can be continued on this one
+func (fidias *Fidias) MultipartUploads() (*MultipartUploads, error) {
+	bkvs, err := fidias.Namespace(BloxNamespace)
+	if err != nil {
+		return nil, err
+	}
+	return NewMultipartUploads(bkvs, fidias, fidias.DurableDevice, fidias.conf.Phi.HashFunc), nil
+}
+
 // WAL returns the write-ahead-log for consistent operations
 func (fidias *Fidias) WAL() phi.WAL {
 	return fidias.phi.WAL()
 }
 
-// KVS returns the kvs instance
+// KVS returns the kvs instance for the default namespace
 func (fidias *Fidias) KVS() *KVS {
 	return fidias.kvs
 }
 
+// Namespace returns the kvs instance for the named namespace.  It returns
+// ErrNamespaceNotFound if the namespace is not configured
+func (fidias *Fidias) Namespace(name string) (*KVS, error) {
+	return fidias.namespaces.kvs(name)
+}
+
+// Authorize returns ErrNamespaceNotFound if the namespace is not configured
+// and ErrPermissionDenied if its acl does not allow the token the access
+func (fidias *Fidias) Authorize(namespace, token string, write bool) error {
+	return fidias.namespaces.authorize(namespace, token, write)
+}
+
+// Usage returns the usage and quota of the namespace on the local node.  Usage
+// covers the keys stored by the node and blocks uploaded through it
+func (fidias *Fidias) Usage(namespace string) (*NamespaceUsage, error) {
+	return fidias.namespaces.usage(namespace)
+}
+
+// RegisterRoot registers an uploaded root to the namespace so it is tracked by
+// the garbage collector
+func (fidias *Fidias) RegisterRoot(namespace string, root *RootRequest) error {
+	_, err := fidias.namespaces.registerRoot(context.Background(), namespace, root)
+	return err
+}
+
+// BeginUpload marks an upload of size bytes to the namespace in progress so
+// the blocks it de-duplicates against are not swept by the garbage collector.
+// The size is checked against the quota of the namespace
+func (fidias *Fidias) BeginUpload(namespace, uploadID string, size int64) error {
+	_, err := fidias.namespaces.beginUpload(context.Background(), namespace, uploadID, size)
+	return err
+}
+
+// EndUpload clears the marker of a failed or aborted upload to the namespace
+func (fidias *Fidias) EndUpload(namespace, uploadID string) error {
+	return fidias.namespaces.endUpload(context.Background(), namespace, uploadID)
+}
+
 func (fidias *Fidias) Shutdown() error {
 	return fmt.Errorf("TBI")
 }
@@ -2,7 +2,11 @@ package fidias
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
+	"os"
+
+	"github.com/golang/protobuf/proto"
 
 	kelips "github.com/hexablock/go-kelips"
 	"github.com/hexablock/hexalog"
@@ -15,6 +19,17 @@ const (
 	opKVSet byte = iota + 1
 	// OpDel is the op to delete a key-value pair
 	opKVDel
+	// OpSetPair is the op to set a key-value pair along with its metadata.  The
+	// data is a serialized KVPair
+	opKVSetPair
+	// OpIncr is the op to add a delta to an integer value.  The data is the
+	// big endian encoded signed 64-bit delta
+	opKVIncr
+	// OpAppend is the op to append data to a value
+	opKVAppend
+	// OpMkdir is the op to create a directory.  The data is a serialized
+	// KVPair containing the directory metadata
+	opKVMkdir
 )
 
 // KVStore is the kv store used by the FSM to perform write operations
@@ -26,12 +41,18 @@ type KVStore interface {
 	// have been implicitly created
 	Set(kvp *KVPair) ([]*KVPair, error)
 
-	// Delete a key.  Called by the fsm
-	Remove(key []byte) error
+	// Delete a key.  Called by the fsm.  Directories with children cannot be
+	// removed.  It returns any implicitly created directories that were removed
+	// as they no longer have children
+	Remove(key []byte) ([]*KVPair, error)
 
 	// Iterate over kv's starting at the prefix.  If recurse is true then all
 	// keys in subdirs are also returned
 	Iter(prefix []byte, recurse bool, f func(kv *KVPair) bool)
+
+	// Iterate over the kv's in the range given by the options in ascending or
+	// descending order
+	Scan(opts *ScanOptions, f func(kv *KVPair) bool)
 }
 
 // FSM is a hexalog FSM for an in-memory key-value store.  It implements the
@@ -49,6 +70,19 @@ type FSM struct {
 
 	// DHT
 	dht phi.DHT
+
+	// Storage used by the applied keys
+	usage *Usage
+
+	// Called with the previous and new view of each key applied or repaired.
+	// The new view is nil for removed keys.  Optional
+	observe func(prev, cur *KVPair)
+
+	// Secondary indexes maintained as keys are applied
+	indexes indexSet
+
+	// Merkle trees of the applied keys compared by anti-entropy
+	merkle *merkleForest
 }
 
 // NewFSM inits a new FSM. localTuple is the local host port tuple for the dht
@@ -57,7 +91,36 @@ func NewFSM(kvprefix string, localTuple kelips.TupleHost, kvs KVStore) *FSM {
 		kvprefix:   []byte(kvprefix),
 		localTuple: localTuple,
 		kvs:        kvs,
+		usage:      &Usage{},
+		merkle:     newMerkleForest(),
+	}
+}
+
+// registerIndexes sets the secondary indexes maintained by the fsm.  It must be
+// called before any entries are applied
+func (fsm *FSM) registerIndexes(defs []*Index) {
+	fsm.indexes = newIndexSet(defs)
+}
+
+// lookupIndex returns the pairs in the local store matching the index query
+func (fsm *FSM) lookupIndex(q *IndexQuery) ([]*KVPair, error) {
+	keys, err := fsm.indexes.query(q)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*KVPair, 0, len(keys))
+	for _, k := range keys {
+		if kvp, er := fsm.kvs.Get([]byte(k)); er == nil {
+			out = append(out, kvp)
+		}
 	}
+	return out, nil
+}
+
+// Usage returns the storage used by the keys applied to the local store
+func (fsm *FSM) Usage() *Usage {
+	return fsm.usage.snapshot()
 }
 
 // RegisterDHT registers the dht to the state machine.  THis is to allow inserts
@@ -82,11 +145,39 @@ func (fsm *FSM) Apply(entryID []byte, entry *hexalog.Entry) interface{} {
 
 	switch op {
 	case opKVSet:
-		resp = fsm.applyKVSet(entryID, entry, entry.Data[1:])
+		resp = fsm.applyKVSet(entryID, entry, &KVPair{Value: entry.Data[1:]})
+
+	case opKVSetPair:
+		var kvp KVPair
+		if err := proto.Unmarshal(entry.Data[1:], &kvp); err != nil {
+			resp = err
+			break
+		}
+		resp = fsm.applyKVSet(entryID, entry, &kvp)
 
 	case opKVDel:
 		resp = fsm.applyKVDelete(entry)
 
+	case opKVIncr:
+		if len(entry.Data) != 9 {
+			resp = fmt.Errorf("invalid increment data size: %d", len(entry.Data)-1)
+			break
+		}
+		delta := int64(binary.BigEndian.Uint64(entry.Data[1:]))
+		resp = fsm.applyKVIncr(entryID, entry, delta)
+
+	case opKVAppend:
+		resp = fsm.applyKVAppend(entryID, entry, entry.Data[1:])
+
+	case opKVMkdir:
+		var kvp KVPair
+		if err := proto.Unmarshal(entry.Data[1:], &kvp); err != nil {
+			resp = err
+			break
+		}
+		kvp.Flags = int64(os.ModeDir)
+		resp = fsm.applyKVSet(entryID, entry, &kvp)
+
 	default:
 		resp = fmt.Errorf("invalid operation: %x", op)
 
@@ -95,49 +186,147 @@ func (fsm *FSM) Apply(entryID []byte, entry *hexalog.Entry) interface{} {
 	return resp
 }
 
-func (fsm *FSM) applyKVSet(entryID []byte, entry *hexalog.Entry, value []byte) error {
+// applyKVSet applies a set operation.  Only the value and its metadata are used
+// from the supplied pair.  The remaining fields are derived from the entry
+func (fsm *FSM) applyKVSet(entryID []byte, entry *hexalog.Entry, data *KVPair) error {
 	kv := &KVPair{
 		Key:          bytes.TrimPrefix(entry.Key, fsm.kvprefix),
-		Value:        value,
+		Value:        data.Value,
+		ContentType:  data.ContentType,
+		Compression:  data.Compression,
+		Flags:        data.Flags,
+		Mode:         data.Mode,
+		Owner:        data.Owner,
+		Metadata:     data.Metadata,
 		Modification: entryID,
 		ModTime:      entry.Timestamp,
 		LTime:        entry.LTime,
 		Height:       entry.Height,
 	}
 
+	return fsm.setKV(entry.Key, kv, "set")
+}
+
+// repair replaces the local view of a key with a newer view from another
+// replica found by anti-entropy.  The view is stored as is.  It returns
+// errNotStale if the local view is not older
+func (fsm *FSM) repair(kv *KVPair) error {
+	if cur, err := fsm.kvs.Get(kv.Key); err == nil && cur.Height >= kv.Height {
+		return errNotStale
+	}
+	return fsm.setKV(append(append([]byte{}, fsm.kvprefix...), kv.Key...), kv, "repair")
+}
+
+// setKV writes the view to the store updating the usage, indexes, merkle trees
+// and dht
+func (fsm *FSM) setKV(nskey []byte, kv *KVPair, op string) error {
+	prev, _ := fsm.kvs.Get(kv.Key)
+
 	createdDirs, err := fsm.kvs.Set(kv)
 	if err != nil {
 		return err
 	}
 
+	keys, size := int64(len(createdDirs)), kvSize(kv)-kvSize(prev)
+	if prev == nil {
+		keys++
+	}
+	for _, c := range createdDirs {
+		size += kvSize(c)
+	}
+	fsm.usage.addKVs(keys, size)
+	fsm.indexes.update(kv.Key, kv)
+	fsm.merkle.apply(kv)
+	if fsm.observe != nil {
+		fsm.observe(prev, kv)
+	}
+
 	// Insert key to dht
-	if err = fsm.dht.Insert(entry.Key, fsm.localTuple); err != nil {
+	if err = fsm.dht.Insert(nskey, fsm.localTuple); err != nil {
 		log.Println("[ERROR] FSM dht insert failed:", err)
 	}
 
 	// Insert any directories created to dht
 	for _, c := range createdDirs {
 		nskey := append(fsm.kvprefix, c.Key...)
-		log.Printf("%s", nskey)
 		if er := fsm.dht.Insert(nskey, fsm.localTuple); er != nil {
 			log.Println("[ERROR] FSM dht insert failed:", er)
 			err = er
 		}
 	}
 
-	log.Printf("[DEBUG] FSM nskey=%s op=set dirs-created=%d height=%d error='%v'",
-		entry.Key, len(createdDirs), kv.Height, err)
+	log.Printf("[DEBUG] FSM nskey=%s op=%s dirs-created=%d height=%d error='%v'",
+		nskey, op, len(createdDirs), kv.Height, err)
 
 	return err
 }
 
+// applyKVIncr applies an increment operation adding delta to the current
+// integer value of the key.  A missing key is treated as zero
+func (fsm *FSM) applyKVIncr(entryID []byte, entry *hexalog.Entry, delta int64) error {
+	cur, err := fsm.currentKVPair(entry)
+	if err == nil {
+		var kvp *KVPair
+		if kvp, err = incrKVPair(cur, delta); err == nil {
+			return fsm.applyKVSet(entryID, entry, kvp)
+		}
+	}
+	log.Printf("[ERROR] FSM nskey=%s op=incr height=%d error='%v'", entry.Key, entry.Height, err)
+	return err
+}
+
+// applyKVAppend applies an append operation adding data to the end of the
+// current value of the key
+func (fsm *FSM) applyKVAppend(entryID []byte, entry *hexalog.Entry, data []byte) error {
+	cur, err := fsm.currentKVPair(entry)
+	if err != nil {
+		log.Printf("[ERROR] FSM nskey=%s op=append height=%d error='%v'", entry.Key, entry.Height, err)
+		return err
+	}
+	return fsm.applyKVSet(entryID, entry, appendKVPair(cur, data))
+}
+
+// currentKVPair returns the current pair for the entry key with its value
+// decompressed.  A nil pair is returned if it does not exist
+func (fsm *FSM) currentKVPair(entry *hexalog.Entry) (*KVPair, error) {
+	kvp, err := fsm.kvs.Get(bytes.TrimPrefix(entry.Key, fsm.kvprefix))
+	if err != nil {
+		return nil, nil
+	}
+	return decompressPair(kvp)
+}
+
 // ApplyDelete applies a hexalog delete operation entry to the fsm
 func (fsm *FSM) applyKVDelete(entry *hexalog.Entry) error {
 	key := bytes.TrimPrefix(entry.Key, fsm.kvprefix)
-	err := fsm.kvs.Remove(key)
+	prev, _ := fsm.kvs.Get(key)
+
+	removedDirs, err := fsm.kvs.Remove(key)
 	if err == nil {
+		keys, size := int64(-1-len(removedDirs)), -kvSize(prev)
+		for _, d := range removedDirs {
+			size -= kvSize(d)
+		}
+		fsm.usage.addKVs(keys, size)
+		fsm.indexes.update(key, nil)
+		fsm.merkle.remove(key, entry.Height)
+		if fsm.observe != nil {
+			fsm.observe(prev, nil)
+		}
+
 		err = fsm.dht.Delete(entry.Key, fsm.localTuple)
 	}
-	log.Printf("[DEBUG] FSM nskey=%s op=delete height=%d error='%v'", entry.Key, entry.Height, err)
+
+	// Delete any directories removed from the dht
+	for _, d := range removedDirs {
+		nskey := append(fsm.kvprefix, d.Key...)
+		if er := fsm.dht.Delete(nskey, fsm.localTuple); er != nil {
+			log.Println("[ERROR] FSM dht delete failed:", er)
+			err = er
+		}
+	}
+
+	log.Printf("[DEBUG] FSM nskey=%s op=delete dirs-removed=%d height=%d error='%v'",
+		entry.Key, len(removedDirs), entry.Height, err)
 	return err
 }
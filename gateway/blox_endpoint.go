@@ -2,15 +2,23 @@ package gateway
 
 import (
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"strconv"
 
 	"github.com/hexablock/blox"
 	"github.com/hexablock/blox/block"
+	"github.com/hexablock/fidias"
 )
 
+// Number of blocks read or written concurrently per request
+const blockWorkers = 3
+
+var errDurabilityNotSupported = errors.New("durability policies not supported")
+
 // Reference: https://stackoverflow.com/questions/2419281/content-length-header-versus-chunked-encoding
 //
 // Use Content-Length, definitely. The server utilization from this will be almost nonexistent and the
@@ -33,7 +41,18 @@ import (
 // download faster thanks to gzip).
 //
 
-func (server *HTTPServer) handleBlox(w http.ResponseWriter, r *http.Request, resourceID string) {
+// handleBlox handles block requests.  Uploads are checked against and
+// accounted to the quota of the namespace.  Uploads may set a durability
+// policy with ?durability=<replicas> or ?durability=<data>:<parity> for erasure
+// coding and be compressed with ?compression=<snappy|zstd>.  Reads reconstruct
+// blocks written with any policy and decompress compressed files.  Large files
+// may be uploaded in parts under uploads/
+func (server *HTTPServer) handleBlox(w http.ResponseWriter, r *http.Request, namespace, resourceID string) {
+	if base, rest := parseDirBase(resourceID); base == "uploads" {
+		server.handleUploads(w, r, namespace, rest)
+		return
+	}
+
 	var err error
 
 	switch r.Method {
@@ -41,7 +60,7 @@ func (server *HTTPServer) handleBlox(w http.ResponseWriter, r *http.Request, res
 		err = server.handlerBloxGet(w, resourceID)
 
 	case http.MethodPost:
-		err = server.handlerBloxPost(w, r)
+		err = server.handlerBloxPost(w, r, namespace)
 
 	default:
 		w.WriteHeader(405)
@@ -62,29 +81,109 @@ func (server *HTTPServer) handlerBloxGet(w http.ResponseWriter, resourceID strin
 		return err
 	}
 
-	asm := blox.NewAssembler(server.Device, 3)
+	var dev blox.BlockDevice = server.Device
+	if server.Durable != nil {
+		if dev, err = server.Durable(nil); err != nil {
+			return err
+		}
+	}
+
+	// The codec is flagged on the root registration
+	codec := fidias.CompressionNone
+	if server.Namespace != nil {
+		bkvs, err := server.Namespace(fidias.BloxNamespace)
+		if err != nil {
+			return err
+		}
+		if codec, err = fidias.RootCompression(bkvs, id); err != nil {
+			return err
+		}
+	}
+
+	asm := blox.NewAssembler(dev, blockWorkers)
 	idx, err := asm.SetRoot(id)
 	if err != nil {
 		return err
 	}
 
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", idx.FileSize()))
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	go func() {
+		pw.CloseWithError(asm.Assemble(pw))
+	}()
+
+	rd, err := fidias.NewDecompressReader(pr, codec)
+	if err != nil {
+		return err
+	}
+
+	// The decompressed size is unknown
+	if codec == fidias.CompressionNone {
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", idx.FileSize()))
+	} else {
+		w.Header().Set(headerCompression, codec.String())
+	}
 	w.Header().Set(headerBlockSize, fmt.Sprintf("%d", idx.BlockSize()))
 	w.Header().Set(headerBlockCount, fmt.Sprintf("%d", idx.BlockCount()))
 	//w.Header().Set(headerBlockReadTime, fmt.Sprintf("%v", asm.Runtime()))
 
 	//  Cannot send an error
-	if err = asm.Assemble(w); err != nil {
+	if _, err = io.Copy(w, rd); err != nil {
 		log.Println("[ERROR]", err)
 	}
 
 	return nil
 }
 
-func (server *HTTPServer) handlerBloxPost(w http.ResponseWriter, r *http.Request) error {
+func (server *HTTPServer) handlerBloxPost(w http.ResponseWriter, r *http.Request, namespace string) error {
 	headers := map[string]string{}
 
-	sharder := blox.NewStreamSharder(server.Device, 3)
+	udev, policy, err := server.uploadDevice(r)
+	if err != nil {
+		return err
+	}
+	dev := fidias.NewDedupeDevice(udev)
+	if policy != nil {
+		headers[headerDurability] = policy.String()
+	}
+
+	codec, err := fidias.ParseCompression(r.URL.Query().Get("compression"))
+	if err != nil {
+		return err
+	}
+	body, err := fidias.NewCompressReader(r.Body, codec)
+	if err != nil {
+		return err
+	}
+	if codec != fidias.CompressionNone {
+		headers[headerCompression] = codec.String()
+	}
+
+	// Marked in progress before any block is written so blocks de-duplicated
+	// against are not swept.  This also checks the quota of the namespace.
+	// The content length is unknown for chunked uploads in which case only
+	// the current usage is checked
+	var (
+		uploadID   string
+		registered bool
+	)
+	if server.Roots != nil {
+		if uploadID, err = fidias.NewUploadID(); err != nil {
+			return err
+		}
+		if err = server.Roots.BeginUpload(namespace, uploadID, r.ContentLength); err != nil {
+			return err
+		}
+		defer func() {
+			if !registered {
+				if er := server.Roots.EndUpload(namespace, uploadID); er != nil {
+					log.Printf("[ERROR] Failed to clear upload marker upload=%s error='%v'", uploadID, er)
+				}
+			}
+		}()
+	}
+
+	sharder := blox.NewStreamSharder(dev, blockWorkers)
 	// assume mbytes
 	if bsize := r.URL.Query().Get("bs"); bsize != "" {
 		bs, err := strconv.ParseInt(bsize, 10, 64)
@@ -94,8 +193,7 @@ func (server *HTTPServer) handlerBloxPost(w http.ResponseWriter, r *http.Request
 		sharder.SetBlockSize(uint64(bs * 1024 * 1024))
 	}
 
-	err := sharder.Shard(r.Body)
-	if err != nil {
+	if err = sharder.Shard(body); err != nil {
 		return err
 	}
 
@@ -105,7 +203,7 @@ func (server *HTTPServer) handlerBloxPost(w http.ResponseWriter, r *http.Request
 	headers[headerBlockWriteTime] = fmt.Sprintf("%v", sharder.Runtime())
 
 	data := sharder.IndexBlock()
-	if _, err = server.Device.SetBlock(data); err != nil {
+	if _, err = dev.SetBlock(data); err != nil {
 
 		if err == block.ErrBlockExists {
 			// The server has fulfilled a request for the resource, and the
@@ -117,6 +215,92 @@ func (server *HTTPServer) handlerBloxPost(w http.ResponseWriter, r *http.Request
 
 	}
 
+	dstats := dev.Stats()
+	headers[headerDedupeBlocks] = fmt.Sprintf("%d/%d", dstats.DuplicateBlocks, dstats.Blocks)
+	headers[headerLogicalBytes] = fmt.Sprintf("%d", dstats.LogicalBytes)
+	headers[headerPhysicalBytes] = fmt.Sprintf("%d", dstats.PhysicalBytes)
+
+	// Track the root for garbage collection and the savings of the upload
+	if err == nil && server.Roots != nil {
+		reg := fidias.NewRootRequest(data, codec, dstats)
+		reg.Upload = uploadID
+		err = server.Roots.RegisterRoot(namespace, reg)
+		registered = err == nil
+	}
+
 	writeJSONResponse(w, code, headers, data, err)
 	return nil
 }
+
+// handleDedupe returns the cluster-wide de-duplication report of uploads
+func (server *HTTPServer) handleDedupe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONResponse(w, 405, nil, nil, errMethodNotAllowed)
+		return
+	}
+	if server.Namespace == nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	bkvs, err := server.Namespace(fidias.BloxNamespace)
+	if err != nil {
+		writeJSONError(w, nil, err)
+		return
+	}
+
+	report, err := fidias.ReadDedupeReport(bkvs)
+	if err != nil {
+		writeJSONError(w, nil, err)
+		return
+	}
+	writeJSONResponse(w, 200, nil, report, nil)
+}
+
+// handleScrub returns the status of the block scrubber on GET and starts a run
+// in the background on POST
+func (server *HTTPServer) handleScrub(w http.ResponseWriter, r *http.Request) {
+	if server.Scrubber == nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSONResponse(w, 200, nil, server.Scrubber.Status(), nil)
+
+	case http.MethodPost:
+		go func() {
+			if err := server.Scrubber.Run(); err != nil {
+				log.Printf("[ERROR] Scrub failed error='%v'", err)
+			}
+		}()
+		w.WriteHeader(202)
+
+	default:
+		writeJSONResponse(w, 405, nil, nil, errMethodNotAllowed)
+	}
+}
+
+// uploadDevice returns the block device for the durability policy of the
+// upload.  The cluster device is returned if no policy is requested
+func (server *HTTPServer) uploadDevice(r *http.Request) (blox.BlockDevice, *fidias.Durability, error) {
+	val := r.URL.Query().Get("durability")
+	if val == "" {
+		return server.Device, nil, nil
+	}
+	if server.Durable == nil {
+		return nil, nil, errDurabilityNotSupported
+	}
+
+	policy, err := fidias.ParseDurability(val)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dev, err := server.Durable(policy)
+	if err != nil {
+		return nil, nil, err
+	}
+	return dev, policy, nil
+}
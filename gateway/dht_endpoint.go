@@ -55,14 +55,12 @@ func (server *HTTPServer) handleDHT(w http.ResponseWriter, r *http.Request, reso
 		err = server.DHT.Insert([]byte(hpath[0]), tuple)
 
 	default:
-		w.WriteHeader(405)
-		w.Write([]byte(`{"error": "Method not allowed"}`))
+		writeJSONResponse(w, 405, nil, nil, errMethodNotAllowed)
 		return
 	}
 
 	if err != nil {
-		w.WriteHeader(400)
-		w.Write([]byte(`{"error": "` + err.Error() + `"}`))
+		writeJSONResponse(w, 400, nil, nil, err)
 	}
 
 }
@@ -0,0 +1,76 @@
+package gateway
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+)
+
+// acceptsGzip returns true if the client accepts gzip encoded responses
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		if i := strings.IndexByte(enc, ';'); i >= 0 {
+			enc = enc[:i]
+		}
+		if strings.TrimSpace(enc) == "gzip" {
+			return true
+		}
+	}
+	return false
+}
+
+// gzipResponseWriter gzip encodes the response body.  The header is deferred
+// until the first write so responses without a body are sent unencoded
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz     *gzip.Writer
+	status int
+}
+
+func newGzipResponseWriter(w http.ResponseWriter) *gzipResponseWriter {
+	return &gzipResponseWriter{ResponseWriter: w}
+}
+
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	if w.gz == nil {
+		h := w.Header()
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", "gzip")
+		h.Add("Vary", "Accept-Encoding")
+		w.writeHeader()
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+	return w.gz.Write(p)
+}
+
+// Flush flushes the encoded data written so far to the client
+func (w *gzipResponseWriter) Flush() {
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close completes the encoded body or sends the header if nothing was written
+func (w *gzipResponseWriter) Close() error {
+	if w.gz == nil {
+		w.writeHeader()
+		return nil
+	}
+	return w.gz.Close()
+}
+
+func (w *gzipResponseWriter) writeHeader() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+}
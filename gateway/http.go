@@ -1,7 +1,9 @@
 package gateway
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -18,17 +20,28 @@ const (
 	headerBlockWriteTime = "Block-Write-Time"
 	headerBlockReadTime  = "Block-Read-Time"
 	headerBlockCount     = "Block-Count"
+	headerCompression    = "Compression"
+	headerDedupeBlocks   = "Dedupe-Blocks"
+	headerDurability     = "Durability"
 	headerFsmTime        = "Fsm-Time"
 	headerGroup          = "Group-Index"
+	headerLogicalBytes   = "Logical-Bytes"
 	headerLookupTime     = "Lookup-Time"
 	headerNodeHBeat      = "Node-Heartbeats"
 	headerNodeRTT        = "Node-Rtt"
 	headerNodePriority   = "Node-Priority"
 	headerParticipants   = "Participants"
+	headerPhysicalBytes  = "Physical-Bytes"
 	headerRespTime       = "Response-Time"
 	headerRuntime        = "Runtime"
 )
 
+// Non-standard status code used when the client closes the connection before
+// the request completes
+const statusClientClosedRequest = 499
+
+var errMethodNotAllowed = errors.New("method not allowed")
+
 var accessControlHeaders = map[string]string{
 	"Access-Control-Allow-Origin": "*",
 }
@@ -37,7 +50,34 @@ var accessControlHeaders = map[string]string{
 type HTTPServer struct {
 	DHT    phi.DHT
 	Device *phi.BlockDevice
-	KVS    *fidias.KVS
+	// Default namespace kvs
+	KVS *fidias.KVS
+	// Returns the kvs for a named namespace
+	Namespace func(name string) (*fidias.KVS, error)
+	// Authorizes the bearer token of a request for the namespace.  All
+	// requests are allowed if nil
+	Authorize func(namespace, token string, write bool) error
+	// Namespace usage and quotas.  Uploads are not checked if nil
+	Quotas Quotas
+	// Registers uploaded roots with the garbage collector.  Roots are not
+	// registered if nil
+	Roots fidias.RootRegistry
+	// Returns a block device for a durability policy.  If nil uploads with a
+	// policy are rejected and reads only use Device
+	Durable func(policy *fidias.Durability) (*fidias.DurableDevice, error)
+	// Block scrubber of the node.  The scrub endpoint is disabled if nil
+	Scrubber *fidias.Scrubber
+	// KV anti-entropy of the node.  The anti-entropy endpoint is disabled if
+	// nil
+	AntiEntropy *fidias.AntiEntropy
+	// Multipart uploads.  The uploads endpoints are disabled if nil
+	Uploads *fidias.MultipartUploads
+}
+
+// Quotas provides the usage of namespaces.  Quotas on block uploads are
+// enforced by the nodes when uploads begin and roots are registered
+type Quotas interface {
+	Usage(namespace string) (*fidias.NamespaceUsage, error)
 }
 
 func (server *HTTPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -47,6 +87,12 @@ func (server *HTTPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if acceptsGzip(r) {
+		gw := newGzipResponseWriter(w)
+		defer gw.Close()
+		w = gw
+	}
+
 	// Resource may be empty and is left up to the specific implementation to
 	// handle
 	endpoint, resource := parseDirBase(reqpath)
@@ -56,15 +102,121 @@ func (server *HTTPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		server.handleDHT(w, r, resource)
 
 	case "blox":
-		server.handleBlox(w, r, resource)
+		if server.authorize(w, r, fidias.DefaultNamespace) {
+			server.handleBlox(w, r, fidias.DefaultNamespace, resource)
+		}
 
 	case "kv":
-		server.handleKV(w, r, resource)
+		if server.authorize(w, r, fidias.DefaultNamespace) {
+			server.handleKV(w, r, server.KVS, resource)
+		}
+
+	case "index":
+		if server.authorize(w, r, fidias.DefaultNamespace) {
+			server.handleIndex(w, r, server.KVS, resource)
+		}
+
+	case "usage":
+		if server.authorize(w, r, fidias.DefaultNamespace) {
+			server.handleUsage(w, r, fidias.DefaultNamespace)
+		}
+
+	case "scrub":
+		server.handleScrub(w, r)
+
+	case "antientropy":
+		server.handleAntiEntropy(w, r)
+
+	case "dedupe":
+		server.handleDedupe(w, r)
+
+	case "v1":
+		server.handleV1(w, r, resource)
+
+	default:
+		w.WriteHeader(404)
+	}
+
+}
+
+// handleV1 handles versioned endpoints.  Currently only namespaced requests of
+// the form ns/<name>/<kv|index|blox|usage>/<resource> are supported
+func (server *HTTPServer) handleV1(w http.ResponseWriter, r *http.Request, resource string) {
+	endpoint, rest := parseDirBase(resource)
+	if endpoint != "ns" || server.Namespace == nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	name, rest := parseDirBase(rest)
+	if name == "" {
+		w.WriteHeader(404)
+		return
+	}
+	if !server.authorize(w, r, name) {
+		return
+	}
+
+	endpoint, resource = parseDirBase(rest)
+	switch endpoint {
+	case "kv", "index":
+		kvs, err := server.Namespace(name)
+		if err != nil {
+			writeJSONResponse(w, 400, nil, nil, err)
+			return
+		}
+		if endpoint == "kv" {
+			server.handleKV(w, r, kvs, resource)
+		} else {
+			server.handleIndex(w, r, kvs, resource)
+		}
+
+	case "blox":
+		server.handleBlox(w, r, name, resource)
+
+	case "usage":
+		server.handleUsage(w, r, name)
 
 	default:
 		w.WriteHeader(404)
 	}
+}
+
+// authorize checks the bearer token of the request against the acl of the
+// namespace.  Requests other than GET and HEAD are writes.  It writes the error
+// and returns false if the request is not allowed
+func (server *HTTPServer) authorize(w http.ResponseWriter, r *http.Request, namespace string) bool {
+	if server.Authorize == nil {
+		return true
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	write := r.Method != http.MethodGet && r.Method != http.MethodHead
+	if err := server.Authorize(namespace, token, write); err != nil {
+		writeJSONError(w, nil, err)
+		return false
+	}
+	return true
+}
+
+// handleUsage serves the usage and quota of the namespace on this node
+func (server *HTTPServer) handleUsage(w http.ResponseWriter, r *http.Request, namespace string) {
+	if r.Method != http.MethodGet {
+		writeJSONResponse(w, 405, nil, nil, errMethodNotAllowed)
+		return
+	}
+	if server.Quotas == nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	usage, err := server.Quotas.Usage(namespace)
+	if err != nil {
+		writeJSONError(w, nil, err)
+		return
+	}
 
+	writeJSONResponse(w, 200, nil, usage, nil)
 }
 
 func setWriteHeaderStats(w http.ResponseWriter, stats *phi.WriteStats) {
@@ -94,6 +246,70 @@ func setNodeGroupHeaders(w http.ResponseWriter, g, p int, node hexatype.Node) {
 	w.Header().Set(headerNodePriority, fmt.Sprintf("%d", p))
 }
 
+// requestContext returns the context for the request.  If a timeout query
+// parameter is provided e.g. ?timeout=500ms the context is bounded by it
+func requestContext(r *http.Request) (context.Context, context.CancelFunc, error) {
+	tstr := r.URL.Query().Get("timeout")
+	if tstr == "" {
+		ctx, cancel := context.WithCancel(r.Context())
+		return ctx, cancel, nil
+	}
+
+	timeout, err := time.ParseDuration(tstr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	return ctx, cancel, nil
+}
+
+// errorStatusCode returns the http status code for the error
+func errorStatusCode(err error) int {
+	switch {
+	case errors.Is(err, fidias.ErrNotFound), errors.Is(err, fidias.ErrIndexNotFound),
+		errors.Is(err, fidias.ErrUploadNotFound), errors.Is(err, fidias.ErrNamespaceNotFound):
+		return http.StatusNotFound
+
+	case errors.Is(err, fidias.ErrPermissionDenied):
+		return http.StatusForbidden
+
+	case errors.Is(err, fidias.ErrCASMismatch):
+		return http.StatusPreconditionFailed
+
+	case errors.Is(err, fidias.ErrTypeChange), errors.Is(err, fidias.ErrDirNotEmpty),
+		errors.Is(err, fidias.ErrKeyExists):
+		return http.StatusConflict
+
+	case errors.Is(err, fidias.ErrQuotaExceeded):
+		return http.StatusInsufficientStorage
+
+	case errors.Is(err, fidias.ErrNoQuorum), errors.Is(err, fidias.ErrInsufficientDurability):
+		return http.StatusServiceUnavailable
+
+	case errors.Is(err, fidias.ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+
+	case errors.Is(err, context.Canceled):
+		return statusClientClosedRequest
+
+	}
+
+	return http.StatusInternalServerError
+}
+
+// writeJSONError writes the error as a json response with the status code for
+// the error
+func writeJSONError(w http.ResponseWriter, headers map[string]string, err error) {
+	writeJSONResponse(w, errorStatusCode(err), headers, nil, err)
+}
+
+// jsonError returns the json encoded error message
+func jsonError(err error) []byte {
+	b, _ := json.Marshal(map[string]string{"error": err.Error()})
+	return b
+}
+
 // writeJSONResponse writes a json response.  It first sets the headers, then the code and
 // finally the data.  It manages serializing the data.  It data is a byte slice then it simply
 // writes the data without setting any content type headers
@@ -112,7 +328,7 @@ func writeJSONResponse(w http.ResponseWriter, code int, headers map[string]strin
 			c = 400
 		}
 		// Error data
-		b = []byte(`{"error":"` + err.Error() + `"}`)
+		b = jsonError(err)
 
 	} else {
 		if data != nil {
@@ -123,7 +339,7 @@ func writeJSONResponse(w http.ResponseWriter, code int, headers map[string]strin
 				if b, err = json.Marshal(data); err != nil {
 
 					c = 500
-					b = []byte(`{"error":"` + err.Error() + `"}`)
+					b = jsonError(err)
 
 				}
 			}
@@ -0,0 +1,73 @@
+package gateway
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/hexablock/fidias"
+)
+
+// handleIndex handles secondary index queries using the kvs of the requested
+// namespace.  Values are given as query parameters e.g. ?value=alice or
+// ?start=10&end=20&limit=5.  Values that are not valid json are treated as
+// strings
+func (server *HTTPServer) handleIndex(w http.ResponseWriter, r *http.Request, kvs *fidias.KVS, index string) {
+	if index == "" {
+		w.WriteHeader(404)
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeJSONResponse(w, 405, nil, nil, errMethodNotAllowed)
+		return
+	}
+
+	q, err := parseIndexQuery(index, r.URL.Query())
+	if err != nil {
+		writeJSONResponse(w, 400, nil, nil, err)
+		return
+	}
+
+	ctx, cancel, err := requestContext(r)
+	if err != nil {
+		writeJSONResponse(w, 400, nil, nil, err)
+		return
+	}
+	defer cancel()
+
+	kvps, stats, err := kvs.QueryIndexContext(ctx, q)
+	if stats != nil && len(stats.Nodes) > 0 {
+		setReadHeader(w, stats)
+	}
+	if err != nil {
+		writeJSONError(w, nil, err)
+		return
+	}
+
+	writeJSONResponse(w, 200, nil, kvps, nil)
+}
+
+// parseIndexQuery builds the index query from the url query parameters
+func parseIndexQuery(index string, params url.Values) (*fidias.IndexQuery, error) {
+	q := &fidias.IndexQuery{Index: index}
+
+	if v, ok := params["value"]; ok {
+		q.Value = fidias.IndexValue(v[0])
+	}
+	if v := params.Get("start"); v != "" {
+		q.Start = fidias.IndexValue(v)
+	}
+	if v := params.Get("end"); v != "" {
+		q.End = fidias.IndexValue(v)
+	}
+
+	if v := params.Get("limit"); v != "" {
+		limit, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		q.Limit = int32(limit)
+	}
+
+	return q, nil
+}
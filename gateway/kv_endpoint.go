@@ -2,22 +2,36 @@ package gateway
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
 
 	"github.com/hexablock/fidias"
 	"github.com/hexablock/phi"
 )
 
-func (server *HTTPServer) handleKV(w http.ResponseWriter, r *http.Request, resource string) {
+// handleKV handles key-value requests using the kvs of the requested namespace
+func (server *HTTPServer) handleKV(w http.ResponseWriter, r *http.Request, kvs *fidias.KVS, resource string) {
 	if resource == "" {
 		w.WriteHeader(404)
 		return
 	}
 
+	ctx, cancel, err := requestContext(r)
+	if err != nil {
+		writeJSONResponse(w, 400, nil, nil, err)
+		return
+	}
+	defer cancel()
+
 	var (
 		data  interface{}
-		err   error
 		stats *phi.WriteStats
 		key   = []byte(resource)
 	)
@@ -30,14 +44,27 @@ func (server *HTTPServer) handleKV(w http.ResponseWriter, r *http.Request, resou
 			kv     *fidias.KVPair
 		)
 
+		// Scan the range of keys with the resource as the prefix
+		if _, ok := r.URL.Query()["scan"]; ok {
+			var opts *fidias.ScanOptions
+			if opts, err = parseScanOptions(resource, r.URL.Query()); err != nil {
+				writeJSONResponse(w, 400, nil, nil, err)
+				return
+			}
+			if data, rstats, err = kvs.ScanContext(ctx, opts); rstats != nil && len(rstats.Nodes) > 0 {
+				setReadHeader(w, rstats)
+			}
+			break
+		}
+
 		// Get KVPair
-		if kv, rstats, err = server.KVS.Get(key, nil); err != nil {
+		if kv, rstats, err = kvs.GetContext(ctx, key, nil); err != nil {
 			break
 		}
 
 		// List contents if directory
 		if kv.IsDir() {
-			data, rstats, err = server.KVS.List(key, &fidias.ReadOptions{})
+			data, rstats, err = kvs.ListContext(ctx, key, &fidias.ReadOptions{})
 		} else {
 			data = kv
 			setNodeGroupHeaders(w, int(rstats.Group), int(rstats.Priority), *rstats.Nodes[0])
@@ -45,24 +72,74 @@ func (server *HTTPServer) handleKV(w http.ResponseWriter, r *http.Request, resou
 
 		setReadHeader(w, rstats)
 
+		// Write the value as is with its content type
+		if _, ok := r.URL.Query()["raw"]; ok && err == nil && !kv.IsDir() {
+			writeRawValue(w, kv)
+			return
+		}
+
 	case "POST":
-		var value []byte
-		if value, err = ioutil.ReadAll(r.Body); err == nil {
+		q := r.URL.Query()
+
+		// A trailing slash creates a directory
+		if strings.HasSuffix(resource, "/") {
+			var dir *fidias.KVPair
+			if dir, err = parseMkdirRequest(r, resource); err != nil {
+				writeJSONResponse(w, 400, nil, nil, err)
+				return
+			}
 			wo := fidias.DefaultWriteOptions()
-			kv := fidias.NewKVPair([]byte(resource), value)
-			data, stats, err = server.KVS.Set(kv, wo)
+			data, stats, err = kvs.MkdirContext(ctx, dir, wo)
+			break
 		}
 
+		// Increment by the delta defaulting to 1
+		if v, ok := q["incr"]; ok {
+			delta := int64(1)
+			if len(v) > 0 && v[0] != "" {
+				if delta, err = strconv.ParseInt(v[0], 10, 64); err != nil {
+					writeJSONResponse(w, 400, nil, nil, err)
+					return
+				}
+			}
+			wo := fidias.DefaultWriteOptions()
+			data, stats, err = kvs.IncrContext(ctx, key, delta, wo)
+			break
+		}
+
+		var value []byte
+		if value, err = ioutil.ReadAll(r.Body); err != nil {
+			break
+		}
+
+		wo := fidias.DefaultWriteOptions()
+		if _, ok := q["append"]; ok {
+			data, stats, err = kvs.AppendContext(ctx, key, value, wo)
+			break
+		}
+
+		kv := fidias.NewKVPair([]byte(resource), value)
+		kv.ContentType = requestContentType(r)
+		data, stats, err = kvs.SetContext(ctx, kv, wo)
+
 	case "DELETE":
 		wo := fidias.DefaultWriteOptions()
-		stats, err = server.KVS.Remove([]byte(resource), wo)
+		if _, ok := r.URL.Query()["recursive"]; ok {
+			var n int
+			if n, err = kvs.RemoveTreeContext(ctx, key, wo); err == nil {
+				data = map[string]int{"Removed": n}
+			}
+			break
+		}
+		stats, err = kvs.RemoveContext(ctx, key, wo)
 
+	default:
+		writeJSONResponse(w, 405, nil, nil, errMethodNotAllowed)
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-
 	if err != nil {
-		w.Write([]byte(`{"error":"` + err.Error() + `"}`))
+		writeJSONError(w, nil, err)
 		return
 	}
 
@@ -70,14 +147,106 @@ func (server *HTTPServer) handleKV(w http.ResponseWriter, r *http.Request, resou
 		setWriteHeaderStats(w, stats)
 	}
 
-	b, err := json.Marshal(data)
+	writeJSONResponse(w, 200, nil, data, nil)
+}
+
+// mkdirRequest is the optional json body of a directory creation request
+type mkdirRequest struct {
+	// Octal permission bits e.g. 0755
+	Mode     string
+	Owner    string
+	Metadata map[string]string
+}
+
+// parseMkdirRequest returns the directory pair for the resource from the
+// optional json request body.  The mode defaults to 0755
+func parseMkdirRequest(r *http.Request, resource string) (*fidias.KVPair, error) {
+	req := &mkdirRequest{Mode: "0755"}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil && err != io.EOF {
+			return nil, err
+		}
+	}
+
+	mode, err := strconv.ParseUint(req.Mode, 8, 32)
 	if err != nil {
-		w.WriteHeader(500)
+		return nil, err
+	}
+
+	dir := fidias.NewDirKVPair([]byte(strings.TrimSuffix(resource, "/")), os.FileMode(mode))
+	dir.Owner = req.Owner
+	dir.Metadata = req.Metadata
+
+	return dir, nil
+}
+
+// parseScanOptions builds the scan options for the prefix from the url query
+// parameters e.g. ?scan&start=a&end=b&reverse&limit=10&keys
+func parseScanOptions(prefix string, params url.Values) (*fidias.ScanOptions, error) {
+	opts := &fidias.ScanOptions{
+		Prefix: []byte(prefix),
+		Start:  []byte(params.Get("start")),
+		End:    []byte(params.Get("end")),
+	}
+	_, opts.Reverse = params["reverse"]
+	_, opts.KeysOnly = params["keys"]
+
+	if v := params.Get("limit"); v != "" {
+		limit, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		opts.Limit = int32(limit)
+	}
+
+	return opts, nil
+}
+
+// requestContentType returns the content type of the request body to be
+// stored with the value.  Form encoding is ignored as it is the default used
+// by most clients when posting data
+func requestContentType(r *http.Request) string {
+	ct := r.Header.Get("Content-Type")
+	if ct == "application/x-www-form-urlencoded" {
+		return ""
+	}
+	return ct
+}
+
+// writeRawValue writes the value bytes with the stored content type
+func writeRawValue(w http.ResponseWriter, kv *fidias.KVPair) {
+	w.Header().Set("Content-Type", kv.MediaType())
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(kv.Value)))
+	for k, v := range accessControlHeaders {
+		w.Header().Set(k, v)
+	}
+	w.WriteHeader(200)
+	w.Write(kv.Value)
+}
+
+// handleAntiEntropy returns the kv anti-entropy status on GET and starts a run
+// in the background on POST
+func (server *HTTPServer) handleAntiEntropy(w http.ResponseWriter, r *http.Request) {
+	if server.AntiEntropy == nil {
+		w.WriteHeader(404)
 		return
 	}
 
-	w.Write(b)
+	switch r.Method {
+	case http.MethodGet:
+		writeJSONResponse(w, 200, nil, server.AntiEntropy.Status(), nil)
+
+	case http.MethodPost:
+		go func() {
+			if err := server.AntiEntropy.Run(); err != nil {
+				log.Printf("[ERROR] Anti-entropy failed error='%v'", err)
+			}
+		}()
+		w.WriteHeader(202)
 
+	default:
+		writeJSONResponse(w, 405, nil, nil, errMethodNotAllowed)
+	}
 }
 
 func parseDirBase(path string) (string, string) {
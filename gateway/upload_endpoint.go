@@ -0,0 +1,137 @@
+package gateway
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/hexablock/fidias"
+)
+
+// handleUploads handles multipart uploads to the namespace:
+//
+//	POST   uploads                initiate an upload with ?durability and ?bs
+//	PUT    uploads/<id>/<number>  upload a part
+//	GET    uploads/<id>           status of the upload and its parts
+//	POST   uploads/<id>           complete the upload returning the root index
+//	DELETE uploads/<id>           abort the upload
+//
+// Uploads are only visible to the namespace they were initiated in
+func (server *HTTPServer) handleUploads(w http.ResponseWriter, r *http.Request, namespace, resource string) {
+	if server.Uploads == nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	uploadID, part := parseDirBase(resource)
+	if uploadID == "" {
+		if r.Method != http.MethodPost {
+			writeJSONResponse(w, 405, nil, nil, errMethodNotAllowed)
+			return
+		}
+		server.handleUploadInitiate(w, r, namespace)
+		return
+	}
+
+	upload, err := server.Uploads.Upload(uploadID)
+	if err == nil && upload.Namespace != namespace {
+		err = fidias.ErrUploadNotFound
+	}
+	if err != nil {
+		writeJSONError(w, nil, err)
+		return
+	}
+
+	if part != "" {
+		if r.Method != http.MethodPut {
+			writeJSONResponse(w, 405, nil, nil, errMethodNotAllowed)
+			return
+		}
+		server.handleUploadPart(w, r, upload, part)
+		return
+	}
+
+	var (
+		code = 200
+		data interface{}
+	)
+
+	switch r.Method {
+	case http.MethodGet:
+		data, err = server.Uploads.Get(uploadID)
+
+	case http.MethodPost:
+		code = 201
+		data, err = server.Uploads.Complete(uploadID)
+
+	case http.MethodDelete:
+		err = server.Uploads.Abort(uploadID)
+
+	default:
+		writeJSONResponse(w, 405, nil, nil, errMethodNotAllowed)
+		return
+	}
+
+	if err != nil {
+		writeJSONError(w, nil, err)
+		log.Printf("[ERROR] Upload operation failed upload=%s error='%v'", uploadID, err)
+		return
+	}
+	writeJSONResponse(w, code, nil, data, nil)
+}
+
+func (server *HTTPServer) handleUploadInitiate(w http.ResponseWriter, r *http.Request, namespace string) {
+	var (
+		policy *fidias.Durability
+		bs     uint64
+		err    error
+	)
+
+	if val := r.URL.Query().Get("durability"); val != "" {
+		if policy, err = fidias.ParseDurability(val); err != nil {
+			writeJSONResponse(w, 400, nil, nil, err)
+			return
+		}
+	}
+	// assume mbytes
+	if bsize := r.URL.Query().Get("bs"); bsize != "" {
+		if bs, err = strconv.ParseUint(bsize, 10, 64); err != nil {
+			writeJSONResponse(w, 400, nil, nil, err)
+			return
+		}
+		bs *= 1024 * 1024
+	}
+
+	upload, err := server.Uploads.Initiate(namespace, bs, policy)
+	if err != nil {
+		writeJSONError(w, nil, err)
+		return
+	}
+	writeJSONResponse(w, 201, nil, upload, nil)
+}
+
+// handleUploadPart uploads a part of the upload.  Parts are checked against
+// and accounted to the quota of the namespace like regular uploads
+func (server *HTTPServer) handleUploadPart(w http.ResponseWriter, r *http.Request, upload *fidias.MultipartUpload, number string) {
+	headers := map[string]string{}
+
+	n, err := strconv.Atoi(number)
+	if err != nil {
+		writeJSONResponse(w, 400, nil, nil, err)
+		return
+	}
+
+	part, dstats, err := server.Uploads.UploadPart(upload.ID, n, r.Body)
+	if err != nil {
+		writeJSONError(w, headers, err)
+		log.Printf("[ERROR] Part upload failed upload=%s part=%d error='%v'", upload.ID, n, err)
+		return
+	}
+
+	headers[headerDedupeBlocks] = fmt.Sprintf("%d/%d", dstats.DuplicateBlocks, dstats.Blocks)
+	headers[headerLogicalBytes] = fmt.Sprintf("%d", dstats.LogicalBytes)
+	headers[headerPhysicalBytes] = fmt.Sprintf("%d", dstats.PhysicalBytes)
+
+	writeJSONResponse(w, 201, headers, part, nil)
+}
@@ -0,0 +1,834 @@
+package fidias
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hexablock/blox"
+	"github.com/hexablock/blox/block"
+	"github.com/hexablock/log"
+)
+
+// Key prefix in the BloxNamespace under which uploaded roots are registered
+const bloxRootsPrefix = "roots/"
+
+// Metadata keys of root registrations
+const (
+	// Marks a root deleted
+	rootDeletedMetadata = "deleted"
+	// Time in unix nanoseconds a root was marked for collection
+	rootMarkedMetadata = "gc-marked"
+	// Kind of root.  Files have none
+	rootKindMetadata = "kind"
+	// Codec the file was compressed with.  Uncompressed files have none
+	rootCompressionMetadata = "compression"
+)
+
+// Root kind of snapshots
+const rootKindSnapshot = "snapshot"
+
+const (
+	// Key prefix in the BloxNamespace of the markers of uploads in progress
+	bloxPendingPrefix = "pending/"
+	// Key in the BloxNamespace flagging a sweep in progress
+	bloxGCSweepKey = "gc/sweep"
+	// Key in the BloxNamespace of the lease electing the node running gc
+	bloxGCLeaseKey = "gc/lease"
+	// Metadata key of the time in unix nanoseconds a gc lease expires
+	gcLeaseExpiresMetadata = "expires"
+	// Upload markers older than this were left by failed uploads and no longer
+	// hold back sweeps
+	pendingUploadTimeout = 24 * time.Hour
+	// Sweep flags older than this were left by a failed run
+	gcSweepTimeout = time.Hour
+	// Interval uploads poll a sweep in progress at
+	gcSweepPollInterval = time.Second
+)
+
+// RootRegistry registers uploaded roots so they are tracked by the garbage
+// collector.  Nodes register roots in the blox kvs directly.  Clients register
+// them through a node, which authorizes the write to the namespace of the
+// upload
+type RootRegistry interface {
+	// RegisterRoot registers the root to the namespace charging its size to
+	// the quota of the namespace
+	RegisterRoot(namespace string, root *RootRequest) error
+	// BeginUpload marks an upload of size bytes in progress, zero if unknown.
+	// It must be called before the blocks of the upload are written and
+	// returns once no sweep that could remove blocks the upload de-duplicates
+	// against is in progress.  ErrQuotaExceeded is returned if the upload
+	// would exceed the quota of the namespace
+	BeginUpload(namespace, uploadID string, size int64) error
+	// EndUpload clears the marker of a failed or aborted upload.  Markers of
+	// successful uploads are cleared by registering their root
+	EndUpload(namespace, uploadID string) error
+}
+
+// NewUploadID returns a random id for an upload
+func NewUploadID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// validUploadID returns true if the id was generated by NewUploadID.  Ids are
+// used in keys so anything else is rejected
+func validUploadID(uploadID string) bool {
+	b, err := hex.DecodeString(uploadID)
+	return err == nil && len(b) == 16
+}
+
+// NewRootRequest returns the registration of the file with the root index
+// compressed with the codec.  The de-duplication stats of the upload are
+// optional
+func NewRootRequest(idx *block.IndexBlock, c Compression, stats *DedupeStats) *RootRequest {
+	req := &RootRequest{
+		ID:     idx.ID(),
+		Size:   int64(idx.FileSize()),
+		Blocks: int64(idx.BlockCount() + 1),
+	}
+	if c != CompressionNone {
+		req.Compression = c.String()
+	}
+	if stats != nil {
+		req.DedupeBlocks = stats.Blocks
+		req.DuplicateBlocks = stats.DuplicateBlocks
+		req.LogicalBytes = stats.LogicalBytes
+		req.PhysicalBytes = stats.PhysicalBytes
+	}
+	return req
+}
+
+// registerRoot records an uploaded root to the namespace in the blox kvs.  The
+// value is the namespace and the codec the file was compressed with, the kind
+// of root and its size are flagged in its metadata.  Registering an existing
+// root restarts its grace period.
+//
+// The size of the root is charged to the namespace as the registration is
+// applied unless the namespace already holds it.  Roots exceeding the quota
+// are registered deleted so their blocks are collected and ErrQuotaExceeded is
+// returned.  The marker of the upload in the request is cleared once the root
+// is registered.  The de-duplication stats of the upload are added to the
+// cluster-wide counters
+func (ns *namespaces) registerRoot(ctx context.Context, name string, req *RootRequest) (*KVPair, error) {
+	if !ns.exists(name) {
+		return nil, ErrNamespaceNotFound
+	}
+	if len(req.ID) == 0 {
+		return nil, fmt.Errorf("root id required")
+	}
+	if _, err := ParseCompression(req.Compression); err != nil {
+		return nil, err
+	}
+	if req.Upload != "" && !validUploadID(req.Upload) {
+		return nil, ErrUploadNotFound
+	}
+
+	bkvs, err := ns.kvs(BloxNamespace)
+	if err != nil {
+		return nil, err
+	}
+
+	cur, _, err := bkvs.GetContext(ctx, rootKey(req.ID), &ReadOptions{})
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		cur = nil
+	}
+
+	kvp := NewKVPair(rootKey(req.ID), []byte(name))
+	kvp.Metadata = make(map[string]string)
+	if req.Compression != "" {
+		kvp.Metadata[rootCompressionMetadata] = req.Compression
+	}
+	if req.Kind != "" {
+		kvp.Metadata[rootKindMetadata] = req.Kind
+	}
+	if req.Size > 0 || req.Blocks > 0 {
+		kvp.Metadata[rootSizeMetadata] = strconv.FormatInt(req.Size, 10)
+		kvp.Metadata[rootBlocksMetadata] = strconv.FormatInt(req.Blocks, 10)
+	}
+
+	// Namespaces are only charged for roots they do not already hold
+	var quotaErr error
+	if cur == nil || string(cur.Value) != name || rootDeleted(cur) {
+		if quotaErr = ns.checkBlockQuota(ctx, name, req.Size); quotaErr != nil {
+			// Roots of other namespaces are left as they are
+			if !errors.Is(quotaErr, ErrQuotaExceeded) || cur != nil {
+				return nil, quotaErr
+			}
+			kvp.Metadata[rootDeletedMetadata] = "true"
+		}
+	}
+
+	if kvp, _, err = bkvs.SetContext(ctx, kvp, DefaultWriteOptions()); err != nil {
+		return nil, err
+	}
+	if quotaErr != nil {
+		return nil, quotaErr
+	}
+
+	if req.Upload != "" {
+		if err = ns.endUpload(ctx, name, req.Upload); err != nil {
+			log.Printf("[ERROR] Failed to clear upload marker upload=%s error='%v'", req.Upload, err)
+		}
+	}
+
+	stats := &DedupeStats{
+		Blocks:          req.DedupeBlocks,
+		DuplicateBlocks: req.DuplicateBlocks,
+		LogicalBytes:    req.LogicalBytes,
+		PhysicalBytes:   req.PhysicalBytes,
+	}
+	if err = RecordDedupe(bkvs, stats); err != nil {
+		log.Printf("[ERROR] Failed to record dedupe stats root=%x error='%v'", req.ID, err)
+	}
+
+	return kvp, nil
+}
+
+// beginUpload marks an upload to the namespace in progress in the blox kvs
+// after checking its expected size against the quota of the namespace.  An
+// unknown size of zero only checks the namespace is not full.  Marking an
+// upload again renews its marker.  Sweeps are not started while
+// markers exist.  A sweep already started did not see the marker so the call
+// waits for it to finish before the upload writes blocks that may be
+// de-duplicated against the blocks being removed
+func (ns *namespaces) beginUpload(ctx context.Context, name, uploadID string, size int64) (*KVPair, error) {
+	if !ns.exists(name) {
+		return nil, ErrNamespaceNotFound
+	}
+	if !validUploadID(uploadID) {
+		return nil, ErrUploadNotFound
+	}
+	if size <= 0 {
+		size = 1
+	}
+	if err := ns.checkBlockQuota(ctx, name, size); err != nil {
+		return nil, err
+	}
+
+	bkvs, err := ns.kvs(BloxNamespace)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = pendingUpload(bkvs, name, uploadID); err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	kvp, _, err := bkvs.SetContext(ctx, NewKVPair(pendingKey(uploadID), []byte(name)), DefaultWriteOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	return kvp, waitSweep(ctx, bkvs)
+}
+
+// endUpload clears the marker of an upload to the namespace.  Uploads without
+// a marker are ignored
+func (ns *namespaces) endUpload(ctx context.Context, name, uploadID string) error {
+	if !ns.exists(name) {
+		return ErrNamespaceNotFound
+	}
+	if !validUploadID(uploadID) {
+		return ErrUploadNotFound
+	}
+
+	bkvs, err := ns.kvs(BloxNamespace)
+	if err != nil {
+		return err
+	}
+	if _, err = pendingUpload(bkvs, name, uploadID); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	_, err = bkvs.RemoveContext(ctx, pendingKey(uploadID), DefaultWriteOptions())
+	return err
+}
+
+// pendingUpload returns the marker of an upload.  It returns ErrUploadNotFound
+// if the marker belongs to another namespace
+func pendingUpload(kv BloxKV, name, uploadID string) (*KVPair, error) {
+	kvp, _, err := kv.Get(pendingKey(uploadID), &ReadOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if string(kvp.Value) != name {
+		return nil, ErrUploadNotFound
+	}
+	return kvp, nil
+}
+
+// waitSweep returns once no sweep is in progress or the context is done
+func waitSweep(ctx context.Context, kv BloxKV) error {
+	for {
+		kvp, _, err := kv.Get([]byte(bloxGCSweepKey), &ReadOptions{})
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				return nil
+			}
+			return err
+		}
+		if time.Since(time.Unix(0, int64(kvp.ModTime))) > gcSweepTimeout {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(gcSweepPollInterval):
+		}
+	}
+}
+
+func pendingKey(uploadID string) []byte {
+	return []byte(bloxPendingPrefix + uploadID)
+}
+
+// RootCompression returns the codec the file with the root id was compressed
+// with.  Unregistered roots are not compressed
+func RootCompression(kv BloxKV, rootID []byte) (Compression, error) {
+	kvp, _, err := kv.Get(rootKey(rootID), &ReadOptions{})
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return CompressionNone, nil
+		}
+		return CompressionNone, err
+	}
+	return ParseCompression(kvp.Metadata[rootCompressionMetadata])
+}
+
+// DeleteRoot marks a registered root deleted.  The next garbage collection
+// collects it without waiting for the grace period unless a kv value still
+// references it.  Its blocks are left to the collector as data blocks may be
+// shared with other roots
+func DeleteRoot(kv BloxKV, rootID []byte) error {
+	kvp, _, err := kv.Get(rootKey(rootID), &ReadOptions{})
+	if err != nil {
+		return err
+	}
+
+	return setRootMetadata(kv, kvp, rootDeletedMetadata, "true")
+}
+
+// rootDeleted returns true if the registered root has been deleted
+func rootDeleted(kvp *KVPair) bool {
+	return kvp.Metadata[rootDeletedMetadata] != ""
+}
+
+// setRootMetadata sets the metadata key of the root registration.  An empty
+// value removes it
+func setRootMetadata(kv BloxKV, kvp *KVPair, key, value string) error {
+	reg := NewKVPair(kvp.Key, kvp.Value)
+	reg.Metadata = make(map[string]string, len(kvp.Metadata)+1)
+	for k, v := range kvp.Metadata {
+		reg.Metadata[k] = v
+	}
+	if value == "" {
+		delete(reg.Metadata, key)
+	} else {
+		reg.Metadata[key] = value
+	}
+
+	_, err := kv.caset(reg, kvp.Modification)
+	return err
+}
+
+// rootMarked returns the time in unix nanoseconds the root was marked for
+// collection or zero if it is not
+func rootMarked(kvp *KVPair) int64 {
+	i, _ := strconv.ParseInt(kvp.Metadata[rootMarkedMetadata], 10, 64)
+	return i
+}
+
+func rootKey(id []byte) []byte {
+	return []byte(bloxRootsPrefix + hex.EncodeToString(id))
+}
+
+// scanRoots returns all registered roots keyed by their hex id
+func scanRoots(kv BloxKV) (map[string]*KVPair, error) {
+	roots, _, err := kv.Scan(&ScanOptions{Prefix: []byte(bloxRootsPrefix)})
+	if err != nil {
+		return nil, err
+	}
+
+	registered := make(map[string]*KVPair, len(roots))
+	for _, kvp := range roots {
+		registered[strings.TrimPrefix(string(kvp.Key), bloxRootsPrefix)] = kvp
+	}
+	return registered, nil
+}
+
+// GCReference is a kv prefix whose values are scanned for references to roots
+type GCReference struct {
+	Namespace string
+	Prefix    string
+}
+
+// ParseGCReferences parses a comma separated list of namespace:prefix
+// references.  A prefix without a namespace uses the default namespace
+func ParseGCReferences(s string) ([]*GCReference, error) {
+	refs := make([]*GCReference, 0)
+	for _, r := range strings.Split(s, ",") {
+		if r = strings.TrimSpace(r); r == "" {
+			continue
+		}
+
+		ref := &GCReference{Prefix: r}
+		if i := strings.IndexByte(r, ':'); i >= 0 {
+			ref.Namespace, ref.Prefix = r[:i], r[i+1:]
+		}
+		if ref.Prefix == "" {
+			return nil, fmt.Errorf("invalid gc reference: %q", r)
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+// GCOptions are the options of a garbage collection run
+type GCOptions struct {
+	// Kv prefixes referencing roots.  A root is referenced if a value contains
+	// its hex id or is its raw id.  Runs fail with ErrEncrypted if a value is
+	// encrypted by the client as its references cannot be seen
+	References []*GCReference
+	// Roots registered within the grace period are never collected giving
+	// uploads time to be referenced.  Deleted roots are not given one.  It is
+	// also the mark window an unreferenced root is held for before its blocks
+	// are swept
+	GracePeriod time.Duration
+	// Report what would be collected without removing anything
+	DryRun bool
+}
+
+// GCReport is the result of a garbage collection run
+type GCReport struct {
+	// Registered roots
+	Roots int
+	// Roots referenced by a kv value
+	Referenced int
+	// Unreferenced roots within the grace period or mark window
+	Pending int
+	// Unreferenced roots marked for collection by this run
+	Marked int
+	// Referenced roots that were not registered e.g. uploaded before garbage
+	// collection was enabled and are now registered
+	Adopted int
+	// Unreferenced roots collected
+	Collected int
+	// Unreferenced roots due for collection left for a later run as uploads
+	// were in progress
+	Deferred int
+	// Blocks removed including index and snapshot tree blocks
+	Blocks int
+	// Bytes of data blocks removed
+	ReclaimedBytes uint64
+	DryRun         bool
+	Runtime        time.Duration
+}
+
+// gcBlock is a block of a root with the bytes reclaimed by removing it
+type gcBlock struct {
+	id   []byte
+	size uint64
+}
+
+// BlockGC garbage collects uploaded blocks no longer referenced by any kv
+// value.  File and snapshot roots are registered when written.  A run marks
+// the roots referenced by the configured kv prefixes and keeps the blocks of
+// every root that is not collected.
+//
+// An unreferenced root past its grace period is first marked for collection
+// and only swept by a run at least a grace period later if it is still
+// unreferenced.  Uploads de-duplicated against its blocks register their own
+// root once done keeping the shared blocks.
+//
+// Uploads mark themselves in progress before writing any block.  A run flags
+// the sweep before looking for markers and reading the roots.  It does not
+// sweep if there are any markers, while uploads wait for a flagged run to
+// finish after writing their marker.  Either way an upload never has blocks it
+// de-duplicated against removed.  Markers older than a day are treated as left
+// by failed uploads.
+//
+// Referenced roots that are not registered are adopted.  Unreferenced roots
+// that were never registered are unknown to the collector so blocks they share
+// with a collected root are removed.  Only one node should run the collector at
+// a time which is elected with Lease
+type BlockGC struct {
+	namespace func(name string) (BloxKV, error)
+	dev       blox.BlockDevice
+}
+
+// NewBlockGC returns a garbage collector using the namespace func to get the
+// kv of namespaces and the device to read and remove blocks
+func NewBlockGC(namespace func(name string) (BloxKV, error), dev blox.BlockDevice) *BlockGC {
+	return &BlockGC{namespace: namespace, dev: dev}
+}
+
+// Run runs a single garbage collection
+func (gc *BlockGC) Run(opts *GCOptions) (*GCReport, error) {
+	if len(opts.References) == 0 {
+		return nil, fmt.Errorf("gc references required")
+	}
+
+	start := time.Now()
+	report := &GCReport{DryRun: opts.DryRun}
+
+	bkvs, err := gc.namespace(BloxNamespace)
+	if err != nil {
+		return nil, err
+	}
+
+	// Flagged before the roots are read so uploads registering a root after
+	// the read have waited for the run to finish
+	sweep := !opts.DryRun
+	if sweep {
+		if sweep, err = gc.beginSweep(bkvs, start); err != nil {
+			return nil, err
+		}
+		if sweep {
+			defer gc.endSweep(bkvs)
+		}
+	}
+
+	registered, err := scanRoots(bkvs)
+	if err != nil {
+		return nil, err
+	}
+	report.Roots = len(registered)
+	if len(registered) == 0 {
+		report.Runtime = time.Since(start)
+		return report, nil
+	}
+
+	referenced, err := gc.mark(opts.References, registered)
+	if err != nil {
+		return nil, err
+	}
+
+	// Roots to collect.  All others are kept along with their blocks
+	var (
+		grace   = start.Add(-opts.GracePeriod).UnixNano()
+		collect = make(map[string][]*gcBlock)
+		keep    = make(map[string]bool)
+	)
+	for id := range referenced {
+		kvp, ok := registered[id]
+		if ok {
+			report.Referenced++
+		}
+
+		rootID, _ := hex.DecodeString(id)
+		blocks, er := gc.rootBlocks(rootID, kvp)
+		if er != nil {
+			// Unreadable roots can be neither kept nor swept.  Unregistered
+			// ids are not necessarily roots
+			if ok {
+				log.Printf("[WARNING] GC skipping root id=%s error='%v'", id, er)
+			}
+			continue
+		}
+		for _, blk := range blocks {
+			keep[string(blk.id)] = true
+		}
+
+		if opts.DryRun {
+			continue
+		}
+		switch {
+		case !ok:
+			if _, er = bkvs.set(NewKVPair(rootKey(rootID), []byte(DefaultNamespace))); er != nil {
+				log.Printf("[ERROR] GC failed to adopt root id=%s error='%v'", id, er)
+				continue
+			}
+			report.Adopted++
+		case rootMarked(kvp) > 0:
+			// Referenced again before being swept
+			if er = setRootMetadata(bkvs, kvp, rootMarkedMetadata, ""); er != nil {
+				log.Printf("[ERROR] GC failed to unmark root id=%s error='%v'", id, er)
+			}
+		}
+	}
+
+	for id, kvp := range registered {
+		if referenced[id] {
+			continue
+		}
+
+		rootID, _ := hex.DecodeString(id)
+		blocks, er := gc.rootBlocks(rootID, kvp)
+		if er != nil {
+			log.Printf("[WARNING] GC skipping root id=%s error='%v'", id, er)
+			continue
+		}
+
+		marked := rootMarked(kvp)
+		switch {
+		case marked > 0 && marked <= grace:
+			collect[id] = blocks
+			continue
+
+		case marked > 0:
+			report.Pending++
+
+		case kvp.ModTime > uint64(grace) && !rootDeleted(kvp):
+			report.Pending++
+
+		default:
+			report.Pending++
+			if !opts.DryRun {
+				if er = setRootMetadata(bkvs, kvp, rootMarkedMetadata, strconv.FormatInt(start.UnixNano(), 10)); er != nil {
+					log.Printf("[ERROR] GC failed to mark root id=%s error='%v'", id, er)
+				}
+			}
+			report.Marked++
+		}
+
+		for _, blk := range blocks {
+			keep[string(blk.id)] = true
+		}
+	}
+
+	if !sweep && !opts.DryRun {
+		report.Deferred = len(collect)
+		collect = nil
+	}
+
+	for id, blocks := range collect {
+		gc.sweep(blocks, keep, report)
+
+		if !opts.DryRun {
+			rootID, _ := hex.DecodeString(id)
+			if er := bkvs.remove(rootKey(rootID)); er != nil {
+				log.Printf("[ERROR] GC failed to unregister root id=%s error='%v'", id, er)
+				continue
+			}
+		}
+		report.Collected++
+	}
+
+	report.Runtime = time.Since(start)
+	return report, nil
+}
+
+// Lease acquires or renews the lease electing the holder to run garbage
+// collection for the ttl.  It returns false if another holder has a lease that
+// has not expired.  Leases are taken with a check and set on the last lease
+// read so only one of concurrent holders wins
+func (gc *BlockGC) Lease(holder string, ttl time.Duration) (bool, error) {
+	bkvs, err := gc.namespace(BloxNamespace)
+	if err != nil {
+		return false, err
+	}
+
+	key := []byte(bloxGCLeaseKey)
+	kvp, _, err := bkvs.Get(key, &ReadOptions{})
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			return false, err
+		}
+		// Created without a holder so a concurrent creation fails the check
+		// and set below
+		if kvp, err = bkvs.set(NewKVPair(key, nil)); err != nil {
+			return false, err
+		}
+	}
+
+	now := time.Now()
+	expires, _ := strconv.ParseInt(kvp.Metadata[gcLeaseExpiresMetadata], 10, 64)
+	if string(kvp.Value) != holder && expires > now.UnixNano() {
+		return false, nil
+	}
+
+	lease := NewKVPair(key, []byte(holder))
+	lease.Metadata = map[string]string{
+		gcLeaseExpiresMetadata: strconv.FormatInt(now.Add(ttl).UnixNano(), 10),
+	}
+	if _, err = bkvs.caset(lease, kvp.Modification); err != nil {
+		if errors.Is(err, ErrCASMismatch) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// beginSweep flags a sweep in progress.  It returns false without a sweep
+// flagged if uploads are in progress in which case the run only marks roots.  The flag is written before the markers
+// are read and uploads write their marker before reading the flag so either
+// the sweep sees the upload or the upload waits for the sweep.  Markers left by
+// failed uploads are removed
+func (gc *BlockGC) beginSweep(kv BloxKV, now time.Time) (bool, error) {
+	if _, err := kv.set(NewKVPair([]byte(bloxGCSweepKey), nil)); err != nil {
+		return false, err
+	}
+
+	markers, _, err := kv.Scan(&ScanOptions{Prefix: []byte(bloxPendingPrefix)})
+	if err != nil {
+		gc.endSweep(kv)
+		return false, err
+	}
+
+	var (
+		expired = uint64(now.Add(-pendingUploadTimeout).UnixNano())
+		pending int
+	)
+	for _, kvp := range markers {
+		if kvp.ModTime > expired {
+			pending++
+			continue
+		}
+		if err = kv.remove(kvp.Key); err != nil {
+			log.Printf("[ERROR] GC failed to remove upload marker key=%s error='%v'", kvp.Key, err)
+		}
+	}
+
+	if pending > 0 {
+		gc.endSweep(kv)
+		return false, nil
+	}
+	return true, nil
+}
+
+// endSweep clears the sweep flag
+func (gc *BlockGC) endSweep(kv BloxKV) {
+	if err := kv.remove([]byte(bloxGCSweepKey)); err != nil {
+		log.Printf("[ERROR] GC failed to clear sweep flag error='%v'", err)
+	}
+}
+
+// mark returns the hex ids of the idLen of registered roots referenced by the
+// values under the reference prefixes.  Ids that are not registered may not be
+// roots
+func (gc *BlockGC) mark(refs []*GCReference, registered map[string]*KVPair) (map[string]bool, error) {
+	var idLen int
+	for id := range registered {
+		idLen = len(id)
+		break
+	}
+
+	referenced := make(map[string]bool)
+	for _, ref := range refs {
+		kvs, err := gc.namespace(ref.Namespace)
+		if err != nil {
+			return nil, err
+		}
+
+		kvps, _, err := kvs.Scan(&ScanOptions{Prefix: []byte(ref.Prefix)})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, kvp := range kvps {
+			// References inside encrypted values cannot be seen so their
+			// roots would be collected
+			if isSealed(kvp.Value) {
+				return nil, ErrEncrypted.wrap(fmt.Errorf("gc reference %s:%s", ref.Namespace, ref.Prefix))
+			}
+			for _, id := range findRootIDs(kvp.Value, idLen) {
+				referenced[id] = true
+			}
+		}
+	}
+	return referenced, nil
+}
+
+// rootBlocks returns the blocks of the root with the root block last.  The
+// registration is nil for unregistered roots which are read as files
+func (gc *BlockGC) rootBlocks(rootID []byte, kvp *KVPair) ([]*gcBlock, error) {
+	if kvp != nil && kvp.Metadata[rootKindMetadata] == rootKindSnapshot {
+		return snapshotBlocks(rootID, func(id []byte) (*snapshotTree, uint64, error) {
+			return readSnapshotBlock(gc.dev, id)
+		})
+	}
+
+	idx, err := blox.NewAssembler(gc.dev, bloxWorkers).SetRoot(rootID)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		bsize  = idx.BlockSize()
+		fsize  = idx.FileSize()
+		blocks = make([]*gcBlock, 0, idx.BlockCount()+1)
+	)
+	idx.Iter(func(index uint64, id []byte) error {
+		blk := &gcBlock{id: id}
+		if off := index * bsize; off < fsize {
+			if blk.size = fsize - off; blk.size > bsize {
+				blk.size = bsize
+			}
+		}
+		blocks = append(blocks, blk)
+		return nil
+	})
+	return append(blocks, &gcBlock{id: idx.ID()}), nil
+}
+
+// sweep removes the blocks of a root that are not kept updating the report.
+// Blocks are only counted in a dry run
+func (gc *BlockGC) sweep(blocks []*gcBlock, keep map[string]bool, report *GCReport) {
+	for _, blk := range blocks {
+		if keep[string(blk.id)] {
+			continue
+		}
+		// Blocks repeated in a root or shared by collected roots are only
+		// removed once
+		keep[string(blk.id)] = true
+
+		if !report.DryRun {
+			if err := gc.dev.RemoveBlock(blk.id); err != nil && err != block.ErrBlockNotFound {
+				log.Printf("[ERROR] GC failed to remove block id=%x error='%v'", blk.id, err)
+				continue
+			}
+		}
+
+		report.Blocks++
+		report.ReclaimedBytes += blk.size
+	}
+}
+
+// findRootIDs returns the hex encoded ids of idLen hex characters referenced by
+// the value.  A value is either the raw id or contains its hex encoding
+func findRootIDs(value []byte, idLen int) []string {
+	if idLen == 0 {
+		return nil
+	}
+	if len(value) == idLen/2 {
+		return []string{hex.EncodeToString(value)}
+	}
+
+	ids := make([]string, 0)
+	for i := 0; i < len(value); {
+		j := i
+		for j < len(value) && isHexDigit(value[j]) {
+			j++
+		}
+		if j-i == idLen {
+			ids = append(ids, string(bytes.ToLower(value[i:j])))
+		}
+		if j == i {
+			j++
+		}
+		i = j
+	}
+	return ids
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
@@ -0,0 +1,188 @@
+package fidias
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_findRootIDs(t *testing.T) {
+	id := bytes.Repeat([]byte{0xab}, 32)
+	hid := hex.EncodeToString(id)
+
+	if ids := findRootIDs(id, 64); len(ids) != 1 || ids[0] != hid {
+		t.Fatal("should find raw id", ids)
+	}
+
+	value := []byte(`{"file":"` + hid + `","other":"abc123"}`)
+	if ids := findRootIDs(value, 64); len(ids) != 1 || ids[0] != hid {
+		t.Fatal("should find hex id", ids)
+	}
+
+	// Longer hex strings are not ids
+	if ids := findRootIDs([]byte(hid+"ab"), 64); len(ids) != 0 {
+		t.Fatal("should not find id", ids)
+	}
+	if ids := findRootIDs(value, 0); ids != nil {
+		t.Fatal("should not find without registered roots")
+	}
+}
+
+func Test_ParseGCReferences(t *testing.T) {
+	refs, err := ParseGCReferences("files/, docs:objects/ ,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 2 {
+		t.Fatal("wrong count", len(refs))
+	}
+	if refs[0].Namespace != DefaultNamespace || refs[0].Prefix != "files/" {
+		t.Fatal("wrong default namespace ref", refs[0])
+	}
+	if refs[1].Namespace != "docs" || refs[1].Prefix != "objects/" {
+		t.Fatal("wrong namespace ref", refs[1])
+	}
+
+	if _, err = ParseGCReferences("docs:"); err == nil {
+		t.Fatal("should require prefix")
+	}
+}
+
+func Test_rootDeleted(t *testing.T) {
+	kvp := NewKVPair(rootKey([]byte{1, 2}), []byte("ns"))
+	if rootDeleted(kvp) {
+		t.Fatal("registered root should not be deleted")
+	}
+	kvp.Metadata = map[string]string{rootDeletedMetadata: "true"}
+	if !rootDeleted(kvp) {
+		t.Fatal("root should be deleted")
+	}
+}
+
+func Test_rootMarked(t *testing.T) {
+	kvp := NewKVPair(rootKey([]byte{1, 2}), []byte("ns"))
+	if rootMarked(kvp) != 0 {
+		t.Fatal("root should not be marked")
+	}
+	kvp.Metadata = map[string]string{rootMarkedMetadata: "42"}
+	if rootMarked(kvp) != 42 {
+		t.Fatal("wrong mark time", rootMarked(kvp))
+	}
+}
+
+func Test_namespaces_registerRoot(t *testing.T) {
+	ns := newTestNamespaces()
+	id := bytes.Repeat([]byte{0xab}, 32)
+
+	stats := &DedupeStats{Blocks: 3, DuplicateBlocks: 1, LogicalBytes: 300, PhysicalBytes: 200}
+	if _, err := ns.registerRoot(context.Background(), DefaultNamespace, &RootRequest{ID: id, Compression: CompressionSnappy.String(),
+		DedupeBlocks: stats.Blocks, DuplicateBlocks: stats.DuplicateBlocks,
+		LogicalBytes: stats.LogicalBytes, PhysicalBytes: stats.PhysicalBytes}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ns.registerRoot(context.Background(), "missing", &RootRequest{ID: id}); err != ErrNamespaceNotFound {
+		t.Fatal("should fail", err)
+	}
+
+	bkvs, err := ns.kvs(BloxNamespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := RootCompression(bkvs, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c != CompressionSnappy {
+		t.Fatal("wrong compression", c)
+	}
+
+	report, err := ReadDedupeReport(bkvs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.DedupeStats != *stats {
+		t.Fatalf("wrong dedupe stats %+v", report.DedupeStats)
+	}
+}
+
+func newTestBlockGC(ns *namespaces) *BlockGC {
+	return NewBlockGC(func(name string) (BloxKV, error) {
+		return ns.kvs(name)
+	}, nil)
+}
+
+func Test_BlockGC_Lease(t *testing.T) {
+	gc := newTestBlockGC(newTestNamespaces())
+
+	if ok, err := gc.Lease("a", time.Minute); err != nil || !ok {
+		t.Fatal("should acquire", ok, err)
+	}
+	if ok, err := gc.Lease("b", time.Minute); err != nil || ok {
+		t.Fatal("should be held", ok, err)
+	}
+	// Renewed by the holder with an expired ttl
+	if ok, err := gc.Lease("a", -time.Second); err != nil || !ok {
+		t.Fatal("should renew", ok, err)
+	}
+	if ok, err := gc.Lease("b", time.Minute); err != nil || !ok {
+		t.Fatal("should take expired lease", ok, err)
+	}
+}
+
+func Test_BlockGC_beginSweep(t *testing.T) {
+	ns := newTestNamespaces()
+	gc := newTestBlockGC(ns)
+	bkvs, _ := ns.kvs(BloxNamespace)
+
+	uploadID, err := NewUploadID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ns.beginUpload(context.Background(), DefaultNamespace, uploadID, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err = ns.endUpload(context.Background(), "other", uploadID); err != ErrNamespaceNotFound {
+		t.Fatal("should fail", err)
+	}
+
+	ok, err := gc.beginSweep(bkvs, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("should defer while uploading")
+	}
+	if _, _, err = bkvs.Get([]byte(bloxGCSweepKey), &ReadOptions{}); !errors.Is(err, ErrNotFound) {
+		t.Fatal("sweep flag should be cleared", err)
+	}
+
+	// Registering the root clears the marker
+	reg := &RootRequest{ID: bytes.Repeat([]byte{0xab}, 32), Upload: uploadID}
+	if _, err = ns.registerRoot(context.Background(), DefaultNamespace, reg); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err = gc.beginSweep(bkvs, time.Now()); err != nil || !ok {
+		t.Fatal("should sweep", ok, err)
+	}
+
+	// Uploads wait for the sweep
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err = ns.beginUpload(ctx, DefaultNamespace, uploadID, 0); err != context.DeadlineExceeded {
+		t.Fatal("should wait for sweep", err)
+	}
+
+	gc.endSweep(bkvs)
+	if _, err = ns.beginUpload(context.Background(), DefaultNamespace, uploadID, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err = ns.endUpload(context.Background(), DefaultNamespace, uploadID); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = bkvs.Get(pendingKey(uploadID), &ReadOptions{}); !errors.Is(err, ErrNotFound) {
+		t.Fatal("marker should be cleared", err)
+	}
+}
@@ -0,0 +1,297 @@
+package fidias
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// IndexValue returns the json encoding of s for use in index queries.  Valid
+// json is returned as is while anything else is encoded as a string
+func IndexValue(s string) []byte {
+	if json.Valid([]byte(s)) {
+		return []byte(s)
+	}
+	b, _ := json.Marshal(s)
+	return b
+}
+
+// validateIndex returns an error if the index definition is incomplete
+func validateIndex(idx *Index) error {
+	if idx.Name == "" {
+		return fmt.Errorf("index name required")
+	}
+	if len(idx.Prefix) == 0 {
+		return fmt.Errorf("index prefix required: %s", idx.Name)
+	}
+	if idx.Path == "" {
+		return fmt.Errorf("index path required: %s", idx.Name)
+	}
+	return nil
+}
+
+// extract returns the value of the indexed field of the pair.  Only keys under
+// the prefix with a json object value containing a string, number or boolean
+// at the path are indexed
+func (idx *Index) extract(kvp *KVPair) (interface{}, bool) {
+	if kvp == nil || kvp.IsDir() || !bytes.HasPrefix(kvp.Key, idx.Prefix) {
+		return nil, false
+	}
+	kvp, err := decompressPair(kvp)
+	if err != nil {
+		return nil, false
+	}
+
+	var v interface{}
+	if err = json.Unmarshal(kvp.Value, &v); err != nil {
+		return nil, false
+	}
+
+	for _, p := range strings.Split(idx.Path, ".") {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = obj[p]; !ok {
+			return nil, false
+		}
+	}
+
+	switch v.(type) {
+	case string, float64, bool:
+		return v, true
+	}
+	return nil, false
+}
+
+// parseIndexValue decodes a json encoded query value.  Only strings, numbers
+// and booleans are supported
+func parseIndexValue(b []byte) (interface{}, error) {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+
+	switch v.(type) {
+	case string, float64, bool:
+		return v, nil
+	}
+	return nil, fmt.Errorf("unsupported index value: %s", b)
+}
+
+// compareIndexValues compares 2 indexed values.  Booleans sort before numbers
+// which sort before strings
+func compareIndexValues(a, b interface{}) int {
+	ra, rb := indexValueRank(a), indexValueRank(b)
+	if ra != rb {
+		return ra - rb
+	}
+
+	switch x := a.(type) {
+	case bool:
+		y := b.(bool)
+		switch {
+		case x == y:
+			return 0
+		case !x:
+			return -1
+		}
+		return 1
+
+	case float64:
+		y := b.(float64)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+
+	case string:
+		return strings.Compare(x, b.(string))
+	}
+
+	return 0
+}
+
+func indexValueRank(v interface{}) int {
+	switch v.(type) {
+	case bool:
+		return 0
+	case float64:
+		return 1
+	}
+	return 2
+}
+
+// indexEntry is a single key in a secondary index
+type indexEntry struct {
+	value interface{}
+	key   string
+}
+
+// less returns true if the entry sorts before the value and key
+func (e indexEntry) less(value interface{}, key string) bool {
+	c := compareIndexValues(e.value, value)
+	return c < 0 || (c == 0 && e.key < key)
+}
+
+// secondaryIndex is an in-memory secondary index.  Entries are sorted by value
+// followed by key
+type secondaryIndex struct {
+	def *Index
+
+	mu      sync.RWMutex
+	values  map[string]interface{}
+	entries []indexEntry
+}
+
+func newSecondaryIndex(def *Index) *secondaryIndex {
+	return &secondaryIndex{
+		def:     def,
+		values:  make(map[string]interface{}),
+		entries: make([]indexEntry, 0),
+	}
+}
+
+// update sets the index entry for the key from the pair.  A nil pair removes
+// the key from the index
+func (si *secondaryIndex) update(key []byte, kvp *KVPair) {
+	k := string(key)
+	value, ok := si.def.extract(kvp)
+
+	si.mu.Lock()
+	defer si.mu.Unlock()
+
+	if cur, exists := si.values[k]; exists {
+		i := si.search(cur, k)
+		si.entries = append(si.entries[:i], si.entries[i+1:]...)
+		delete(si.values, k)
+	}
+
+	if !ok {
+		return
+	}
+
+	i := si.search(value, k)
+	si.entries = append(si.entries, indexEntry{})
+	copy(si.entries[i+1:], si.entries[i:])
+	si.entries[i] = indexEntry{value: value, key: k}
+	si.values[k] = value
+}
+
+// search returns the position of the first entry not before the value and key
+func (si *secondaryIndex) search(value interface{}, key string) int {
+	return sort.Search(len(si.entries), func(i int) bool {
+		return !si.entries[i].less(value, key)
+	})
+}
+
+// query returns the keys matching the query in index order
+func (si *secondaryIndex) query(q *IndexQuery) ([]string, error) {
+	var (
+		start, end interface{}
+		err        error
+	)
+
+	if len(q.Value) > 0 {
+		if start, err = parseIndexValue(q.Value); err != nil {
+			return nil, err
+		}
+	} else {
+		if len(q.Start) > 0 {
+			if start, err = parseIndexValue(q.Start); err != nil {
+				return nil, err
+			}
+		}
+		if len(q.End) > 0 {
+			if end, err = parseIndexValue(q.End); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	si.mu.RLock()
+	defer si.mu.RUnlock()
+
+	var i int
+	if start != nil {
+		i = si.search(start, "")
+	}
+
+	keys := make([]string, 0)
+	for ; i < len(si.entries); i++ {
+		e := si.entries[i]
+		if len(q.Value) > 0 {
+			if compareIndexValues(e.value, start) != 0 {
+				break
+			}
+		} else if end != nil && compareIndexValues(e.value, end) >= 0 {
+			break
+		}
+
+		keys = append(keys, e.key)
+		if q.Limit > 0 && len(keys) == int(q.Limit) {
+			break
+		}
+	}
+
+	return keys, nil
+}
+
+// indexSet contains all secondary indexes maintained by an fsm
+type indexSet map[string]*secondaryIndex
+
+func newIndexSet(defs []*Index) indexSet {
+	set := make(indexSet, len(defs))
+	for _, d := range defs {
+		set[d.Name] = newSecondaryIndex(d)
+	}
+	return set
+}
+
+// update updates the key in all indexes
+func (set indexSet) update(key []byte, kvp *KVPair) {
+	for _, si := range set {
+		si.update(key, kvp)
+	}
+}
+
+// query returns the keys matching the query on the index
+func (set indexSet) query(q *IndexQuery) ([]string, error) {
+	si, ok := set[q.Index]
+	if !ok {
+		return nil, ErrIndexNotFound
+	}
+	return si.query(q)
+}
+
+// sortIndexResults sorts the pairs by their value on the index followed by key
+// and applies the limit
+func sortIndexResults(def *Index, kvs []*KVPair, limit int32) []*KVPair {
+	entries := make([]indexEntry, 0, len(kvs))
+	byKey := make(map[string]*KVPair, len(kvs))
+	for _, kvp := range kvs {
+		if v, ok := def.extract(kvp); ok {
+			entries = append(entries, indexEntry{value: v, key: string(kvp.Key)})
+			byKey[string(kvp.Key)] = kvp
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].less(entries[j].value, entries[j].key)
+	})
+	if limit > 0 && len(entries) > int(limit) {
+		entries = entries[:limit]
+	}
+
+	out := make([]*KVPair, len(entries))
+	for i, e := range entries {
+		out[i] = byKey[e.key]
+	}
+	return out
+}
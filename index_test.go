@@ -0,0 +1,92 @@
+package fidias
+
+import (
+	"fmt"
+	"testing"
+)
+
+func testIndexPair(key, doc string) *KVPair {
+	return NewKVPair([]byte(key), []byte(doc))
+}
+
+func Test_Index_extract(t *testing.T) {
+	idx := &Index{Name: "age", Prefix: []byte("users/"), Path: "profile.age"}
+
+	v, ok := idx.extract(testIndexPair("users/a", `{"profile":{"age":30}}`))
+	if !ok || v.(float64) != 30 {
+		t.Fatal("should extract", v)
+	}
+
+	for _, kvp := range []*KVPair{
+		testIndexPair("groups/a", `{"profile":{"age":30}}`),
+		testIndexPair("users/b", `{"profile":{"age":[30]}}`),
+		testIndexPair("users/c", `{"profile":30}`),
+		testIndexPair("users/d", `not json`),
+		NewDirKVPair([]byte("users/e"), 0755),
+	} {
+		if _, ok = idx.extract(kvp); ok {
+			t.Fatalf("should not extract %s", kvp.Key)
+		}
+	}
+}
+
+func Test_compareIndexValues(t *testing.T) {
+	ordered := []interface{}{false, true, -1.5, 2.0, "a", "b"}
+	for i := 1; i < len(ordered); i++ {
+		if compareIndexValues(ordered[i-1], ordered[i]) >= 0 {
+			t.Fatalf("%v should sort before %v", ordered[i-1], ordered[i])
+		}
+	}
+	if compareIndexValues("a", "a") != 0 {
+		t.Fatal("should be equal")
+	}
+}
+
+func Test_secondaryIndex(t *testing.T) {
+	si := newSecondaryIndex(&Index{Name: "age", Prefix: []byte("users/"), Path: "age"})
+	for i, age := range []int{40, 20, 30, 20} {
+		key := fmt.Sprintf("users/%d", i)
+		si.update([]byte(key), testIndexPair(key, fmt.Sprintf(`{"age":%d}`, age)))
+	}
+
+	keys, err := si.query(&IndexQuery{Value: []byte("20")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0] != "users/1" || keys[1] != "users/3" {
+		t.Fatal("wrong keys", keys)
+	}
+
+	if keys, _ = si.query(&IndexQuery{Start: []byte("25"), End: []byte("40")}); len(keys) != 1 || keys[0] != "users/2" {
+		t.Fatal("wrong range", keys)
+	}
+	if keys, _ = si.query(&IndexQuery{Limit: 3}); len(keys) != 3 || keys[2] != "users/2" {
+		t.Fatal("wrong limit", keys)
+	}
+
+	// Update and remove
+	si.update([]byte("users/1"), testIndexPair("users/1", `{"age":50}`))
+	si.update([]byte("users/3"), nil)
+	if keys, _ = si.query(&IndexQuery{Value: []byte("20")}); len(keys) != 0 {
+		t.Fatal("should be updated", keys)
+	}
+	if keys, _ = si.query(&IndexQuery{Start: []byte("45")}); len(keys) != 1 || keys[0] != "users/1" {
+		t.Fatal("wrong keys", keys)
+	}
+
+	if _, err = si.query(&IndexQuery{Value: []byte("{}")}); err == nil {
+		t.Fatal("should fail on object")
+	}
+	if _, err = newIndexSet(nil).query(&IndexQuery{Index: "age"}); err != ErrIndexNotFound {
+		t.Fatal("should not find index", err)
+	}
+}
+
+func Test_IndexValue(t *testing.T) {
+	if string(IndexValue("42")) != "42" {
+		t.Fatal("number should be as is")
+	}
+	if string(IndexValue("alice")) != `"alice"` {
+		t.Fatal("should encode string")
+	}
+}
@@ -1,17 +1,24 @@
 package fidias
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"sort"
+	"strconv"
 	"time"
 
-	"github.com/hexablock/hexatype"
+	"github.com/golang/protobuf/proto"
 	"github.com/hexablock/log"
 	"github.com/hexablock/phi"
 
 	"github.com/hexablock/hexalog"
+	"github.com/hexablock/hexatype"
 )
 
 // WriteStats contains stats regarding a write operation to the log
@@ -38,6 +45,11 @@ func NewKVPair(key, value []byte) *KVPair {
 	return &KVPair{Key: key, Value: value}
 }
 
+// NewDirKVPair inits a new directory kv pair with the key and permission bits
+func NewDirKVPair(key []byte, mode os.FileMode) *KVPair {
+	return &KVPair{Key: key, Flags: int64(os.ModeDir), Mode: uint32(mode.Perm())}
+}
+
 // IsDir returns if the KVPair is a directory
 func (kvp *KVPair) IsDir() bool {
 	return os.FileMode(kvp.Flags) == os.ModeDir
@@ -48,7 +60,11 @@ func (kvp KVPair) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
 		Key          string
 		Value        []byte
+		ContentType  string `json:",omitempty"`
 		Flags        int64
+		Mode         string            `json:",omitempty"`
+		Owner        string            `json:",omitempty"`
+		Metadata     map[string]string `json:",omitempty"`
 		ModTime      time.Time
 		LTime        uint64
 		Modification string
@@ -56,7 +72,11 @@ func (kvp KVPair) MarshalJSON() ([]byte, error) {
 	}{
 		string(kvp.Key),
 		kvp.Value,
+		kvp.ContentType,
 		kvp.Flags,
+		kvp.modeString(),
+		kvp.Owner,
+		kvp.Metadata,
 		time.Unix(0, int64(kvp.ModTime)),
 		kvp.LTime,
 		hex.EncodeToString(kvp.Modification),
@@ -64,6 +84,14 @@ func (kvp KVPair) MarshalJSON() ([]byte, error) {
 	})
 }
 
+// modeString returns the permission bits of a directory in symbolic notation
+func (kvp *KVPair) modeString() string {
+	if !kvp.IsDir() {
+		return ""
+	}
+	return (os.ModeDir | os.FileMode(kvp.Mode).Perm()).String()
+}
+
 // ReadOptions contains request options for read requests
 type ReadOptions struct{}
 
@@ -91,6 +119,8 @@ func DefaultWriteOptions() *WriteOptions {
 type KVTransport interface {
 	GetKey(ctx context.Context, host string, key []byte) (*KVPair, error)
 	ListDir(ctx context.Context, host string, dir []byte) ([]*KVPair, error)
+	LookupIndex(ctx context.Context, host string, q *IndexQuery) ([]*KVPair, error)
+	Scan(ctx context.Context, host string, opts *ScanOptions) ([]*KVPair, error)
 	Register(kv KVStore)
 }
 
@@ -109,10 +139,24 @@ type KVS struct {
 
 	// DHT used for lookups to perform gets
 	dht phi.DHT
+
+	// Namespace the kvs serves.  It is sent with read requests so remotes use
+	// the store of the namespace
+	namespace string
+
+	// Checks writes against the namespace quota.  Writes are not checked if nil
+	quotas quotaChecker
+
+	// Secondary indexes that can be queried keyed by name
+	indexes map[string]*Index
+
+	// Codec used to compress large values on write.  Compressed values are
+	// decompressed on read regardless
+	compression Compression
 }
 
 // NewKVS inits a new KVS instance using the store for reads and write
-// operations by appending entries to the log
+// operations by appending entries to the log.  It serves the default namespace
 //func NewKVS(host, prefix string, kvstore KVStore, wal WAL, remote KVTransport, dht DHT) *KVS {
 func NewKVS(prefix string, wal phi.WAL, trans KVTransport, dht phi.DHT) *KVS {
 	kv := &KVS{
@@ -127,6 +171,12 @@ func NewKVS(prefix string, wal phi.WAL, trans KVTransport, dht phi.DHT) *KVS {
 
 // Get returns a KVPair for the key if it exists otherwise an error is returned
 func (kvs *KVS) Get(key []byte, opt *ReadOptions) (*KVPair, *ReadStats, error) {
+	return kvs.GetContext(context.Background(), key, opt)
+}
+
+// GetContext returns a KVPair for the key if it exists otherwise an error is
+// returned.  The context bounds the time spent querying the nodes
+func (kvs *KVS) GetContext(ctx context.Context, key []byte, opt *ReadOptions) (*KVPair, *ReadStats, error) {
 	nskey := append(kvs.prefix, key...)
 
 	start := time.Now()
@@ -137,7 +187,7 @@ func (kvs *KVS) Get(key []byte, opt *ReadOptions) (*KVPair, *ReadStats, error) {
 	}
 
 	if nodes == nil || len(nodes) == 0 {
-		return nil, nil, hexatype.ErrKeyNotFound
+		return nil, nil, ErrNotFound
 	}
 
 	var (
@@ -146,9 +196,14 @@ func (kvs *KVS) Get(key []byte, opt *ReadOptions) (*KVPair, *ReadStats, error) {
 	)
 
 	for i, n := range nodes {
+		if err = ctx.Err(); err != nil {
+			err = contextError(err)
+			break
+		}
+
 		meta := n.Metadata()
 
-		kvp, err = kvs.trans.GetKey(context.Background(), meta["hexalog"], key)
+		kvp, err = kvs.trans.GetKey(withNamespace(ctx, kvs.namespace), meta["hexalog"], key)
 		if err == nil {
 			// Set the node returning the response to the first one in the list
 			// if it isn't
@@ -158,7 +213,8 @@ func (kvs *KVS) Get(key []byte, opt *ReadOptions) (*KVPair, *ReadStats, error) {
 			stats.Nodes = nodes
 			stats.Priority = int32(i)
 			stats.RespTime = time.Since(start).Nanoseconds()
-			return kvp, stats, nil
+			kvp, err = decompressPair(kvp)
+			return kvp, stats, err
 		}
 	}
 	// Set the nodes queried
@@ -170,6 +226,12 @@ func (kvs *KVS) Get(key []byte, opt *ReadOptions) (*KVPair, *ReadStats, error) {
 
 // List performs a lookup on dir and retrieves all children from each node.
 func (kvs *KVS) List(dir []byte, opt *ReadOptions) ([]*KVPair, *ReadStats, error) {
+	return kvs.ListContext(context.Background(), dir, opt)
+}
+
+// ListContext performs a lookup on dir and retrieves all children from each
+// node.  The context bounds the time spent querying the nodes
+func (kvs *KVS) ListContext(ctx context.Context, dir []byte, opt *ReadOptions) ([]*KVPair, *ReadStats, error) {
 	nsdir := append(kvs.prefix, dir...)
 
 	start := time.Now()
@@ -188,9 +250,14 @@ func (kvs *KVS) List(dir []byte, opt *ReadOptions) ([]*KVPair, *ReadStats, error
 	stats := &ReadStats{Nodes: nodes}
 
 	for _, n := range nodes {
+		if er := ctx.Err(); er != nil {
+			err = contextError(er)
+			break
+		}
+
 		meta := n.Metadata()
 		// TODO: Opmitize by selecting the right nodes
-		ls, er := kvs.trans.ListDir(context.Background(), meta["hexalog"], d)
+		ls, er := kvs.trans.ListDir(withNamespace(ctx, kvs.namespace), meta["hexalog"], d)
 		if er != nil {
 			err = er
 			continue
@@ -216,14 +283,168 @@ func (kvs *KVS) List(dir []byte, opt *ReadOptions) ([]*KVPair, *ReadStats, error
 	for _, v := range out {
 		o = append(o, v)
 	}
+	if er := decompressPairs(o); er != nil {
+		return nil, stats, er
+	}
 
 	stats.RespTime = time.Since(start).Nanoseconds()
 
 	return o, stats, err
 }
 
+// Scan returns the keys in the range given by the options in ascending or
+// descending order.  The prefix is required and the nodes owning its
+// directory are queried
+func (kvs *KVS) Scan(opts *ScanOptions) ([]*KVPair, *ReadStats, error) {
+	return kvs.ScanContext(context.Background(), opts)
+}
+
+// ScanContext returns the keys in the range given by the options.  The context
+// bounds the time spent querying the nodes
+func (kvs *KVS) ScanContext(ctx context.Context, opts *ScanOptions) ([]*KVPair, *ReadStats, error) {
+	if len(opts.Prefix) == 0 {
+		return nil, nil, fmt.Errorf("scan prefix required")
+	}
+
+	start := time.Now()
+
+	nodes, err := kvs.dht.Lookup(append(kvs.prefix, scanDir(opts.Prefix)...))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	out := make(map[string]*KVPair)
+	stats := &ReadStats{Nodes: nodes}
+
+	for _, n := range nodes {
+		if er := ctx.Err(); er != nil {
+			err = contextError(er)
+			break
+		}
+
+		meta := n.Metadata()
+		ls, er := kvs.trans.Scan(withNamespace(ctx, kvs.namespace), meta["hexalog"], opts)
+		if er != nil {
+			err = er
+			continue
+		}
+
+		// Keep the latest view of each key
+		for _, l := range ls {
+			k := string(l.Key)
+			if hav, ok := out[k]; !ok || l.Height > hav.Height {
+				out[k] = l
+			}
+		}
+	}
+
+	o := make([]*KVPair, 0, len(out))
+	for _, v := range out {
+		o = append(o, v)
+	}
+	sort.Slice(o, func(i, j int) bool {
+		if opts.Reverse {
+			return bytes.Compare(o[i].Key, o[j].Key) > 0
+		}
+		return bytes.Compare(o[i].Key, o[j].Key) < 0
+	})
+	if opts.Limit > 0 && len(o) > int(opts.Limit) {
+		o = o[:opts.Limit]
+	}
+	if er := decompressPairs(o); er != nil {
+		return nil, stats, er
+	}
+
+	stats.RespTime = time.Since(start).Nanoseconds()
+
+	return o, stats, err
+}
+
+// scanDir returns the directory containing the keys with the prefix
+func scanDir(prefix []byte) []byte {
+	if i := bytes.LastIndexByte(prefix, '/'); i > 0 {
+		return prefix[:i]
+	}
+	return prefix
+}
+
+// QueryIndex returns the keys matching the query on a secondary index.  The
+// nodes owning the index prefix are queried and the results are returned in
+// index order
+func (kvs *KVS) QueryIndex(q *IndexQuery) ([]*KVPair, *ReadStats, error) {
+	return kvs.QueryIndexContext(context.Background(), q)
+}
+
+// QueryIndexContext returns the keys matching the query on a secondary index.
+// The context bounds the time spent querying the nodes
+func (kvs *KVS) QueryIndexContext(ctx context.Context, q *IndexQuery) ([]*KVPair, *ReadStats, error) {
+	idx, ok := kvs.indexes[q.Index]
+	if !ok {
+		return nil, nil, ErrIndexNotFound
+	}
+
+	start := time.Now()
+
+	nodes, err := kvs.dht.Lookup(append(kvs.prefix, bytes.TrimSuffix(idx.Prefix, []byte("/"))...))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	out := make(map[string]*KVPair)
+	stats := &ReadStats{Nodes: nodes}
+
+	for _, n := range nodes {
+		if er := ctx.Err(); er != nil {
+			err = contextError(er)
+			break
+		}
+
+		meta := n.Metadata()
+		ls, er := kvs.trans.LookupIndex(withNamespace(ctx, kvs.namespace), meta["hexalog"], q)
+		if er != nil {
+			err = er
+			continue
+		}
+
+		// Keep the latest view of each key
+		for _, l := range ls {
+			k := string(l.Key)
+			if hav, ok := out[k]; !ok || l.Height > hav.Height {
+				out[k] = l
+			}
+		}
+	}
+
+	o := make([]*KVPair, 0, len(out))
+	for _, v := range out {
+		o = append(o, v)
+	}
+	if er := decompressPairs(o); er != nil {
+		return nil, stats, er
+	}
+
+	stats.RespTime = time.Since(start).Nanoseconds()
+
+	return sortIndexResults(idx, o, q.Limit), stats, err
+}
+
 // Set consistently sets a key-value pair by submitting the operation to the log
 func (kvs *KVS) Set(kv *KVPair, wo *WriteOptions) (*KVPair, *phi.WriteStats, error) {
+	return kvs.SetContext(context.Background(), kv, wo)
+}
+
+// SetContext consistently sets a key-value pair by submitting the operation to
+// the log.  The context deadline bounds the time waiting on the ballot and
+// apply
+func (kvs *KVS) SetContext(ctx context.Context, kv *KVPair, wo *WriteOptions) (*KVPair, *phi.WriteStats, error) {
+	ckv, err := compressPair(kvs.compression, kv)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = kvs.checkQuota(ctx, kv.Key, setSize(ckv)); err != nil {
+		return nil, nil, err
+	}
+
 	nskey := append(kvs.prefix, kv.Key...)
 
 	var stats *phi.WriteStats
@@ -231,10 +452,12 @@ func (kvs *KVS) Set(kv *KVPair, wo *WriteOptions) (*KVPair, *phi.WriteStats, err
 	ent, peers, err := kvs.hxl.NewEntry(nskey)
 	if err == nil {
 
-		ent.Data = append([]byte{opKVSet}, kv.Value...)
+		if ent.Data, err = encodeSetData(ckv); err != nil {
+			return nil, nil, err
+		}
 		opt := buildLogOpts(peers, wo)
 		retryOpt := &phi.RetryOptions{Retries: int(wo.Retries), RetryInterval: time.Duration(wo.RetryInterval)}
-		if kv.Modification, stats, err = kvs.hxl.ProposeEntry(ent, opt, retryOpt); err == nil {
+		if kv.Modification, stats, err = kvs.propose(ctx, ent, opt, retryOpt); err == nil {
 			kv.Height = ent.Height
 			kv.ModTime = ent.Timestamp
 			kv.LTime = ent.LTime
@@ -250,25 +473,42 @@ func (kvs *KVS) Set(kv *KVPair, wo *WriteOptions) (*KVPair, *phi.WriteStats, err
 // used as the appension point.  An error is returned if there is a mismatch
 // otherwise a KVPair with the new Modification and Height is returned
 func (kvs *KVS) CASet(kv *KVPair, mod []byte, wo *WriteOptions) (*KVPair, *phi.WriteStats, error) {
+	return kvs.CASetContext(context.Background(), kv, mod, wo)
+}
+
+// CASetContext checks and sets a key value pair.  It is the same as CASet with
+// the context bounding the time waiting on the ballot and apply
+func (kvs *KVS) CASetContext(ctx context.Context, kv *KVPair, mod []byte, wo *WriteOptions) (*KVPair, *phi.WriteStats, error) {
+	ckv, err := compressPair(kvs.compression, kv)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = kvs.checkQuota(ctx, kv.Key, setSize(ckv)); err != nil {
+		return nil, nil, err
+	}
+
 	nskey := append(kvs.prefix, kv.Key...)
 
 	last, err := kvs.hxl.GetEntry(nskey, mod)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, ErrCASMismatch.wrap(err)
 	}
 
 	var stats *phi.WriteStats
 
 	ent, peers, err := kvs.hxl.NewEntryFrom(last)
 	if err == nil {
-		ent.Data = append([]byte{opKVSet}, kv.Value...)
+		if ent.Data, err = encodeSetData(ckv); err != nil {
+			return nil, nil, err
+		}
 		opt := buildLogOpts(peers, wo)
 		retryOpt := &phi.RetryOptions{Retries: 1, RetryInterval: time.Duration(wo.RetryInterval)}
 		// Set retries to 1 as the log may be well ahead
-		if kv.Modification, stats, err = kvs.hxl.ProposeEntry(ent, opt, retryOpt); err == nil {
+		if kv.Modification, stats, err = kvs.propose(ctx, ent, opt, retryOpt); err == nil {
 			kv.Height = ent.Height
 			return kv, stats, nil
 		}
+		err = kvs.casError(nskey, mod, err)
 
 	}
 
@@ -278,6 +518,16 @@ func (kvs *KVS) CASet(kv *KVPair, mod []byte, wo *WriteOptions) (*KVPair, *phi.W
 // Remove consistently removes a key by submitting a remove operation to the
 // log to be applied by the FSM
 func (kvs *KVS) Remove(key []byte, wo *WriteOptions) (*phi.WriteStats, error) {
+	return kvs.RemoveContext(context.Background(), key, wo)
+}
+
+// RemoveContext consistently removes a key.  It is the same as Remove with the
+// context bounding the time waiting on the ballot and apply
+func (kvs *KVS) RemoveContext(ctx context.Context, key []byte, wo *WriteOptions) (*phi.WriteStats, error) {
+	if err := kvs.checkRemove(ctx, key); err != nil {
+		return nil, err
+	}
+
 	nskey := append(kvs.prefix, key...)
 
 	var stats *phi.WriteStats
@@ -287,7 +537,7 @@ func (kvs *KVS) Remove(key []byte, wo *WriteOptions) (*phi.WriteStats, error) {
 		ent.Data = []byte{opKVDel}
 		opt := buildLogOpts(peers, wo)
 		retryOpt := &phi.RetryOptions{Retries: int(wo.Retries), RetryInterval: time.Duration(wo.RetryInterval)}
-		_, stats, err = kvs.hxl.ProposeEntry(ent, opt, retryOpt)
+		_, stats, err = kvs.propose(ctx, ent, opt, retryOpt)
 	}
 
 	return stats, err
@@ -296,11 +546,22 @@ func (kvs *KVS) Remove(key []byte, wo *WriteOptions) (*phi.WriteStats, error) {
 // CARemove checks the mod hash against the last entry and applies the remove.
 // It returns an error if there is a mismatch
 func (kvs *KVS) CARemove(key []byte, mod []byte, wo *WriteOptions) (*phi.WriteStats, error) {
+	return kvs.CARemoveContext(context.Background(), key, mod, wo)
+}
+
+// CARemoveContext checks the mod hash against the last entry and applies the
+// remove.  It is the same as CARemove with the context bounding the time
+// waiting on the ballot and apply
+func (kvs *KVS) CARemoveContext(ctx context.Context, key []byte, mod []byte, wo *WriteOptions) (*phi.WriteStats, error) {
+	if err := kvs.checkRemove(ctx, key); err != nil {
+		return nil, err
+	}
+
 	nskey := append(kvs.prefix, key...)
 
 	last, err := kvs.hxl.GetEntry(nskey, mod)
 	if err != nil {
-		return nil, err
+		return nil, ErrCASMismatch.wrap(err)
 	}
 
 	var stats *phi.WriteStats
@@ -310,12 +571,420 @@ func (kvs *KVS) CARemove(key []byte, mod []byte, wo *WriteOptions) (*phi.WriteSt
 		ent.Data = []byte{opKVDel}
 		opt := buildLogOpts(peers, wo)
 		retryOpt := &phi.RetryOptions{Retries: int(wo.Retries), RetryInterval: time.Duration(wo.RetryInterval)}
-		_, stats, err = kvs.hxl.ProposeEntry(ent, opt, retryOpt)
+		if _, stats, err = kvs.propose(ctx, ent, opt, retryOpt); err != nil {
+			err = kvs.casError(nskey, mod, err)
+		}
 	}
 
 	return stats, err
 }
 
+// Mkdir consistently creates an empty directory by submitting a mkdir operation
+// to the log.  The mode, owner and metadata of the supplied pair are stored
+// with the directory.  Missing parent directories are implicitly created.  An
+// existing directory is updated with the supplied metadata and is no longer
+// removed once it is empty
+func (kvs *KVS) Mkdir(dir *KVPair, wo *WriteOptions) (*KVPair, *phi.WriteStats, error) {
+	return kvs.MkdirContext(context.Background(), dir, wo)
+}
+
+// MkdirContext consistently creates an empty directory.  It is the same as
+// Mkdir with the context bounding the time waiting on the ballot and apply
+func (kvs *KVS) MkdirContext(ctx context.Context, dir *KVPair, wo *WriteOptions) (*KVPair, *phi.WriteStats, error) {
+	if len(dir.Key) == 0 {
+		return nil, nil, fmt.Errorf("directory required")
+	}
+	if os.FileMode(dir.Mode)&^os.ModePerm != 0 {
+		return nil, nil, fmt.Errorf("invalid mode: %o", dir.Mode)
+	}
+	if err := kvs.checkQuota(ctx, dir.Key, setSize(dir)); err != nil {
+		return nil, nil, err
+	}
+
+	b, err := proto.Marshal(&KVPair{Mode: dir.Mode, Owner: dir.Owner, Metadata: dir.Metadata})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	kvp, stats, err := kvs.proposeOp(ctx, dir.Key, append([]byte{opKVMkdir}, b...), wo)
+	if err != nil {
+		return nil, stats, err
+	}
+
+	// Fill in the requested fields if the view could not be read back
+	if !kvp.IsDir() {
+		kvp.Flags = int64(os.ModeDir)
+		kvp.Mode = dir.Mode
+		kvp.Owner = dir.Owner
+		kvp.Metadata = dir.Metadata
+	}
+
+	return kvp, stats, nil
+}
+
+// RemoveTree consistently removes the directory along with all of its
+// descendants.  Each key is removed by submitting a remove operation to its
+// log, deepest first, so a failure leaves the remaining tree intact.  It
+// returns the number of keys removed
+func (kvs *KVS) RemoveTree(dir []byte, wo *WriteOptions) (int, error) {
+	return kvs.RemoveTreeContext(context.Background(), dir, wo)
+}
+
+// RemoveTreeContext consistently removes the directory along with all of its
+// descendants.  It is the same as RemoveTree with the context bounding the
+// time spent
+func (kvs *KVS) RemoveTreeContext(ctx context.Context, dir []byte, wo *WriteOptions) (int, error) {
+	return removeTree(ctx, dir,
+		func(key []byte) (*KVPair, error) {
+			kvp, _, err := kvs.GetContext(ctx, key, &ReadOptions{})
+			return kvp, err
+		},
+		func(d []byte) ([]*KVPair, error) {
+			ls, _, err := kvs.ListContext(ctx, d, &ReadOptions{})
+			return ls, err
+		},
+		func(key []byte) error {
+			_, err := kvs.RemoveContext(ctx, key, wo)
+			return err
+		},
+	)
+}
+
+// removeTree removes all descendants of dir, deepest first, followed by dir
+// itself using the supplied get, list and remove functions.  It returns the
+// number of keys removed
+func removeTree(ctx context.Context, dir []byte, get func([]byte) (*KVPair, error),
+	list func([]byte) ([]*KVPair, error), remove func([]byte) error) (int, error) {
+
+	if len(dir) == 0 {
+		return 0, fmt.Errorf("directory required")
+	}
+
+	kvp, err := get(dir)
+	if err != nil {
+		return 0, err
+	}
+	if !kvp.IsDir() {
+		return 0, fmt.Errorf("not a directory: %s", dir)
+	}
+
+	return removeDir(ctx, dir, get, list, remove)
+}
+
+// removeDir removes the children of dir and then dir itself.  Implicitly
+// created directories are removed by the fsm once their last child is removed
+// so are only removed here if they still exist
+func removeDir(ctx context.Context, dir []byte, get func([]byte) (*KVPair, error),
+	list func([]byte) ([]*KVPair, error), remove func([]byte) error) (int, error) {
+
+	ls, err := list(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var c int
+	for _, kvp := range ls {
+		if err = ctx.Err(); err != nil {
+			return c, contextError(err)
+		}
+
+		if kvp.IsDir() {
+			var n int
+			n, err = removeDir(ctx, kvp.Key, get, list, remove)
+			c += n
+			if err != nil {
+				return c, err
+			}
+			continue
+		}
+
+		if err = remove(kvp.Key); err == nil {
+			c++
+		} else if !errors.Is(err, ErrNotFound) {
+			return c, err
+		}
+	}
+
+	if _, err = get(dir); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			err = nil
+		}
+		return c, err
+	}
+
+	if err = remove(dir); err == nil {
+		c++
+	} else if errors.Is(err, ErrNotFound) {
+		err = nil
+	}
+
+	return c, err
+}
+
+// checkRemove returns ErrDirNotEmpty if the key is a directory with children.
+// A missing key is left to the log to handle
+func (kvs *KVS) checkRemove(ctx context.Context, key []byte) error {
+	kvp, _, err := kvs.GetContext(ctx, key, &ReadOptions{})
+	if err != nil || !kvp.IsDir() {
+		return nil
+	}
+
+	ls, _, err := kvs.ListContext(ctx, key, &ReadOptions{})
+	if err != nil {
+		return err
+	}
+	if len(ls) > 0 {
+		return ErrDirNotEmpty
+	}
+	return nil
+}
+
+// Incr atomically adds delta to the integer value of the key by submitting an
+// increment operation to the log.  The FSM adds the delta to the value at the
+// time the entry is applied so concurrent increments are never lost.  A
+// missing key is treated as zero.  Use a negative delta to decrement
+func (kvs *KVS) Incr(key []byte, delta int64, wo *WriteOptions) (*KVPair, *phi.WriteStats, error) {
+	return kvs.IncrContext(context.Background(), key, delta, wo)
+}
+
+// IncrContext atomically adds delta to the integer value of the key.  It is
+// the same as Incr with the context bounding the time waiting on the ballot
+// and apply
+func (kvs *KVS) IncrContext(ctx context.Context, key []byte, delta int64, wo *WriteOptions) (*KVPair, *phi.WriteStats, error) {
+	// Assume the value grows by the digits of delta
+	if err := kvs.checkQuota(ctx, key, growSize(key, len(strconv.FormatInt(delta, 10)))); err != nil {
+		return nil, nil, err
+	}
+
+	data := make([]byte, 9)
+	data[0] = opKVIncr
+	binary.BigEndian.PutUint64(data[1:], uint64(delta))
+
+	return kvs.proposeOp(ctx, key, data, wo)
+}
+
+// Append atomically appends data to the value of the key by submitting an
+// append operation to the log.  The content type of an existing value is
+// retained.  A missing key is created with the data as its value
+func (kvs *KVS) Append(key, data []byte, wo *WriteOptions) (*KVPair, *phi.WriteStats, error) {
+	return kvs.AppendContext(context.Background(), key, data, wo)
+}
+
+// AppendContext atomically appends data to the value of the key.  It is the
+// same as Append with the context bounding the time waiting on the ballot and
+// apply
+func (kvs *KVS) AppendContext(ctx context.Context, key, data []byte, wo *WriteOptions) (*KVPair, *phi.WriteStats, error) {
+	if err := kvs.checkQuota(ctx, key, growSize(key, len(data))); err != nil {
+		return nil, nil, err
+	}
+
+	return kvs.proposeOp(ctx, key, append([]byte{opKVAppend}, data...), wo)
+}
+
+// checkQuota checks the write to the key against the quota of the namespace.
+// Removes are never checked.  The usage it adds is accounted as it is applied
+func (kvs *KVS) checkQuota(ctx context.Context, key []byte, size func(cur *KVPair) int64) error {
+	if kvs.quotas == nil {
+		return nil
+	}
+	delta, err := kvs.quotas.quotaDelta(ctx, kvs.namespace, key, size)
+	if err != nil || delta == nil {
+		return err
+	}
+	return kvs.quotas.checkQuota(ctx, kvs.namespace, delta)
+}
+
+// setSize returns the size function for a write replacing the value with that
+// of the pair
+func setSize(kvp *KVPair) func(*KVPair) int64 {
+	return func(*KVPair) int64 {
+		return kvSize(kvp)
+	}
+}
+
+// growSize returns the size function for a write growing the current value of
+// the key by n bytes
+func growSize(key []byte, n int) func(*KVPair) int64 {
+	return func(cur *KVPair) int64 {
+		size := int64(len(key) + n)
+		if cur != nil {
+			size += int64(len(cur.Value))
+		}
+		return size
+	}
+}
+
+// proposeOp proposes the op data to the log of the key and returns the view of
+// the key.  As the result depends on the value at the time the entry is
+// applied, the view is read back from the nodes owning it when waiting on the
+// apply.  If the view cannot be read, as is the case when it has already been
+// superseded, the returned pair only contains the modification and height
+func (kvs *KVS) proposeOp(ctx context.Context, key, data []byte, wo *WriteOptions) (*KVPair, *phi.WriteStats, error) {
+	if wo == nil {
+		wo = DefaultWriteOptions()
+	}
+
+	nskey := append(kvs.prefix, key...)
+
+	ent, peers, err := kvs.hxl.NewEntry(nskey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ent.Data = data
+	opt := buildLogOpts(peers, wo)
+	retryOpt := &phi.RetryOptions{Retries: int(wo.Retries), RetryInterval: time.Duration(wo.RetryInterval)}
+
+	id, stats, err := kvs.propose(ctx, ent, opt, retryOpt)
+	if err != nil {
+		return nil, stats, err
+	}
+
+	kvp := &KVPair{Key: key}
+	if wo.WaitApply {
+		var er error
+		if kvp, er = kvs.getView(ctx, key, id); er != nil {
+			log.Printf("[WARNING] Failed to read applied view key=%s error='%v'", key, er)
+			kvp = &KVPair{Key: key}
+		}
+	}
+
+	kvp.Modification = id
+	kvp.Height = ent.Height
+	kvp.ModTime = ent.Timestamp
+	kvp.LTime = ent.LTime
+
+	return kvp, stats, nil
+}
+
+// getView returns the view of the key resulting from the entry with the
+// given id from the first node that has it
+func (kvs *KVS) getView(ctx context.Context, key, id []byte) (*KVPair, error) {
+	nodes, err := kvs.dht.Lookup(append(kvs.prefix, key...))
+	if err != nil {
+		return nil, err
+	}
+
+	err = ErrNotFound
+	for _, n := range nodes {
+		if er := ctx.Err(); er != nil {
+			return nil, contextError(er)
+		}
+
+		kvp, er := kvs.trans.GetKey(withNamespace(ctx, kvs.namespace), n.Metadata()["hexalog"], key)
+		if er != nil {
+			err = er
+			continue
+		}
+		if bytes.Equal(kvp.Modification, id) {
+			return decompressPair(kvp)
+		}
+		err = fmt.Errorf("view superseded height=%d", kvp.Height)
+	}
+
+	return nil, err
+}
+
+// casError returns ErrCASMismatch if mod is no longer the last entry for the
+// key, as is the case when another write was applied first.  Otherwise the
+// given error is returned
+func (kvs *KVS) casError(nskey, mod []byte, err error) error {
+	if errors.Is(err, ErrTimeout) || errors.Is(err, context.Canceled) {
+		return err
+	}
+
+	ent, _, er := kvs.hxl.NewEntry(nskey)
+	if er == nil && !bytes.Equal(ent.Previous, mod) {
+		return ErrCASMismatch.wrap(err)
+	}
+	return err
+}
+
+// propose proposes the entry to the log.  If the context has a deadline the
+// apply timeout is capped to it.  Retries are made here rather than by the log
+// so no further attempt is made once the context is done.  The log does not
+// support cancellation, so an attempt in flight when the context is done runs
+// to its apply timeout and the entry may still be applied.  Only errors known
+// to mean the participants could not be reached are returned as ErrNoQuorum
+func (kvs *KVS) propose(ctx context.Context, ent *hexalog.Entry, opt *hexalog.RequestOptions, retry *phi.RetryOptions) ([]byte, *phi.WriteStats, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, contextError(err)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout := int32(time.Until(deadline) / time.Millisecond)
+		if timeout <= 0 {
+			return nil, nil, ErrTimeout
+		}
+		if timeout < opt.WaitApplyTimeout {
+			opt.WaitApplyTimeout = timeout
+		}
+	}
+
+	type result struct {
+		id    []byte
+		stats *phi.WriteStats
+		err   error
+	}
+
+	ch := make(chan result, 1)
+	go func() {
+		var r result
+		for i := 0; ; i++ {
+			r.id, r.stats, r.err = kvs.hxl.ProposeEntry(ent, opt, &phi.RetryOptions{})
+			if r.err == nil || i >= retry.Retries || !retryable(r.err) {
+				break
+			}
+
+			select {
+			case <-ctx.Done():
+				r.err = contextError(ctx.Err())
+				ch <- r
+				return
+			case <-time.After(retry.RetryInterval):
+			}
+		}
+		ch <- r
+	}()
+
+	select {
+	case r := <-ch:
+		if r.err != nil && errors.Is(r.err, hexatype.ErrInsufficientPeers) {
+			r.err = ErrNoQuorum.wrap(r.err)
+		}
+		return r.id, r.stats, r.err
+
+	case <-ctx.Done():
+		return nil, nil, contextError(ctx.Err())
+	}
+}
+
+// retryable returns true if a proposal failing with the error may succeed when
+// retried.  Typed errors and cas mismatches are final
+func retryable(err error) bool {
+	var ferr *Error
+	return !errors.As(err, &ferr) && !errors.Is(err, hexatype.ErrPreviousHash)
+}
+
+// encodeSetData returns the log entry data to set the key-value pair.  Pairs
+// without metadata only write the value
+func encodeSetData(kv *KVPair) ([]byte, error) {
+	if kv.ContentType == "" && kv.Compression == 0 && len(kv.Metadata) == 0 {
+		return append([]byte{opKVSet}, kv.Value...), nil
+	}
+
+	b, err := proto.Marshal(&KVPair{
+		Value:       kv.Value,
+		ContentType: kv.ContentType,
+		Compression: kv.Compression,
+		Metadata:    kv.Metadata,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{opKVSetPair}, b...), nil
+}
+
 // build hexalog request options
 func buildLogOpts(peers []*hexalog.Participant, opt *WriteOptions) *hexalog.RequestOptions {
 	wo := opt
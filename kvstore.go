@@ -1,26 +1,29 @@
 package fidias
 
 import (
-	"fmt"
 	"os"
-	"sort"
 	"strings"
 	"sync"
 
-	"github.com/hexablock/hexatype"
 	"github.com/hexablock/log"
 )
 
-// InmemKVStore implements a in-memory key-value store used by the FSM
+// InmemKVStore implements a in-memory key-value store used by the FSM.  Keys
+// are kept in a skiplist so prefix and range iteration is O(log n + k)
 type InmemKVStore struct {
 	mu sync.RWMutex
-	kv map[string]*KVPair
+	kv *skiplist
+
+	// Directories implicitly created when setting a key.  These are removed
+	// once they no longer have any children
+	implicit map[string]bool
 }
 
-// NewInmemKVStore implements in in-memory kv store using a map
+// NewInmemKVStore implements in in-memory kv store using a skiplist
 func NewInmemKVStore() *InmemKVStore {
 	return &InmemKVStore{
-		kv: make(map[string]*KVPair),
+		kv:       newSkiplist(),
+		implicit: make(map[string]bool),
 	}
 }
 
@@ -29,78 +32,111 @@ func (kvs *InmemKVStore) Get(key []byte) (*KVPair, error) {
 	k := string(key)
 
 	kvs.mu.RLock()
-	value, ok := kvs.kv[k]
-	if !ok {
-		kvs.mu.RUnlock()
-		return nil, hexatype.ErrKeyNotFound
-	}
+	value := kvs.kv.get(k)
 	kvs.mu.RUnlock()
 
+	if value == nil {
+		return nil, ErrNotFound
+	}
 	return value, nil
-
 }
 
 // Iter iterates over each key matching the prefix.  If the callback returns
 // false iteration is immediately terminated
 func (kvs *InmemKVStore) Iter(prefix []byte, recurse bool, f func(kvp *KVPair) bool) {
 	pre := string(prefix)
+	end := prefixEnd(prefix)
 
 	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
 
-	keys := kvs.sortedKeys()
-	var seek int
-	for i, k := range keys {
-		if strings.HasPrefix(k, pre) {
-			seek = i
+	for n := kvs.kv.seek(pre); n != nil; n = n.next[0] {
+		// Break as we've passed the prefix
+		if end != nil && n.key >= string(end) {
+			break
+		}
+		// Skip keys in sub-directories
+		if !recurse && strings.Contains(n.key[len(pre):], "/") {
+			continue
+		}
+		if !f(n.value) {
 			break
 		}
 	}
+}
 
-	if recurse {
+// Scan iterates over the keys in the range given by the options in ascending
+// or descending order.  Keys only scans return pairs without their value.  If
+// the callback returns false iteration is immediately terminated
+func (kvs *InmemKVStore) Scan(opts *ScanOptions, f func(kvp *KVPair) bool) {
+	lower, upper := scanRange(opts)
 
-		for _, k := range keys[seek:] {
-			// Break as we've passed the prefix
-			if !strings.HasPrefix(k, pre) {
-				break
-			}
-			if !f(kvs.kv[k]) {
-				break
-			}
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+
+	var (
+		n    *slNode
+		next func(*slNode) *slNode
+		in   func(string) bool
+	)
+
+	if opts.Reverse {
+		n = kvs.kv.tail
+		if upper != nil {
+			n = kvs.kv.seekBefore(string(upper))
 		}
-
+		next = func(n *slNode) *slNode { return n.prev }
+		in = func(k string) bool { return k >= lower }
 	} else {
+		n = kvs.kv.seek(lower)
+		next = func(n *slNode) *slNode { return n.next[0] }
+		in = func(k string) bool { return upper == nil || k < string(upper) }
+	}
 
-		for _, k := range keys[seek:] {
-			// Break as we've passed the prefix
-			if !strings.HasPrefix(k, pre) {
-				break
-			}
-
-			// Trim prefix to check if it's a dir
-			sk := strings.TrimPrefix(k, pre)
-			if strings.Contains(sk, "/") {
-				continue
-			}
-
-			if !f(kvs.kv[k]) {
-				break
-			}
-
+	for c := 0; n != nil && in(n.key); n = next(n) {
+		kvp := n.value
+		if opts.KeysOnly {
+			v := *kvp
+			v.Value = nil
+			kvp = &v
 		}
+		if !f(kvp) {
+			break
+		}
+		if c++; opts.Limit > 0 && c == int(opts.Limit) {
+			break
+		}
+	}
+}
 
+// scanRange returns the lower inclusive and upper exclusive bounds of the scan
+// options.  A nil upper bound is unbounded
+func scanRange(opts *ScanOptions) (string, []byte) {
+	lower := string(opts.Prefix)
+	if s := string(opts.Start); s > lower {
+		lower = s
 	}
 
-	kvs.mu.RUnlock()
+	upper := prefixEnd(opts.Prefix)
+	if len(opts.End) > 0 && (upper == nil || string(opts.End) < string(upper)) {
+		upper = opts.End
+	}
+
+	return lower, upper
 }
 
-func (kvs *InmemKVStore) sortedKeys() []string {
-	keys := make([]string, 0, len(kvs.kv))
-	for k := range kvs.kv {
-		keys = append(keys, k)
+// prefixEnd returns the first key after all keys with the prefix.  It returns
+// nil if there is no such key i.e. the prefix is empty or all 0xff
+func prefixEnd(prefix []byte) []byte {
+	end := make([]byte, len(prefix))
+	copy(end, prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
 	}
-
-	sort.Strings(keys)
-	return keys
+	return nil
 }
 
 // Set writes the KVPair to the store.  This is meant to be directly called only
@@ -109,39 +145,73 @@ func (kvs *InmemKVStore) sortedKeys() []string {
 func (kvs *InmemKVStore) Set(kvp *KVPair) ([]*KVPair, error) {
 	k := string(kvp.Key)
 
-	kvs.mu.RLock()
-	if val, ok := kvs.kv[k]; ok {
-		if val.Flags != kvp.Flags {
-			kvs.mu.RUnlock()
-			return nil, fmt.Errorf("cannot change key-value type")
-		}
+	// Hold the write lock for the type check so it cannot change before the
+	// write
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+
+	if val := kvs.kv.get(k); val != nil && val.Flags != kvp.Flags {
+		return nil, ErrTypeChange
 	}
-	kvs.mu.RUnlock()
 
-	kvs.mu.Lock()
-	// Assign key
-	kvs.kv[k] = kvp
+	// Assign key.  An explicitly set directory is no longer garbage collected
+	kvs.kv.set(k, kvp)
+	delete(kvs.implicit, k)
 	// Create any required dirs
-	created := kvs.upsertPathDir(kvp)
-	kvs.mu.Unlock()
-
-	return created, nil
+	return kvs.upsertPathDir(kvp), nil
 }
 
 // Remove removes a key from the store.  This is meant to be directly called only
-// by the fsm to ensure consistency
-func (kvs *InmemKVStore) Remove(key []byte) error {
+// by the fsm to ensure consistency.  A directory can only be removed once it has
+// no children.  It returns any implicitly created parent directories that were
+// removed as they no longer have any children
+func (kvs *InmemKVStore) Remove(key []byte) ([]*KVPair, error) {
 	k := string(key)
 
 	kvs.mu.Lock()
 	defer kvs.mu.Unlock()
 
-	if _, ok := kvs.kv[k]; ok {
-		delete(kvs.kv, k)
-		return nil
+	kvp := kvs.kv.get(k)
+	if kvp == nil {
+		return nil, ErrNotFound
 	}
 
-	return hexatype.ErrKeyNotFound
+	if kvp.IsDir() && kvs.hasChildren(k) {
+		return nil, ErrDirNotEmpty
+	}
+
+	kvs.kv.delete(k)
+	delete(kvs.implicit, k)
+
+	return kvs.removeEmptyPathDirs(k), nil
+}
+
+// removeEmptyPathDirs removes the implicitly created parent directories of the
+// key, deepest first, until one with children is found.  It returns the
+// removed directories
+func (kvs *InmemKVStore) removeEmptyPathDirs(key string) []*KVPair {
+	removed := make([]*KVPair, 0)
+
+	for i := strings.LastIndex(key, "/"); i > 0; i = strings.LastIndex(key, "/") {
+		key = key[:i]
+		if !kvs.implicit[key] || kvs.hasChildren(key) {
+			break
+		}
+
+		log.Printf("[DEBUG] Removed dir=%s", key)
+		removed = append(removed, kvs.kv.get(key))
+		kvs.kv.delete(key)
+		delete(kvs.implicit, key)
+	}
+
+	return removed
+}
+
+// hasChildren returns true if there is at least one key in the dir
+func (kvs *InmemKVStore) hasChildren(dir string) bool {
+	pre := dir + "/"
+	n := kvs.kv.seek(pre)
+	return n != nil && strings.HasPrefix(n.key, pre)
 }
 
 func (kvs *InmemKVStore) upsertPathDir(kvp *KVPair) []*KVPair {
@@ -152,7 +222,7 @@ func (kvs *InmemKVStore) upsertPathDir(kvp *KVPair) []*KVPair {
 	for i, c := range key {
 		if c == '/' {
 			k := string(key[:i])
-			if _, ok := kvs.kv[k]; ok {
+			if kvs.kv.get(k) != nil {
 				continue
 			}
 
@@ -168,7 +238,8 @@ func (kvs *InmemKVStore) upsertPathDir(kvp *KVPair) []*KVPair {
 
 			log.Printf("[DEBUG] Created dir=%s", kv.Key)
 			created = append(created, kv)
-			kvs.kv[k] = kv
+			kvs.kv.set(k, kv)
+			kvs.implicit[k] = true
 		}
 	}
 
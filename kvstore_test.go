@@ -1,6 +1,10 @@
 package fidias
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
 	"testing"
 )
 
@@ -61,3 +65,238 @@ func parseDirBytes(key []byte) ([]byte, bool) {
 	}
 	return key, false
 }
+
+func Test_InmemKVStore_Remove(t *testing.T) {
+	kvs := NewInmemKVStore()
+	kvs.Set(NewKVPair([]byte("a/b/c"), []byte("value")))
+	kvs.Set(NewKVPair([]byte("a/d"), []byte("value")))
+
+	if _, err := kvs.Remove([]byte("a/b")); !errors.Is(err, ErrDirNotEmpty) {
+		t.Fatalf("should fail with dir not empty: %v", err)
+	}
+
+	removed, err := kvs.Remove([]byte("a/b/c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || string(removed[0].Key) != "a/b" {
+		t.Fatalf("should remove a/b: %v", removed)
+	}
+	if _, err = kvs.Get([]byte("a")); err != nil {
+		t.Fatal("a should exist", err)
+	}
+
+	if removed, err = kvs.Remove([]byte("a/d")); err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || string(removed[0].Key) != "a" {
+		t.Fatalf("should remove a: %v", removed)
+	}
+
+	// Explicitly set directories are not collected
+	dir := NewKVPair([]byte("x"), nil)
+	dir.Flags = int64(os.ModeDir)
+	kvs.Set(dir)
+	kvs.Set(NewKVPair([]byte("x/y"), []byte("value")))
+	if removed, _ = kvs.Remove([]byte("x/y")); len(removed) != 0 {
+		t.Fatalf("should not remove x: %v", removed)
+	}
+}
+
+func Test_removeTree(t *testing.T) {
+	kvs := NewInmemKVStore()
+	kvs.Set(NewKVPair([]byte("a/b/c"), []byte("value")))
+	kvs.Set(NewKVPair([]byte("a/b/d"), []byte("value")))
+	kvs.Set(NewKVPair([]byte("a/e"), []byte("value")))
+	kvs.Set(NewKVPair([]byte("f"), []byte("value")))
+
+	list := func(dir []byte) ([]*KVPair, error) {
+		out := make([]*KVPair, 0)
+		kvs.Iter(append(dir, '/'), false, func(kvp *KVPair) bool {
+			out = append(out, kvp)
+			return true
+		})
+		return out, nil
+	}
+	remove := func(key []byte) error {
+		_, err := kvs.Remove(key)
+		return err
+	}
+
+	if _, err := removeTree(context.Background(), []byte("f"), kvs.Get, list, remove); err == nil {
+		t.Fatal("should fail on non-directory")
+	}
+
+	n, err := removeTree(context.Background(), []byte("a"), kvs.Get, list, remove)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Fatalf("have=%d want=3", n)
+	}
+	if _, err = kvs.Get([]byte("a")); !errors.Is(err, ErrNotFound) {
+		t.Fatal("a should be removed")
+	}
+	if _, err = kvs.Get([]byte("f")); err != nil {
+		t.Fatal("f should exist", err)
+	}
+}
+
+func Test_InmemKVStore_explicitDir(t *testing.T) {
+	kvs := NewInmemKVStore()
+	kvs.Set(NewKVPair([]byte("ns/key"), []byte("value")))
+
+	// Converts the implicit dir to an explicit one
+	dir := NewDirKVPair([]byte("ns"), 0750)
+	dir.Owner = "app"
+	if _, err := kvs.Set(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if removed, _ := kvs.Remove([]byte("ns/key")); len(removed) != 0 {
+		t.Fatalf("should not remove ns: %v", removed)
+	}
+
+	kvp, err := kvs.Get([]byte("ns"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !kvp.IsDir() || kvp.Owner != "app" || kvp.modeString() != "drwxr-x---" {
+		t.Fatalf("wrong dir: %v %s", kvp, kvp.modeString())
+	}
+
+	if _, err = kvs.Set(NewKVPair([]byte("ns"), []byte("value"))); !errors.Is(err, ErrTypeChange) {
+		t.Fatal("should not change dir to key", err)
+	}
+}
+
+func Test_InmemKVStore_Scan(t *testing.T) {
+	kvs := NewInmemKVStore()
+	for _, k := range []string{"s/c", "s/a", "s/e", "s/b", "s/d", "t"} {
+		kvs.Set(NewKVPair([]byte(k), []byte("value")))
+	}
+
+	scan := func(opts *ScanOptions) string {
+		var out string
+		kvs.Scan(opts, func(kvp *KVPair) bool {
+			if opts.KeysOnly && kvp.Value != nil {
+				t.Fatal("value should not be returned")
+			}
+			out += string(kvp.Key) + " "
+			return true
+		})
+		return out
+	}
+
+	cases := []struct {
+		opts *ScanOptions
+		want string
+	}{
+		{&ScanOptions{Prefix: []byte("s/")}, "s/a s/b s/c s/d s/e "},
+		{&ScanOptions{Prefix: []byte("s/"), Start: []byte("s/b"), End: []byte("s/d")}, "s/b s/c "},
+		{&ScanOptions{Prefix: []byte("s/"), Reverse: true, Limit: 2}, "s/e s/d "},
+		{&ScanOptions{Prefix: []byte("s/"), Start: []byte("s/c"), Reverse: true, KeysOnly: true}, "s/e s/d s/c "},
+		{&ScanOptions{Start: []byte("s/e")}, "s/e t "},
+		{&ScanOptions{Prefix: []byte("s/"), Start: []byte("u")}, ""},
+	}
+	for i, c := range cases {
+		if have := scan(c.opts); have != c.want {
+			t.Errorf("%d have=%q want=%q", i, have, c.want)
+		}
+	}
+
+	// Sorted keys are maintained on remove
+	kvs.Remove([]byte("s/c"))
+	if have := scan(&ScanOptions{Prefix: []byte("s/")}); have != "s/a s/b s/d s/e " {
+		t.Fatal("wrong keys", have)
+	}
+}
+
+func Test_prefixEnd(t *testing.T) {
+	if string(prefixEnd([]byte("ab"))) != "ac" {
+		t.Fatal("should increment last byte")
+	}
+	if string(prefixEnd([]byte{'a', 0xff})) != "b" {
+		t.Fatal("should carry")
+	}
+	if prefixEnd([]byte{0xff}) != nil || prefixEnd(nil) != nil {
+		t.Fatal("should be unbounded")
+	}
+}
+
+func Test_InmemKVStore_concurrentSet(t *testing.T) {
+	kvs := NewInmemKVStore()
+
+	// Racing writes of a file and a dir for the same key must only ever
+	// succeed for one type
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		go func(i int) {
+			var kvp *KVPair
+			if i%2 == 0 {
+				kvp = NewKVPair([]byte("race"), []byte("value"))
+			} else {
+				kvp = NewDirKVPair([]byte("race"), 0755)
+			}
+			_, err := kvs.Set(kvp)
+			errs <- err
+		}(i)
+	}
+
+	var ok int
+	for i := 0; i < 20; i++ {
+		if err := <-errs; err == nil {
+			ok++
+		} else if err != ErrTypeChange {
+			t.Fatal(err)
+		}
+	}
+	if ok != 10 {
+		t.Fatal("only one type should be written", ok)
+	}
+}
+
+func benchInmemKVStore(b *testing.B, n int) *InmemKVStore {
+	kvs := NewInmemKVStore()
+	for i := 0; i < n; i++ {
+		kvs.Set(NewKVPair([]byte(fmt.Sprintf("bench/%08d", i)), []byte("value")))
+	}
+	return kvs
+}
+
+func BenchmarkInmemKVStore_Set(b *testing.B) {
+	kvs := NewInmemKVStore()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		kvs.Set(NewKVPair([]byte(fmt.Sprintf("bench/%08d", i)), []byte("value")))
+	}
+}
+
+func BenchmarkInmemKVStore_Get(b *testing.B) {
+	kvs := benchInmemKVStore(b, 100000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		kvs.Get([]byte(fmt.Sprintf("bench/%08d", i%100000)))
+	}
+}
+
+func BenchmarkInmemKVStore_Iter(b *testing.B) {
+	kvs := benchInmemKVStore(b, 100000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Iterate over 10 keys out of 100k
+		prefix := []byte(fmt.Sprintf("bench/%07d", i%10000))
+		kvs.Iter(prefix, true, func(*KVPair) bool { return true })
+	}
+}
+
+func BenchmarkInmemKVStore_Scan(b *testing.B) {
+	kvs := benchInmemKVStore(b, 100000)
+	opts := &ScanOptions{Prefix: []byte("bench/"), Limit: 10}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		opts.Start = []byte(fmt.Sprintf("bench/%08d", i%100000))
+		opts.Reverse = i%2 == 1
+		kvs.Scan(opts, func(*KVPair) bool { return true })
+	}
+}
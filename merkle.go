@@ -0,0 +1,389 @@
+package fidias
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hexablock/hexalog"
+)
+
+const (
+	// Children of each inner node of a merkle tree
+	merkleFanout = 16
+	// Levels below the root.  Leaves are at this level
+	merkleDepth = 2
+	// Leaves of a merkle tree each covering a range of key hashes
+	merkleLeaves = 256
+)
+
+// merkleKey is the view of a key covered by the merkle trees of a store
+type merkleKey struct {
+	key          string
+	height       uint32
+	modification []byte
+	// Hash of the key, height and modification
+	digest []byte
+	// Replica set the key is placed in.  Empty until placed
+	set string
+}
+
+// merkleTree is a merkle tree over the keys of a replica set.  Keys are placed
+// in a leaf by the hash of the key so every node of the tree covers the same
+// range on all nodes.  A leaf is the xor of the digests of its keys so it is
+// updated in place as keys are applied.  Inner nodes are rehashed when next
+// requested after a leaf changed
+type merkleTree struct {
+	leaves [][]byte
+	keys   map[string]*merkleKey
+	// Inner levels with level 0 the root.  Nil when a leaf changed
+	levels [][][]byte
+}
+
+func newMerkleTree() *merkleTree {
+	tree := &merkleTree{
+		leaves: make([][]byte, merkleLeaves),
+		keys:   make(map[string]*merkleKey),
+	}
+	for i := range tree.leaves {
+		tree.leaves[i] = make([]byte, sha256.Size)
+	}
+	return tree
+}
+
+func (tree *merkleTree) add(k *merkleKey) {
+	tree.toggle(k)
+	tree.keys[k.key] = k
+}
+
+func (tree *merkleTree) remove(k *merkleKey) {
+	tree.toggle(k)
+	delete(tree.keys, k.key)
+}
+
+// toggle xors the digest of the key into its leaf adding or removing it
+func (tree *merkleTree) toggle(k *merkleKey) {
+	leaf := tree.leaves[merkleLeaf([]byte(k.key))]
+	for i := range leaf {
+		leaf[i] ^= k.digest[i]
+	}
+	tree.levels = nil
+}
+
+// hashes returns copies of the hashes of the nodes at the level
+func (tree *merkleTree) hashes(level int32, nodes []int32) ([][]byte, error) {
+	if level < 0 || level > merkleDepth {
+		return nil, fmt.Errorf("invalid merkle level: %d", level)
+	}
+
+	var hashes [][]byte
+	if level == merkleDepth {
+		hashes = tree.leaves
+	} else {
+		if tree.levels == nil {
+			tree.rehash()
+		}
+		hashes = tree.levels[level]
+	}
+
+	out := make([][]byte, len(nodes))
+	for i, n := range nodes {
+		if n < 0 || int(n) >= len(hashes) {
+			return nil, fmt.Errorf("invalid merkle node: %d/%d", level, n)
+		}
+		out[i] = append([]byte{}, hashes[n]...)
+	}
+	return out, nil
+}
+
+// rehash computes the inner levels from the leaves
+func (tree *merkleTree) rehash() {
+	tree.levels = make([][][]byte, merkleDepth)
+
+	children := tree.leaves
+	for l := merkleDepth - 1; l >= 0; l-- {
+		level := make([][]byte, len(children)/merkleFanout)
+		for i := range level {
+			h := sha256.New()
+			for _, c := range children[i*merkleFanout : (i+1)*merkleFanout] {
+				h.Write(c)
+			}
+			level[i] = h.Sum(nil)
+		}
+		tree.levels[l] = level
+		children = level
+	}
+}
+
+// merkleForest caches a merkle tree per replica set over the keys of a local
+// store so nodes only compare the keys they both replicate.  The replica set of
+// a key is the sorted hosts its log is placed on.  Trees are updated as keys
+// are applied.  Keys are placed in the tree of their replica set by the locate
+// function when next needed, as placing a key requires a lookup that is not
+// made on apply.  Directories are left out as they are implied by their keys
+// and implicitly created directories differ between replicas
+type merkleForest struct {
+	// Returns the replica set of a key.  Keys are left unplaced if nil
+	locate func(key []byte) (string, error)
+
+	mu    sync.Mutex
+	keys  map[string]*merkleKey
+	trees map[string]*merkleTree
+	// Keys not yet placed in a tree
+	unplaced map[string]bool
+	// Height each key was last removed at.  Keys only found on a peer at or
+	// below it are not copied as the peer missed the remove
+	removed map[string]uint32
+}
+
+func newMerkleForest() *merkleForest {
+	return &merkleForest{
+		keys:     make(map[string]*merkleKey),
+		trees:    make(map[string]*merkleTree),
+		unplaced: make(map[string]bool),
+		removed:  make(map[string]uint32),
+	}
+}
+
+// apply updates the trees with the view of the key applied to the store.  A key
+// keeps its replica set across updates
+func (forest *merkleForest) apply(kvp *KVPair) {
+	key := string(kvp.Key)
+
+	forest.mu.Lock()
+	defer forest.mu.Unlock()
+
+	delete(forest.removed, key)
+
+	prev, ok := forest.keys[key]
+	if ok && prev.set != "" {
+		forest.trees[prev.set].remove(prev)
+	}
+	if kvp.IsDir() {
+		delete(forest.keys, key)
+		delete(forest.unplaced, key)
+		return
+	}
+
+	k := newMerkleKey(kvp)
+	forest.keys[key] = k
+	if ok && prev.set != "" {
+		k.set = prev.set
+		forest.trees[k.set].add(k)
+		return
+	}
+	forest.unplaced[key] = true
+}
+
+// remove removes the key from the trees recording the height of the remove
+func (forest *merkleForest) remove(key []byte, height uint32) {
+	k := string(key)
+
+	forest.mu.Lock()
+	defer forest.mu.Unlock()
+
+	forest.removed[k] = height
+	if prev, ok := forest.keys[k]; ok && prev.set != "" {
+		forest.trees[prev.set].remove(prev)
+	}
+	delete(forest.keys, k)
+	delete(forest.unplaced, k)
+}
+
+// removedAt returns the height the key was last removed at
+func (forest *merkleForest) removedAt(key []byte) (uint32, bool) {
+	forest.mu.Lock()
+	defer forest.mu.Unlock()
+
+	h, ok := forest.removed[string(key)]
+	return h, ok
+}
+
+// contains returns true if the key is in the store
+func (forest *merkleForest) contains(key []byte) bool {
+	forest.mu.Lock()
+	defer forest.mu.Unlock()
+
+	_, ok := forest.keys[string(key)]
+	return ok
+}
+
+// place places the unplaced keys in the tree of their replica set
+func (forest *merkleForest) place() error {
+	forest.mu.Lock()
+	keys := make([]string, 0, len(forest.unplaced))
+	for k := range forest.unplaced {
+		keys = append(keys, k)
+	}
+	forest.mu.Unlock()
+
+	return forest.relocate(keys)
+}
+
+// relocate looks up the replica set of the keys moving those whose set changed
+// to the tree of their new set.  Sets change as nodes join and leave
+func (forest *merkleForest) relocate(keys []string) error {
+	if forest.locate == nil {
+		return nil
+	}
+
+	for _, key := range keys {
+		set, err := forest.locate([]byte(key))
+		if err != nil {
+			return err
+		}
+
+		forest.mu.Lock()
+		if k, ok := forest.keys[key]; ok && set != "" && k.set != set {
+			if k.set != "" {
+				forest.trees[k.set].remove(k)
+			}
+			k.set = set
+			tree, ok := forest.trees[set]
+			if !ok {
+				tree = newMerkleTree()
+				forest.trees[set] = tree
+			}
+			tree.add(k)
+			delete(forest.unplaced, key)
+		}
+		forest.mu.Unlock()
+	}
+	return nil
+}
+
+// sets returns the replica sets of the placed keys
+func (forest *merkleForest) sets() []string {
+	forest.mu.Lock()
+	defer forest.mu.Unlock()
+
+	out := make([]string, 0, len(forest.trees))
+	for set, tree := range forest.trees {
+		if len(tree.keys) > 0 {
+			out = append(out, set)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// hashes returns the hashes of the nodes at the level of the tree of the
+// replica set.  Sets without keys have an empty tree
+func (forest *merkleForest) hashes(set string, level int32, nodes []int32) ([][]byte, error) {
+	forest.mu.Lock()
+	defer forest.mu.Unlock()
+
+	tree, ok := forest.trees[set]
+	if !ok {
+		tree = newMerkleTree()
+	}
+	return tree.hashes(level, nodes)
+}
+
+// leafDigests calls f with the digests of the keys in the leaves of the tree of
+// the replica set.  Digests are pairs without their value
+func (forest *merkleForest) leafDigests(set string, leaves []int32, f func(kvp *KVPair) bool) {
+	in := make(map[int]bool, len(leaves))
+	for _, l := range leaves {
+		in[int(l)] = true
+	}
+
+	forest.mu.Lock()
+	digests := make([]*KVPair, 0)
+	if tree, ok := forest.trees[set]; ok {
+		for _, k := range tree.keys {
+			if in[merkleLeaf([]byte(k.key))] {
+				digests = append(digests, &KVPair{Key: []byte(k.key), Height: k.height, Modification: k.modification})
+			}
+		}
+	}
+	forest.mu.Unlock()
+
+	for _, kvp := range digests {
+		if !f(kvp) {
+			return
+		}
+	}
+}
+
+func newMerkleKey(kvp *KVPair) *merkleKey {
+	h := sha256.New()
+	writeKeyDigest(h, kvp)
+	return &merkleKey{
+		key:          string(kvp.Key),
+		height:       kvp.Height,
+		modification: kvp.Modification,
+		digest:       h.Sum(nil),
+	}
+}
+
+// replicaSet returns the replica set of the log participants
+func replicaSet(peers []*hexalog.Participant) string {
+	hosts := make([]string, 0, len(peers))
+	for _, p := range peers {
+		hosts = append(hosts, p.Host)
+	}
+	sort.Strings(hosts)
+	return strings.Join(hosts, ",")
+}
+
+// replicaPeers returns the hosts of the replica set other than the local host
+func replicaPeers(set, local string) []string {
+	out := make([]string, 0)
+	for _, host := range strings.Split(set, ",") {
+		if host != "" && host != local {
+			out = append(out, host)
+		}
+	}
+	return out
+}
+
+// inReplicaSet returns true if the host is part of the replica set
+func inReplicaSet(set, host string) bool {
+	for _, h := range strings.Split(set, ",") {
+		if h == host {
+			return true
+		}
+	}
+	return false
+}
+
+// merkleLeaf returns the leaf the key belongs to
+func merkleLeaf(key []byte) int {
+	h := fnv.New32a()
+	h.Write(key)
+	return int(h.Sum32() % merkleLeaves)
+}
+
+// merkleChildren returns the children of the nodes
+func merkleChildren(nodes []int32) []int32 {
+	out := make([]int32, 0, len(nodes)*merkleFanout)
+	for _, n := range nodes {
+		for i := int32(0); i < merkleFanout; i++ {
+			out = append(out, n*merkleFanout+i)
+		}
+	}
+	return out
+}
+
+// writeKeyDigest writes the length prefixed key, height and modification to
+// the hash
+func writeKeyDigest(h hash.Hash, kvp *KVPair) {
+	var b [4]byte
+
+	binary.BigEndian.PutUint32(b[:], uint32(len(kvp.Key)))
+	h.Write(b[:])
+	h.Write(kvp.Key)
+
+	binary.BigEndian.PutUint32(b[:], kvp.Height)
+	h.Write(b[:])
+
+	binary.BigEndian.PutUint32(b[:], uint32(len(kvp.Modification)))
+	h.Write(b[:])
+	h.Write(kvp.Modification)
+}
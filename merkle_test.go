@@ -0,0 +1,161 @@
+package fidias
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/hexablock/hexalog"
+)
+
+// testMerkleForest returns a forest placing keys under x/ in replica set b and
+// all others in replica set a
+func testMerkleForest(keys ...string) *merkleForest {
+	forest := newMerkleForest()
+	forest.locate = func(key []byte) (string, error) {
+		if bytes.HasPrefix(key, []byte("x/")) {
+			return "b", nil
+		}
+		return "a", nil
+	}
+	for _, k := range keys {
+		forest.apply(&KVPair{Key: []byte(k), Value: []byte(k), Height: 1})
+	}
+	return forest
+}
+
+func Test_merkleForest(t *testing.T) {
+	keys := []string{"a", "b", "dir/c", "dir/d", "dir/sub/e", "x/f"}
+	f1 := testMerkleForest(keys...)
+	f2 := testMerkleForest(keys...)
+	if err := f1.place(); err != nil {
+		t.Fatal(err)
+	}
+	f2.place()
+
+	if sets := f1.sets(); len(sets) != 2 || sets[0] != "a" || sets[1] != "b" {
+		t.Fatal("wrong replica sets", sets)
+	}
+	r1, _ := f1.hashes("a", 0, []int32{0})
+	r2, _ := f2.hashes("a", 0, []int32{0})
+	if !bytes.Equal(r1[0], r2[0]) {
+		t.Fatal("same keys should have the same root")
+	}
+
+	// Trees are updated in place as keys are applied
+	f3 := testMerkleForest(keys...)
+	f3.place()
+	f3.apply(&KVPair{Key: []byte("dir/c"), Value: []byte("c"), Height: 2})
+	f3.apply(&KVPair{Key: []byte("x/f"), Value: []byte("f"), Height: 2})
+
+	differ := 0
+	for i := 0; i < merkleLeaves; i++ {
+		a, _ := f1.hashes("a", merkleDepth, []int32{int32(i)})
+		b, _ := f3.hashes("a", merkleDepth, []int32{int32(i)})
+		if !bytes.Equal(a[0], b[0]) {
+			differ++
+			if i != merkleLeaf([]byte("dir/c")) {
+				t.Fatal("wrong leaf differs", i)
+			}
+		}
+	}
+	if differ != 1 {
+		t.Fatal("one leaf should differ", differ)
+	}
+
+	// Reverting the key restores the tree
+	f3.apply(&KVPair{Key: []byte("dir/c"), Value: []byte("dir/c"), Height: 1})
+	r3, _ := f3.hashes("a", 0, []int32{0})
+	if !bytes.Equal(r1[0], r3[0]) {
+		t.Fatal("tree not updated in place")
+	}
+
+	if _, err := f1.hashes("a", merkleDepth+1, []int32{0}); err == nil {
+		t.Fatal("should fail with invalid level")
+	}
+	if _, err := f1.hashes("a", 1, []int32{merkleFanout}); err == nil {
+		t.Fatal("should fail with invalid node")
+	}
+}
+
+func Test_merkleForest_unplaced(t *testing.T) {
+	forest := testMerkleForest("a")
+	empty, _ := newMerkleTree().hashes(0, []int32{0})
+	if r, _ := forest.hashes("a", 0, []int32{0}); !bytes.Equal(r[0], empty[0]) {
+		t.Fatal("unplaced keys should not be in a tree")
+	}
+
+	forest.place()
+	if r, _ := forest.hashes("a", 0, []int32{0}); bytes.Equal(r[0], empty[0]) {
+		t.Fatal("key not placed")
+	}
+
+	// Keys move with their replica set
+	forest.locate = func(key []byte) (string, error) { return "c", nil }
+	forest.relocate([]string{"a"})
+	if sets := forest.sets(); len(sets) != 1 || sets[0] != "c" {
+		t.Fatal("key not relocated", sets)
+	}
+}
+
+func Test_merkleForest_remove(t *testing.T) {
+	forest := testMerkleForest("a", "dir/b")
+	forest.place()
+	forest.apply(NewDirKVPair([]byte("dir"), 0755))
+
+	forest.remove([]byte("dir/b"), 3)
+	if forest.contains([]byte("dir/b")) || forest.contains([]byte("dir")) {
+		t.Fatal("removed keys and directories should not be contained")
+	}
+	if h, ok := forest.removedAt([]byte("dir/b")); !ok || h != 3 {
+		t.Fatal("remove height not recorded", h)
+	}
+
+	got := 0
+	forest.leafDigests("a", []int32{int32(merkleLeaf([]byte("a")))}, func(kvp *KVPair) bool {
+		if string(kvp.Key) != "a" || kvp.Value != nil || kvp.Height != 1 {
+			t.Fatal("wrong digest", kvp)
+		}
+		got++
+		return true
+	})
+	if got != 1 {
+		t.Fatal("wrong digest count", got)
+	}
+
+	forest.apply(&KVPair{Key: []byte("dir/b"), Height: 4})
+	if _, ok := forest.removedAt([]byte("dir/b")); ok {
+		t.Fatal("remove should be cleared when set again")
+	}
+}
+
+func Test_replicaSet(t *testing.T) {
+	set := replicaSet([]*hexalog.Participant{{Host: "h2:1"}, {Host: "h1:1"}, {Host: "h3:1"}})
+	if set != "h1:1,h2:1,h3:1" {
+		t.Fatal("wrong replica set", set)
+	}
+	if peers := replicaPeers(set, "h2:1"); len(peers) != 2 || peers[0] != "h1:1" || peers[1] != "h3:1" {
+		t.Fatal("wrong replica peers", peers)
+	}
+	if !inReplicaSet(set, "h3:1") || inReplicaSet(set, "h4:1") {
+		t.Fatal("wrong replica set membership")
+	}
+}
+
+func Test_merkleChildren(t *testing.T) {
+	c := merkleChildren([]int32{0, 2})
+	if len(c) != 2*merkleFanout {
+		t.Fatal("wrong child count", len(c))
+	}
+	if c[0] != 0 || c[merkleFanout] != 2*merkleFanout || c[len(c)-1] != 3*merkleFanout-1 {
+		t.Fatal("wrong children", c)
+	}
+}
+
+func Test_FSM_repair_notStale(t *testing.T) {
+	store := NewInmemKVStore()
+	store.Set(&KVPair{Key: []byte("a"), Value: []byte("a"), Height: 1})
+	fsm := &FSM{kvs: store}
+	if err := fsm.repair(&KVPair{Key: []byte("a"), Height: 1}); err != errNotStale {
+		t.Fatal("should not repair with the same height", err)
+	}
+}
@@ -0,0 +1,358 @@
+package fidias
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hexablock/blox"
+	"github.com/hexablock/blox/block"
+)
+
+const (
+	// Key prefix in the BloxNamespace of multipart upload state.  Each upload
+	// is a directory holding its metadata and parts
+	bloxUploadsPrefix = "uploads/"
+	// Block size of multipart uploads when not specified
+	defaultMultipartBlockSize = 1024 * 1024
+	// Highest part number of an upload
+	maxUploadParts = 10000
+)
+
+var errNoUploadParts = errors.New("upload has no parts")
+
+// MultipartUpload is an upload whose parts are uploaded independently and
+// assembled into a single file on completion
+type MultipartUpload struct {
+	ID        string
+	Namespace string
+	// Block size all parts are sharded with
+	BlockSize  uint64
+	Durability *Durability `json:",omitempty"`
+	Created    time.Time
+	// Parts uploaded so far ordered by number
+	Parts []*UploadPart `json:",omitempty"`
+}
+
+// UploadPart is an uploaded part of a multipart upload
+type UploadPart struct {
+	Number int
+	// Root id of the part
+	ID []byte
+	// Part size in bytes
+	Size uint64
+	// Number of data blocks
+	Blocks int
+}
+
+// MultipartUploads manages multipart uploads.  Upload state is stored in the
+// BloxNamespace so any node can continue an upload.  Each part is sharded on
+// its own and registered as a root so the blocks of abandoned uploads are
+// reclaimed by the garbage collector.  Completing an upload writes a root
+// index referencing the blocks of all parts in order.  Uploads are marked in
+// progress with the registry while their blocks are written
+type MultipartUploads struct {
+	kv       BloxKV
+	roots    RootRegistry
+	device   func(policy *Durability) (*DurableDevice, error)
+	hashFunc func() hash.Hash
+}
+
+// NewMultipartUploads returns a MultipartUploads storing state in the blox kv,
+// registering roots with the registry and writing blocks to the device for the
+// policy of each upload
+func NewMultipartUploads(kv BloxKV, roots RootRegistry, device func(policy *Durability) (*DurableDevice, error), hashFunc func() hash.Hash) *MultipartUploads {
+	return &MultipartUploads{kv: kv, roots: roots, device: device, hashFunc: hashFunc}
+}
+
+// Initiate starts a multipart upload to the namespace.  A zero block size uses
+// the default and a nil policy the cluster replication factor
+func (mu *MultipartUploads) Initiate(namespace string, blockSize uint64, policy *Durability) (*MultipartUpload, error) {
+	if blockSize == 0 {
+		blockSize = defaultMultipartBlockSize
+	}
+	if policy != nil {
+		if err := policy.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	uploadID, err := NewUploadID()
+	if err != nil {
+		return nil, err
+	}
+
+	upload := &MultipartUpload{
+		ID:         uploadID,
+		Namespace:  namespace,
+		BlockSize:  blockSize,
+		Durability: policy,
+	}
+
+	b, err := json.Marshal(upload)
+	if err != nil {
+		return nil, err
+	}
+
+	kvp, err := mu.kv.set(NewKVPair(uploadKey(upload.ID), b))
+	if err != nil {
+		return nil, err
+	}
+	upload.Created = time.Unix(0, int64(kvp.ModTime))
+
+	return upload, nil
+}
+
+// Get returns the upload along with the parts uploaded so far
+func (mu *MultipartUploads) Get(uploadID string) (*MultipartUpload, error) {
+	upload, err := mu.Upload(uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	if upload.Parts, err = mu.parts(uploadID); err != nil {
+		return nil, err
+	}
+	return upload, nil
+}
+
+// UploadPart shards the part from the reader and records it against the
+// upload replacing any previous part with the same number.  Parts may be
+// uploaded concurrently.  All parts but the last must be a multiple of the
+// block size of the upload
+func (mu *MultipartUploads) UploadPart(uploadID string, number int, r io.Reader) (*UploadPart, *DedupeStats, error) {
+	if number < 1 || number > maxUploadParts {
+		return nil, nil, fmt.Errorf("invalid part number: %d", number)
+	}
+
+	upload, err := mu.Upload(uploadID)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Renews the marker of the upload for each part
+	if err = mu.roots.BeginUpload(upload.Namespace, uploadID, 0); err != nil {
+		return nil, nil, err
+	}
+
+	durable, err := mu.device(upload.Durability)
+	if err != nil {
+		return nil, nil, err
+	}
+	dev := NewDedupeDevice(durable)
+
+	sharder := blox.NewStreamSharder(dev, bloxWorkers)
+	sharder.SetBlockSize(upload.BlockSize)
+	if err = sharder.Shard(r); err != nil {
+		return nil, nil, err
+	}
+
+	idx := sharder.IndexBlock()
+	if _, err = dev.SetBlock(idx); err != nil && err != block.ErrBlockExists {
+		return nil, nil, err
+	}
+
+	// Registered so the part is collected if the upload is abandoned
+	stats := dev.Stats()
+	if err = mu.roots.RegisterRoot(upload.Namespace, NewRootRequest(idx, CompressionNone, stats)); err != nil {
+		return nil, nil, err
+	}
+
+	kvp := NewKVPair(partKey(uploadID, number), idx.ID())
+	if _, err = mu.kv.set(kvp); err != nil {
+		return nil, nil, err
+	}
+
+	part := &UploadPart{Number: number, ID: idx.ID(), Size: idx.FileSize(), Blocks: idx.BlockCount()}
+	return part, stats, nil
+}
+
+// Complete writes the root index of the file made up of the parts in order of
+// their number, registers it and removes the upload state.  Parts must be
+// numbered from 1 without gaps.  It returns the root index block
+func (mu *MultipartUploads) Complete(uploadID string) (*block.IndexBlock, error) {
+	upload, err := mu.Get(uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkUploadParts(upload.Parts, upload.BlockSize); err != nil {
+		return nil, err
+	}
+
+	dev, err := mu.device(upload.Durability)
+	if err != nil {
+		return nil, err
+	}
+	// The root index may already exist as part of a root being collected
+	if err = mu.roots.BeginUpload(upload.Namespace, uploadID, 0); err != nil {
+		return nil, err
+	}
+
+	root := block.NewIndexBlock(nil, mu.hashFunc)
+	root.SetBlockSize(upload.BlockSize)
+
+	var size, index uint64
+	for _, part := range upload.Parts {
+		idx, err := blox.NewAssembler(dev, bloxWorkers).SetRoot(part.ID)
+		if err != nil {
+			return nil, fmt.Errorf("part %d: %v", part.Number, err)
+		}
+
+		// Iteration order is not guaranteed so blocks are placed by index
+		err = idx.Iter(func(i uint64, id []byte) error {
+			root.AddBlock(index+i, &memBlock{id: id, typ: block.BlockTypeData})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		index += uint64(idx.BlockCount())
+		size += idx.FileSize()
+	}
+	root.SetFileSize(size)
+
+	if _, err = dev.SetBlock(root); err != nil && err != block.ErrBlockExists {
+		return nil, err
+	}
+	// Data blocks are already charged to the namespace through the registered
+	// part roots so only the root index is
+	reg := NewRootRequest(root, CompressionNone, nil)
+	reg.Size, reg.Blocks = 0, 1
+	reg.Upload = uploadID
+	if err = mu.roots.RegisterRoot(upload.Namespace, reg); err != nil {
+		return nil, err
+	}
+
+	_, err = mu.kv.RemoveTree(uploadDir(uploadID), DefaultWriteOptions())
+	return root, err
+}
+
+// checkUploadParts checks the parts ordered by number can be stitched into a
+// single file.  Parts must be numbered from 1 without gaps as a missing part
+// would silently be left out of the file.  All but the last part must be a
+// non-zero multiple of the block size
+func checkUploadParts(parts []*UploadPart, blockSize uint64) error {
+	if len(parts) == 0 {
+		return errNoUploadParts
+	}
+
+	for i, part := range parts {
+		if part.Number != i+1 {
+			return fmt.Errorf("part %d missing", i+1)
+		}
+		if i < len(parts)-1 && (part.Size == 0 || part.Size%blockSize != 0) {
+			return fmt.Errorf("part %d size %d not a multiple of the block size %d",
+				part.Number, part.Size, blockSize)
+		}
+	}
+	return nil
+}
+
+// Abort removes the upload state and clears its marker.  Blocks of the
+// uploaded parts are left to the garbage collector as they may be shared with
+// other files
+func (mu *MultipartUploads) Abort(uploadID string) error {
+	upload, err := mu.Upload(uploadID)
+	if err != nil {
+		return err
+	}
+	if _, err = mu.kv.RemoveTree(uploadDir(uploadID), DefaultWriteOptions()); err != nil {
+		return err
+	}
+	return mu.roots.EndUpload(upload.Namespace, uploadID)
+}
+
+// Upload returns the upload without its parts
+func (mu *MultipartUploads) Upload(uploadID string) (*MultipartUpload, error) {
+	if !validUploadID(uploadID) {
+		return nil, ErrUploadNotFound
+	}
+
+	kvp, _, err := mu.kv.Get(uploadKey(uploadID), &ReadOptions{})
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrUploadNotFound
+		}
+		return nil, err
+	}
+
+	var upload MultipartUpload
+	if err = json.Unmarshal(kvp.Value, &upload); err != nil {
+		return nil, err
+	}
+	upload.ID = uploadID
+	upload.Created = time.Unix(0, int64(kvp.ModTime))
+
+	return &upload, nil
+}
+
+// parts returns the parts of the upload ordered by number
+func (mu *MultipartUploads) parts(uploadID string) ([]*UploadPart, error) {
+	kvps, _, err := mu.kv.Scan(&ScanOptions{Prefix: []byte(partPrefix(uploadID))})
+	if err != nil {
+		return nil, err
+	}
+
+	dev, err := mu.device(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		parts = make([]*UploadPart, len(kvps))
+		errs  = make([]error, len(kvps))
+		wg    sync.WaitGroup
+	)
+	for i, kvp := range kvps {
+		n, err := strconv.Atoi(strings.TrimPrefix(string(kvp.Key), partPrefix(uploadID)))
+		if err != nil {
+			return nil, fmt.Errorf("invalid part key: %s", kvp.Key)
+		}
+
+		wg.Add(1)
+		go func(i, n int, id []byte) {
+			defer wg.Done()
+			idx, er := blox.NewAssembler(dev, bloxWorkers).SetRoot(id)
+			if er != nil {
+				errs[i] = fmt.Errorf("part %d: %v", n, er)
+				return
+			}
+			parts[i] = &UploadPart{Number: n, ID: id, Size: idx.FileSize(), Blocks: idx.BlockCount()}
+		}(i, n, kvp.Value)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(parts, func(i, j int) bool { return parts[i].Number < parts[j].Number })
+	return parts, nil
+}
+
+func uploadDir(uploadID string) []byte {
+	return []byte(bloxUploadsPrefix + uploadID)
+}
+
+func uploadKey(uploadID string) []byte {
+	return []byte(bloxUploadsPrefix + uploadID + "/upload")
+}
+
+func partPrefix(uploadID string) string {
+	return bloxUploadsPrefix + uploadID + "/part-"
+}
+
+// partKey returns the key of the part.  Numbers are zero padded so parts are
+// scanned in order
+func partKey(uploadID string, number int) []byte {
+	return []byte(fmt.Sprintf("%s%05d", partPrefix(uploadID), number))
+}
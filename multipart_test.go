@@ -0,0 +1,57 @@
+package fidias
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_partKey(t *testing.T) {
+	id := "0123456789abcdef0123456789abcdef"
+
+	// Keys must sort in part order to be scanned in order
+	if bytes.Compare(partKey(id, 9), partKey(id, 10)) >= 0 {
+		t.Fatal("part keys out of order")
+	}
+	if bytes.Compare(partKey(id, 999), partKey(id, maxUploadParts)) >= 0 {
+		t.Fatal("part keys out of order")
+	}
+	if !bytes.HasPrefix(partKey(id, 1), uploadDir(id)) || !bytes.HasPrefix(uploadKey(id), uploadDir(id)) {
+		t.Fatal("upload keys not under the upload directory")
+	}
+}
+
+func Test_MultipartUploads_invalidID(t *testing.T) {
+	mu := NewMultipartUploads(nil, nil, nil, nil)
+	for _, id := range []string{"", "../dedupe", "0123", "zz23456789abcdef0123456789abcdef"} {
+		if _, err := mu.Upload(id); err != ErrUploadNotFound {
+			t.Fatal("should not be found", id, err)
+		}
+	}
+	if _, _, err := mu.UploadPart("0123", 0, nil); err == nil {
+		t.Fatal("part number should be invalid")
+	}
+}
+
+func Test_checkUploadParts(t *testing.T) {
+	if err := checkUploadParts(nil, 4); err != errNoUploadParts {
+		t.Fatal("should fail without parts", err)
+	}
+
+	parts := []*UploadPart{{Number: 1, Size: 8}, {Number: 2, Size: 4}, {Number: 3, Size: 1}}
+	if err := checkUploadParts(parts, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	// Gaps in the part numbers
+	if err := checkUploadParts([]*UploadPart{parts[0], parts[2]}, 4); err == nil {
+		t.Fatal("should fail with a missing part")
+	}
+	if err := checkUploadParts(parts[1:], 4); err == nil {
+		t.Fatal("should fail without the first part")
+	}
+
+	// Only the last part may be a partial block
+	if err := checkUploadParts([]*UploadPart{{Number: 1, Size: 3}, parts[1]}, 4); err == nil {
+		t.Fatal("should fail with a partial block")
+	}
+}
@@ -0,0 +1,399 @@
+package fidias
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+
+	kelips "github.com/hexablock/go-kelips"
+	"github.com/hexablock/hexalog"
+	"github.com/hexablock/log"
+	"github.com/hexablock/phi"
+	"google.golang.org/grpc/metadata"
+)
+
+// DefaultNamespace is the namespace used when one is not specified.  Its keys
+// are stored under the configured kv prefix as is
+const DefaultNamespace = ""
+
+// grpc metadata key carrying the namespace of a request
+const namespaceMetadataKey = "fidias-namespace"
+
+// Log entry key prefix for named namespaces
+var namespaceKeyPrefix = []byte("ns/")
+
+// validateNamespace returns an error if the name is not a valid namespace
+// name.  Names may only contain letters, digits, '.', '-' and '_'
+func validateNamespace(name string) error {
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '.' || c == '-' || c == '_':
+		default:
+			return fmt.Errorf("invalid namespace: %q", name)
+		}
+	}
+	return nil
+}
+
+// namespacePrefix returns the log entry key prefix for the namespace
+func namespacePrefix(kvprefix, name string) string {
+	if name == DefaultNamespace {
+		return kvprefix
+	}
+	return string(namespaceKeyPrefix) + name + "/" + kvprefix
+}
+
+type namespaceContextKey struct{}
+
+// withNamespace returns a context carrying the namespace
+func withNamespace(ctx context.Context, name string) context.Context {
+	if name == DefaultNamespace {
+		return ctx
+	}
+	return context.WithValue(ctx, namespaceContextKey{}, name)
+}
+
+// namespaceFromContext returns the namespace carried by the context
+func namespaceFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(namespaceContextKey{}).(string)
+	return name
+}
+
+// outgoingContext adds the namespace carried by the context and the token to
+// the outgoing grpc metadata
+func outgoingContext(ctx context.Context, token string) context.Context {
+	pairs := make([]string, 0, 4)
+	if name := namespaceFromContext(ctx); name != DefaultNamespace {
+		pairs = append(pairs, namespaceMetadataKey, name)
+	}
+	if token != "" {
+		pairs = append(pairs, tokenMetadataKey, token)
+	}
+	if len(pairs) == 0 {
+		return ctx
+	}
+
+	md, _ := metadata.FromOutgoingContext(ctx)
+	return metadata.NewOutgoingContext(ctx, metadata.Join(md, metadata.Pairs(pairs...)))
+}
+
+// incomingNamespace returns the namespace from the incoming grpc metadata
+func incomingNamespace(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return DefaultNamespace
+	}
+	if v := md[namespaceMetadataKey]; len(v) > 0 {
+		return v[0]
+	}
+	return DefaultNamespace
+}
+
+// namespace contains the state of a single namespace on a node
+type namespace struct {
+	name string
+
+	// Local store written to by the fsm
+	store KVStore
+
+	fsm *FSM
+
+	// Consistent kvs for the namespace.  This is only available once the log
+	// has been registered
+	kvs *KVS
+}
+
+// namespaces manages all namespaces on a node.  It implements the phi FSM
+// interface routing each log entry to the fsm of the namespace it belongs to.
+// Only the default, blox and configured namespaces exist.  Their state is
+// created on first use
+type namespaces struct {
+	kvprefix   []byte
+	localTuple kelips.TupleHost
+
+	mu sync.RWMutex
+	m  map[string]*namespace
+
+	// Configured namespaces and their acl
+	acls map[string]*ACL
+	// Token of the cluster allowed access to all namespaces
+	token string
+
+	// Secondary indexes maintained in each namespace
+	indexes []*Index
+
+	// Quota applied to namespaces without one of their own
+	defaultQuota *Quota
+	quotas       map[string]*Quota
+
+	// Codec compressing large values written through the kvs of each
+	// namespace
+	compression Compression
+
+	// Used to init the fsm and kvs of each namespace
+	dht   phi.DHT
+	wal   phi.WAL
+	trans KVTransport
+}
+
+func newNamespaces(kvprefix string, localTuple kelips.TupleHost) *namespaces {
+	return &namespaces{
+		kvprefix:   []byte(kvprefix),
+		localTuple: localTuple,
+		m:          make(map[string]*namespace),
+	}
+}
+
+// Apply applies the entry to the fsm of its namespace
+func (ns *namespaces) Apply(entryID []byte, entry *hexalog.Entry) interface{} {
+	n, err := ns.get(ns.parseKey(entry.Key))
+	if err != nil {
+		log.Printf("[ERROR] Namespace not available key=%s error='%v'", entry.Key, err)
+		return err
+	}
+	return n.fsm.Apply(entryID, entry)
+}
+
+// RegisterDHT registers the dht with the fsm of all existing and future
+// namespaces
+func (ns *namespaces) RegisterDHT(dht phi.DHT) {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	ns.dht = dht
+	for _, n := range ns.m {
+		n.fsm.RegisterDHT(dht)
+	}
+}
+
+// registerWAL registers the log, transport and dht used by the kvs of all
+// existing and future namespaces
+func (ns *namespaces) registerWAL(wal phi.WAL, trans KVTransport, dht phi.DHT) {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	ns.wal = wal
+	ns.trans = trans
+	ns.dht = dht
+	for _, n := range ns.m {
+		n.fsm.RegisterDHT(dht)
+		n.kvs = ns.newKVS(n.name)
+		n.fsm.merkle.locate = ns.locator(n.name)
+	}
+}
+
+// parseKey returns the namespace of the log entry key.  Keys not belonging to
+// a named namespace belong to the default one
+func (ns *namespaces) parseKey(key []byte) string {
+	if !bytes.HasPrefix(key, namespaceKeyPrefix) {
+		return DefaultNamespace
+	}
+
+	rest := key[len(namespaceKeyPrefix):]
+	i := bytes.IndexByte(rest, '/')
+	if i < 1 || !bytes.HasPrefix(rest[i+1:], ns.kvprefix) {
+		return DefaultNamespace
+	}
+
+	return string(rest[:i])
+}
+
+// setNamespaces sets the configured namespaces and the cluster token.  It must
+// be called before any requests are served
+func (ns *namespaces) setNamespaces(token string, acls map[string]*ACL) error {
+	for name := range acls {
+		if err := validateNamespace(name); err != nil {
+			return err
+		}
+	}
+
+	ns.mu.Lock()
+	ns.token = token
+	ns.acls = acls
+	ns.mu.Unlock()
+
+	return nil
+}
+
+// exists returns true if the namespace is the default, blox or a configured
+// namespace
+func (ns *namespaces) exists(name string) bool {
+	if name == DefaultNamespace || name == BloxNamespace {
+		return true
+	}
+
+	ns.mu.RLock()
+	defer ns.mu.RUnlock()
+	_, ok := ns.acls[name]
+	return ok
+}
+
+// authorize returns ErrNamespaceNotFound if the namespace does not exist and
+// ErrPermissionDenied if the token is not allowed the access by its acl.  The
+// cluster token is allowed all access
+func (ns *namespaces) authorize(name, token string, write bool) error {
+	if !ns.exists(name) {
+		return ErrNamespaceNotFound
+	}
+
+	ns.mu.RLock()
+	defer ns.mu.RUnlock()
+
+	if token != "" && token == ns.token {
+		return nil
+	}
+	if !ns.acls[name].allows(token, write) {
+		return ErrPermissionDenied
+	}
+	return nil
+}
+
+// get returns the namespace with the name creating its state if it does not
+// exist.  It returns ErrNamespaceNotFound if the namespace is not configured
+func (ns *namespaces) get(name string) (*namespace, error) {
+	ns.mu.RLock()
+	n, ok := ns.m[name]
+	ns.mu.RUnlock()
+	if ok {
+		return n, nil
+	}
+
+	if err := validateNamespace(name); err != nil {
+		return nil, err
+	}
+	if !ns.exists(name) {
+		return nil, ErrNamespaceNotFound
+	}
+
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	if n, ok = ns.m[name]; ok {
+		return n, nil
+	}
+
+	n = &namespace{name: name, store: NewInmemKVStore()}
+	n.fsm = NewFSM(namespacePrefix(string(ns.kvprefix), name), ns.localTuple, n.store)
+	n.fsm.registerIndexes(ns.indexes)
+	if name == BloxNamespace {
+		n.fsm.observe = ns.applyRootUsage
+	}
+	if ns.dht != nil {
+		n.fsm.RegisterDHT(ns.dht)
+	}
+	if ns.wal != nil {
+		n.kvs = ns.newKVS(name)
+		n.fsm.merkle.locate = ns.locator(name)
+	}
+
+	ns.m[name] = n
+	log.Printf("[INFO] Namespace created name=%q", name)
+
+	return n, nil
+}
+
+// newKVS returns a new kvs for the namespace
+func (ns *namespaces) newKVS(name string) *KVS {
+	kvs := NewKVS(namespacePrefix(string(ns.kvprefix), name), ns.wal, ns.trans, ns.dht)
+	kvs.namespace = name
+	kvs.quotas = ns
+	kvs.compression = ns.compression
+	kvs.indexes = make(map[string]*Index, len(ns.indexes))
+	for _, idx := range ns.indexes {
+		kvs.indexes[idx.Name] = idx
+	}
+	return kvs
+}
+
+// locator returns a func returning the replica set of a key of the namespace
+// i.e. the hosts the log of the key is placed on.  It must be called with the
+// log registered
+func (ns *namespaces) locator(name string) func(key []byte) (string, error) {
+	prefix, wal := namespacePrefix(string(ns.kvprefix), name), ns.wal
+	return func(key []byte) (string, error) {
+		_, peers, err := wal.NewEntry(append([]byte(prefix), key...))
+		if err != nil {
+			return "", err
+		}
+		return replicaSet(peers), nil
+	}
+}
+
+// setIndexes sets the secondary indexes maintained in each namespace.  It must
+// be called before any namespace is created
+func (ns *namespaces) setIndexes(defs []*Index) error {
+	for _, d := range defs {
+		if err := validateIndex(d); err != nil {
+			return err
+		}
+	}
+
+	ns.mu.Lock()
+	ns.indexes = defs
+	ns.mu.Unlock()
+
+	return nil
+}
+
+// lookupIndex returns the pairs in the local store of the namespace matching
+// the index query.  Nothing is returned if the namespace does not exist
+func (ns *namespaces) lookupIndex(name string, q *IndexQuery) ([]*KVPair, error) {
+	ns.mu.RLock()
+	n, ok := ns.m[name]
+	ns.mu.RUnlock()
+	if !ok {
+		return []*KVPair{}, nil
+	}
+	return n.fsm.lookupIndex(q)
+}
+
+// store returns the local store of the namespace.  Namespaces are not created
+// on reads so ErrNotFound is returned if it does not exist
+func (ns *namespaces) store(name string) (KVStore, error) {
+	ns.mu.RLock()
+	n, ok := ns.m[name]
+	ns.mu.RUnlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return n.store, nil
+}
+
+// merkle returns the merkle trees of the local store of the namespace.
+// Namespaces are not created on reads so ErrNotFound is returned if it does
+// not exist
+func (ns *namespaces) merkle(name string) (*merkleForest, error) {
+	ns.mu.RLock()
+	n, ok := ns.m[name]
+	ns.mu.RUnlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return n.fsm.merkle, nil
+}
+
+// names returns the names of all namespaces on the node
+func (ns *namespaces) names() []string {
+	ns.mu.RLock()
+	defer ns.mu.RUnlock()
+
+	out := make([]string, 0, len(ns.m))
+	for name := range ns.m {
+		out = append(out, name)
+	}
+	return out
+}
+
+// kvs returns the consistent kvs of the namespace
+func (ns *namespaces) kvs(name string) (*KVS, error) {
+	n, err := ns.get(name)
+	if err != nil {
+		return nil, err
+	}
+	if n.kvs == nil {
+		return nil, fmt.Errorf("namespace not ready: %q", name)
+	}
+	return n.kvs, nil
+}
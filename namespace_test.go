@@ -0,0 +1,124 @@
+package fidias
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+)
+
+func Test_validateNamespace(t *testing.T) {
+	for _, name := range []string{"", "tenant-1", "a.b_c"} {
+		if err := validateNamespace(name); err != nil {
+			t.Fatal(name, err)
+		}
+	}
+	for _, name := range []string{"a/b", "a b", "ns:1"} {
+		if err := validateNamespace(name); err == nil {
+			t.Fatalf("%q should be invalid", name)
+		}
+	}
+}
+
+func Test_namespaces_parseKey(t *testing.T) {
+	ns := newNamespaces("kv/", nil)
+
+	if p := namespacePrefix("kv/", DefaultNamespace); p != "kv/" {
+		t.Fatal("default prefix changed", p)
+	}
+
+	key := []byte(namespacePrefix("kv/", "tenant") + "foo/bar")
+	if string(key) != "ns/tenant/kv/foo/bar" {
+		t.Fatal("wrong key", string(key))
+	}
+	if n := ns.parseKey(key); n != "tenant" {
+		t.Fatal("wrong namespace", n)
+	}
+
+	for _, k := range []string{"kv/foo", "ns/tenant/foo", "ns//kv/foo"} {
+		if n := ns.parseKey([]byte(k)); n != DefaultNamespace {
+			t.Fatalf("%s should be in the default namespace: %q", k, n)
+		}
+	}
+}
+
+func Test_namespaces_store(t *testing.T) {
+	ns := newNamespaces("kv/", nil)
+	if _, err := ns.get("tenant"); err != ErrNamespaceNotFound {
+		t.Fatal("should not create unconfigured namespaces", err)
+	}
+	ns.setNamespaces("", map[string]*ACL{"tenant": nil})
+
+	if _, err := ns.store("tenant"); err != ErrNotFound {
+		t.Fatal("should not create on read", err)
+	}
+	if _, err := ns.get("bad/name"); err == nil {
+		t.Fatal("should fail on invalid name")
+	}
+	if _, err := ns.kvs("tenant"); err == nil {
+		t.Fatal("kvs should not be ready")
+	}
+	if _, err := ns.store("tenant"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_namespace_metadata(t *testing.T) {
+	ctx := context.Background()
+	if out := outgoingContext(withNamespace(ctx, DefaultNamespace), ""); out != ctx {
+		t.Fatal("default namespace should not add metadata")
+	}
+
+	out := outgoingContext(withNamespace(ctx, "tenant"), "secret")
+	md, ok := metadata.FromOutgoingContext(out)
+	if !ok {
+		t.Fatal("metadata not set")
+	}
+
+	in := metadata.NewIncomingContext(ctx, md)
+	if n := incomingNamespace(in); n != "tenant" {
+		t.Fatal("wrong namespace", n)
+	}
+	if n := incomingNamespace(ctx); n != DefaultNamespace {
+		t.Fatal("wrong namespace", n)
+	}
+	if tok := incomingToken(in); tok != "secret" {
+		t.Fatal("wrong token", tok)
+	}
+}
+
+func Test_namespaces_authorize(t *testing.T) {
+	ns := newNamespaces("kv/", nil)
+	ns.setNamespaces("cluster", map[string]*ACL{
+		"open":   nil,
+		"tenant": {ReadWrite: []string{"rw"}, ReadOnly: []string{"ro"}},
+	})
+
+	if err := ns.authorize("other", "cluster", false); err != ErrNamespaceNotFound {
+		t.Fatal("should not find unconfigured namespace", err)
+	}
+	if err := ns.authorize("open", "", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := ns.authorize(BloxNamespace, "", false); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		token string
+		write bool
+		ok    bool
+	}{
+		{"rw", true, true},
+		{"ro", false, true},
+		{"ro", true, false},
+		{"", false, false},
+		{"other", false, false},
+		{"cluster", true, true},
+	} {
+		err := ns.authorize("tenant", c.token, c.write)
+		if c.ok && err != nil || !c.ok && err != ErrPermissionDenied {
+			t.Fatalf("token=%q write=%v error=%v", c.token, c.write, err)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package fidias
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"time"
 
@@ -17,10 +18,16 @@ type LocalNodeProvider interface {
 type NetTransport struct {
 	localProv LocalNodeProvider
 
-	kv KVStore
-
+	// Default namespace store and kvs
+	kv  KVStore
 	kvs *KVS
 
+	// All namespaces served.  If nil only the default namespace is served
+	namespaces *namespaces
+
+	// Token sent with outgoing requests
+	token string
+
 	pool *outPool
 }
 
@@ -42,21 +49,75 @@ func (trans *NetTransport) Register(kvs KVStore) {
 	trans.kv = kvs
 }
 
+// namespace returns the namespace of the incoming request once the token of
+// the request is authorized for the access
+func (trans *NetTransport) namespace(ctx context.Context, write bool) (string, error) {
+	name := incomingNamespace(ctx)
+	if trans.namespaces == nil {
+		return name, nil
+	}
+	return name, trans.namespaces.authorize(name, incomingToken(ctx), write)
+}
+
+// namespaceKVS returns the kvs for the namespace of the incoming request
+func (trans *NetTransport) namespaceKVS(ctx context.Context, write bool) (*KVS, error) {
+	name, err := trans.namespace(ctx, write)
+	if err != nil {
+		return nil, err
+	}
+	if name == DefaultNamespace || trans.namespaces == nil {
+		return trans.kvs, nil
+	}
+	return trans.namespaces.kvs(name)
+}
+
+// namespaceStore returns the local store for the namespace of the incoming
+// request
+func (trans *NetTransport) namespaceStore(ctx context.Context) (KVStore, error) {
+	name, err := trans.namespace(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	if name == DefaultNamespace || trans.namespaces == nil {
+		return trans.kv, nil
+	}
+	return trans.namespaces.store(name)
+}
+
+// namespaceMerkle returns the merkle trees of the local store for the namespace
+// of the incoming request.  Trees are only kept by the fsm of a namespace
+func (trans *NetTransport) namespaceMerkle(ctx context.Context) (*merkleForest, error) {
+	name, err := trans.namespace(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	if trans.namespaces == nil {
+		return nil, ErrNamespaceNotFound
+	}
+	return trans.namespaces.merkle(name)
+}
+
 // LocalNode returns the LocalNode from the remote host
 func (trans *NetTransport) LocalNode(host string) (hexatype.Node, error) {
+	return trans.LocalNodeContext(context.Background(), host)
+}
+
+// LocalNodeContext returns the LocalNode from the remote host using the given
+// context for the request
+func (trans *NetTransport) LocalNodeContext(ctx context.Context, host string) (hexatype.Node, error) {
 	var n hexatype.Node
 	conn, err := trans.pool.getConn(host)
 	if err != nil {
 		return n, err
 	}
 
-	node, err := conn.client.LocalNodeRPC(context.Background(), &Request{})
+	node, err := conn.client.LocalNodeRPC(ctx, &Request{})
 	trans.pool.returnConn(conn)
 	if err == nil {
 		n = *node
 	}
 
-	return n, err
+	return n, fromRPCError(err)
 }
 
 // GetKey retrieves a key from the single host.  It returns an error if not found
@@ -66,10 +127,10 @@ func (trans *NetTransport) GetKey(ctx context.Context, host string, key []byte)
 		return nil, err
 	}
 
-	kvp, err := conn.client.GetKeyRPC(ctx, &KVPair{Key: key})
+	kvp, err := conn.client.GetKeyRPC(outgoingContext(ctx, trans.token), &KVPair{Key: key})
 	trans.pool.returnConn(conn)
 
-	return kvp, err
+	return kvp, fromRPCError(err)
 }
 
 // ListDir gets the contents of a directory from a single host
@@ -79,8 +140,11 @@ func (trans *NetTransport) ListDir(ctx context.Context, host string, dir []byte)
 		return nil, err
 	}
 
-	stream, err := conn.client.ListDirRPC(context.Background(), &KVPair{Key: dir})
+	stream, err := conn.client.ListDirRPC(outgoingContext(ctx, trans.token), &KVPair{Key: dir})
 	defer trans.pool.returnConn(conn)
+	if err != nil {
+		return nil, fromRPCError(err)
+	}
 
 	out := make([]*KVPair, 0)
 	for {
@@ -94,14 +158,123 @@ func (trans *NetTransport) ListDir(ctx context.Context, host string, dir []byte)
 		out = append(out, kvp)
 	}
 
-	return out, err
+	return out, fromRPCError(err)
+}
+
+// Scan scans the key range given by the options on a single host
+func (trans *NetTransport) Scan(ctx context.Context, host string, opts *ScanOptions) ([]*KVPair, error) {
+	conn, err := trans.pool.getConn(host)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := conn.client.ScanRPC(outgoingContext(ctx, trans.token), opts)
+	defer trans.pool.returnConn(conn)
+	if err != nil {
+		return nil, fromRPCError(err)
+	}
+
+	out := make([]*KVPair, 0)
+	for {
+		kvp, er := stream.Recv()
+		if er != nil {
+			if er != io.EOF {
+				err = er
+			}
+			break
+		}
+		out = append(out, kvp)
+	}
+
+	return out, fromRPCError(err)
+}
+
+// LookupIndex looks up the index query on a single host
+func (trans *NetTransport) LookupIndex(ctx context.Context, host string, q *IndexQuery) ([]*KVPair, error) {
+	conn, err := trans.pool.getConn(host)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := conn.client.LookupIndexRPC(outgoingContext(ctx, trans.token), q)
+	trans.pool.returnConn(conn)
+	if err != nil {
+		return nil, fromRPCError(err)
+	}
+
+	return resp.KVs, nil
+}
+
+// Usage returns the usage of the namespace carried by the context on a single
+// host
+func (trans *NetTransport) Usage(ctx context.Context, host string) (*NamespaceUsage, error) {
+	conn, err := trans.pool.getConn(host)
+	if err != nil {
+		return nil, err
+	}
+
+	usage, err := conn.client.UsageRPC(outgoingContext(ctx, trans.token), &Request{})
+	trans.pool.returnConn(conn)
+
+	return usage, fromRPCError(err)
+}
+
+// MerkleHashes returns the hashes of the nodes at the level of the merkle tree
+// of the replica set of the namespace carried by the context on a single host
+func (trans *NetTransport) MerkleHashes(ctx context.Context, host, set string, level int32, nodes []int32) ([][]byte, error) {
+	conn, err := trans.pool.getConn(host)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := conn.client.MerkleRPC(outgoingContext(ctx, trans.token), &MerkleRequest{Level: level, Nodes: nodes, Set: set})
+	trans.pool.returnConn(conn)
+	if err != nil {
+		return nil, fromRPCError(err)
+	}
+
+	return resp.Hashes, nil
+}
+
+// MerkleLeaves returns the key digests of the merkle tree leaves of the replica
+// set of the namespace carried by the context on a single host
+func (trans *NetTransport) MerkleLeaves(ctx context.Context, host, set string, leaves []int32) ([]*KVPair, error) {
+	conn, err := trans.pool.getConn(host)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := conn.client.MerkleLeavesRPC(outgoingContext(ctx, trans.token), &MerkleRequest{Level: merkleDepth, Nodes: leaves, Set: set})
+	defer trans.pool.returnConn(conn)
+	if err != nil {
+		return nil, fromRPCError(err)
+	}
+
+	out := make([]*KVPair, 0)
+	for {
+		kvp, er := stream.Recv()
+		if er != nil {
+			if er != io.EOF {
+				err = er
+			}
+			break
+		}
+		out = append(out, kvp)
+	}
+
+	return out, fromRPCError(err)
 }
 
 // SetRPC serves a set request on the cluster.
 func (trans *NetTransport) SetRPC(ctx context.Context, req *WriteRequest) (*WriteResponse, error) {
-	kv, stats, err := trans.kvs.Set(req.KV, req.Options)
+	kvs, err := trans.namespaceKVS(ctx, true)
 	if err != nil {
-		return nil, err
+		return nil, toRPCError(err)
+	}
+
+	kv, stats, err := kvs.SetContext(ctx, req.KV, req.Options)
+	if err != nil {
+		return nil, toRPCError(err)
 	}
 
 	resp := &WriteResponse{
@@ -118,9 +291,14 @@ func (trans *NetTransport) SetRPC(ctx context.Context, req *WriteRequest) (*Writ
 
 // CASetRPC serves a cluster CASet request
 func (trans *NetTransport) CASetRPC(ctx context.Context, req *WriteRequest) (*WriteResponse, error) {
-	kv, stats, err := trans.kvs.CASet(req.KV, req.KV.Modification, req.Options)
+	kvs, err := trans.namespaceKVS(ctx, true)
 	if err != nil {
-		return nil, err
+		return nil, toRPCError(err)
+	}
+
+	kv, stats, err := kvs.CASetContext(ctx, req.KV, req.KV.Modification, req.Options)
+	if err != nil {
+		return nil, toRPCError(err)
 	}
 
 	resp := &WriteResponse{
@@ -137,9 +315,14 @@ func (trans *NetTransport) CASetRPC(ctx context.Context, req *WriteRequest) (*Wr
 
 // RemoveRPC serves a cluster Remove request
 func (trans *NetTransport) RemoveRPC(ctx context.Context, req *WriteRequest) (*WriteResponse, error) {
-	stats, err := trans.kvs.Remove(req.KV.Key, req.Options)
+	kvs, err := trans.namespaceKVS(ctx, true)
 	if err != nil {
-		return nil, err
+		return nil, toRPCError(err)
+	}
+
+	stats, err := kvs.RemoveContext(ctx, req.KV.Key, req.Options)
+	if err != nil {
+		return nil, toRPCError(err)
 	}
 
 	resp := &WriteResponse{
@@ -155,9 +338,14 @@ func (trans *NetTransport) RemoveRPC(ctx context.Context, req *WriteRequest) (*W
 
 // CARemoveRPC serves a cluster CARemove request
 func (trans *NetTransport) CARemoveRPC(ctx context.Context, req *WriteRequest) (*WriteResponse, error) {
-	stats, err := trans.kvs.CARemove(req.KV.Key, req.KV.Modification, req.Options)
+	kvs, err := trans.namespaceKVS(ctx, true)
 	if err != nil {
-		return nil, err
+		return nil, toRPCError(err)
+	}
+
+	stats, err := kvs.CARemoveContext(ctx, req.KV.Key, req.KV.Modification, req.Options)
+	if err != nil {
+		return nil, toRPCError(err)
 	}
 
 	resp := &WriteResponse{
@@ -171,25 +359,290 @@ func (trans *NetTransport) CARemoveRPC(ctx context.Context, req *WriteRequest) (
 	return resp, nil
 }
 
+// IncrRPC serves a cluster Incr request
+func (trans *NetTransport) IncrRPC(ctx context.Context, req *IncrRequest) (*WriteResponse, error) {
+	kvs, err := trans.namespaceKVS(ctx, true)
+	if err != nil {
+		return nil, toRPCError(err)
+	}
+
+	kv, stats, err := kvs.IncrContext(ctx, req.Key, req.Delta, req.Options)
+	if err != nil {
+		return nil, toRPCError(err)
+	}
+
+	resp := &WriteResponse{
+		KV: kv,
+		Stats: &WriteStats{
+			BallotTime:   stats.BallotTime.Nanoseconds(),
+			ApplyTime:    stats.ApplyTime.Nanoseconds(),
+			Participants: stats.Participants,
+		},
+	}
+
+	return resp, nil
+}
+
+// AppendRPC serves a cluster Append request
+func (trans *NetTransport) AppendRPC(ctx context.Context, req *WriteRequest) (*WriteResponse, error) {
+	kvs, err := trans.namespaceKVS(ctx, true)
+	if err != nil {
+		return nil, toRPCError(err)
+	}
+
+	kv, stats, err := kvs.AppendContext(ctx, req.KV.Key, req.KV.Value, req.Options)
+	if err != nil {
+		return nil, toRPCError(err)
+	}
+
+	resp := &WriteResponse{
+		KV: kv,
+		Stats: &WriteStats{
+			BallotTime:   stats.BallotTime.Nanoseconds(),
+			ApplyTime:    stats.ApplyTime.Nanoseconds(),
+			Participants: stats.Participants,
+		},
+	}
+
+	return resp, nil
+}
+
+// MkdirRPC serves a cluster Mkdir request
+func (trans *NetTransport) MkdirRPC(ctx context.Context, req *WriteRequest) (*WriteResponse, error) {
+	kvs, err := trans.namespaceKVS(ctx, true)
+	if err != nil {
+		return nil, toRPCError(err)
+	}
+
+	kv, stats, err := kvs.MkdirContext(ctx, req.KV, req.Options)
+	if err != nil {
+		return nil, toRPCError(err)
+	}
+
+	resp := &WriteResponse{
+		KV: kv,
+		Stats: &WriteStats{
+			BallotTime:   stats.BallotTime.Nanoseconds(),
+			ApplyTime:    stats.ApplyTime.Nanoseconds(),
+			Participants: stats.Participants,
+		},
+	}
+
+	return resp, nil
+}
+
+// RegisterRootRPC serves the registration of an uploaded root to the namespace
+// of the request.  The token must be allowed to write to the namespace
+func (trans *NetTransport) RegisterRootRPC(ctx context.Context, req *RootRequest) (*WriteResponse, error) {
+	if trans.namespaces == nil {
+		return nil, toRPCError(ErrNamespaceNotFound)
+	}
+
+	name, err := trans.namespace(ctx, true)
+	if err != nil {
+		return nil, toRPCError(err)
+	}
+
+	kv, err := trans.namespaces.registerRoot(ctx, name, req)
+	if err != nil {
+		return nil, toRPCError(err)
+	}
+
+	return &WriteResponse{KV: kv}, nil
+}
+
+// BeginUploadRPC serves marking an upload to the namespace of the request in
+// progress.  The token must be allowed to write to the namespace
+func (trans *NetTransport) BeginUploadRPC(ctx context.Context, req *UploadRequest) (*WriteResponse, error) {
+	if trans.namespaces == nil {
+		return nil, toRPCError(ErrNamespaceNotFound)
+	}
+
+	name, err := trans.namespace(ctx, true)
+	if err != nil {
+		return nil, toRPCError(err)
+	}
+
+	kv, err := trans.namespaces.beginUpload(ctx, name, req.ID, req.Size)
+	if err != nil {
+		return nil, toRPCError(err)
+	}
+
+	return &WriteResponse{KV: kv}, nil
+}
+
+// EndUploadRPC serves clearing the marker of an upload to the namespace of the
+// request.  The token must be allowed to write to the namespace
+func (trans *NetTransport) EndUploadRPC(ctx context.Context, req *UploadRequest) (*WriteResponse, error) {
+	if trans.namespaces == nil {
+		return nil, toRPCError(ErrNamespaceNotFound)
+	}
+
+	name, err := trans.namespace(ctx, true)
+	if err != nil {
+		return nil, toRPCError(err)
+	}
+
+	if err = trans.namespaces.endUpload(ctx, name, req.ID); err != nil {
+		return nil, toRPCError(err)
+	}
+
+	return &WriteResponse{}, nil
+}
+
 // GetKeyRPC serves a get key request performing a local lookup
 func (trans *NetTransport) GetKeyRPC(ctx context.Context, in *KVPair) (*KVPair, error) {
 	log.Printf("[DEBUG] NetTransport.GetKeyRPC key=%s", in.Key)
-	return trans.kv.Get(in.Key)
+	store, err := trans.namespaceStore(ctx)
+	if err != nil {
+		return nil, toRPCError(err)
+	}
+
+	kvp, err := store.Get(in.Key)
+	return kvp, toRPCError(err)
 }
 
 // ListDirRPC serves a list dir request from the local store.  It streams all
 // kv's for a given dir
 func (trans *NetTransport) ListDirRPC(in *KVPair, stream FidiasRPC_ListDirRPCServer) error {
 	log.Printf("[DEBUG] NetTransport.ListDirRPC key=%s", in.Key)
-	var err error
-	trans.kv.Iter(in.Key, false, func(kv *KVPair) bool {
+	ctx := stream.Context()
+	store, err := trans.namespaceStore(ctx)
+	if err != nil {
+		return toRPCError(err)
+	}
+
+	store.Iter(in.Key, false, func(kv *KVPair) bool {
+		if err = ctx.Err(); err != nil {
+			return false
+		}
+		if err = stream.Send(kv); err != nil {
+			return false
+		}
+		return true
+	})
+
+	return toRPCError(err)
+}
+
+// QueryIndexRPC serves an index query on the cluster
+func (trans *NetTransport) QueryIndexRPC(ctx context.Context, req *IndexQuery) (*IndexResult, error) {
+	kvs, err := trans.namespaceKVS(ctx, false)
+	if err != nil {
+		return nil, toRPCError(err)
+	}
+
+	kvps, stats, err := kvs.QueryIndexContext(ctx, req)
+	if err != nil {
+		return nil, toRPCError(err)
+	}
+
+	return &IndexResult{KVs: kvps, Stats: stats}, nil
+}
+
+// LookupIndexRPC serves an index query from the local store
+func (trans *NetTransport) LookupIndexRPC(ctx context.Context, req *IndexQuery) (*IndexResult, error) {
+	log.Printf("[DEBUG] NetTransport.LookupIndexRPC index=%s", req.Index)
+	if trans.namespaces == nil {
+		return nil, toRPCError(ErrIndexNotFound)
+	}
+
+	name, err := trans.namespace(ctx, false)
+	if err != nil {
+		return nil, toRPCError(err)
+	}
+
+	kvps, err := trans.namespaces.lookupIndex(name, req)
+	if err != nil {
+		return nil, toRPCError(err)
+	}
+
+	return &IndexResult{KVs: kvps}, nil
+}
+
+// UsageRPC serves the usage of the namespace of the request on the local node
+func (trans *NetTransport) UsageRPC(ctx context.Context, req *Request) (*NamespaceUsage, error) {
+	if trans.namespaces == nil {
+		return nil, toRPCError(ErrNotFound)
+	}
+
+	name, err := trans.namespace(ctx, false)
+	if err != nil {
+		return nil, toRPCError(err)
+	}
+
+	usage, err := trans.namespaces.usage(name)
+	return usage, toRPCError(err)
+}
+
+// ScanRPC serves a range scan from the local store.  It streams all kv's in the
+// range
+func (trans *NetTransport) ScanRPC(in *ScanOptions, stream FidiasRPC_ScanRPCServer) error {
+	log.Printf("[DEBUG] NetTransport.ScanRPC prefix=%s", in.Prefix)
+	ctx := stream.Context()
+	store, err := trans.namespaceStore(ctx)
+	if err != nil {
+		return toRPCError(err)
+	}
+
+	store.Scan(in, func(kv *KVPair) bool {
+		if err = ctx.Err(); err != nil {
+			return false
+		}
 		if err = stream.Send(kv); err != nil {
 			return false
 		}
 		return true
 	})
 
-	return err
+	return toRPCError(err)
+}
+
+// MerkleRPC serves the hashes of the cached merkle tree of the replica set of
+// the local store of the namespace of the request.  Keys applied since the
+// last request are placed in their tree first
+func (trans *NetTransport) MerkleRPC(ctx context.Context, req *MerkleRequest) (*MerkleResponse, error) {
+	forest, err := trans.namespaceMerkle(ctx)
+	if err != nil {
+		return nil, toRPCError(err)
+	}
+	if err = forest.place(); err != nil {
+		return nil, toRPCError(err)
+	}
+
+	hashes, err := forest.hashes(req.Set, req.Level, req.Nodes)
+	if err != nil {
+		return nil, toRPCError(err)
+	}
+
+	return &MerkleResponse{Hashes: hashes}, nil
+}
+
+// MerkleLeavesRPC serves the key digests of the requested merkle tree leaves of
+// the replica set from the local store.  It streams a pair without its value
+// for each key
+func (trans *NetTransport) MerkleLeavesRPC(req *MerkleRequest, stream FidiasRPC_MerkleLeavesRPCServer) error {
+	ctx := stream.Context()
+	if req.Level != merkleDepth {
+		return toRPCError(fmt.Errorf("invalid merkle leaf level: %d", req.Level))
+	}
+
+	forest, err := trans.namespaceMerkle(ctx)
+	if err != nil {
+		return toRPCError(err)
+	}
+
+	forest.leafDigests(req.Set, req.Nodes, func(kvp *KVPair) bool {
+		if err = ctx.Err(); err != nil {
+			return false
+		}
+		if err = stream.Send(kvp); err != nil {
+			return false
+		}
+		return true
+	})
+
+	return toRPCError(err)
 }
 
 func (trans *NetTransport) LocalNodeRPC(ctx context.Context, req *Request) (*hexatype.Node, error) {
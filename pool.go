@@ -1,12 +1,14 @@
 package fidias
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
 )
 
 type rpcOutConn struct {
@@ -33,6 +35,24 @@ func newOutPool(maxIdle, reapInterval time.Duration) *outPool {
 	}
 }
 
+// ready waits for the connection to the host to be established.  It returns
+// false if it could not be or the context is done, in which case nothing has
+// been sent on it
+func (o *rpcOutConn) ready(ctx context.Context) bool {
+	for {
+		s := o.conn.GetState()
+		switch s {
+		case connectivity.Ready:
+			return true
+		case connectivity.TransientFailure, connectivity.Shutdown:
+			return false
+		}
+		if !o.conn.WaitForStateChange(ctx, s) {
+			return false
+		}
+	}
+}
+
 func (pool *outPool) returnConn(o *rpcOutConn) {
 	// Close and discard connection if we've shutdown
 	if atomic.LoadInt32(&pool.stopped) == 1 {
@@ -0,0 +1,356 @@
+package fidias
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/hexablock/log"
+)
+
+// Key prefix in the BloxNamespace of the usage reports of namespaces with a
+// quota.  Each node reports the usage of its own stores
+const bloxUsagePrefix = "usage/"
+
+const (
+	// Interval nodes report the usage of namespaces with a quota at
+	usageReportInterval = 10 * time.Second
+	// Reports older than this are from nodes that have left the cluster
+	usageReportTTL = 3 * usageReportInterval
+)
+
+// Metadata keys of root registrations charged to the quota of their namespace
+const (
+	// Stored file size in bytes
+	rootSizeMetadata = "size"
+	// Number of blocks including the root
+	rootBlocksMetadata = "blocks"
+)
+
+// quotaChecker checks writes against the quota of a namespace before they are
+// proposed.  Usage is derived from the stores of each node as entries are
+// applied and reported by every node so the quota applies to the namespace as
+// a whole regardless of the node a write is proposed through
+type quotaChecker interface {
+	// quotaDelta returns the usage a write to the key adds to the namespace or
+	// nil if the namespace has no quota.  size returns the size of the key
+	// once written given its current pair, which is nil if it does not exist.
+	// A negative size removes the key
+	quotaDelta(ctx context.Context, namespace string, key []byte, size func(cur *KVPair) int64) (*Usage, error)
+
+	// checkQuota returns ErrQuotaExceeded if adding the usage exceeds the
+	// quota of the namespace
+	checkQuota(ctx context.Context, namespace string, delta *Usage) error
+}
+
+// usageReportPrefix returns the key prefix of the usage reports of the
+// namespace.  The default namespace uses a name no namespace can have
+func usageReportPrefix(name string) string {
+	if name == DefaultNamespace {
+		name = ":default"
+	}
+	return bloxUsagePrefix + name + "/"
+}
+
+// kvSize returns the number of bytes accounted for the pair
+func kvSize(kvp *KVPair) int64 {
+	if kvp == nil {
+		return 0
+	}
+	return int64(len(kvp.Key) + len(kvp.Value))
+}
+
+// addKVs atomically adds to the key count and bytes
+func (u *Usage) addKVs(keys, bytes int64) {
+	atomic.AddInt64(&u.Keys, keys)
+	atomic.AddInt64(&u.Bytes, bytes)
+}
+
+// addBlocks atomically adds to the block count and bytes
+func (u *Usage) addBlocks(blocks, bytes int64) {
+	atomic.AddInt64(&u.Blocks, blocks)
+	atomic.AddInt64(&u.BlockBytes, bytes)
+}
+
+// snapshot returns a copy of the usage
+func (u *Usage) snapshot() *Usage {
+	return &Usage{
+		Keys:       atomic.LoadInt64(&u.Keys),
+		Bytes:      atomic.LoadInt64(&u.Bytes),
+		Blocks:     atomic.LoadInt64(&u.Blocks),
+		BlockBytes: atomic.LoadInt64(&u.BlockBytes),
+	}
+}
+
+// check returns ErrQuotaExceeded if adding keys and bytes to the usage exceeds
+// the quota.  Key and block bytes count towards the same limit.  Only increases
+// are checked so writes reducing usage are always allowed
+func (q *Quota) check(u *Usage, keys, bytes int64) error {
+	if q == nil {
+		return nil
+	}
+
+	if keys > 0 && q.MaxKeys > 0 && u.Keys+keys > q.MaxKeys {
+		return ErrQuotaExceeded.wrap(fmt.Errorf("keys %d/%d", u.Keys, q.MaxKeys))
+	}
+
+	used := u.Bytes + u.BlockBytes
+	if bytes > 0 && q.MaxBytes > 0 && used+bytes > q.MaxBytes {
+		return ErrQuotaExceeded.wrap(fmt.Errorf("bytes %d/%d", used, q.MaxBytes))
+	}
+
+	return nil
+}
+
+// setQuotas sets the default quota and those of specific namespaces
+func (ns *namespaces) setQuotas(def *Quota, quotas map[string]*Quota) {
+	ns.mu.Lock()
+	ns.defaultQuota = def
+	ns.quotas = quotas
+	ns.mu.Unlock()
+}
+
+// quota returns the quota of the namespace falling back to the default quota.
+// An empty quota is returned if neither is set or for the BloxNamespace
+func (ns *namespaces) quota(name string) *Quota {
+	if name == BloxNamespace {
+		return &Quota{}
+	}
+
+	ns.mu.RLock()
+	defer ns.mu.RUnlock()
+
+	if q, ok := ns.quotas[name]; ok && q != nil {
+		return q
+	}
+	if ns.defaultQuota != nil {
+		return ns.defaultQuota
+	}
+	return &Quota{}
+}
+
+// limited returns true if the namespace has a quota
+func (ns *namespaces) limited(name string) bool {
+	q := ns.quota(name)
+	return q.MaxKeys > 0 || q.MaxBytes > 0
+}
+
+// quotaDelta returns the usage the write to the key adds to the namespace
+// using the current pair on the cluster.  Nil is returned if the namespace has
+// no quota as its usage is not tracked
+func (ns *namespaces) quotaDelta(ctx context.Context, name string, key []byte, size func(cur *KVPair) int64) (*Usage, error) {
+	if !ns.limited(name) {
+		return nil, nil
+	}
+
+	kvs, err := ns.kvs(name)
+	if err != nil {
+		return nil, err
+	}
+
+	cur, _, err := kvs.GetContext(ctx, key, &ReadOptions{})
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		cur = nil
+	}
+
+	return usageDelta(cur, size(cur)), nil
+}
+
+// usageDelta returns the usage added by writing size bytes to a key with the
+// current pair, which is nil if it does not exist.  A negative size removes
+// the key
+func usageDelta(cur *KVPair, size int64) *Usage {
+	switch {
+	case size < 0 && cur == nil:
+		return &Usage{}
+	case size < 0:
+		return &Usage{Keys: -1, Bytes: -kvSize(cur)}
+	case cur == nil:
+		return &Usage{Keys: 1, Bytes: size}
+	}
+	return &Usage{Bytes: size - kvSize(cur)}
+}
+
+// checkQuota checks adding the usage against the quota of the namespace using
+// the cluster-wide usage.  Writes not adding usage are not checked
+func (ns *namespaces) checkQuota(ctx context.Context, name string, delta *Usage) error {
+	if delta == nil || (delta.Keys <= 0 && delta.Bytes <= 0 && delta.BlockBytes <= 0) {
+		return nil
+	}
+
+	u, err := ns.clusterUsage(ctx, name)
+	if err != nil {
+		return err
+	}
+	return ns.quota(name).check(u, delta.Keys, delta.Bytes+delta.BlockBytes)
+}
+
+// clusterUsage returns the cluster-wide usage of the namespace from the
+// reports of each node.  Keys and roots are applied on each of their replicas
+// so the sum of the reports is divided by the replication factor
+func (ns *namespaces) clusterUsage(ctx context.Context, name string) (*Usage, error) {
+	bkvs, err := ns.kvs(BloxNamespace)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := usageReportPrefix(name)
+	kvps, _, err := bkvs.ScanContext(ctx, &ScanOptions{Prefix: []byte(prefix)})
+	if err != nil {
+		return nil, err
+	}
+
+	// The log of the report key is replicated like that of any other key
+	_, peers, err := ns.wal.NewEntry(append([]byte(namespacePrefix(string(ns.kvprefix), BloxNamespace)), prefix...))
+	if err != nil {
+		return nil, err
+	}
+
+	return sumUsageReports(kvps, time.Now().Add(-usageReportTTL), len(peers)), nil
+}
+
+// sumUsageReports returns the usage of the reports made after since divided by
+// the number of replicas
+func sumUsageReports(kvps []*KVPair, since time.Time, replicas int) *Usage {
+	u := &Usage{}
+	for _, kvp := range kvps {
+		if kvp.ModTime < uint64(since.UnixNano()) {
+			continue
+		}
+
+		var r Usage
+		if err := json.Unmarshal(kvp.Value, &r); err != nil {
+			continue
+		}
+		u.addKVs(r.Keys, r.Bytes)
+		u.addBlocks(r.Blocks, r.BlockBytes)
+	}
+
+	if replicas > 1 {
+		n := int64(replicas)
+		u.Keys, u.Bytes, u.Blocks, u.BlockBytes = u.Keys/n, u.Bytes/n, u.Blocks/n, u.BlockBytes/n
+	}
+	return u
+}
+
+// reportUsage writes the usage of the local store of each namespace with a
+// quota to the blox kvs under the host.  Reports replace the previous one so a
+// lost report is corrected by the next
+func (ns *namespaces) reportUsage(host string) error {
+	bkvs, err := ns.kvs(BloxNamespace)
+	if err != nil {
+		return err
+	}
+
+	ns.mu.RLock()
+	usage := make(map[string]*Usage, len(ns.m))
+	for name, n := range ns.m {
+		usage[name] = n.fsm.Usage()
+	}
+	ns.mu.RUnlock()
+
+	for name, u := range usage {
+		if !ns.limited(name) {
+			continue
+		}
+
+		b, er := json.Marshal(u)
+		if er != nil {
+			return er
+		}
+		if _, _, er = bkvs.Set(NewKVPair([]byte(usageReportPrefix(name)+host), b), DefaultWriteOptions()); er != nil {
+			err = er
+		}
+	}
+	return err
+}
+
+// startUsageReports reports the usage of namespaces with a quota every
+// interval in the background
+func (ns *namespaces) startUsageReports(host string, interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			if err := ns.reportUsage(host); err != nil {
+				log.Printf("[ERROR] Failed to report usage error='%v'", err)
+			}
+		}
+	}()
+}
+
+// checkBlockQuota checks storing size bytes of blocks against the quota of the
+// namespace using the cluster-wide usage
+func (ns *namespaces) checkBlockQuota(ctx context.Context, name string, size int64) error {
+	if !ns.exists(name) {
+		return ErrNamespaceNotFound
+	}
+	if !ns.limited(name) {
+		return nil
+	}
+	return ns.checkQuota(ctx, name, &Usage{BlockBytes: size})
+}
+
+// applyRootUsage charges the blocks of a root registration to the usage of the
+// namespace it is registered to as the registration is applied to the blox
+// store.  prev and cur are the registration before and after, either of which
+// is nil if it does not exist.  Deleted roots are not charged so deleting a
+// file frees its quota before its blocks are collected
+func (ns *namespaces) applyRootUsage(prev, cur *KVPair) {
+	for _, c := range []struct {
+		kvp  *KVPair
+		sign int64
+	}{{prev, -1}, {cur, 1}} {
+		name, blocks, size := rootUsage(c.kvp)
+		if blocks == 0 && size == 0 {
+			continue
+		}
+
+		n, err := ns.get(name)
+		if err != nil {
+			log.Printf("[ERROR] Failed to charge root usage namespace=%q error='%v'", name, err)
+			continue
+		}
+		n.fsm.usage.addBlocks(c.sign*blocks, c.sign*size)
+	}
+}
+
+// rootUsage returns the namespace a root is registered to with the blocks and
+// bytes charged to it.  Nothing is charged for other pairs or deleted roots
+func rootUsage(kvp *KVPair) (string, int64, int64) {
+	if kvp == nil || !bytes.HasPrefix(kvp.Key, []byte(bloxRootsPrefix)) || rootDeleted(kvp) {
+		return "", 0, 0
+	}
+	kvp, err := decompressPair(kvp)
+	if err != nil {
+		return "", 0, 0
+	}
+
+	blocks, _ := strconv.ParseInt(kvp.Metadata[rootBlocksMetadata], 10, 64)
+	size, _ := strconv.ParseInt(kvp.Metadata[rootSizeMetadata], 10, 64)
+	return string(kvp.Value), blocks, size
+}
+
+// usage returns the usage of the namespace on the local node and its quota,
+// which is enforced against the cluster-wide usage reported by all nodes.
+// Namespaces are not created so ErrNotFound is returned if it does not exist
+func (ns *namespaces) usage(name string) (*NamespaceUsage, error) {
+	ns.mu.RLock()
+	n, ok := ns.m[name]
+	ns.mu.RUnlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return &NamespaceUsage{
+		Namespace: name,
+		Usage:     n.fsm.Usage(),
+		Quota:     ns.quota(name),
+	}, nil
+}
@@ -0,0 +1,155 @@
+package fidias
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_Quota_check(t *testing.T) {
+	var q *Quota
+	if err := q.check(&Usage{Keys: 100}, 1, 1); err != nil {
+		t.Fatal("nil quota should be unlimited", err)
+	}
+
+	q = &Quota{MaxKeys: 2, MaxBytes: 10}
+	u := &Usage{Keys: 2, Bytes: 4, BlockBytes: 4}
+
+	if err := q.check(u, 1, 0); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatal("should exceed keys", err)
+	}
+	if err := q.check(u, 0, 3); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatal("should exceed bytes", err)
+	}
+	if err := q.check(u, 0, 2); err != nil {
+		t.Fatal(err)
+	}
+	// Reductions are always allowed
+	if err := q.check(&Usage{Keys: 5, Bytes: 20}, -1, -5); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_usageDelta(t *testing.T) {
+	cur := NewKVPair([]byte("key"), []byte("value"))
+
+	// Updating an existing key does not add a key
+	if u := usageDelta(cur, 4); u.Keys != 0 || u.Bytes != 4-kvSize(cur) {
+		t.Fatal("wrong update delta", u)
+	}
+	if u := usageDelta(nil, 4); u.Keys != 1 || u.Bytes != 4 {
+		t.Fatal("wrong create delta", u)
+	}
+	if u := usageDelta(cur, -1); u.Keys != -1 || u.Bytes != -kvSize(cur) {
+		t.Fatal("wrong remove delta", u)
+	}
+	if u := usageDelta(nil, -1); u.Keys != 0 || u.Bytes != 0 {
+		t.Fatal("removing a missing key should not change usage", u)
+	}
+}
+
+func Test_sumUsageReports(t *testing.T) {
+	prefix := usageReportPrefix(DefaultNamespace)
+	if prefix != bloxUsagePrefix+":default/" {
+		t.Fatal("wrong default prefix", prefix)
+	}
+
+	now := time.Now()
+	report := func(host string, u *Usage, at time.Time) *KVPair {
+		b, _ := json.Marshal(u)
+		kvp := NewKVPair([]byte(prefix+host), b)
+		kvp.ModTime = uint64(at.UnixNano())
+		return kvp
+	}
+	kvps := []*KVPair{
+		report("a", &Usage{Keys: 2, Bytes: 10, Blocks: 3, BlockBytes: 4}, now),
+		report("b", &Usage{Keys: 2, Bytes: 10, Blocks: 3, BlockBytes: 4}, now),
+		report("c", &Usage{Keys: 100}, now.Add(-2*usageReportTTL)),
+		NewKVPair([]byte(prefix+"d"), []byte("invalid")),
+	}
+
+	u := sumUsageReports(kvps, now.Add(-usageReportTTL), 2)
+	if u.Keys != 2 || u.Bytes != 10 || u.Blocks != 3 || u.BlockBytes != 4 {
+		t.Fatal("wrong usage", u)
+	}
+}
+
+func Test_namespaces_checkQuota(t *testing.T) {
+	ns := newNamespaces("kv/", nil)
+	ns.setQuotas(&Quota{MaxKeys: 1}, map[string]*Quota{"big": {MaxBytes: 8}})
+	ns.setNamespaces("", map[string]*ACL{"small": nil, "big": nil})
+
+	if !ns.limited("small") || !ns.limited("big") || ns.limited(BloxNamespace) {
+		t.Fatal("wrong limited namespaces")
+	}
+
+	// Writes not adding usage do not read the cluster usage
+	if err := ns.checkQuota(context.Background(), "small", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ns.checkQuota(context.Background(), "small", &Usage{Keys: -1, Bytes: -5}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ns.checkBlockQuota(context.Background(), BloxNamespace, 1<<30); err != nil {
+		t.Fatal(err)
+	}
+	if err := ns.checkBlockQuota(context.Background(), "missing", 1); err != ErrNamespaceNotFound {
+		t.Fatal("should fail with missing namespace", err)
+	}
+
+	if _, err := ns.usage("missing"); err != ErrNotFound {
+		t.Fatal("should not create on usage", err)
+	}
+}
+
+func Test_namespaces_rootUsage(t *testing.T) {
+	ns := newTestNamespaces()
+	ns.setQuotas(nil, map[string]*Quota{"big": {MaxBytes: 10}})
+	ns.setNamespaces("", map[string]*ACL{"big": nil})
+
+	ctx := context.Background()
+	id := bytes.Repeat([]byte{0xab}, 32)
+	checkUsage := func(blocks, size int64) {
+		t.Helper()
+		usage, err := ns.usage("big")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if usage.Usage.Blocks != blocks || usage.Usage.BlockBytes != size {
+			t.Fatal("wrong usage", usage.Usage)
+		}
+	}
+
+	// Registering the same root again does not charge it twice
+	for i := 0; i < 2; i++ {
+		if _, err := ns.registerRoot(ctx, "big", &RootRequest{ID: id, Size: 6, Blocks: 2}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkUsage(2, 6)
+
+	if err := ns.reportUsage("host"); err != nil {
+		t.Fatal(err)
+	}
+	other := bytes.Repeat([]byte{0xcd}, 32)
+	if _, err := ns.registerRoot(ctx, "big", &RootRequest{ID: other, Size: 6, Blocks: 2}); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatal("should exceed quota", err)
+	}
+	if _, err := ns.beginUpload(ctx, "big", "00000000000000000000000000000000", 6); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatal("should exceed quota", err)
+	}
+	checkUsage(2, 6)
+
+	// Deleting the root frees its usage
+	bkvs, err := ns.kvs(BloxNamespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = DeleteRoot(bkvs, id); err != nil {
+		t.Fatal(err)
+	}
+	checkUsage(0, 0)
+}
@@ -15,6 +15,20 @@ It has these top-level messages:
 	WriteRequest
 	Request
 	WriteResponse
+	IncrRequest
+	Quota
+	Usage
+	NamespaceUsage
+	Index
+	IndexQuery
+	IndexResult
+	ScanOptions
+	Shard
+	ShardMap
+	MerkleRequest
+	MerkleResponse
+	RootRequest
+	UploadRequest
 */
 package fidias
 
@@ -56,6 +70,16 @@ type KVPair struct {
 	Modification []byte `protobuf:"bytes,6,opt,name=Modification,proto3" json:"Modification,omitempty"`
 	// Entry height creating this view
 	Height uint32 `protobuf:"varint,7,opt,name=Height" json:"Height,omitempty"`
+	// Content type of the value e.g. application/json
+	ContentType string `protobuf:"bytes,8,opt,name=ContentType" json:"ContentType,omitempty"`
+	// Permission bits of a directory
+	Mode uint32 `protobuf:"varint,9,opt,name=Mode" json:"Mode,omitempty"`
+	// Owner of a directory
+	Owner string `protobuf:"bytes,10,opt,name=Owner" json:"Owner,omitempty"`
+	// Arbitrary user metadata
+	Metadata map[string]string `protobuf:"bytes,11,rep,name=Metadata" json:"Metadata,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// Codec the value is compressed with.  Zero is uncompressed
+	Compression int32 `protobuf:"varint,12,opt,name=Compression" json:"Compression,omitempty"`
 }
 
 func (m *KVPair) Reset()                    { *m = KVPair{} }
@@ -112,6 +136,41 @@ func (m *KVPair) GetHeight() uint32 {
 	return 0
 }
 
+func (m *KVPair) GetContentType() string {
+	if m != nil {
+		return m.ContentType
+	}
+	return ""
+}
+
+func (m *KVPair) GetMode() uint32 {
+	if m != nil {
+		return m.Mode
+	}
+	return 0
+}
+
+func (m *KVPair) GetOwner() string {
+	if m != nil {
+		return m.Owner
+	}
+	return ""
+}
+
+func (m *KVPair) GetMetadata() map[string]string {
+	if m != nil {
+		return m.Metadata
+	}
+	return nil
+}
+
+func (m *KVPair) GetCompression() int32 {
+	if m != nil {
+		return m.Compression
+	}
+	return 0
+}
+
 type ReadStats struct {
 	// Node serving the read
 	Nodes []*hexatype.Node `protobuf:"bytes,1,rep,name=Nodes" json:"Nodes,omitempty"`
@@ -293,6 +352,564 @@ func (m *WriteResponse) GetStats() *WriteStats {
 	return nil
 }
 
+// Request to atomically add a delta to an integer key
+type IncrRequest struct {
+	Key     []byte        `protobuf:"bytes,1,opt,name=Key,proto3" json:"Key,omitempty"`
+	Delta   int64         `protobuf:"varint,2,opt,name=Delta" json:"Delta,omitempty"`
+	Options *WriteOptions `protobuf:"bytes,3,opt,name=Options" json:"Options,omitempty"`
+}
+
+func (m *IncrRequest) Reset()                    { *m = IncrRequest{} }
+func (m *IncrRequest) String() string            { return proto.CompactTextString(m) }
+func (*IncrRequest) ProtoMessage()               {}
+func (*IncrRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
+
+func (m *IncrRequest) GetKey() []byte {
+	if m != nil {
+		return m.Key
+	}
+	return nil
+}
+
+func (m *IncrRequest) GetDelta() int64 {
+	if m != nil {
+		return m.Delta
+	}
+	return 0
+}
+
+func (m *IncrRequest) GetOptions() *WriteOptions {
+	if m != nil {
+		return m.Options
+	}
+	return nil
+}
+
+// Storage limits of a namespace.  Zero values are unlimited
+type Quota struct {
+	MaxKeys  int64 `protobuf:"varint,1,opt,name=MaxKeys" json:"MaxKeys,omitempty"`
+	MaxBytes int64 `protobuf:"varint,2,opt,name=MaxBytes" json:"MaxBytes,omitempty"`
+}
+
+func (m *Quota) Reset()                    { *m = Quota{} }
+func (m *Quota) String() string            { return proto.CompactTextString(m) }
+func (*Quota) ProtoMessage()               {}
+func (*Quota) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{8} }
+
+func (m *Quota) GetMaxKeys() int64 {
+	if m != nil {
+		return m.MaxKeys
+	}
+	return 0
+}
+
+func (m *Quota) GetMaxBytes() int64 {
+	if m != nil {
+		return m.MaxBytes
+	}
+	return 0
+}
+
+// Storage used by a namespace
+type Usage struct {
+	Keys       int64 `protobuf:"varint,1,opt,name=Keys" json:"Keys,omitempty"`
+	Bytes      int64 `protobuf:"varint,2,opt,name=Bytes" json:"Bytes,omitempty"`
+	Blocks     int64 `protobuf:"varint,3,opt,name=Blocks" json:"Blocks,omitempty"`
+	BlockBytes int64 `protobuf:"varint,4,opt,name=BlockBytes" json:"BlockBytes,omitempty"`
+}
+
+func (m *Usage) Reset()                    { *m = Usage{} }
+func (m *Usage) String() string            { return proto.CompactTextString(m) }
+func (*Usage) ProtoMessage()               {}
+func (*Usage) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{9} }
+
+func (m *Usage) GetKeys() int64 {
+	if m != nil {
+		return m.Keys
+	}
+	return 0
+}
+
+func (m *Usage) GetBytes() int64 {
+	if m != nil {
+		return m.Bytes
+	}
+	return 0
+}
+
+func (m *Usage) GetBlocks() int64 {
+	if m != nil {
+		return m.Blocks
+	}
+	return 0
+}
+
+func (m *Usage) GetBlockBytes() int64 {
+	if m != nil {
+		return m.BlockBytes
+	}
+	return 0
+}
+
+// Usage and quota of a namespace on a node
+type NamespaceUsage struct {
+	Namespace string `protobuf:"bytes,1,opt,name=Namespace" json:"Namespace,omitempty"`
+	Usage     *Usage `protobuf:"bytes,2,opt,name=Usage" json:"Usage,omitempty"`
+	Quota     *Quota `protobuf:"bytes,3,opt,name=Quota" json:"Quota,omitempty"`
+}
+
+func (m *NamespaceUsage) Reset()                    { *m = NamespaceUsage{} }
+func (m *NamespaceUsage) String() string            { return proto.CompactTextString(m) }
+func (*NamespaceUsage) ProtoMessage()               {}
+func (*NamespaceUsage) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{10} }
+
+func (m *NamespaceUsage) GetNamespace() string {
+	if m != nil {
+		return m.Namespace
+	}
+	return ""
+}
+
+func (m *NamespaceUsage) GetUsage() *Usage {
+	if m != nil {
+		return m.Usage
+	}
+	return nil
+}
+
+func (m *NamespaceUsage) GetQuota() *Quota {
+	if m != nil {
+		return m.Quota
+	}
+	return nil
+}
+
+// Secondary index on a field of the json values of keys under a prefix.
+// Path is the dot separated path to the field e.g. user.name
+type Index struct {
+	Name   string `protobuf:"bytes,1,opt,name=Name" json:"Name,omitempty"`
+	Prefix []byte `protobuf:"bytes,2,opt,name=Prefix,proto3" json:"Prefix,omitempty"`
+	Path   string `protobuf:"bytes,3,opt,name=Path" json:"Path,omitempty"`
+}
+
+func (m *Index) Reset()                    { *m = Index{} }
+func (m *Index) String() string            { return proto.CompactTextString(m) }
+func (*Index) ProtoMessage()               {}
+func (*Index) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{11} }
+
+func (m *Index) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *Index) GetPrefix() []byte {
+	if m != nil {
+		return m.Prefix
+	}
+	return nil
+}
+
+func (m *Index) GetPath() string {
+	if m != nil {
+		return m.Path
+	}
+	return ""
+}
+
+// Query on a secondary index.  Values are json encoded.  Value matches a
+// single value otherwise keys with values from Start up to but not including End
+// are matched.  Empty bounds are unbounded
+type IndexQuery struct {
+	Index string `protobuf:"bytes,1,opt,name=Index" json:"Index,omitempty"`
+	Value []byte `protobuf:"bytes,2,opt,name=Value,proto3" json:"Value,omitempty"`
+	Start []byte `protobuf:"bytes,3,opt,name=Start,proto3" json:"Start,omitempty"`
+	End   []byte `protobuf:"bytes,4,opt,name=End,proto3" json:"End,omitempty"`
+	Limit int32  `protobuf:"varint,5,opt,name=Limit" json:"Limit,omitempty"`
+}
+
+func (m *IndexQuery) Reset()                    { *m = IndexQuery{} }
+func (m *IndexQuery) String() string            { return proto.CompactTextString(m) }
+func (*IndexQuery) ProtoMessage()               {}
+func (*IndexQuery) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{12} }
+
+func (m *IndexQuery) GetIndex() string {
+	if m != nil {
+		return m.Index
+	}
+	return ""
+}
+
+func (m *IndexQuery) GetValue() []byte {
+	if m != nil {
+		return m.Value
+	}
+	return nil
+}
+
+func (m *IndexQuery) GetStart() []byte {
+	if m != nil {
+		return m.Start
+	}
+	return nil
+}
+
+func (m *IndexQuery) GetEnd() []byte {
+	if m != nil {
+		return m.End
+	}
+	return nil
+}
+
+func (m *IndexQuery) GetLimit() int32 {
+	if m != nil {
+		return m.Limit
+	}
+	return 0
+}
+
+// Result of an index query
+type IndexResult struct {
+	KVs   []*KVPair  `protobuf:"bytes,1,rep,name=KVs" json:"KVs,omitempty"`
+	Stats *ReadStats `protobuf:"bytes,2,opt,name=Stats" json:"Stats,omitempty"`
+}
+
+func (m *IndexResult) Reset()                    { *m = IndexResult{} }
+func (m *IndexResult) String() string            { return proto.CompactTextString(m) }
+func (*IndexResult) ProtoMessage()               {}
+func (*IndexResult) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{13} }
+
+func (m *IndexResult) GetKVs() []*KVPair {
+	if m != nil {
+		return m.KVs
+	}
+	return nil
+}
+
+func (m *IndexResult) GetStats() *ReadStats {
+	if m != nil {
+		return m.Stats
+	}
+	return nil
+}
+
+// Options for a range scan.  Keys with the prefix from Start up to but not
+// including End are scanned.  Empty bounds are unbounded
+type ScanOptions struct {
+	Prefix   []byte `protobuf:"bytes,1,opt,name=Prefix,proto3" json:"Prefix,omitempty"`
+	Start    []byte `protobuf:"bytes,2,opt,name=Start,proto3" json:"Start,omitempty"`
+	End      []byte `protobuf:"bytes,3,opt,name=End,proto3" json:"End,omitempty"`
+	Reverse  bool   `protobuf:"varint,4,opt,name=Reverse" json:"Reverse,omitempty"`
+	Limit    int32  `protobuf:"varint,5,opt,name=Limit" json:"Limit,omitempty"`
+	KeysOnly bool   `protobuf:"varint,6,opt,name=KeysOnly" json:"KeysOnly,omitempty"`
+}
+
+func (m *ScanOptions) Reset()                    { *m = ScanOptions{} }
+func (m *ScanOptions) String() string            { return proto.CompactTextString(m) }
+func (*ScanOptions) ProtoMessage()               {}
+func (*ScanOptions) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{14} }
+
+func (m *ScanOptions) GetPrefix() []byte {
+	if m != nil {
+		return m.Prefix
+	}
+	return nil
+}
+
+func (m *ScanOptions) GetStart() []byte {
+	if m != nil {
+		return m.Start
+	}
+	return nil
+}
+
+func (m *ScanOptions) GetEnd() []byte {
+	if m != nil {
+		return m.End
+	}
+	return nil
+}
+
+func (m *ScanOptions) GetReverse() bool {
+	if m != nil {
+		return m.Reverse
+	}
+	return false
+}
+
+func (m *ScanOptions) GetLimit() int32 {
+	if m != nil {
+		return m.Limit
+	}
+	return 0
+}
+
+func (m *ScanOptions) GetKeysOnly() bool {
+	if m != nil {
+		return m.KeysOnly
+	}
+	return false
+}
+
+// A single erasure coded shard of a block and the host storing it
+type Shard struct {
+	ID   []byte `protobuf:"bytes,1,opt,name=ID,proto3" json:"ID,omitempty"`
+	Host string `protobuf:"bytes,2,opt,name=Host" json:"Host,omitempty"`
+}
+
+func (m *Shard) Reset()                    { *m = Shard{} }
+func (m *Shard) String() string            { return proto.CompactTextString(m) }
+func (*Shard) ProtoMessage()               {}
+func (*Shard) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{15} }
+
+func (m *Shard) GetID() []byte {
+	if m != nil {
+		return m.ID
+	}
+	return nil
+}
+
+func (m *Shard) GetHost() string {
+	if m != nil {
+		return m.Host
+	}
+	return ""
+}
+
+// Data and parity shards of an erasure coded block
+type ShardMap struct {
+	ID           []byte   `protobuf:"bytes,1,opt,name=ID,proto3" json:"ID,omitempty"`
+	Type         int32    `protobuf:"varint,2,opt,name=Type" json:"Type,omitempty"`
+	Size         uint64   `protobuf:"varint,3,opt,name=Size" json:"Size,omitempty"`
+	DataShards   int32    `protobuf:"varint,4,opt,name=DataShards" json:"DataShards,omitempty"`
+	ParityShards int32    `protobuf:"varint,5,opt,name=ParityShards" json:"ParityShards,omitempty"`
+	Shards       []*Shard `protobuf:"bytes,6,rep,name=Shards" json:"Shards,omitempty"`
+}
+
+func (m *ShardMap) Reset()                    { *m = ShardMap{} }
+func (m *ShardMap) String() string            { return proto.CompactTextString(m) }
+func (*ShardMap) ProtoMessage()               {}
+func (*ShardMap) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{16} }
+
+func (m *ShardMap) GetID() []byte {
+	if m != nil {
+		return m.ID
+	}
+	return nil
+}
+
+func (m *ShardMap) GetType() int32 {
+	if m != nil {
+		return m.Type
+	}
+	return 0
+}
+
+func (m *ShardMap) GetSize() uint64 {
+	if m != nil {
+		return m.Size
+	}
+	return 0
+}
+
+func (m *ShardMap) GetDataShards() int32 {
+	if m != nil {
+		return m.DataShards
+	}
+	return 0
+}
+
+func (m *ShardMap) GetParityShards() int32 {
+	if m != nil {
+		return m.ParityShards
+	}
+	return 0
+}
+
+func (m *ShardMap) GetShards() []*Shard {
+	if m != nil {
+		return m.Shards
+	}
+	return nil
+}
+
+// Request for the nodes at a level of the merkle tree of a replica set of the
+// local store of a namespace.  Level 0 is the root
+type MerkleRequest struct {
+	Level int32   `protobuf:"varint,1,opt,name=Level" json:"Level,omitempty"`
+	Nodes []int32 `protobuf:"varint,2,rep,packed,name=Nodes" json:"Nodes,omitempty"`
+	// Sorted comma separated hosts of the replica set
+	Set string `protobuf:"bytes,3,opt,name=Set" json:"Set,omitempty"`
+}
+
+func (m *MerkleRequest) Reset()                    { *m = MerkleRequest{} }
+func (m *MerkleRequest) String() string            { return proto.CompactTextString(m) }
+func (*MerkleRequest) ProtoMessage()               {}
+func (*MerkleRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{17} }
+
+func (m *MerkleRequest) GetLevel() int32 {
+	if m != nil {
+		return m.Level
+	}
+	return 0
+}
+
+func (m *MerkleRequest) GetNodes() []int32 {
+	if m != nil {
+		return m.Nodes
+	}
+	return nil
+}
+
+func (m *MerkleRequest) GetSet() string {
+	if m != nil {
+		return m.Set
+	}
+	return ""
+}
+
+// Hashes of the requested merkle tree nodes in request order
+type MerkleResponse struct {
+	Hashes [][]byte `protobuf:"bytes,1,rep,name=Hashes,proto3" json:"Hashes,omitempty"`
+}
+
+func (m *MerkleResponse) Reset()                    { *m = MerkleResponse{} }
+func (m *MerkleResponse) String() string            { return proto.CompactTextString(m) }
+func (*MerkleResponse) ProtoMessage()               {}
+func (*MerkleResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{18} }
+
+func (m *MerkleResponse) GetHashes() [][]byte {
+	if m != nil {
+		return m.Hashes
+	}
+	return nil
+}
+
+// Registration of an uploaded root to the namespace of the request along with
+// the de-duplication stats of the upload
+type RootRequest struct {
+	ID []byte `protobuf:"bytes,1,opt,name=ID,proto3" json:"ID,omitempty"`
+	// Kind of root.  Files have none
+	Kind string `protobuf:"bytes,2,opt,name=Kind" json:"Kind,omitempty"`
+	// Codec the file was compressed with.  Uncompressed files have none
+	Compression string `protobuf:"bytes,3,opt,name=Compression" json:"Compression,omitempty"`
+	// De-duplication stats of the blocks written by the upload
+	DedupeBlocks    int64 `protobuf:"varint,4,opt,name=DedupeBlocks" json:"DedupeBlocks,omitempty"`
+	DuplicateBlocks int64 `protobuf:"varint,5,opt,name=DuplicateBlocks" json:"DuplicateBlocks,omitempty"`
+	LogicalBytes    int64 `protobuf:"varint,6,opt,name=LogicalBytes" json:"LogicalBytes,omitempty"`
+	PhysicalBytes   int64 `protobuf:"varint,7,opt,name=PhysicalBytes" json:"PhysicalBytes,omitempty"`
+	// Upload whose marker is cleared by the registration.  Optional
+	Upload string `protobuf:"bytes,8,opt,name=Upload" json:"Upload,omitempty"`
+	// Stored file size in bytes and number of blocks including the root
+	// charged to the quota of the namespace
+	Size   int64 `protobuf:"varint,9,opt,name=Size" json:"Size,omitempty"`
+	Blocks int64 `protobuf:"varint,10,opt,name=Blocks" json:"Blocks,omitempty"`
+}
+
+func (m *RootRequest) Reset()                    { *m = RootRequest{} }
+func (m *RootRequest) String() string            { return proto.CompactTextString(m) }
+func (*RootRequest) ProtoMessage()               {}
+func (*RootRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{19} }
+
+func (m *RootRequest) GetID() []byte {
+	if m != nil {
+		return m.ID
+	}
+	return nil
+}
+
+func (m *RootRequest) GetKind() string {
+	if m != nil {
+		return m.Kind
+	}
+	return ""
+}
+
+func (m *RootRequest) GetCompression() string {
+	if m != nil {
+		return m.Compression
+	}
+	return ""
+}
+
+func (m *RootRequest) GetDedupeBlocks() int64 {
+	if m != nil {
+		return m.DedupeBlocks
+	}
+	return 0
+}
+
+func (m *RootRequest) GetDuplicateBlocks() int64 {
+	if m != nil {
+		return m.DuplicateBlocks
+	}
+	return 0
+}
+
+func (m *RootRequest) GetLogicalBytes() int64 {
+	if m != nil {
+		return m.LogicalBytes
+	}
+	return 0
+}
+
+func (m *RootRequest) GetPhysicalBytes() int64 {
+	if m != nil {
+		return m.PhysicalBytes
+	}
+	return 0
+}
+
+func (m *RootRequest) GetUpload() string {
+	if m != nil {
+		return m.Upload
+	}
+	return ""
+}
+
+func (m *RootRequest) GetSize() int64 {
+	if m != nil {
+		return m.Size
+	}
+	return 0
+}
+
+func (m *RootRequest) GetBlocks() int64 {
+	if m != nil {
+		return m.Blocks
+	}
+	return 0
+}
+
+// Upload in progress whose blocks are protected from garbage collection
+type UploadRequest struct {
+	// Random id of the upload
+	ID string `protobuf:"bytes,1,opt,name=ID" json:"ID,omitempty"`
+	// Expected upload size in bytes checked against the quota of the
+	// namespace.  Zero if unknown
+	Size int64 `protobuf:"varint,2,opt,name=Size" json:"Size,omitempty"`
+}
+
+func (m *UploadRequest) Reset()                    { *m = UploadRequest{} }
+func (m *UploadRequest) String() string            { return proto.CompactTextString(m) }
+func (*UploadRequest) ProtoMessage()               {}
+func (*UploadRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{20} }
+
+func (m *UploadRequest) GetID() string {
+	if m != nil {
+		return m.ID
+	}
+	return ""
+}
+
+func (m *UploadRequest) GetSize() int64 {
+	if m != nil {
+		return m.Size
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterType((*KVPair)(nil), "fidias.KVPair")
 	proto.RegisterType((*ReadStats)(nil), "fidias.ReadStats")
@@ -301,6 +918,20 @@ func init() {
 	proto.RegisterType((*WriteRequest)(nil), "fidias.WriteRequest")
 	proto.RegisterType((*Request)(nil), "fidias.Request")
 	proto.RegisterType((*WriteResponse)(nil), "fidias.WriteResponse")
+	proto.RegisterType((*IncrRequest)(nil), "fidias.IncrRequest")
+	proto.RegisterType((*Quota)(nil), "fidias.Quota")
+	proto.RegisterType((*Usage)(nil), "fidias.Usage")
+	proto.RegisterType((*NamespaceUsage)(nil), "fidias.NamespaceUsage")
+	proto.RegisterType((*Index)(nil), "fidias.Index")
+	proto.RegisterType((*IndexQuery)(nil), "fidias.IndexQuery")
+	proto.RegisterType((*IndexResult)(nil), "fidias.IndexResult")
+	proto.RegisterType((*ScanOptions)(nil), "fidias.ScanOptions")
+	proto.RegisterType((*Shard)(nil), "fidias.Shard")
+	proto.RegisterType((*ShardMap)(nil), "fidias.ShardMap")
+	proto.RegisterType((*MerkleRequest)(nil), "fidias.MerkleRequest")
+	proto.RegisterType((*MerkleResponse)(nil), "fidias.MerkleResponse")
+	proto.RegisterType((*RootRequest)(nil), "fidias.RootRequest")
+	proto.RegisterType((*UploadRequest)(nil), "fidias.UploadRequest")
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -328,40 +959,186 @@ type FidiasRPCClient interface {
 	RemoveRPC(ctx context.Context, in *WriteRequest, opts ...grpc.CallOption) (*WriteResponse, error)
 	// Remove key on cluster
 	CARemoveRPC(ctx context.Context, in *WriteRequest, opts ...grpc.CallOption) (*WriteResponse, error)
+	// Add a delta to an integer key on cluster
+	IncrRPC(ctx context.Context, in *IncrRequest, opts ...grpc.CallOption) (*WriteResponse, error)
+	// Append data to a key value on cluster
+	AppendRPC(ctx context.Context, in *WriteRequest, opts ...grpc.CallOption) (*WriteResponse, error)
+	// Create a directory on cluster
+	MkdirRPC(ctx context.Context, in *WriteRequest, opts ...grpc.CallOption) (*WriteResponse, error)
+	// Returns the usage of the namespace on the node
+	UsageRPC(ctx context.Context, in *Request, opts ...grpc.CallOption) (*NamespaceUsage, error)
+	// Query a secondary index on cluster
+	QueryIndexRPC(ctx context.Context, in *IndexQuery, opts ...grpc.CallOption) (*IndexResult, error)
+	// Lookup a secondary index on a single remote
+	LookupIndexRPC(ctx context.Context, in *IndexQuery, opts ...grpc.CallOption) (*IndexResult, error)
+	// Scan a key range from a single remote
+	ScanRPC(ctx context.Context, in *ScanOptions, opts ...grpc.CallOption) (FidiasRPC_ScanRPCClient, error)
+	// Returns hashes of the merkle tree of a namespace on a single remote
+	MerkleRPC(ctx context.Context, in *MerkleRequest, opts ...grpc.CallOption) (*MerkleResponse, error)
+	// Returns the key digests of merkle tree leaves from a single remote
+	MerkleLeavesRPC(ctx context.Context, in *MerkleRequest, opts ...grpc.CallOption) (FidiasRPC_MerkleLeavesRPCClient, error)
+	// Register an uploaded root on cluster
+	RegisterRootRPC(ctx context.Context, in *RootRequest, opts ...grpc.CallOption) (*WriteResponse, error)
+	// Mark an upload in progress before writing its blocks
+	BeginUploadRPC(ctx context.Context, in *UploadRequest, opts ...grpc.CallOption) (*WriteResponse, error)
+	// Clear the marker of a failed or aborted upload
+	EndUploadRPC(ctx context.Context, in *UploadRequest, opts ...grpc.CallOption) (*WriteResponse, error)
+}
+
+type fidiasRPCClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewFidiasRPCClient(cc *grpc.ClientConn) FidiasRPCClient {
+	return &fidiasRPCClient{cc}
+}
+
+func (c *fidiasRPCClient) LocalNodeRPC(ctx context.Context, in *Request, opts ...grpc.CallOption) (*hexatype.Node, error) {
+	out := new(hexatype.Node)
+	err := grpc.Invoke(ctx, "/fidias.FidiasRPC/LocalNodeRPC", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fidiasRPCClient) GetKeyRPC(ctx context.Context, in *KVPair, opts ...grpc.CallOption) (*KVPair, error) {
+	out := new(KVPair)
+	err := grpc.Invoke(ctx, "/fidias.FidiasRPC/GetKeyRPC", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fidiasRPCClient) ListDirRPC(ctx context.Context, in *KVPair, opts ...grpc.CallOption) (FidiasRPC_ListDirRPCClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_FidiasRPC_serviceDesc.Streams[0], c.cc, "/fidias.FidiasRPC/ListDirRPC", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fidiasRPCListDirRPCClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type FidiasRPC_ListDirRPCClient interface {
+	Recv() (*KVPair, error)
+	grpc.ClientStream
+}
+
+type fidiasRPCListDirRPCClient struct {
+	grpc.ClientStream
+}
+
+func (x *fidiasRPCListDirRPCClient) Recv() (*KVPair, error) {
+	m := new(KVPair)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *fidiasRPCClient) SetRPC(ctx context.Context, in *WriteRequest, opts ...grpc.CallOption) (*WriteResponse, error) {
+	out := new(WriteResponse)
+	err := grpc.Invoke(ctx, "/fidias.FidiasRPC/SetRPC", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fidiasRPCClient) CASetRPC(ctx context.Context, in *WriteRequest, opts ...grpc.CallOption) (*WriteResponse, error) {
+	out := new(WriteResponse)
+	err := grpc.Invoke(ctx, "/fidias.FidiasRPC/CASetRPC", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fidiasRPCClient) RemoveRPC(ctx context.Context, in *WriteRequest, opts ...grpc.CallOption) (*WriteResponse, error) {
+	out := new(WriteResponse)
+	err := grpc.Invoke(ctx, "/fidias.FidiasRPC/RemoveRPC", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fidiasRPCClient) CARemoveRPC(ctx context.Context, in *WriteRequest, opts ...grpc.CallOption) (*WriteResponse, error) {
+	out := new(WriteResponse)
+	err := grpc.Invoke(ctx, "/fidias.FidiasRPC/CARemoveRPC", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fidiasRPCClient) IncrRPC(ctx context.Context, in *IncrRequest, opts ...grpc.CallOption) (*WriteResponse, error) {
+	out := new(WriteResponse)
+	err := grpc.Invoke(ctx, "/fidias.FidiasRPC/IncrRPC", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
 }
 
-type fidiasRPCClient struct {
-	cc *grpc.ClientConn
+func (c *fidiasRPCClient) AppendRPC(ctx context.Context, in *WriteRequest, opts ...grpc.CallOption) (*WriteResponse, error) {
+	out := new(WriteResponse)
+	err := grpc.Invoke(ctx, "/fidias.FidiasRPC/AppendRPC", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
 }
 
-func NewFidiasRPCClient(cc *grpc.ClientConn) FidiasRPCClient {
-	return &fidiasRPCClient{cc}
+func (c *fidiasRPCClient) MkdirRPC(ctx context.Context, in *WriteRequest, opts ...grpc.CallOption) (*WriteResponse, error) {
+	out := new(WriteResponse)
+	err := grpc.Invoke(ctx, "/fidias.FidiasRPC/MkdirRPC", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
 }
 
-func (c *fidiasRPCClient) LocalNodeRPC(ctx context.Context, in *Request, opts ...grpc.CallOption) (*hexatype.Node, error) {
-	out := new(hexatype.Node)
-	err := grpc.Invoke(ctx, "/fidias.FidiasRPC/LocalNodeRPC", in, out, c.cc, opts...)
+func (c *fidiasRPCClient) UsageRPC(ctx context.Context, in *Request, opts ...grpc.CallOption) (*NamespaceUsage, error) {
+	out := new(NamespaceUsage)
+	err := grpc.Invoke(ctx, "/fidias.FidiasRPC/UsageRPC", in, out, c.cc, opts...)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-func (c *fidiasRPCClient) GetKeyRPC(ctx context.Context, in *KVPair, opts ...grpc.CallOption) (*KVPair, error) {
-	out := new(KVPair)
-	err := grpc.Invoke(ctx, "/fidias.FidiasRPC/GetKeyRPC", in, out, c.cc, opts...)
+func (c *fidiasRPCClient) QueryIndexRPC(ctx context.Context, in *IndexQuery, opts ...grpc.CallOption) (*IndexResult, error) {
+	out := new(IndexResult)
+	err := grpc.Invoke(ctx, "/fidias.FidiasRPC/QueryIndexRPC", in, out, c.cc, opts...)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-func (c *fidiasRPCClient) ListDirRPC(ctx context.Context, in *KVPair, opts ...grpc.CallOption) (FidiasRPC_ListDirRPCClient, error) {
-	stream, err := grpc.NewClientStream(ctx, &_FidiasRPC_serviceDesc.Streams[0], c.cc, "/fidias.FidiasRPC/ListDirRPC", opts...)
+func (c *fidiasRPCClient) LookupIndexRPC(ctx context.Context, in *IndexQuery, opts ...grpc.CallOption) (*IndexResult, error) {
+	out := new(IndexResult)
+	err := grpc.Invoke(ctx, "/fidias.FidiasRPC/LookupIndexRPC", in, out, c.cc, opts...)
 	if err != nil {
 		return nil, err
 	}
-	x := &fidiasRPCListDirRPCClient{stream}
+	return out, nil
+}
+
+func (c *fidiasRPCClient) ScanRPC(ctx context.Context, in *ScanOptions, opts ...grpc.CallOption) (FidiasRPC_ScanRPCClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_FidiasRPC_serviceDesc.Streams[1], c.cc, "/fidias.FidiasRPC/ScanRPC", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fidiasRPCScanRPCClient{stream}
 	if err := x.ClientStream.SendMsg(in); err != nil {
 		return nil, err
 	}
@@ -371,16 +1148,16 @@ func (c *fidiasRPCClient) ListDirRPC(ctx context.Context, in *KVPair, opts ...gr
 	return x, nil
 }
 
-type FidiasRPC_ListDirRPCClient interface {
+type FidiasRPC_ScanRPCClient interface {
 	Recv() (*KVPair, error)
 	grpc.ClientStream
 }
 
-type fidiasRPCListDirRPCClient struct {
+type fidiasRPCScanRPCClient struct {
 	grpc.ClientStream
 }
 
-func (x *fidiasRPCListDirRPCClient) Recv() (*KVPair, error) {
+func (x *fidiasRPCScanRPCClient) Recv() (*KVPair, error) {
 	m := new(KVPair)
 	if err := x.ClientStream.RecvMsg(m); err != nil {
 		return nil, err
@@ -388,36 +1165,68 @@ func (x *fidiasRPCListDirRPCClient) Recv() (*KVPair, error) {
 	return m, nil
 }
 
-func (c *fidiasRPCClient) SetRPC(ctx context.Context, in *WriteRequest, opts ...grpc.CallOption) (*WriteResponse, error) {
-	out := new(WriteResponse)
-	err := grpc.Invoke(ctx, "/fidias.FidiasRPC/SetRPC", in, out, c.cc, opts...)
+func (c *fidiasRPCClient) MerkleRPC(ctx context.Context, in *MerkleRequest, opts ...grpc.CallOption) (*MerkleResponse, error) {
+	out := new(MerkleResponse)
+	err := grpc.Invoke(ctx, "/fidias.FidiasRPC/MerkleRPC", in, out, c.cc, opts...)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-func (c *fidiasRPCClient) CASetRPC(ctx context.Context, in *WriteRequest, opts ...grpc.CallOption) (*WriteResponse, error) {
+func (c *fidiasRPCClient) MerkleLeavesRPC(ctx context.Context, in *MerkleRequest, opts ...grpc.CallOption) (FidiasRPC_MerkleLeavesRPCClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_FidiasRPC_serviceDesc.Streams[2], c.cc, "/fidias.FidiasRPC/MerkleLeavesRPC", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fidiasRPCMerkleLeavesRPCClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type FidiasRPC_MerkleLeavesRPCClient interface {
+	Recv() (*KVPair, error)
+	grpc.ClientStream
+}
+
+type fidiasRPCMerkleLeavesRPCClient struct {
+	grpc.ClientStream
+}
+
+func (x *fidiasRPCMerkleLeavesRPCClient) Recv() (*KVPair, error) {
+	m := new(KVPair)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *fidiasRPCClient) RegisterRootRPC(ctx context.Context, in *RootRequest, opts ...grpc.CallOption) (*WriteResponse, error) {
 	out := new(WriteResponse)
-	err := grpc.Invoke(ctx, "/fidias.FidiasRPC/CASetRPC", in, out, c.cc, opts...)
+	err := grpc.Invoke(ctx, "/fidias.FidiasRPC/RegisterRootRPC", in, out, c.cc, opts...)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-func (c *fidiasRPCClient) RemoveRPC(ctx context.Context, in *WriteRequest, opts ...grpc.CallOption) (*WriteResponse, error) {
+func (c *fidiasRPCClient) BeginUploadRPC(ctx context.Context, in *UploadRequest, opts ...grpc.CallOption) (*WriteResponse, error) {
 	out := new(WriteResponse)
-	err := grpc.Invoke(ctx, "/fidias.FidiasRPC/RemoveRPC", in, out, c.cc, opts...)
+	err := grpc.Invoke(ctx, "/fidias.FidiasRPC/BeginUploadRPC", in, out, c.cc, opts...)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-func (c *fidiasRPCClient) CARemoveRPC(ctx context.Context, in *WriteRequest, opts ...grpc.CallOption) (*WriteResponse, error) {
+func (c *fidiasRPCClient) EndUploadRPC(ctx context.Context, in *UploadRequest, opts ...grpc.CallOption) (*WriteResponse, error) {
 	out := new(WriteResponse)
-	err := grpc.Invoke(ctx, "/fidias.FidiasRPC/CARemoveRPC", in, out, c.cc, opts...)
+	err := grpc.Invoke(ctx, "/fidias.FidiasRPC/EndUploadRPC", in, out, c.cc, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -441,6 +1250,30 @@ type FidiasRPCServer interface {
 	RemoveRPC(context.Context, *WriteRequest) (*WriteResponse, error)
 	// Remove key on cluster
 	CARemoveRPC(context.Context, *WriteRequest) (*WriteResponse, error)
+	// Add a delta to an integer key on cluster
+	IncrRPC(context.Context, *IncrRequest) (*WriteResponse, error)
+	// Append data to a key value on cluster
+	AppendRPC(context.Context, *WriteRequest) (*WriteResponse, error)
+	// Create a directory on cluster
+	MkdirRPC(context.Context, *WriteRequest) (*WriteResponse, error)
+	// Returns the usage of the namespace on the node
+	UsageRPC(context.Context, *Request) (*NamespaceUsage, error)
+	// Query a secondary index on cluster
+	QueryIndexRPC(context.Context, *IndexQuery) (*IndexResult, error)
+	// Lookup a secondary index on a single remote
+	LookupIndexRPC(context.Context, *IndexQuery) (*IndexResult, error)
+	// Scan a key range from a single remote
+	ScanRPC(*ScanOptions, FidiasRPC_ScanRPCServer) error
+	// Returns hashes of the merkle tree of a namespace on a single remote
+	MerkleRPC(context.Context, *MerkleRequest) (*MerkleResponse, error)
+	// Returns the key digests of merkle tree leaves from a single remote
+	MerkleLeavesRPC(*MerkleRequest, FidiasRPC_MerkleLeavesRPCServer) error
+	// Register an uploaded root on cluster
+	RegisterRootRPC(context.Context, *RootRequest) (*WriteResponse, error)
+	// Mark an upload in progress before writing its blocks
+	BeginUploadRPC(context.Context, *UploadRequest) (*WriteResponse, error)
+	// Clear the marker of a failed or aborted upload
+	EndUploadRPC(context.Context, *UploadRequest) (*WriteResponse, error)
 }
 
 func RegisterFidiasRPCServer(s *grpc.Server, srv FidiasRPCServer) {
@@ -576,6 +1409,228 @@ func _FidiasRPC_CARemoveRPC_Handler(srv interface{}, ctx context.Context, dec fu
 	return interceptor(ctx, in, info, handler)
 }
 
+func _FidiasRPC_IncrRPC_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(IncrRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FidiasRPCServer).IncrRPC(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fidias.FidiasRPC/IncrRPC",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FidiasRPCServer).IncrRPC(ctx, req.(*IncrRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FidiasRPC_AppendRPC_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WriteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FidiasRPCServer).AppendRPC(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fidias.FidiasRPC/AppendRPC",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FidiasRPCServer).AppendRPC(ctx, req.(*WriteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FidiasRPC_MkdirRPC_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WriteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FidiasRPCServer).MkdirRPC(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fidias.FidiasRPC/MkdirRPC",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FidiasRPCServer).MkdirRPC(ctx, req.(*WriteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FidiasRPC_UsageRPC_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Request)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FidiasRPCServer).UsageRPC(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fidias.FidiasRPC/UsageRPC",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FidiasRPCServer).UsageRPC(ctx, req.(*Request))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FidiasRPC_QueryIndexRPC_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(IndexQuery)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FidiasRPCServer).QueryIndexRPC(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fidias.FidiasRPC/QueryIndexRPC",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FidiasRPCServer).QueryIndexRPC(ctx, req.(*IndexQuery))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FidiasRPC_LookupIndexRPC_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(IndexQuery)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FidiasRPCServer).LookupIndexRPC(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fidias.FidiasRPC/LookupIndexRPC",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FidiasRPCServer).LookupIndexRPC(ctx, req.(*IndexQuery))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FidiasRPC_ScanRPC_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ScanOptions)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FidiasRPCServer).ScanRPC(m, &fidiasRPCScanRPCServer{stream})
+}
+
+type FidiasRPC_ScanRPCServer interface {
+	Send(*KVPair) error
+	grpc.ServerStream
+}
+
+type fidiasRPCScanRPCServer struct {
+	grpc.ServerStream
+}
+
+func (x *fidiasRPCScanRPCServer) Send(m *KVPair) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _FidiasRPC_MerkleRPC_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MerkleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FidiasRPCServer).MerkleRPC(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fidias.FidiasRPC/MerkleRPC",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FidiasRPCServer).MerkleRPC(ctx, req.(*MerkleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FidiasRPC_MerkleLeavesRPC_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(MerkleRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FidiasRPCServer).MerkleLeavesRPC(m, &fidiasRPCMerkleLeavesRPCServer{stream})
+}
+
+type FidiasRPC_MerkleLeavesRPCServer interface {
+	Send(*KVPair) error
+	grpc.ServerStream
+}
+
+type fidiasRPCMerkleLeavesRPCServer struct {
+	grpc.ServerStream
+}
+
+func (x *fidiasRPCMerkleLeavesRPCServer) Send(m *KVPair) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _FidiasRPC_RegisterRootRPC_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RootRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FidiasRPCServer).RegisterRootRPC(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fidias.FidiasRPC/RegisterRootRPC",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FidiasRPCServer).RegisterRootRPC(ctx, req.(*RootRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FidiasRPC_BeginUploadRPC_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UploadRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FidiasRPCServer).BeginUploadRPC(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fidias.FidiasRPC/BeginUploadRPC",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FidiasRPCServer).BeginUploadRPC(ctx, req.(*UploadRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FidiasRPC_EndUploadRPC_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UploadRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FidiasRPCServer).EndUploadRPC(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fidias.FidiasRPC/EndUploadRPC",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FidiasRPCServer).EndUploadRPC(ctx, req.(*UploadRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _FidiasRPC_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "fidias.FidiasRPC",
 	HandlerType: (*FidiasRPCServer)(nil),
@@ -604,6 +1659,46 @@ var _FidiasRPC_serviceDesc = grpc.ServiceDesc{
 			MethodName: "CARemoveRPC",
 			Handler:    _FidiasRPC_CARemoveRPC_Handler,
 		},
+		{
+			MethodName: "IncrRPC",
+			Handler:    _FidiasRPC_IncrRPC_Handler,
+		},
+		{
+			MethodName: "AppendRPC",
+			Handler:    _FidiasRPC_AppendRPC_Handler,
+		},
+		{
+			MethodName: "MkdirRPC",
+			Handler:    _FidiasRPC_MkdirRPC_Handler,
+		},
+		{
+			MethodName: "UsageRPC",
+			Handler:    _FidiasRPC_UsageRPC_Handler,
+		},
+		{
+			MethodName: "QueryIndexRPC",
+			Handler:    _FidiasRPC_QueryIndexRPC_Handler,
+		},
+		{
+			MethodName: "LookupIndexRPC",
+			Handler:    _FidiasRPC_LookupIndexRPC_Handler,
+		},
+		{
+			MethodName: "MerkleRPC",
+			Handler:    _FidiasRPC_MerkleRPC_Handler,
+		},
+		{
+			MethodName: "RegisterRootRPC",
+			Handler:    _FidiasRPC_RegisterRootRPC_Handler,
+		},
+		{
+			MethodName: "BeginUploadRPC",
+			Handler:    _FidiasRPC_BeginUploadRPC_Handler,
+		},
+		{
+			MethodName: "EndUploadRPC",
+			Handler:    _FidiasRPC_EndUploadRPC_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -611,6 +1706,16 @@ var _FidiasRPC_serviceDesc = grpc.ServiceDesc{
 			Handler:       _FidiasRPC_ListDirRPC_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "ScanRPC",
+			Handler:       _FidiasRPC_ScanRPC_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "MerkleLeavesRPC",
+			Handler:       _FidiasRPC_MerkleLeavesRPC_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "rpc.proto",
 }
@@ -0,0 +1,309 @@
+package fidias
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hexablock/blox"
+	"github.com/hexablock/blox/block"
+	"github.com/hexablock/log"
+)
+
+// Maximum number of recent corruption events kept in the scrub status
+const maxScrubEvents = 100
+
+var errNoHealthyCopy = errors.New("no healthy copy found")
+
+// ScrubEvent is a corrupt block found by the scrubber
+type ScrubEvent struct {
+	// Hex id of the block or shard
+	ID       string
+	Time     time.Time
+	Repaired bool
+	// Reason the repair failed
+	Error string `json:",omitempty"`
+}
+
+// ScrubStatus is the status of the scrubber on a node.  Counters are totals
+// since the scrubber was created
+type ScrubStatus struct {
+	Runs int64
+	// Local blocks and shards verified
+	Verified   int64
+	Corrupt    int64
+	Repaired   int64
+	Unrepaired int64
+	Running    bool
+	LastRun    time.Time
+	LastError  string `json:",omitempty"`
+	// Duration of the last completed run
+	Runtime time.Duration
+	// Most recent corruption events
+	Events []*ScrubEvent
+}
+
+// LocalBlockDevice is the device holding the blocks stored on the local node
+type LocalBlockDevice interface {
+	blox.BlockDevice
+	// IterIDs calls f with the id of each block in the index of the device
+	IterIDs(f func(id []byte) error) error
+}
+
+// Scrubber periodically verifies the blocks stored on the local node against
+// their content address.  The index of the local device is iterated and each
+// block is read and hashed in place so blocks of any root or none are
+// verified.  A corrupt replica is replaced with a healthy copy from another
+// replica located via the dht while a corrupt erasure coded shard is rebuilt
+// from the other shards
+type Scrubber struct {
+	// Counters first for 64-bit alignment of atomic operations
+	runs, verified, corrupt, repaired, unrepaired int64
+
+	// Local block host
+	host string
+	// Device of the local blocks
+	local LocalBlockDevice
+	// Device used to locate and read the other copies of a block
+	dev *DurableDevice
+
+	mu      sync.Mutex
+	running bool
+	lastRun time.Time
+	lastErr error
+	runtime time.Duration
+	events  []*ScrubEvent
+
+	stop chan struct{}
+}
+
+// NewScrubber returns a scrubber for the blocks of the local device on the host
+func NewScrubber(host string, local LocalBlockDevice, dev *DurableDevice) *Scrubber {
+	return &Scrubber{
+		host:   host,
+		local:  local,
+		dev:    dev,
+		events: make([]*ScrubEvent, 0),
+	}
+}
+
+// Start runs the scrubber in the background every interval until stopped
+func (s *Scrubber) Start(interval time.Duration) {
+	s.stop = make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-time.After(interval):
+			}
+
+			if err := s.Run(); err != nil {
+				log.Printf("[ERROR] Scrub failed error='%v'", err)
+			}
+		}
+	}()
+}
+
+// Stop stops the background scrubber
+func (s *Scrubber) Stop() {
+	if s.stop != nil {
+		close(s.stop)
+	}
+}
+
+// Run verifies all local blocks once
+func (s *Scrubber) Run() error {
+	s.mu.Lock()
+	if s.running {
+		s.mu.Unlock()
+		return nil
+	}
+	s.running = true
+	s.mu.Unlock()
+
+	start := time.Now()
+	err := s.run()
+
+	s.mu.Lock()
+	s.running = false
+	s.lastRun = start
+	s.lastErr = err
+	s.runtime = time.Since(start)
+	s.mu.Unlock()
+
+	atomic.AddInt64(&s.runs, 1)
+	return err
+}
+
+func (s *Scrubber) run() error {
+	return s.local.IterIDs(func(id []byte) error {
+		s.scrubBlock(id)
+		return nil
+	})
+}
+
+// scrubBlock verifies the local block in place repairing it if corrupt
+func (s *Scrubber) scrubBlock(id []byte) {
+	blk, err := s.local.GetBlock(id)
+	if err != nil {
+		// Removed since it was listed
+		return
+	}
+
+	data, err := readBlock(blk)
+	if err == nil && s.verify(id, blk.Type(), data) {
+		return
+	}
+
+	s.recordCorrupt(id, s.repair(id))
+}
+
+// repair replaces the corrupt local block.  It is repaired as a replica and
+// failing that as the shard of an erasure coded block
+func (s *Scrubber) repair(id []byte) error {
+	err := s.repairReplica(id)
+	if err != errNoHealthyCopy {
+		return err
+	}
+
+	sm, er := s.dev.shards.FindShardMap(id)
+	if er != nil {
+		if errors.Is(er, ErrNotFound) {
+			return err
+		}
+		return er
+	}
+	for i, shard := range sm.Shards {
+		if bytes.Equal(shard.ID, id) && shard.Host == s.host {
+			return s.repairShard(sm, i)
+		}
+	}
+	return err
+}
+
+func (s *Scrubber) verify(id []byte, typ block.BlockType, data []byte) bool {
+	atomic.AddInt64(&s.verified, 1)
+	return bytes.Equal(computeBlockID(s.dev.hashFunc, typ, data), id)
+}
+
+// repairReplica replaces the local copy of the block with a healthy copy from
+// another node
+func (s *Scrubber) repairReplica(id []byte) error {
+	hosts, err := s.dev.placement(id, 0)
+	if err != nil {
+		return err
+	}
+
+	for _, host := range hosts {
+		if host == s.host {
+			continue
+		}
+
+		blk, er := s.dev.trans.GetBlock(host, id)
+		if er != nil {
+			continue
+		}
+		data, er := readBlock(blk)
+		if er != nil || !bytes.Equal(computeBlockID(s.dev.hashFunc, blk.Type(), data), id) {
+			continue
+		}
+
+		return s.replace(newMemBlock(blk.Type(), data, s.dev.hashFunc))
+	}
+
+	return errNoHealthyCopy
+}
+
+// repairShard rebuilds the local shard from the other shards of the block
+func (s *Scrubber) repairShard(sm *ShardMap, i int) error {
+	rs, err := newReedSolomon(int(sm.DataShards), int(sm.ParityShards))
+	if err != nil {
+		return err
+	}
+
+	shards := make([][]byte, len(sm.Shards))
+	for j, shard := range sm.Shards {
+		if j == i {
+			continue
+		}
+		blk, er := s.dev.trans.GetBlock(shard.Host, shard.ID)
+		if er != nil {
+			continue
+		}
+		data, er := readBlock(blk)
+		if er != nil || !bytes.Equal(computeBlockID(s.dev.hashFunc, block.BlockTypeData, data), shard.ID) {
+			continue
+		}
+		shards[j] = data
+	}
+
+	if err = rs.reconstruct(shards); err != nil {
+		return err
+	}
+
+	blk := newMemBlock(block.BlockTypeData, shards[i], s.dev.hashFunc)
+	if !bytes.Equal(blk.ID(), sm.Shards[i].ID) {
+		return errNoHealthyCopy
+	}
+	return s.replace(blk)
+}
+
+// replace removes the corrupt local copy and writes the healthy block
+func (s *Scrubber) replace(blk block.Block) error {
+	if err := s.local.RemoveBlock(blk.ID()); err != nil && err != block.ErrBlockNotFound {
+		return err
+	}
+	_, err := s.local.SetBlock(blk)
+	return err
+}
+
+// recordCorrupt updates the counters and events with the result of a repair
+func (s *Scrubber) recordCorrupt(id []byte, err error) {
+	atomic.AddInt64(&s.corrupt, 1)
+
+	ev := &ScrubEvent{ID: hex.EncodeToString(id), Time: time.Now(), Repaired: err == nil}
+	if err == nil {
+		atomic.AddInt64(&s.repaired, 1)
+		log.Printf("[WARNING] Scrub repaired corrupt block id=%s", ev.ID)
+	} else {
+		atomic.AddInt64(&s.unrepaired, 1)
+		ev.Error = err.Error()
+		log.Printf("[ERROR] Scrub failed to repair corrupt block id=%s error='%v'", ev.ID, err)
+	}
+
+	s.mu.Lock()
+	s.events = append(s.events, ev)
+	if len(s.events) > maxScrubEvents {
+		s.events = s.events[len(s.events)-maxScrubEvents:]
+	}
+	s.mu.Unlock()
+}
+
+// Status returns the current scrub status
+func (s *Scrubber) Status() *ScrubStatus {
+	st := &ScrubStatus{
+		Runs:       atomic.LoadInt64(&s.runs),
+		Verified:   atomic.LoadInt64(&s.verified),
+		Corrupt:    atomic.LoadInt64(&s.corrupt),
+		Repaired:   atomic.LoadInt64(&s.repaired),
+		Unrepaired: atomic.LoadInt64(&s.unrepaired),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st.Running = s.running
+	st.LastRun = s.lastRun
+	st.Runtime = s.runtime
+	if s.lastErr != nil {
+		st.LastError = s.lastErr.Error()
+	}
+	st.Events = make([]*ScrubEvent, len(s.events))
+	copy(st.Events, s.events)
+
+	return st
+}
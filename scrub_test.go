@@ -0,0 +1,107 @@
+package fidias
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/hexablock/blox/block"
+)
+
+// testLocalDevice is the local device of a host of a testBlockNet
+type testLocalDevice struct {
+	bn   *testBlockNet
+	host string
+}
+
+func (dev *testLocalDevice) SetBlock(blk block.Block) ([]byte, error) {
+	return dev.bn.SetBlock(dev.host, blk)
+}
+
+func (dev *testLocalDevice) GetBlock(id []byte) (block.Block, error) {
+	return dev.bn.GetBlock(dev.host, id)
+}
+
+func (dev *testLocalDevice) RemoveBlock(id []byte) error {
+	return dev.bn.RemoveBlock(dev.host, id)
+}
+
+func (dev *testLocalDevice) BlockExists(id []byte) (bool, error) {
+	_, err := dev.GetBlock(id)
+	return err == nil, nil
+}
+
+func (dev *testLocalDevice) IterIDs(f func(id []byte) error) error {
+	dev.bn.mu.Lock()
+	ids := make([][]byte, 0, len(dev.bn.blocks[dev.host]))
+	for id := range dev.bn.blocks[dev.host] {
+		ids = append(ids, []byte(id))
+	}
+	dev.bn.mu.Unlock()
+
+	for _, id := range ids {
+		if err := f(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func Test_Scrubber_replica(t *testing.T) {
+	bn := newTestBlockNet(5)
+	dev, _ := NewDurableDevice(&Durability{Replicas: 3}, testBaseDevice{}, bn, bn, bn, sha256.New)
+
+	blk := newMemBlock(block.BlockTypeData, []byte("scrubbed"), sha256.New)
+	if _, err := dev.SetBlock(blk); err != nil {
+		t.Fatal(err)
+	}
+	hosts, _ := dev.placement(blk.ID(), 3)
+
+	s := NewScrubber(hosts[0], &testLocalDevice{bn: bn, host: hosts[0]}, dev)
+
+	// Healthy block
+	if err := s.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if st := s.Status(); st.Verified != 1 || st.Corrupt != 0 {
+		t.Fatal("should be healthy", st)
+	}
+
+	bn.blocks[hosts[0]][string(blk.ID())] = []byte("rotten")
+	s.Run()
+
+	st := s.Status()
+	if st.Corrupt != 1 || st.Repaired != 1 || len(st.Events) != 1 || !st.Events[0].Repaired {
+		t.Fatal("should be repaired", st)
+	}
+	if !bytes.Equal(bn.blocks[hosts[0]][string(blk.ID())], []byte("scrubbed")) {
+		t.Fatal("local copy not replaced")
+	}
+}
+
+func Test_Scrubber_shard(t *testing.T) {
+	bn := newTestBlockNet(6)
+	dev, _ := NewDurableDevice(&Durability{DataShards: 3, ParityShards: 2}, testBaseDevice{}, bn, bn, bn, sha256.New)
+
+	blk := newMemBlock(block.BlockTypeData, bytes.Repeat([]byte("shard"), 20), sha256.New)
+	if _, err := dev.SetBlock(blk); err != nil {
+		t.Fatal(err)
+	}
+	sm, _ := bn.GetShardMap(blk.ID())
+	shard := sm.Shards[1]
+	healthy := bn.blocks[shard.Host][string(shard.ID)]
+
+	bn.blocks[shard.Host][string(shard.ID)] = []byte("rotten")
+
+	s := NewScrubber(shard.Host, &testLocalDevice{bn: bn, host: shard.Host}, dev)
+	if err := s.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if st := s.Status(); st.Corrupt != 1 || st.Repaired != 1 {
+		t.Fatal("should be repaired", st)
+	}
+	if !bytes.Equal(bn.blocks[shard.Host][string(shard.ID)], healthy) {
+		t.Fatal("shard not rebuilt")
+	}
+}
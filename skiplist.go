@@ -0,0 +1,154 @@
+package fidias
+
+import (
+	"math/rand"
+	"time"
+)
+
+const (
+	// Maximum number of levels in the skiplist.  This is sufficient for well
+	// over 2^32 keys with a level probability of 1/4
+	skiplistMaxLevel = 16
+	// Probability of a node being promoted to the next level is 1/skiplistP
+	skiplistP = 4
+)
+
+// slNode is a single node in the skiplist
+type slNode struct {
+	key   string
+	value *KVPair
+
+	// Next node at each level the node is part of
+	next []*slNode
+	// Previous node at the lowest level for reverse iteration
+	prev *slNode
+}
+
+// skiplist is an ordered map of keys to pairs.  Lookups, inserts and deletes
+// are O(log n) and iteration from any key is O(log n + k) in either direction.
+// It is not safe for concurrent use and relies on the caller for locking
+type skiplist struct {
+	head  *slNode
+	tail  *slNode
+	level int
+	len   int
+
+	rnd *rand.Rand
+}
+
+func newSkiplist() *skiplist {
+	return &skiplist{
+		head:  &slNode{next: make([]*slNode, skiplistMaxLevel)},
+		level: 1,
+		rnd:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// randomLevel returns the level for a new node
+func (sl *skiplist) randomLevel() int {
+	level := 1
+	for level < skiplistMaxLevel && sl.rnd.Intn(skiplistP) == 0 {
+		level++
+	}
+	return level
+}
+
+// findPrev returns the last node before the key at each level
+func (sl *skiplist) findPrev(key string) []*slNode {
+	prev := make([]*slNode, skiplistMaxLevel)
+	n := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for n.next[i] != nil && n.next[i].key < key {
+			n = n.next[i]
+		}
+		prev[i] = n
+	}
+	return prev
+}
+
+// seek returns the first node with a key greater than or equal to the key
+func (sl *skiplist) seek(key string) *slNode {
+	n := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for n.next[i] != nil && n.next[i].key < key {
+			n = n.next[i]
+		}
+	}
+	return n.next[0]
+}
+
+// seekBefore returns the last node with a key less than the key
+func (sl *skiplist) seekBefore(key string) *slNode {
+	if n := sl.seek(key); n != nil {
+		return n.prev
+	}
+	return sl.tail
+}
+
+// get returns the pair for the key or nil if it does not exist
+func (sl *skiplist) get(key string) *KVPair {
+	if n := sl.seek(key); n != nil && n.key == key {
+		return n.value
+	}
+	return nil
+}
+
+// set inserts or replaces the pair for the key
+func (sl *skiplist) set(key string, value *KVPair) {
+	prev := sl.findPrev(key)
+	if n := prev[0].next[0]; n != nil && n.key == key {
+		n.value = value
+		return
+	}
+
+	level := sl.randomLevel()
+	if level > sl.level {
+		for i := sl.level; i < level; i++ {
+			prev[i] = sl.head
+		}
+		sl.level = level
+	}
+
+	n := &slNode{key: key, value: value, next: make([]*slNode, level)}
+	for i := 0; i < level; i++ {
+		n.next[i] = prev[i].next[i]
+		prev[i].next[i] = n
+	}
+
+	if prev[0] != sl.head {
+		n.prev = prev[0]
+	}
+	if n.next[0] != nil {
+		n.next[0].prev = n
+	} else {
+		sl.tail = n
+	}
+
+	sl.len++
+}
+
+// delete removes the key returning true if it existed
+func (sl *skiplist) delete(key string) bool {
+	prev := sl.findPrev(key)
+	n := prev[0].next[0]
+	if n == nil || n.key != key {
+		return false
+	}
+
+	for i := 0; i < len(n.next); i++ {
+		prev[i].next[i] = n.next[i]
+	}
+
+	if n.next[0] != nil {
+		n.next[0].prev = n.prev
+	} else {
+		sl.tail = n.prev
+	}
+
+	for sl.level > 1 && sl.head.next[sl.level-1] == nil {
+		sl.level--
+	}
+
+	sl.len--
+	return true
+}
@@ -0,0 +1,50 @@
+package fidias
+
+import (
+	"fmt"
+	"testing"
+)
+
+func Test_skiplist(t *testing.T) {
+	sl := newSkiplist()
+	for _, i := range []int{5, 1, 9, 3, 7, 3} {
+		k := fmt.Sprintf("%d", i)
+		sl.set(k, NewKVPair([]byte(k), nil))
+	}
+	if sl.len != 5 {
+		t.Fatal("wrong length", sl.len)
+	}
+
+	walk := func() (fwd, rev string) {
+		for n := sl.seek(""); n != nil; n = n.next[0] {
+			fwd += n.key
+		}
+		for n := sl.tail; n != nil; n = n.prev {
+			rev += n.key
+		}
+		return
+	}
+	if fwd, rev := walk(); fwd != "13579" || rev != "97531" {
+		t.Fatal("wrong order", fwd, rev)
+	}
+
+	if n := sl.seek("4"); n.key != "5" {
+		t.Fatal("wrong seek", n.key)
+	}
+	if n := sl.seekBefore("5"); n.key != "3" {
+		t.Fatal("wrong seek before", n.key)
+	}
+	if sl.seekBefore("1") != nil || sl.seekBefore("a").key != "9" {
+		t.Fatal("wrong seek before bounds")
+	}
+
+	if !sl.delete("9") || !sl.delete("1") || sl.delete("2") {
+		t.Fatal("wrong delete")
+	}
+	if sl.get("9") != nil || sl.get("5") == nil {
+		t.Fatal("wrong get")
+	}
+	if fwd, rev := walk(); fwd != "357" || rev != "753" {
+		t.Fatal("wrong order after delete", fwd, rev)
+	}
+}
@@ -0,0 +1,479 @@
+package fidias
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/hexablock/blox"
+	"github.com/hexablock/blox/block"
+)
+
+// Changes between two snapshots
+const (
+	SnapshotAdded    = "added"
+	SnapshotRemoved  = "removed"
+	SnapshotModified = "modified"
+)
+
+// snapshotTree is a directory of a snapshot.  It is stored as a json encoded
+// data block so its id is the hash of the directory contents.  Directory
+// entries reference the id of their own tree and keys the data block of their
+// value making the root id a Merkle hash of the whole snapshot.  Unchanged
+// subtrees at the same key in different snapshots share the same blocks as do
+// equal values
+type snapshotTree struct {
+	// Key of the directory when the snapshot was taken
+	Dir      string            `json:",omitempty"`
+	Mode     uint32            `json:",omitempty"`
+	Owner    string            `json:",omitempty"`
+	Metadata map[string]string `json:",omitempty"`
+	// Entries ordered by name
+	Entries []*SnapshotEntry
+}
+
+// SnapshotEntry is a key of a snapshot directory
+type SnapshotEntry struct {
+	// Name relative to the directory
+	Name string
+	// Id of the tree of a directory
+	Tree []byte `json:",omitempty"`
+	// Id of the data block holding the value of a key as stored.  Values of
+	// encrypted namespaces remain encrypted
+	Value []byte `json:",omitempty"`
+	// Value size in bytes
+	Size         uint64 `json:",omitempty"`
+	ContentType  string `json:",omitempty"`
+	Modification []byte `json:",omitempty"`
+}
+
+// IsDir returns true if the entry is a directory
+func (entry *SnapshotEntry) IsDir() bool {
+	return entry.Tree != nil
+}
+
+// SnapshotChange is a key that differs between two snapshots
+type SnapshotChange struct {
+	// Key relative to the snapshot root
+	Key    string
+	Change string
+}
+
+// SnapshotTree writes an immutable snapshot of the directory and all of its
+// descendants to the device and returns the root id.  Each directory is
+// listed once so the snapshot is consistent per directory rather than across
+// the tree.  The root is not registered with the garbage collector
+func (kvs *KVS) SnapshotTree(dir []byte, dev *DurableDevice) ([]byte, error) {
+	return kvs.SnapshotTreeContext(context.Background(), dir, dev)
+}
+
+// SnapshotTreeContext writes a snapshot of the directory.  It is the same as
+// SnapshotTree with the context bounding the time spent
+func (kvs *KVS) SnapshotTreeContext(ctx context.Context, dir []byte, dev *DurableDevice) ([]byte, error) {
+	dir = bytes.TrimSuffix(dir, []byte("/"))
+	if len(dir) == 0 {
+		return nil, fmt.Errorf("directory required")
+	}
+
+	kvp, _, err := kvs.GetContext(ctx, dir, &ReadOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if !kvp.IsDir() {
+		return nil, fmt.Errorf("not a directory: %s", dir)
+	}
+
+	return snapshotDir(ctx, kvp,
+		func(d []byte) ([]*KVPair, error) {
+			ls, _, err := kvs.ListContext(ctx, d, &ReadOptions{})
+			return ls, err
+		},
+		func(data []byte) ([]byte, error) {
+			blk := newMemBlock(block.BlockTypeData, data, dev.hashFunc)
+			if _, err := dev.SetBlock(blk); err != nil && err != block.ErrBlockExists {
+				return nil, err
+			}
+			return blk.ID(), nil
+		},
+	)
+}
+
+// RestoreTree recreates the snapshot with the root id under dir.  dir must not
+// exist.  Values and content types are restored as is and directories with
+// their mode, owner and metadata.  Restored keys have new modifications.
+// Values encrypted by the client are bound to their key so they can only be
+// restored to the directory the snapshot was taken of, failing with
+// ErrEncrypted otherwise.  It returns the number of keys restored
+func (kvs *KVS) RestoreTree(root, dir []byte, dev blox.BlockDevice, wo *WriteOptions) (int, error) {
+	return kvs.RestoreTreeContext(context.Background(), root, dir, dev, wo)
+}
+
+// RestoreTreeContext recreates the snapshot under dir.  It is the same as
+// RestoreTree with the context bounding the time spent
+func (kvs *KVS) RestoreTreeContext(ctx context.Context, root, dir []byte, dev blox.BlockDevice, wo *WriteOptions) (int, error) {
+	return restoreTree(ctx, root, dir, dev,
+		func(key []byte) error {
+			_, _, err := kvs.GetContext(ctx, key, &ReadOptions{})
+			return err
+		},
+		func(kvp *KVPair, src []byte) error {
+			if kvp.IsDir() {
+				_, _, err := kvs.MkdirContext(ctx, kvp, wo)
+				return err
+			}
+			if isSealed(kvp.Value) && !bytes.Equal(kvp.Key, src) {
+				return ErrEncrypted
+			}
+			_, _, err := kvs.SetContext(ctx, kvp, wo)
+			return err
+		},
+	)
+}
+
+// restoreTree recreates the snapshot under dir from the device.  The get func
+// checks dir does not exist and the set func writes each directory and value
+// given its key in the snapshot
+func restoreTree(ctx context.Context, root, dir []byte, dev blox.BlockDevice, get func(key []byte) error,
+	set func(kvp *KVPair, src []byte) error) (int, error) {
+
+	dir = bytes.TrimSuffix(dir, []byte("/"))
+	if len(dir) == 0 {
+		return 0, fmt.Errorf("directory required")
+	}
+
+	err := get(dir)
+	if err == nil {
+		return 0, ErrKeyExists
+	} else if !errors.Is(err, ErrNotFound) {
+		return 0, err
+	}
+
+	return restoreDir(ctx, root, dir,
+		func(id []byte) (*snapshotTree, error) {
+			return readSnapshotTree(dev, id)
+		},
+		func(id []byte) ([]byte, error) {
+			return readSnapshotValue(dev, id)
+		},
+		set,
+	)
+}
+
+// DiffSnapshots returns the keys added, removed or modified from snapshot a to
+// snapshot b.  Subtrees with the same id are skipped and values are compared
+// by id without being read.  A directory is only reported as modified if its
+// mode, owner or metadata changed
+func DiffSnapshots(dev blox.BlockDevice, a, b []byte) ([]*SnapshotChange, error) {
+	changes := make([]*SnapshotChange, 0)
+	err := diffTrees("", a, b, func(id []byte) (*snapshotTree, error) {
+		return readSnapshotTree(dev, id)
+	}, func(key, change string) {
+		changes = append(changes, &SnapshotChange{Key: key, Change: change})
+	})
+	return changes, err
+}
+
+// snapshotDir writes the tree of the directory and all of its descendants
+// deepest first using the list and put functions.  Values are put as blocks of
+// their own ahead of the tree referencing them so the size of a tree only
+// depends on the number of entries.  It returns the id of the tree
+func snapshotDir(ctx context.Context, dir *KVPair, list func([]byte) ([]*KVPair, error),
+	put func([]byte) ([]byte, error)) ([]byte, error) {
+
+	ls, err := list(dir.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	tree := &snapshotTree{
+		Dir:      string(dir.Key),
+		Mode:     dir.Mode,
+		Owner:    dir.Owner,
+		Metadata: dir.Metadata,
+		Entries:  make([]*SnapshotEntry, 0, len(ls)),
+	}
+
+	prefix := append(append([]byte{}, dir.Key...), '/')
+	for _, kvp := range ls {
+		if err = ctx.Err(); err != nil {
+			return nil, contextError(err)
+		}
+
+		entry := &SnapshotEntry{Name: string(bytes.TrimPrefix(kvp.Key, prefix))}
+		if kvp.IsDir() {
+			if entry.Tree, err = snapshotDir(ctx, kvp, list, put); err != nil {
+				return nil, err
+			}
+		} else {
+			if entry.Value, err = put(kvp.Value); err != nil {
+				return nil, err
+			}
+			entry.Size = uint64(len(kvp.Value))
+			entry.ContentType = kvp.ContentType
+			entry.Modification = kvp.Modification
+		}
+		tree.Entries = append(tree.Entries, entry)
+	}
+
+	// Listings are unordered.  Sorting makes the id depend only on content
+	sort.Slice(tree.Entries, func(i, j int) bool { return tree.Entries[i].Name < tree.Entries[j].Name })
+
+	data, err := json.Marshal(tree)
+	if err != nil {
+		return nil, err
+	}
+	return put(data)
+}
+
+// restoreDir creates dir from the tree with the id followed by its entries
+// using the read function for trees, the value function for values and the
+// set function.  set is called with each pair and its key in the snapshot,
+// which is nil for snapshots not recording it.  It returns the number of keys
+// set
+func restoreDir(ctx context.Context, id, dir []byte, read func([]byte) (*snapshotTree, error),
+	value func([]byte) ([]byte, error), set func(kvp *KVPair, src []byte) error) (int, error) {
+
+	tree, err := read(id)
+	if err != nil {
+		return 0, err
+	}
+
+	d := NewDirKVPair(dir, os.FileMode(tree.Mode))
+	d.Owner = tree.Owner
+	d.Metadata = tree.Metadata
+	if err = set(d, snapshotKey(tree.Dir, "")); err != nil {
+		return 0, err
+	}
+	c := 1
+
+	for _, entry := range tree.Entries {
+		if err = ctx.Err(); err != nil {
+			return c, contextError(err)
+		}
+
+		key := append(append(append([]byte{}, dir...), '/'), entry.Name...)
+		if entry.IsDir() {
+			var n int
+			n, err = restoreDir(ctx, entry.Tree, key, read, value, set)
+			c += n
+			if err != nil {
+				return c, err
+			}
+			continue
+		}
+
+		var val []byte
+		if val, err = value(entry.Value); err != nil {
+			return c, err
+		}
+		kvp := NewKVPair(key, val)
+		kvp.ContentType = entry.ContentType
+		if err = set(kvp, snapshotKey(tree.Dir, entry.Name)); err != nil {
+			return c, err
+		}
+		c++
+	}
+
+	return c, nil
+}
+
+// diffTrees calls the change function for each key that differs between the
+// trees with ids a and b.  A nil id is an empty tree
+func diffTrees(prefix string, a, b []byte, read func([]byte) (*snapshotTree, error),
+	change func(key, change string)) error {
+
+	if bytes.Equal(a, b) {
+		return nil
+	}
+
+	ta, tb := &snapshotTree{}, &snapshotTree{}
+	var err error
+	if a != nil {
+		if ta, err = read(a); err != nil {
+			return err
+		}
+	}
+	if b != nil {
+		if tb, err = read(b); err != nil {
+			return err
+		}
+	}
+
+	// The root is not a key of the snapshot
+	if prefix != "" && a != nil && b != nil && !sameDirAttrs(ta, tb) {
+		change(prefix, SnapshotModified)
+	}
+
+	var i, j int
+	for i < len(ta.Entries) || j < len(tb.Entries) {
+		var ea, eb *SnapshotEntry
+		switch {
+		case j == len(tb.Entries) || (i < len(ta.Entries) && ta.Entries[i].Name < tb.Entries[j].Name):
+			ea = ta.Entries[i]
+			i++
+		case i == len(ta.Entries) || tb.Entries[j].Name < ta.Entries[i].Name:
+			eb = tb.Entries[j]
+			j++
+		default:
+			ea, eb = ta.Entries[i], tb.Entries[j]
+			i++
+			j++
+		}
+
+		if err = diffEntries(prefix, ea, eb, read, change); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diffEntries compares entries of the same name.  Either may be nil if the key
+// only exists in one snapshot.  Keys that changed between a file and a
+// directory are reported as removed and added
+func diffEntries(prefix string, ea, eb *SnapshotEntry, read func([]byte) (*snapshotTree, error),
+	change func(key, change string)) error {
+
+	var name string
+	if ea != nil {
+		name = ea.Name
+	} else {
+		name = eb.Name
+	}
+	key := name
+	if prefix != "" {
+		key = prefix + "/" + name
+	}
+
+	if ea != nil && eb != nil {
+		switch {
+		case ea.IsDir() && eb.IsDir():
+			return diffTrees(key, ea.Tree, eb.Tree, read, change)
+
+		case !ea.IsDir() && !eb.IsDir():
+			if !bytes.Equal(ea.Value, eb.Value) || ea.ContentType != eb.ContentType ||
+				!bytes.Equal(ea.Modification, eb.Modification) {
+				change(key, SnapshotModified)
+			}
+			return nil
+		}
+	}
+
+	if ea != nil {
+		change(key, SnapshotRemoved)
+		if ea.IsDir() {
+			if err := diffTrees(key, ea.Tree, nil, read, change); err != nil {
+				return err
+			}
+		}
+	}
+	if eb != nil {
+		change(key, SnapshotAdded)
+		if eb.IsDir() {
+			return diffTrees(key, nil, eb.Tree, read, change)
+		}
+	}
+	return nil
+}
+
+// snapshotKey returns the key of the entry of the directory in the snapshot or
+// of the directory itself if name is empty.  It is nil if the directory is not
+// recorded
+func snapshotKey(dir, name string) []byte {
+	switch {
+	case dir == "":
+		return nil
+	case name == "":
+		return []byte(dir)
+	}
+	return []byte(dir + "/" + name)
+}
+
+func sameDirAttrs(a, b *snapshotTree) bool {
+	if a.Mode != b.Mode || a.Owner != b.Owner || len(a.Metadata) != len(b.Metadata) {
+		return false
+	}
+	for k, v := range a.Metadata {
+		if bv, ok := b.Metadata[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// snapshotBlocks returns the tree and value blocks of the snapshot with the
+// root id deepest first with the root last using the read function returning a
+// tree and the size of its block.  Values are not read as their size is kept
+// in the tree
+func snapshotBlocks(root []byte, read func([]byte) (*snapshotTree, uint64, error)) ([]*gcBlock, error) {
+	var (
+		blocks = make([]*gcBlock, 0)
+		walk   func(id []byte) error
+	)
+	walk = func(id []byte) error {
+		tree, size, err := read(id)
+		if err != nil {
+			return err
+		}
+		for _, entry := range tree.Entries {
+			if !entry.IsDir() {
+				blocks = append(blocks, &gcBlock{id: entry.Value, size: entry.Size})
+				continue
+			}
+			if err = walk(entry.Tree); err != nil {
+				return err
+			}
+		}
+		blocks = append(blocks, &gcBlock{id: id, size: size})
+		return nil
+	}
+
+	if err := walk(root); err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
+
+// readSnapshotTree reads the tree with the id from the device
+func readSnapshotTree(dev blox.BlockDevice, id []byte) (*snapshotTree, error) {
+	tree, _, err := readSnapshotBlock(dev, id)
+	return tree, err
+}
+
+// readSnapshotValue reads the value block with the id from the device
+func readSnapshotValue(dev blox.BlockDevice, id []byte) ([]byte, error) {
+	blk, err := dev.GetBlock(id)
+	if err != nil {
+		return nil, err
+	}
+	if blk.Type() != block.BlockTypeData {
+		return nil, fmt.Errorf("not a snapshot value: %x", id)
+	}
+	return readBlock(blk)
+}
+
+// readSnapshotBlock reads the tree with the id from the device along with the
+// size of its block
+func readSnapshotBlock(dev blox.BlockDevice, id []byte) (*snapshotTree, uint64, error) {
+	blk, err := dev.GetBlock(id)
+	if err != nil {
+		return nil, 0, err
+	}
+	if blk.Type() != block.BlockTypeData {
+		return nil, 0, fmt.Errorf("not a snapshot: %x", id)
+	}
+
+	data, err := readBlock(blk)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var tree snapshotTree
+	if err = json.Unmarshal(data, &tree); err != nil {
+		return nil, 0, fmt.Errorf("not a snapshot: %x", id)
+	}
+	return &tree, uint64(len(data)), nil
+}
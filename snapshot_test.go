@@ -0,0 +1,162 @@
+package fidias
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// testSnapshotStore is an in-memory tree of kv pairs and blocks
+type testSnapshotStore struct {
+	keys   map[string]*KVPair
+	blocks map[string][]byte
+}
+
+func newTestSnapshotStore(keys ...*KVPair) *testSnapshotStore {
+	st := &testSnapshotStore{keys: make(map[string]*KVPair), blocks: make(map[string][]byte)}
+	for _, kvp := range keys {
+		st.keys[string(kvp.Key)] = kvp
+	}
+	return st
+}
+
+func (st *testSnapshotStore) list(dir []byte) ([]*KVPair, error) {
+	out := make([]*KVPair, 0)
+	for k, kvp := range st.keys {
+		if rest := strings.TrimPrefix(k, string(dir)+"/"); rest != k && !strings.Contains(rest, "/") {
+			out = append(out, kvp)
+		}
+	}
+	return out, nil
+}
+
+func (st *testSnapshotStore) put(data []byte) ([]byte, error) {
+	id := sha256.Sum256(data)
+	st.blocks[string(id[:])] = data
+	return id[:], nil
+}
+
+func (st *testSnapshotStore) read(id []byte) (*snapshotTree, error) {
+	var tree snapshotTree
+	err := json.Unmarshal(st.blocks[string(id)], &tree)
+	return &tree, err
+}
+
+func (st *testSnapshotStore) value(id []byte) ([]byte, error) {
+	return st.blocks[string(id)], nil
+}
+
+func (st *testSnapshotStore) snapshot(t *testing.T) []byte {
+	id, err := snapshotDir(context.Background(), st.keys["dir"], st.list, st.put)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func Test_snapshotDir(t *testing.T) {
+	st := newTestSnapshotStore(
+		NewDirKVPair([]byte("dir"), 0755),
+		NewKVPair([]byte("dir/a"), []byte("a")),
+		NewDirKVPair([]byte("dir/sub"), 0700),
+		NewKVPair([]byte("dir/sub/b"), []byte("b")),
+	)
+
+	id := st.snapshot(t)
+	if len(st.blocks) != 4 {
+		t.Fatal("should have a tree per directory and a block per value", len(st.blocks))
+	}
+	if !bytes.Equal(st.snapshot(t), id) {
+		t.Fatal("snapshots of the same tree should have the same id")
+	}
+
+	restored := newTestSnapshotStore()
+	n, err := restoreDir(context.Background(), id, []byte("copy"), st.read, st.value, func(kvp *KVPair, src []byte) error {
+		if want := "dir" + strings.TrimPrefix(string(kvp.Key), "copy"); string(src) != want {
+			t.Fatalf("wrong snapshot key for %s: %s", kvp.Key, src)
+		}
+		restored.keys[string(kvp.Key)] = kvp
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Fatal("wrong restored count", n)
+	}
+	if b := restored.keys["copy/sub/b"]; b == nil || string(b.Value) != "b" {
+		t.Fatal("value not restored")
+	}
+	if sub := restored.keys["copy/sub"]; sub == nil || !sub.IsDir() || sub.Mode != 0700 {
+		t.Fatal("directory not restored")
+	}
+}
+
+func Test_diffTrees(t *testing.T) {
+	st := newTestSnapshotStore(
+		NewDirKVPair([]byte("dir"), 0755),
+		NewKVPair([]byte("dir/a"), []byte("a")),
+		NewKVPair([]byte("dir/b"), []byte("b")),
+		NewDirKVPair([]byte("dir/sub"), 0755),
+		NewKVPair([]byte("dir/sub/c"), []byte("c")),
+	)
+	a := st.snapshot(t)
+
+	st.keys["dir/a"] = NewKVPair([]byte("dir/a"), []byte("changed"))
+	delete(st.keys, "dir/b")
+	delete(st.keys, "dir/sub/c")
+	st.keys["dir/sub/d"] = NewKVPair([]byte("dir/sub/d"), []byte("d"))
+	b := st.snapshot(t)
+
+	got := make([]string, 0)
+	err := diffTrees("", a, b, st.read, func(key, change string) {
+		got = append(got, key+":"+change)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "a:modified,b:removed,sub/c:removed,sub/d:added"
+	if strings.Join(got, ",") != want {
+		t.Fatal("wrong diff", got)
+	}
+
+	got = got[:0]
+	diffTrees("", a, a, st.read, func(key, change string) { got = append(got, key) })
+	if len(got) != 0 {
+		t.Fatal("same snapshots should not differ", got)
+	}
+}
+
+func Test_snapshotBlocks(t *testing.T) {
+	st := newTestSnapshotStore(
+		NewDirKVPair([]byte("dir"), 0755),
+		NewDirKVPair([]byte("dir/x"), 0755),
+		NewDirKVPair([]byte("dir/y"), 0755),
+		NewKVPair([]byte("dir/x/a"), []byte("a")),
+		NewKVPair([]byte("dir/y/a"), []byte("a")),
+	)
+	id := st.snapshot(t)
+
+	blocks, err := snapshotBlocks(id, func(id []byte) (*snapshotTree, uint64, error) {
+		tree, err := st.read(id)
+		return tree, uint64(len(st.blocks[string(id)])), err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Three trees and the value shared by both keys
+	if len(blocks) != 5 || len(st.blocks) != 4 {
+		t.Fatal("wrong block count", len(blocks), len(st.blocks))
+	}
+	value := sha256.Sum256([]byte("a"))
+	if !bytes.Equal(blocks[0].id, value[:]) || blocks[0].size != 1 {
+		t.Fatal("value block should be included")
+	}
+	if root := blocks[len(blocks)-1]; !bytes.Equal(root.id, id) || root.size != uint64(len(st.blocks[string(id)])) {
+		t.Fatal("root should be last")
+	}
+}
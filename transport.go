@@ -8,6 +8,10 @@ type localKVTransport struct {
 	host   string
 	kv     KVStore
 	remote KVTransport
+
+	// All namespaces served locally.  If nil only the default namespace is
+	// served
+	namespaces *namespaces
 }
 
 func newLocalKVTransport(host string, remote KVTransport) *localKVTransport {
@@ -19,24 +23,76 @@ func newLocalKVTransport(host string, remote KVTransport) *localKVTransport {
 
 func (trans *localKVTransport) GetKey(ctx context.Context, host string, key []byte) (*KVPair, error) {
 	if trans.host == host {
-		return trans.kv.Get(key)
+		if err := ctx.Err(); err != nil {
+			return nil, contextError(err)
+		}
+		store, err := trans.store(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return store.Get(key)
 	}
 	return trans.remote.GetKey(ctx, host, key)
 }
 
 func (trans *localKVTransport) ListDir(ctx context.Context, host string, dir []byte) ([]*KVPair, error) {
 	if trans.host == host {
+		store, err := trans.store(ctx)
+		if err != nil {
+			return nil, err
+		}
+
 		out := make([]*KVPair, 0)
-		trans.kv.Iter(dir, false, func(kv *KVPair) bool {
+		store.Iter(dir, false, func(kv *KVPair) bool {
 			out = append(out, kv)
-			return true
+			return ctx.Err() == nil
 		})
-		return out, nil
+		return out, contextError(ctx.Err())
 	}
 
 	return trans.remote.ListDir(ctx, host, dir)
 }
 
+func (trans *localKVTransport) LookupIndex(ctx context.Context, host string, q *IndexQuery) ([]*KVPair, error) {
+	if trans.host == host {
+		if err := ctx.Err(); err != nil {
+			return nil, contextError(err)
+		}
+		if trans.namespaces == nil {
+			return nil, ErrIndexNotFound
+		}
+		return trans.namespaces.lookupIndex(namespaceFromContext(ctx), q)
+	}
+	return trans.remote.LookupIndex(ctx, host, q)
+}
+
+func (trans *localKVTransport) Scan(ctx context.Context, host string, opts *ScanOptions) ([]*KVPair, error) {
+	if trans.host == host {
+		store, err := trans.store(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		out := make([]*KVPair, 0)
+		store.Scan(opts, func(kv *KVPair) bool {
+			out = append(out, kv)
+			return ctx.Err() == nil
+		})
+		return out, contextError(ctx.Err())
+	}
+
+	return trans.remote.Scan(ctx, host, opts)
+}
+
+// store returns the local store for the namespace carried by the context
+func (trans *localKVTransport) store(ctx context.Context) (KVStore, error) {
+	name := namespaceFromContext(ctx)
+	if name == DefaultNamespace || trans.namespaces == nil {
+		return trans.kv, nil
+	}
+	return trans.namespaces.store(name)
+}
+
 func (trans *localKVTransport) Register(kv KVStore) {
 	// set internal
 	trans.kv = kv
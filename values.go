@@ -0,0 +1,152 @@
+package fidias
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+var errValueUnavailable = errors.New("resulting value unavailable")
+
+const (
+	// ContentTypeBinary is the content type for opaque values.  It is assumed
+	// when a pair has no content type
+	ContentTypeBinary = "application/octet-stream"
+	// ContentTypeJSON is the content type for json encoded values
+	ContentTypeJSON = "application/json"
+	// ContentTypeText is the content type for plain text values
+	ContentTypeText = "text/plain"
+	// ContentTypeInt64 is the content type for signed 64-bit integers.  The
+	// value is stored as a base 10 string so it is human readable
+	ContentTypeInt64 = "application/x-int64"
+)
+
+// NewJSONKVPair inits a new kv pair with the json encoded value
+func NewJSONKVPair(key []byte, v interface{}) (*KVPair, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	kvp := NewKVPair(key, b)
+	kvp.ContentType = ContentTypeJSON
+	return kvp, nil
+}
+
+// NewInt64KVPair inits a new kv pair with the integer value
+func NewInt64KVPair(key []byte, i int64) *KVPair {
+	kvp := NewKVPair(key, []byte(strconv.FormatInt(i, 10)))
+	kvp.ContentType = ContentTypeInt64
+	return kvp
+}
+
+// MediaType returns the content type of the value defaulting to binary if not
+// set
+func (kvp *KVPair) MediaType() string {
+	if kvp.ContentType == "" {
+		return ContentTypeBinary
+	}
+	return kvp.ContentType
+}
+
+// DecodeJSON decodes the json value into v
+func (kvp *KVPair) DecodeJSON(v interface{}) error {
+	if kvp.ContentType != ContentTypeJSON {
+		return fmt.Errorf("content type not %s: %s", ContentTypeJSON, kvp.MediaType())
+	}
+	return json.Unmarshal(kvp.Value, v)
+}
+
+// Int64 returns the integer value.  Values without a content type are parsed
+// as base 10 integers
+func (kvp *KVPair) Int64() (int64, error) {
+	if kvp.ContentType != ContentTypeInt64 && kvp.ContentType != "" {
+		return 0, fmt.Errorf("content type not %s: %s", ContentTypeInt64, kvp.MediaType())
+	}
+	return strconv.ParseInt(string(kvp.Value), 10, 64)
+}
+
+// incrKVPair returns the pair resulting from adding delta to the integer
+// value of cur.  A nil cur is treated as zero.  It is used by the fsm so must
+// be deterministic
+func incrKVPair(cur *KVPair, delta int64) (*KVPair, error) {
+	var i int64
+	if cur != nil {
+		var err error
+		if i, err = cur.Int64(); err != nil {
+			return nil, err
+		}
+	}
+
+	sum := i + delta
+	if (delta > 0 && sum < i) || (delta < 0 && sum > i) {
+		return nil, fmt.Errorf("integer overflow: %d + %d", i, delta)
+	}
+
+	return NewInt64KVPair(nil, sum), nil
+}
+
+// appendKVPair returns the pair resulting from appending data to the value of
+// cur retaining its content type.  A nil cur is treated as an empty value
+func appendKVPair(cur *KVPair, data []byte) *KVPair {
+	if cur == nil {
+		return NewKVPair(nil, data)
+	}
+
+	value := make([]byte, 0, len(cur.Value)+len(data))
+	value = append(append(value, cur.Value...), data...)
+
+	return &KVPair{Value: value, ContentType: cur.ContentType}
+}
+
+// SetJSON sets the key to the json encoded value
+func (kv *KV) SetJSON(key []byte, v interface{}, wo *WriteOptions) (*KVPair, *WriteStats, error) {
+	kvp, err := NewJSONKVPair(key, v)
+	if err != nil {
+		return nil, nil, err
+	}
+	return kv.Set(kvp, wo)
+}
+
+// GetJSON gets the key and decodes its json value into v
+func (kv *KV) GetJSON(key []byte, v interface{}, opt *ReadOptions) (*KVPair, *ReadStats, error) {
+	kvp, stats, err := kv.Get(key, opt)
+	if err == nil {
+		err = kvp.DecodeJSON(v)
+	}
+	return kvp, stats, err
+}
+
+// SetInt64 sets the key to the integer value
+func (kv *KV) SetInt64(key []byte, i int64, wo *WriteOptions) (*KVPair, *WriteStats, error) {
+	return kv.Set(NewInt64KVPair(key, i), wo)
+}
+
+// GetInt64 gets the integer value for the key
+func (kv *KV) GetInt64(key []byte, opt *ReadOptions) (int64, *KVPair, error) {
+	kvp, _, err := kv.Get(key, opt)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	i, err := kvp.Int64()
+	return i, kvp, err
+}
+
+// IncrInt64 atomically adds delta to the integer value of the key returning
+// the new value.  The delta is applied by the cluster so concurrent increments
+// are not lost.  If the key does not exist it is set to delta
+func (kv *KV) IncrInt64(key []byte, delta int64, wo *WriteOptions) (int64, *KVPair, error) {
+	kvp, _, err := kv.Incr(key, delta, wo)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if kvp.Value == nil {
+		return 0, kvp, errValueUnavailable
+	}
+
+	i, err := kvp.Int64()
+	return i, kvp, err
+}
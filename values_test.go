@@ -0,0 +1,79 @@
+package fidias
+
+import (
+	"math"
+	"testing"
+)
+
+func Test_KVPair_typed(t *testing.T) {
+	kvp := NewInt64KVPair([]byte("counter"), -42)
+	if string(kvp.Value) != "-42" {
+		t.Fatalf("wrong value: %s", kvp.Value)
+	}
+	i, err := kvp.Int64()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i != -42 {
+		t.Fatalf("have=%d want=-42", i)
+	}
+
+	if kvp, err = NewJSONKVPair([]byte("doc"), map[string]int{"a": 1}); err != nil {
+		t.Fatal(err)
+	}
+	var v map[string]int
+	if err = kvp.DecodeJSON(&v); err != nil {
+		t.Fatal(err)
+	}
+	if v["a"] != 1 {
+		t.Fatal("wrong json value")
+	}
+	if _, err = kvp.Int64(); err == nil {
+		t.Fatal("should fail on content type mismatch")
+	}
+
+	if NewKVPair(nil, nil).MediaType() != ContentTypeBinary {
+		t.Fatal("should default to binary")
+	}
+}
+
+func Test_incrKVPair(t *testing.T) {
+	kvp, err := incrKVPair(nil, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kvp.ContentType != ContentTypeInt64 || string(kvp.Value) != "5" {
+		t.Fatalf("wrong pair: %s %s", kvp.ContentType, kvp.Value)
+	}
+
+	// Untyped values are parsed
+	if kvp, err = incrKVPair(NewKVPair(nil, []byte("10")), -3); err != nil {
+		t.Fatal(err)
+	}
+	if string(kvp.Value) != "7" {
+		t.Fatalf("have=%s want=7", kvp.Value)
+	}
+
+	if _, err = incrKVPair(NewKVPair(nil, []byte("abc")), 1); err == nil {
+		t.Fatal("should fail on non-integer value")
+	}
+	if _, err = incrKVPair(NewInt64KVPair(nil, math.MaxInt64), 1); err == nil {
+		t.Fatal("should fail on overflow")
+	}
+}
+
+func Test_appendKVPair(t *testing.T) {
+	kvp := appendKVPair(nil, []byte("a"))
+	if string(kvp.Value) != "a" {
+		t.Fatalf("have=%s want=a", kvp.Value)
+	}
+
+	cur := &KVPair{Value: []byte("a"), ContentType: ContentTypeText}
+	kvp = appendKVPair(cur, []byte("b"))
+	if string(kvp.Value) != "ab" || kvp.ContentType != ContentTypeText {
+		t.Fatalf("wrong pair: %s %s", kvp.ContentType, kvp.Value)
+	}
+	if string(cur.Value) != "a" {
+		t.Fatal("current value should not be modified")
+	}
+}